@@ -0,0 +1,156 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/ch3lo/yale/tracing"
+	"github.com/ch3lo/yale/util"
+	"github.com/codegangsta/cli"
+)
+
+func agentFlags() []cli.Flag {
+	return []cli.Flag{
+		cli.StringFlag{
+			Name:  "interval",
+			Value: "1m",
+			Usage: "Tiempo entre reconciliaciones periodicas. Por ejemplo 30s, 5m",
+		},
+		cli.BoolTFlag{
+			Name:  "events",
+			Usage: "Reconcilia cuando Docker notifica la caida de un contenedor. Se desactiva con --events=false",
+		},
+		cli.StringFlag{
+			Name:  "settle",
+			Value: "10s",
+			Usage: "Tiempo minimo entre el termino de una reconciliacion y la siguiente gatillada por un evento",
+		},
+		cli.StringFlag{
+			Name:   "metrics-listen",
+			Usage:  "Direccion donde se exponen las metricas de Prometheus en /metrics, por ejemplo :9102. Vacio no las expone",
+			EnvVar: "YALE_METRICS_LISTEN",
+		},
+	}
+}
+
+func agentBefore(c *cli.Context) error {
+	if c.GlobalString("state-file") == "" {
+		return errors.New("Se debe indicar el archivo de estado deseado con el flag global state-file")
+	}
+
+	for _, flag := range []string{"interval", "settle"} {
+		if d, err := time.ParseDuration(c.String(flag)); err != nil || d <= 0 {
+			return errors.New("Valor del parámetro " + flag + " invalido")
+		}
+	}
+
+	return nil
+}
+
+// reconcileDesiredState reconcilia cada servicio registrado en el archivo de estado deseado
+func reconcileDesiredState(ctx context.Context, path string) {
+	state, err := loadDesiredState(path)
+	if err != nil {
+		util.Log.Errorln("No se pudo cargar el estado deseado", err)
+		return
+	}
+
+	for _, key := range state.keys() {
+		if ctx.Err() != nil {
+			return
+		}
+
+		desired := state.Services[key]
+		serviceConfig, err := desired.Manifest.serviceConfig()
+		if err != nil {
+			util.Log.Errorf("El estado deseado del servicio %s es invalido: %s", key, err)
+			continue
+		}
+
+		util.Log.Infof("Reconciliando el servicio %s con imagen %s:%s", key, serviceConfig.ImageName, serviceConfig.Tag)
+		traceCtx, span := tracing.Start(ctx, "reconcile "+key,
+			tracing.Attr("container.image", serviceConfig.ImageName),
+			tracing.Attr("container.tag", serviceConfig.Tag))
+		results := stackManager.Reconcile(traceCtx, serviceConfig, desired.Manifest.smokeConfig(), desired.Manifest.warmUpConfig(), desired.Manifest.Tolerance, desired.Stacks)
+		for stackKey, result := range results {
+			if result.Err != nil {
+				util.Log.Errorf("No se pudo reconciliar el servicio %s en el stack %s: %s", key, stackKey, result.Err)
+				span.SetError(result.Err)
+			}
+		}
+		span.End()
+	}
+}
+
+func agentCmd(c *cli.Context) {
+	path := c.GlobalString("state-file")
+	interval, _ := time.ParseDuration(c.String("interval"))
+	settle, _ := time.ParseDuration(c.String("settle"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		util.Log.Warnln("Se recibió una señal de término, deteniendo el agente")
+		cancel()
+	}()
+
+	if addr := c.String("metrics-listen"); addr != "" {
+		serveMetrics(addr)
+	}
+
+	trigger := make(chan string, 1)
+	if c.BoolT("events") {
+		events, err := stackManager.Events(ctx)
+		if err != nil {
+			util.Log.Fatalln(err)
+		}
+
+		go func() {
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case event := <-events:
+					if !event.Event.IsDeath() {
+						continue
+					}
+					util.Log.Infof("El contenedor %s del stack %s notificó %s", event.Event.Name, event.Stack, event.Event.Status)
+					select {
+					case trigger <- event.Stack:
+					default:
+					}
+				}
+			}
+		}()
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	util.Log.Infof("Iniciando el agente con el estado deseado %s", path)
+	for {
+		reconcileDesiredState(ctx, path)
+		last := time.Now()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case stackKey := <-trigger:
+			util.Log.Infof("Reconciliacion gatillada por un evento del stack %s", stackKey)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(settle - time.Since(last)):
+			}
+		}
+	}
+}
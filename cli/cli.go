@@ -1,10 +1,15 @@
 package cli
 
 import (
+	"bufio"
+	"context"
 	"errors"
 	"fmt"
 	"os"
 	"path"
+	"strings"
+	"sync"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/ch3lo/yale/cluster"
@@ -81,7 +86,7 @@ func globalFlags() []cli.Flag {
 		},
 		cli.StringSliceFlag{
 			Name:   "endpoint, ep",
-			Usage:  "Endpoint de la API de Docker",
+			Usage:  "Endpoint de la API de Docker con el formato [nombre=]url[,weight=N][,instances=N][,required=true]",
 			EnvVar: "DOCKER_HOST",
 		},
 		cli.BoolFlag{
@@ -122,6 +127,94 @@ func globalFlags() []cli.Flag {
 			Usage:  "Archivo de configuracion de la autenticacion",
 			EnvVar: "DEPLOYER_AUTH_CONFIG",
 		},
+		cli.StringFlag{
+			Name:   "state-file",
+			Usage:  "Archivo donde se registra el estado deseado de los servicios desplegados. Es utilizado por el comando agent",
+			EnvVar: "YALE_STATE_FILE",
+		},
+		cli.IntFlag{
+			Name:   "api-retries",
+			Value:  3,
+			Usage:  "Cantidad de reintentos de las llamadas idempotentes al API de Docker ante errores transitorios",
+			EnvVar: "YALE_API_RETRIES",
+		},
+		cli.StringFlag{
+			Name:   "api-backoff",
+			Value:  "500ms",
+			Usage:  "Espera antes del primer reintento, se duplica en cada reintento hasta 10 veces su valor",
+			EnvVar: "YALE_API_BACKOFF",
+		},
+		cli.StringFlag{
+			Name:   "api-timeout",
+			Value:  "30s",
+			Usage:  "Tiempo maximo de cada llamada al API de Docker. 0 no limita el tiempo",
+			EnvVar: "YALE_API_TIMEOUT",
+		},
+		cli.IntFlag{
+			Name:   "api-breaker-threshold",
+			Value:  5,
+			Usage:  "Errores transitorios consecutivos que abren el circuit breaker de un endpoint. 0 lo desactiva",
+			EnvVar: "YALE_API_BREAKER_THRESHOLD",
+		},
+		cli.StringFlag{
+			Name:   "api-breaker-cooldown",
+			Value:  "30s",
+			Usage:  "Tiempo que un endpoint con el circuit breaker abierto rechaza las llamadas",
+			EnvVar: "YALE_API_BREAKER_COOLDOWN",
+		},
+		cli.StringSliceFlag{
+			Name:  "webhook",
+			Usage: "Destino de los eventos de despliegue con el formato url[,secret=S][,events=TIPO]. Acepta http, https y file://ruta. Se puede repetir",
+		},
+		cli.StringFlag{
+			Name:   "webhook-secret",
+			Usage:  "Secreto con que se firman los eventos enviados a los webhooks que no definen uno",
+			EnvVar: "YALE_WEBHOOK_SECRET",
+		},
+		cli.StringSliceFlag{
+			Name:  "chat",
+			Usage: "Incoming webhook de chat que recibe el resumen del inicio y termino de los despliegues, con el formato [slack|teams|generic=]url[,template=ARCHIVO]. Se puede repetir",
+		},
+		cli.StringFlag{
+			Name:   "chat-template",
+			Usage:  "Archivo con plantillas de Go que redefinen los mensajes de chat \"title\", \"started\" y \"finished\"",
+			EnvVar: "YALE_CHAT_TEMPLATE",
+		},
+		cli.IntFlag{
+			Name:  "webhook-retries",
+			Value: 3,
+			Usage: "Cantidad de reintentos de la entrega de un evento o mensaje de chat ante errores transitorios",
+		},
+		cli.StringFlag{
+			Name:  "webhook-timeout",
+			Value: "5s",
+			Usage: "Tiempo maximo de cada entrega de un evento",
+		},
+		cli.StringFlag{
+			Name:   "metrics-push",
+			Usage:  "Url del Pushgateway de Prometheus donde se publican los gauges del ultimo despliegue (momento, resultado y duracion) al terminar un deploy o scale",
+			EnvVar: "YALE_METRICS_PUSH",
+		},
+		cli.StringFlag{
+			Name:  "metrics-job",
+			Value: "yale",
+			Usage: "Nombre del job con que se publican las metricas en el Pushgateway",
+		},
+		cli.StringFlag{
+			Name:   "trace-endpoint",
+			Usage:  "Destino de las trazas OpenTelemetry: url OTLP/HTTP del collector (por ejemplo http://localhost:4318), stdout, stderr o file://ruta. Si no se entrega se usa la url base de OTEL_EXPORTER_OTLP_ENDPOINT con /v1/traces. Vacio las desactiva",
+			EnvVar: "OTEL_EXPORTER_OTLP_TRACES_ENDPOINT",
+		},
+		cli.StringSliceFlag{
+			Name:  "trace-header",
+			Usage: "Header de los envios al collector con el formato clave=valor. Se puede repetir",
+		},
+		cli.StringFlag{
+			Name:   "trace-service",
+			Value:  "yale",
+			Usage:  "Nombre del servicio con que se registran las trazas",
+			EnvVar: "OTEL_SERVICE_NAME",
+		},
 		cli.StringFlag{
 			Name:   "log-level",
 			Value:  "info",
@@ -150,6 +243,48 @@ func globalFlags() []cli.Flag {
 	return flags
 }
 
+// stdinLines entrega las lineas de la entrada estandar. Las lee una unica goroutine, de manera
+// que una confirmacion cancelada no consume la respuesta de la siguiente
+var stdinLines = make(chan string)
+var stdinOnce sync.Once
+
+func readStdinLines() {
+	reader := bufio.NewReader(os.Stdin)
+	for {
+		line, err := reader.ReadString('\n')
+		if line != "" || err == nil {
+			stdinLines <- line
+		}
+		if err != nil {
+			close(stdinLines)
+			return
+		}
+	}
+}
+
+// confirm solicita una confirmacion por la entrada estandar. La pregunta se escribe en
+// la salida de error para no mezclarla con la salida del comando
+func confirm(question string) bool {
+	return confirmContext(context.Background(), question)
+}
+
+// confirmContext solicita una confirmacion que se rechaza si ctx se cancela antes de la respuesta
+func confirmContext(ctx context.Context, question string) bool {
+	stdinOnce.Do(func() { go readStdinLines() })
+	fmt.Fprintf(os.Stderr, "%s [s/N] ", question)
+
+	var answer string
+	select {
+	case <-ctx.Done():
+		fmt.Fprintln(os.Stderr)
+		return false
+	case answer = <-stdinLines:
+	}
+	answer = strings.ToLower(strings.TrimSpace(answer))
+
+	return answer == "s" || answer == "si" || answer == "y" || answer == "yes"
+}
+
 func buildCertPath(certPath string, file string) string {
 	if file == "" {
 		return ""
@@ -162,6 +297,75 @@ func buildCertPath(certPath string, file string) string {
 	return file
 }
 
+// resilienceConfig construye la proteccion de las llamadas al API de Docker de los endpoints a
+// partir de los flags globales
+func resilienceConfig(c *cli.Context) (helper.ResilienceConfig, error) {
+	config := helper.DefaultResilienceConfig()
+	config.Retries = c.GlobalInt("api-retries")
+	config.BreakerThreshold = c.GlobalInt("api-breaker-threshold")
+	if config.Retries < 0 || config.BreakerThreshold < 0 {
+		return config, errors.New("Los reintentos y el umbral del circuit breaker no pueden ser negativos")
+	}
+
+	durations := map[string]*time.Duration{
+		"api-backoff":          &config.Backoff,
+		"api-timeout":          &config.Timeout,
+		"api-breaker-cooldown": &config.BreakerCooldown,
+	}
+	for flag, value := range durations {
+		d, err := time.ParseDuration(c.GlobalString(flag))
+		if err != nil || d < 0 {
+			return config, errors.New("Valor del parámetro " + flag + " invalido")
+		}
+		*value = d
+	}
+	config.MaxBackoff = 10 * config.Backoff
+
+	return config, nil
+}
+
+// newStackManager crea un stack por cada endpoint con la configuracion TLS y de autenticacion
+// de los flags globales
+func newStackManager(c *cli.Context, endpoints []string, resilience helper.ResilienceConfig) (*cluster.StackManager, error) {
+	sm := cluster.NewStackManager()
+
+	for _, spec := range groupEndpointSpecs(endpoints) {
+		epConfig, err := parseEndpoint(spec)
+		if err != nil {
+			fmt.Println("Endpoint de Docker invalido")
+			return nil, err
+		}
+
+		ep := epConfig.url
+		util.Log.Infof("Configurando el endpoint de Docker %s", ep)
+		var dh *helper.DockerHelper
+		if c.GlobalBool("tlsverify") {
+			ca := buildCertPath(c.GlobalString("cert_path"), c.GlobalString("tlscacert"))
+			cert := buildCertPath(c.GlobalString("cert_path"), c.GlobalString("tlscert"))
+			key := buildCertPath(c.GlobalString("cert_path"), c.GlobalString("tlskey"))
+			dh, err = helper.NewDockerTlsVerifyHelper(ep, c.GlobalString("auth-file"), cert, key, ca)
+		} else if c.GlobalBool("tls") {
+			cert := buildCertPath(c.GlobalString("cert_path"), c.GlobalString("tlscert"))
+			key := buildCertPath(c.GlobalString("cert_path"), c.GlobalString("tlskey"))
+			dh, err = helper.NewDockerTlsHelper(ep, c.GlobalString("auth-file"), cert, key)
+		} else {
+			dh, err = helper.NewDockerHelper(ep, c.GlobalString("auth-file"))
+		}
+
+		if err != nil {
+			fmt.Println("No se pudo configurar el endpoint de Docker")
+			return nil, err
+		}
+		dh.SetResilience(resilience)
+		if err = sm.AppendStack(epConfig.name, dh, epConfig.stack); err != nil {
+			fmt.Println("No se pudo configurar el endpoint de Docker")
+			return nil, err
+		}
+	}
+
+	return sm, nil
+}
+
 func setupGlobalFlags(c *cli.Context) error {
 	var config logConfig = logConfig{}
 	config.LogLevel = c.String("log-level")
@@ -176,31 +380,28 @@ func setupGlobalFlags(c *cli.Context) error {
 		return err
 	}
 
-	stackManager = cluster.NewStackManager()
+	resilience, err := resilienceConfig(c)
+	if err != nil {
+		return err
+	}
 
-	for _, ep := range c.StringSlice("endpoint") {
-		util.Log.Infof("Configurando el endpoint de Docker %s", ep)
-		var dh *helper.DockerHelper
-		if c.Bool("tlsverify") {
-			ca := buildCertPath(c.String("cert_path"), c.String("tlscacert"))
-			cert := buildCertPath(c.String("cert_path"), c.String("tlscert"))
-			key := buildCertPath(c.String("cert_path"), c.String("tlskey"))
-			dh, err = helper.NewDockerTlsVerifyHelper(ep, c.String("auth-file"), cert, key, ca)
-		} else if c.Bool("tls") {
-			cert := buildCertPath(c.String("cert_path"), c.String("tlscert"))
-			key := buildCertPath(c.String("cert_path"), c.String("tlskey"))
-			dh, err = helper.NewDockerTlsHelper(ep, c.String("auth-file"), cert, key)
-		} else {
-			dh, err = helper.NewDockerHelper(ep, c.String("auth-file"))
-		}
+	if stackManager, err = newStackManager(c, c.StringSlice("endpoint"), resilience); err != nil {
+		return err
+	}
 
-		if err != nil {
-			fmt.Println("No se pudo configurar el endpoint de Docker")
-			return err
-		}
-		stackManager.AppendStack(dh)
+	if err = setupWebhooks(c); err != nil {
+		fmt.Println("No se pudieron configurar los webhooks")
+		return err
 	}
 
+	if err = setupTracing(c); err != nil {
+		fmt.Println("No se pudieron configurar las trazas")
+		return err
+	}
+
+	metricsGateway = c.String("metrics-push")
+	metricsJob = c.String("metrics-job")
+
 	return nil
 }
 
@@ -228,6 +429,7 @@ func RunApp() {
 	}
 
 	err = app.Run(os.Args)
+	closeTracing()
 	if err != nil {
 		fmt.Println(err)
 		util.Log.Fatalln(err)
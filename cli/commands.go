@@ -11,18 +11,91 @@ var commands = []cli.Command{
 		Before:  deployBefore,
 		Action:  deployCmd,
 	},
+	{
+		Name:   "scale",
+		Usage:  "Cambia la cantidad de instancias de un servicio desplegado",
+		Flags:  scaleFlags(),
+		Before: scaleBefore,
+		Action: scaleCmd,
+	},
+	{
+		Name:   "undeploy",
+		Usage:  "Remueve los contenedores seleccionados de todos los stacks",
+		Flags:  undeployFlags(),
+		Before: undeployBefore,
+		Action: undeployCmd,
+	},
+	{
+		Name:   "restart",
+		Usage:  "Reinicia progresivamente los contenedores seleccionados verificando su smoke test",
+		Flags:  restartFlags(),
+		Before: restartBefore,
+		Action: restartCmd,
+	},
+	{
+		Name:   "gc",
+		Usage:  "Remueve los contenedores detenidos y las imagenes de versiones antiguas de acuerdo a una politica de retencion",
+		Flags:  gcFlags(),
+		Before: gcBefore,
+		Action: gcCmd,
+	},
+	{
+		Name:   "agent",
+		Usage:  "Mantiene la cantidad de instancias deseada de los servicios desplegados reemplazando los contenedores caidos",
+		Flags:  agentFlags(),
+		Before: agentBefore,
+		Action: agentCmd,
+	},
+	{
+		Name:   "logs",
+		Usage:  "Muestra los logs de los contenedores seleccionados de todos los stacks. Solo se pueden leer los de contenedores con el driver json-file o journald (deploy --log-driver); yale despliega con syslog por defecto",
+		Flags:  logsFlags(),
+		Before: logsBefore,
+		Action: logsCmd,
+	},
+	{
+		Name:   "exec",
+		Usage:  "Ejecuta un comando en los contenedores seleccionados de todos los stacks",
+		Flags:  execFlags(),
+		Before: execBefore,
+		Action: execCmd,
+	},
+	{
+		Name:   "events",
+		Usage:  "Muestra los eventos de los contenedores de todos los stacks",
+		Flags:  eventsFlags(),
+		Action: eventsCmd,
+	},
+	{
+		Name:   "serve",
+		Usage:  "Atiende un API HTTP para desplegar, escalar, hacer rollback, remover y consultar servicios en uno o mas ambientes",
+		Flags:  serveFlags(),
+		Before: serveBefore,
+		Action: serveCmd,
+	},
+	{
+		Name:    "status",
+		Aliases: []string{"inspect"},
+		Usage:   "Muestra el estado de un servicio desplegado en todos los stacks",
+		Flags:   statusFlags(),
+		Before:  statusBefore,
+		Action:  statusCmd,
+	},
 	{
 		Name:    "list",
 		Aliases: []string{"l"},
 		Usage:   "Lista contenedores",
 		Flags:   listFlags(),
+		Before:  listBefore,
 		Action:  listCmd,
 	},
 	{
-		Name:    "filter",
-		Aliases: []string{"f"},
-		Usage:   "[WIP] Filtra contenedores",
-		Flags:   filterFlags(),
-		Action:  filterCmd,
+		Name:      "filter",
+		Aliases:   []string{"f"},
+		Usage:     "Filtra contenedores con un selector sobre sus labels, imagen, tag, nombre, estado, nodo y stack",
+		ArgsUsage: "SELECTOR",
+		Flags:     filterFlags(),
+		Before:    filterBefore,
+		Action:    filterCmd,
 	},
 }
@@ -1,34 +1,44 @@
 package cli
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
+	"time"
 
 	"github.com/ch3lo/yale/cluster"
-	"github.com/ch3lo/yale/monitor"
-	"github.com/ch3lo/yale/service"
+	"github.com/ch3lo/yale/helper"
 	"github.com/ch3lo/yale/util"
 	"github.com/codegangsta/cli"
-	"github.com/pivotal-golang/bytefmt"
 )
 
-func handleDeploySigTerm(sm *cluster.StackManager) {
-	c := make(chan os.Signal, 1)
+// handleDeploySigTerm cancela el despliegue al recibir una señal de término, lo que gatilla
+// el rollback. Una segunda señal termina el proceso sin esperar el rollback
+func handleDeploySigTerm(cancel context.CancelFunc) {
+	c := make(chan os.Signal, 2)
 	signal.Notify(c, os.Interrupt)
 	signal.Notify(c, syscall.SIGTERM)
 	go func() {
 		<-c
-		sm.Rollback()
-		os.Exit(1)
+		util.Log.Warnln("Se recibió una señal de término, cancelando el despliegue")
+		cancel()
+		<-c
+		util.Log.Errorln("Se recibió una segunda señal de término, terminando sin esperar el rollback")
+		os.Exit(exitDeployCanceled)
 	}()
 }
 
 func deployFlags() []cli.Flag {
 	return []cli.Flag{
+		cli.StringFlag{
+			Name:  "manifest",
+			Usage: "Archivo JSON con la definicion del despliegue. Los flags tienen precedencia sobre sus valores",
+		},
 		cli.StringFlag{
 			Name:  "service-id",
 			Usage: "Id del servicio",
@@ -50,6 +60,11 @@ func deployFlags() []cli.Flag {
 			Name:  "memory",
 			Usage: "Cantidad de memoria principal (Unidades: M, m, MB, mb, GB, G) que puede utilizar el servicio. Mas info 'man docker-run' memory.",
 		},
+		cli.StringFlag{
+			Name:  "log-driver",
+			Value: "syslog",
+			Usage: "Driver de logs de los contenedores. El comando logs solo puede leer los de json-file y journald",
+		},
 		cli.StringSliceFlag{
 			Name:  "env-file",
 			Usage: "Archivo con variables de entorno",
@@ -63,6 +78,10 @@ func deployFlags() []cli.Flag {
 			Value: 1,
 			Usage: "Total de servicios que se quieren obtener en cada uno de los stack.",
 		},
+		cli.IntFlag{
+			Name:  "total-instances",
+			Usage: "Total de servicios a distribuir entre los stacks de acuerdo a su peso. Reemplaza a instances",
+		},
 		cli.Float64Flag{
 			Name:  "tolerance",
 			Value: 0.5,
@@ -70,6 +89,27 @@ func deployFlags() []cli.Flag {
 				"Este valor es respecto al total de instancias." +
 				"Por ejemplo, si se despliegan 5 servicios y fallan ",
 		},
+		cli.StringFlag{
+			Name:  "failure-policy",
+			Value: "all",
+			Usage: "Politica de fallo entre stacks. all | quorum | best-effort",
+		},
+		cli.StringFlag{
+			Name:  "timeout",
+			Usage: "Tiempo maximo del despliegue. Superado este tiempo se cancela y se realiza rollback. Por ejemplo 10m",
+		},
+		cli.StringSliceFlag{
+			Name:  "wave",
+			Usage: "Stacks separados por coma que se despliegan en una ola. Las olas se despliegan en el orden entregado y los stacks restantes en una ultima ola",
+		},
+		cli.StringFlag{
+			Name:  "wave-soak",
+			Usage: "Tiempo de espera entre olas. Por ejemplo 30s, 5m",
+		},
+		cli.BoolFlag{
+			Name:  "wave-approval",
+			Usage: "Solicita confirmacion antes de desplegar cada ola a partir de la segunda",
+		},
 		cli.IntFlag{
 			Name:  "smoke-retries",
 			Value: 10,
@@ -98,106 +138,220 @@ func deployFlags() []cli.Flag {
 			Value: ".*",
 			Usage: "Valor esperado del resultado del calentamiento. Si se cumple el valor pasado, se asume un calentamiento exitoso",
 		},
+		cli.StringFlag{
+			Name:  "output",
+			Value: "containers",
+			Usage: "Formato del resultado en la salida estandar. containers | summary. containers es el listado de contenedores desplegados con RegisterId y Address, summary agrega los stacks exitosos, fallidos y con rollback",
+		},
+		cli.IntFlag{
+			Name:  "gc-keep",
+			Usage: "Luego de un despliegue exitoso remueve los contenedores detenidos e imagenes de la imagen desplegada conservando esta cantidad de tags. 0 lo desactiva",
+		},
 	}
 }
 
 func deployBefore(c *cli.Context) error {
-	if c.String("image") == "" {
+	manifest, err := deployManifestFromContext(c)
+	if err != nil {
+		return errors.New(fmt.Sprintf("No se pudo cargar el manifiesto: %s", err))
+	}
+
+	if manifest.Image == "" {
 		return errors.New("El nombre de la imagen esta vacio")
 	}
 
-	if c.String("tag") == "" {
+	if manifest.Tag == "" {
 		return errors.New("El TAG de la imagen esta vacio")
 	}
 
-	if c.String("smoke-request") == "" {
-		return errors.New("El endpoint de Smoke Test esta vacio")
+	if err := checkDeployOutput(c.String("output")); err != nil {
+		return err
 	}
 
-	if c.String("memory") != "" {
-		if _, err := bytefmt.ToMegabytes(c.String("memory")); err != nil {
-			return errors.New("Valor del parámetro memory invalido")
-		}
-
+	if err := manifest.validate(); err != nil {
+		return err
 	}
 
-	for _, file := range c.StringSlice("env-file") {
-		if err := util.FileExists(file); err != nil {
-			return errors.New(fmt.Sprintf("El archivo %s con variables de entorno no existe", file))
-		}
+	return manifest.configureStacks(stackManager)
+}
+
+func approveWave(ctx context.Context, wave int, stacks []string) bool {
+	return confirmContext(ctx, fmt.Sprintf("¿Desplegar la ola %d con los stacks %s?", wave, strings.Join(stacks, ", ")))
+}
+
+const (
+	exitDeployFailed   = 1
+	exitDeployPartial  = 3
+	exitDeployCanceled = 4
+	exitDeployTimeout  = 5
+)
+
+// deployContext crea el contexto del despliegue a partir de parent, con el tiempo maximo si esta definido
+func deployContext(parent context.Context, timeout string) (context.Context, context.CancelFunc) {
+	if timeout == "" {
+		return context.WithCancel(parent)
 	}
 
-	return nil
+	duration, _ := time.ParseDuration(timeout)
+	return context.WithTimeout(parent, duration)
+}
+
+type deployResume struct {
+	cluster.DeployResult
+	DeployId   string                      `json:"DeployId"`
+	TraceId    string                      `json:"TraceId,omitempty"`
+	Containers []callbackResume            `json:"Containers"`
+	DockerApi  map[string]helper.CallStats `json:"DockerApi"`
 }
 
 type callbackResume struct {
+	Stack      string `json:"Stack"`
 	RegisterId string `json:"RegisterId"`
 	Address    string `json:"Address"`
 }
 
-func deployCmd(c *cli.Context) {
-
-	envs, err := util.ParseMultiFileLinesToArray(c.StringSlice("env-file"))
-	if err != nil {
-		util.Log.Fatalln("No se pudo procesar el archivo con variables de entorno", err)
+// newDeployResume arma el resumen del ultimo despliegue del stack manager. Si el despliegue fue
+// exitoso incluye los contenedores nuevos con su direccion
+func newDeployResume(sm *cluster.StackManager, ok bool, instancesConfig cluster.InstancesConfig) deployResume {
+	resume := deployResume{
+		DeployResult: sm.Result(),
+		DeployId:     sm.DeployId(),
+		TraceId:      sm.TraceId(),
+		Containers:   []callbackResume{},
+		DockerApi:    sm.CallStats(),
 	}
 
-	for _, v := range c.StringSlice("env") {
-		envs = append(envs, v)
+	if resume.TraceId != "" {
+		util.Log.Infof("La traza del despliegue %s es %s", resume.DeployId, resume.TraceId)
 	}
 
-	serviceConfig := service.ServiceConfig{
-		ServiceId: c.String("service-id"),
-		CpuShares: c.Int("cpu"),
-		Envs:      envs,
-		ImageName: c.String("image"),
-		Tag:       c.String("tag"),
-	}
-
-	if c.String("memory") != "" {
-		megabytes, _ := bytefmt.ToMegabytes(c.String("memory"))
-		memory := megabytes * 1024 * 1024
-		serviceConfig.Memory = int64(memory)
-	}
-
-	smokeConfig := monitor.MonitorConfig{
-		Retries:  c.Int("smoke-retries"),
-		Type:     monitor.GetMonitor(c.String("smoke-type")),
-		Request:  c.String("smoke-request"),
-		Expected: c.String("smoke-expected"),
+	for _, stackKey := range sm.StackKeys() {
+		stats := resume.DockerApi[stackKey]
+		if stats.Retries > 0 || stats.Failures > 0 || stats.Rejected > 0 {
+			util.Log.Warnf("El stack %s realizó %d llamadas al API de Docker con %d reintentos, %d fallas y %d rechazos del circuit breaker", stackKey, stats.Calls, stats.Retries, stats.Failures, stats.Rejected)
+		}
 	}
 
-	warmUpConfig := monitor.MonitorConfig{
-		Retries:  1,
-		Type:     monitor.HTTP,
-		Request:  c.String("warmup-request"),
-		Expected: c.String("warmup-expected"),
+	if !ok {
+		return resume
 	}
 
-	util.Log.Debugf("La configuración del servicio es: %#v", serviceConfig.String())
-
-	handleDeploySigTerm(stackManager)
-	if stackManager.Deploy(serviceConfig, smokeConfig, warmUpConfig, c.Int("instances"), c.Float64("tolerance")) {
-		services := stackManager.DeployedContainers()
-		var resume []callbackResume
+	targets := sm.Targets(instancesConfig)
+	stacks := sm.DeployedContainers()
 
+	for _, stackKey := range sortedStackKeys(stacks) {
+		services := stacks[stackKey]
+		if targets[stackKey] != cluster.KeepInstances {
+			util.Log.Infof("El stack %s tiene como objetivo %d instancias, se desplegaron %d nuevas", stackKey, targets[stackKey], len(services))
+		}
 		for k := range services {
 			if addr, err := services[k].AddressAndPort(8080); err != nil {
 				util.Log.Errorln(err)
 			} else {
 				util.Log.Infof("Se desplegó %s con el tag de registrator %s y dirección %s", services[k].GetId(), services[k].RegistratorId(), addr)
 				containerInfo := callbackResume{
+					Stack:      stackKey,
 					RegisterId: services[k].RegistratorId(),
 					Address:    addr,
 				}
-				resume = append(resume, containerInfo)
+				resume.Containers = append(resume.Containers, containerInfo)
 			}
 		}
+	}
 
-		jsonResume, _ := json.Marshal(resume)
+	return resume
+}
+
+func checkDeployOutput(output string) error {
+	if output != "containers" && output != "summary" {
+		return errors.New(fmt.Sprintf("Formato de salida %s invalido", output))
+	}
+
+	return nil
+}
 
+// deployedContainer es un contenedor del listado que imprime el formato de salida containers
+type deployedContainer struct {
+	RegisterId string `json:"RegisterId"`
+	Address    string `json:"Address"`
+}
+
+// printDeployOutput imprime el resultado del despliegue en el formato entregado. El formato
+// containers solo se imprime si el despliegue fue exitoso
+func printDeployOutput(resume deployResume, ok bool, output string) {
+	if output == "summary" {
+		jsonResume, _ := json.Marshal(resume)
 		fmt.Println(string(jsonResume))
-	} else {
-		util.Log.Fatalln("Proceso de deploy con errores")
+		return
+	}
+
+	if !ok {
+		return
+	}
+
+	var containers []deployedContainer
+	for _, container := range resume.Containers {
+		containers = append(containers, deployedContainer{RegisterId: container.RegisterId, Address: container.Address})
+	}
+
+	jsonContainers, _ := json.Marshal(containers)
+	fmt.Println(string(jsonContainers))
+}
+
+// finishDeploy imprime el resultado del despliegue y termina con el codigo de salida que corresponde
+func finishDeploy(ctx context.Context, ok bool, image string, instancesConfig cluster.InstancesConfig, output string) {
+	resume := newDeployResume(stackManager, ok, instancesConfig)
+
+	printDeployOutput(resume, ok, output)
+	closeWebhooks()
+	closeTracing()
+	pushMetrics(image)
+
+	if !ok {
+		switch ctx.Err() {
+		case context.DeadlineExceeded:
+			util.Log.Errorln("Proceso de deploy cancelado por tiempo maximo")
+			os.Exit(exitDeployTimeout)
+		case context.Canceled:
+			util.Log.Errorln("Proceso de deploy cancelado")
+			os.Exit(exitDeployCanceled)
+		}
+
+		util.Log.Errorln("Proceso de deploy con errores")
+		os.Exit(exitDeployFailed)
+	}
+
+	if len(resume.Failed) > 0 {
+		util.Log.Warnf("Proceso de deploy con stacks fallidos %v", resume.Failed)
+		os.Exit(exitDeployPartial)
+	}
+}
+
+func deployCmd(c *cli.Context) {
+	manifest, err := deployManifestFromContext(c)
+	if err != nil {
+		util.Log.Fatalln("No se pudo cargar el manifiesto", err)
+	}
+
+	serviceConfig, err := manifest.serviceConfig()
+	if err != nil {
+		util.Log.Fatalln("No se pudo procesar el archivo con variables de entorno", err)
+	}
+
+	deployConfig := manifest.deployConfig()
+
+	util.Log.Debugf("La configuración del servicio es: %#v", serviceConfig.String())
+
+	ctx, cancel := deployContext(context.Background(), manifest.Timeout)
+	defer cancel()
+
+	handleDeploySigTerm(cancel)
+	ok := stackManager.Deploy(ctx, serviceConfig, manifest.smokeConfig(), manifest.warmUpConfig(), deployConfig)
+	if ok && c.GlobalString("state-file") != "" {
+		recordDeploy(c.GlobalString("state-file"), manifest, serviceConfig, stackManager.Targets(deployConfig.Instances), stackManager.Result().Succeeded)
+	}
+	if ok && manifest.GcKeep > 0 {
+		collectDeployGarbage(stackManager, manifest.Image, manifest.GcKeep)
 	}
+	finishDeploy(ctx, ok, manifest.Image, deployConfig.Instances, c.String("output"))
 }
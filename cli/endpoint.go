@@ -0,0 +1,109 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/ch3lo/yale/cluster"
+)
+
+var endpointOptions = []string{"weight", "instances", "required"}
+
+// endpointOptionValue son los valores de las opciones. Un valor con : o / es la url de un
+// endpoint cuyo stack se llama como una opcion, por ejemplo weight=tcp://10.0.0.1:2376
+var endpointOptionValue = regexp.MustCompile("^[^:/]*$")
+
+var endpointName = regexp.MustCompile("^([a-zA-Z0-9_.-]+)=(.*)$")
+
+type endpointConfig struct {
+	name  string
+	url   string
+	stack cluster.StackConfig
+}
+
+func isEndpointOption(spec string) bool {
+	for _, opt := range endpointOptions {
+		if strings.HasPrefix(spec, opt+"=") {
+			return endpointOptionValue.MatchString(spec[len(opt)+1:])
+		}
+	}
+
+	return false
+}
+
+// groupEndpointSpecs junta las opciones con su endpoint. Es necesario cuando el
+// endpoint se obtiene desde DOCKER_HOST, ya que su valor se separa por comas.
+func groupEndpointSpecs(specs []string) []string {
+	var grouped []string
+	for _, spec := range specs {
+		if isEndpointOption(spec) && len(grouped) > 0 {
+			grouped[len(grouped)-1] = grouped[len(grouped)-1] + "," + spec
+		} else {
+			grouped = append(grouped, spec)
+		}
+	}
+
+	return grouped
+}
+
+func parseEndpointCount(value string) (int, error) {
+	count, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, err
+	}
+
+	if count < 0 {
+		return 0, errors.New("El valor no puede ser negativo")
+	}
+
+	return count, nil
+}
+
+// parseEndpoint procesa un endpoint con el formato [nombre=]url[,weight=N][,instances=N][,required=true]
+// Si no se entrega el nombre, el stack se identificara con una letra
+func parseEndpoint(spec string) (endpointConfig, error) {
+	parts := strings.Split(spec, ",")
+	config := endpointConfig{
+		url:   strings.TrimSpace(parts[0]),
+		stack: cluster.StackConfig{Weight: 1},
+	}
+
+	if result := endpointName.FindStringSubmatch(config.url); result != nil {
+		config.name = result[1]
+		config.url = result[2]
+	}
+
+	if config.url == "" {
+		return config, errors.New(fmt.Sprintf("El endpoint %s no tiene url", spec))
+	}
+
+	for _, opt := range parts[1:] {
+		kv := strings.SplitN(strings.TrimSpace(opt), "=", 2)
+		if len(kv) != 2 {
+			return config, errors.New(fmt.Sprintf("Opcion %s del endpoint %s invalida", opt, config.url))
+		}
+
+		var err error
+		switch kv[0] {
+		case "weight":
+			config.stack.Weight, err = parseEndpointCount(kv[1])
+		case "instances":
+			var instances int
+			instances, err = parseEndpointCount(kv[1])
+			config.stack.Instances = &instances
+		case "required":
+			config.stack.Required, err = strconv.ParseBool(kv[1])
+		default:
+			return config, errors.New(fmt.Sprintf("Opcion %s del endpoint %s desconocida", kv[0], config.url))
+		}
+
+		if err != nil {
+			return config, errors.New(fmt.Sprintf("Valor de la opcion %s del endpoint %s invalido", kv[0], config.url))
+		}
+	}
+
+	return config, nil
+}
@@ -0,0 +1,76 @@
+package cli
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestGroupEndpointSpecs(t *testing.T) {
+	cases := []struct {
+		specs    []string
+		expected []string
+	}{
+		{nil, nil},
+		{[]string{"tcp://10.0.0.1:2376"}, []string{"tcp://10.0.0.1:2376"}},
+		{[]string{"dc1=tcp://10.0.0.1:2376", "weight=2", "required=true", "dc2=tcp://10.0.0.2:2376", "instances=0"},
+			[]string{"dc1=tcp://10.0.0.1:2376,weight=2,required=true", "dc2=tcp://10.0.0.2:2376,instances=0"}},
+		{[]string{"tcp://10.0.0.1:2376", "weight=tcp://10.0.0.2:2376", "instances=unix:///var/run/docker.sock"},
+			[]string{"tcp://10.0.0.1:2376", "weight=tcp://10.0.0.2:2376", "instances=unix:///var/run/docker.sock"}},
+		{[]string{"weight=2", "tcp://10.0.0.1:2376"}, []string{"weight=2", "tcp://10.0.0.1:2376"}},
+		{[]string{"dc1=tcp://10.0.0.1:2376", "weights=2"}, []string{"dc1=tcp://10.0.0.1:2376", "weights=2"}},
+	}
+
+	for _, c := range cases {
+		if grouped := groupEndpointSpecs(c.specs); !reflect.DeepEqual(grouped, c.expected) {
+			t.Errorf("Los endpoints %q se agruparon como %q, se esperaba %q", c.specs, grouped, c.expected)
+		}
+	}
+}
+
+func TestParseEndpoint(t *testing.T) {
+	intValue := func(value int) *int { return &value }
+
+	cases := []struct {
+		spec      string
+		name      string
+		url       string
+		weight    int
+		instances *int
+		required  bool
+		invalid   bool
+	}{
+		{spec: "tcp://10.0.0.1:2376", url: "tcp://10.0.0.1:2376", weight: 1},
+		{spec: "dc1=tcp://10.0.0.1:2376", name: "dc1", url: "tcp://10.0.0.1:2376", weight: 1},
+		{spec: "dc1=tcp://10.0.0.1:2376, weight=3 ,instances=2,required=true", name: "dc1", url: "tcp://10.0.0.1:2376", weight: 3, instances: intValue(2), required: true},
+		{spec: "dc1=tcp://10.0.0.1:2376,weight=0,instances=0", name: "dc1", url: "tcp://10.0.0.1:2376", weight: 0, instances: intValue(0)},
+		{spec: "weight=tcp://10.0.0.1:2376,weight=2", name: "weight", url: "tcp://10.0.0.1:2376", weight: 2},
+		{spec: "unix:///var/run/docker.sock", url: "unix:///var/run/docker.sock", weight: 1},
+		{spec: "dc.1-a_b=10.0.0.1:2376", name: "dc.1-a_b", url: "10.0.0.1:2376", weight: 1},
+		{spec: "dc1=", invalid: true},
+		{spec: ",weight=1", invalid: true},
+		{spec: "dc1=tcp://10.0.0.1:2376,weight", invalid: true},
+		{spec: "dc1=tcp://10.0.0.1:2376,weight=-1", invalid: true},
+		{spec: "dc1=tcp://10.0.0.1:2376,instances=dos", invalid: true},
+		{spec: "dc1=tcp://10.0.0.1:2376,required=quizas", invalid: true},
+		{spec: "dc1=tcp://10.0.0.1:2376,zona=a", invalid: true},
+	}
+
+	for _, c := range cases {
+		config, err := parseEndpoint(c.spec)
+		if c.invalid {
+			if err == nil {
+				t.Errorf("El endpoint %q se aceptó como %+v", c.spec, config)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("El endpoint %q no se aceptó: %s", c.spec, err)
+			continue
+		}
+
+		if config.name != c.name || config.url != c.url || config.stack.Weight != c.weight || config.stack.Required != c.required || !reflect.DeepEqual(config.stack.Instances, c.instances) {
+			t.Errorf("El endpoint %q se interpretó como %+v", c.spec, config)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/ch3lo/yale/cluster"
+	"github.com/ch3lo/yale/util"
+	"github.com/codegangsta/cli"
+)
+
+func eventsFlags() []cli.Flag {
+	return []cli.Flag{
+		cli.StringFlag{
+			Name:  "status",
+			Value: "die,oom,health_status,restart",
+			Usage: "Eventos que se muestran separados por coma. Un valor vacio muestra todos los eventos",
+		},
+		cli.StringSliceFlag{
+			Name:  "label, lb",
+			Usage: "Label que deben tener los contenedores en formato KEY=VALUE. Se puede repetir",
+		},
+	}
+}
+
+// eventMatches indica si el evento cumple con los estados y labels entregados. Los estados se
+// comparan por prefijo ya que Docker agrega el resultado al evento, por ejemplo "health_status: unhealthy"
+func eventMatches(event cluster.StackEvent, statuses []string, labels []string) bool {
+	for _, label := range labels {
+		parts := strings.SplitN(label, "=", 2)
+		value, ok := event.Event.Labels[parts[0]]
+		if !ok || (len(parts) == 2 && value != parts[1]) {
+			return false
+		}
+	}
+
+	if len(statuses) == 0 {
+		return true
+	}
+
+	for _, status := range statuses {
+		if strings.HasPrefix(event.Event.Status, status) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func eventsCmd(c *cli.Context) {
+	var statuses []string
+	for _, status := range strings.Split(c.String("status"), ",") {
+		if status = strings.TrimSpace(status); status != "" {
+			statuses = append(statuses, status)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		cancel()
+	}()
+
+	events, err := stackManager.Events(ctx)
+	if err != nil {
+		util.Log.Fatalln(err)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event := <-events:
+			if !eventMatches(event, statuses, c.StringSlice("label")) {
+				continue
+			}
+
+			image := event.Event.Labels["image_name"] + ":" + event.Event.Labels["image_tag"]
+			fmt.Printf("%s [%s] %s %s %s\n", event.Event.Time.Format(time.RFC3339), event.Stack, event.Event.Name, image, event.Event.Status)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package cli
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/ch3lo/yale/helper"
+	"github.com/ch3lo/yale/service"
+	"github.com/ch3lo/yale/util"
+	"github.com/codegangsta/cli"
+	"github.com/olekukonko/tablewriter"
+)
+
+func execFlags() []cli.Flag {
+	flags := []cli.Flag{
+		cli.IntFlag{
+			Name:  "parallel, p",
+			Value: 5,
+			Usage: "Cantidad de contenedores en los que se ejecuta el comando a la vez",
+		},
+		cli.StringFlag{
+			Name:  "user, u",
+			Usage: "Usuario con el que se ejecuta el comando",
+		},
+		cli.IntFlag{
+			Name:  "max-output",
+			Value: 2000,
+			Usage: "Cantidad maxima de caracteres de la salida que se muestran por contenedor. 0 muestra la salida completa",
+		},
+	}
+
+	return append(selectionFlags(), flags...)
+}
+
+func execBefore(c *cli.Context) error {
+	if err := requireSelection(c); err != nil {
+		return err
+	}
+
+	if len(c.Args()) == 0 {
+		return errors.New("Se debe indicar el comando a ejecutar. Por ejemplo: yale exec --if foo -- jstack 1")
+	}
+
+	if c.Int("parallel") <= 0 {
+		return errors.New("Valor del parámetro parallel invalido")
+	}
+
+	if c.Int("max-output") < 0 {
+		return errors.New("Valor del parámetro max-output invalido")
+	}
+
+	return nil
+}
+
+type execOutcome struct {
+	stack   string
+	service *service.DockerService
+	result  helper.ExecResult
+	err     error
+}
+
+// execServices ejecuta el comando en los contenedores de todos los stacks, con a lo mas
+// parallel ejecuciones a la vez. Los resultados se entregan en el orden de los contenedores
+func execServices(stackMap map[string][]*service.DockerService, cmd []string, user string, parallel int) []execOutcome {
+	var outcomes []execOutcome
+	for _, stackKey := range sortedStackKeys(stackMap) {
+		for _, srv := range stackMap[stackKey] {
+			outcomes = append(outcomes, execOutcome{stack: stackKey, service: srv})
+		}
+	}
+
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, parallel)
+	for k := range outcomes {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(outcome *execOutcome) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			outcome.result, outcome.err = outcome.service.Exec(cmd, user)
+		}(&outcomes[k])
+	}
+
+	wg.Wait()
+	return outcomes
+}
+
+// truncateOutput limita la salida a max caracteres, sin cortar caracteres de varios bytes
+func truncateOutput(output string, max int) string {
+	output = strings.TrimRight(output, "\n")
+	if max == 0 || utf8.RuneCountInString(output) <= max {
+		return output
+	}
+
+	cut := 0
+	for k := 0; k < max; k++ {
+		_, size := utf8.DecodeRuneInString(output[cut:])
+		cut += size
+	}
+
+	return output[:cut] + "\n... (" + strconv.Itoa(utf8.RuneCountInString(output[cut:])) + " caracteres omitidos)"
+}
+
+func execCmd(c *cli.Context) {
+	stackMap, err := selectContainers(c)
+	if err != nil {
+		util.Log.Fatalln(err)
+	}
+
+	// solo se puede ejecutar un comando en contenedores en ejecucion
+	for stackKey, services := range stackMap {
+		var running []*service.DockerService
+		for _, srv := range services {
+			if srv.CheckState(service.RUNNING) {
+				running = append(running, srv)
+			} else {
+				util.Log.Infof("Se omite el contenedor %s del stack %s porque no esta en ejecucion", srv.ContainerName(), stackKey)
+			}
+		}
+		stackMap[stackKey] = running
+	}
+
+	outcomes := execServices(stackMap, c.Args(), c.String("user"), c.Int("parallel"))
+	if len(outcomes) == 0 {
+		util.Log.Warnln("No se encontraron contenedores")
+		return
+	}
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Stack", "Name", "Exit", "Output"})
+	table.SetAutoWrapText(false)
+	table.SetRowLine(true)
+
+	failed := 0
+	for _, outcome := range outcomes {
+		if outcome.err != nil {
+			failed++
+			table.Append([]string{outcome.stack, outcome.service.ContainerName(), "-", outcome.err.Error()})
+			continue
+		}
+
+		if outcome.result.ExitCode != 0 {
+			failed++
+		}
+
+		output := truncateOutput(outcome.result.Stdout+outcome.result.Stderr, c.Int("max-output"))
+		table.Append([]string{outcome.stack, outcome.service.ContainerName(), strconv.Itoa(outcome.result.ExitCode), output})
+	}
+	table.Render()
+
+	if failed > 0 {
+		util.Log.Errorf("El comando fallo en %d de %d contenedores", failed, len(outcomes))
+		os.Exit(1)
+	}
+}
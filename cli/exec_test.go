@@ -0,0 +1,31 @@
+package cli
+
+import (
+	"testing"
+	"unicode/utf8"
+)
+
+func TestTruncateOutput(t *testing.T) {
+	cases := []struct {
+		output   string
+		max      int
+		expected string
+	}{
+		{"hola\n", 0, "hola"},
+		{"hola", 4, "hola"},
+		{"hola mundo", 4, "hola\n... (6 caracteres omitidos)"},
+		{"ñandú", 4, "ñand\n... (1 caracteres omitidos)"},
+		{"日本語のテキスト", 3, "日本語\n... (5 caracteres omitidos)"},
+	}
+
+	for _, c := range cases {
+		output := truncateOutput(c.output, c.max)
+		if output != c.expected {
+			t.Errorf("La salida %q limitada a %d se truncó como %q, se esperaba %q", c.output, c.max, output, c.expected)
+		}
+
+		if !utf8.ValidString(output) {
+			t.Errorf("La salida %q limitada a %d no es UTF-8 valido", c.output, c.max)
+		}
+	}
+}
@@ -1,49 +1,68 @@
 package cli
 
 import (
-	"os"
-	"strconv"
+	"errors"
+	"fmt"
+	"strings"
 
+	"github.com/ch3lo/yale/cluster"
 	"github.com/ch3lo/yale/util"
 	"github.com/codegangsta/cli"
-	"github.com/olekukonko/tablewriter"
 )
 
 func filterFlags() []cli.Flag {
-	return []cli.Flag{
+	flags := []cli.Flag{
 		cli.StringFlag{
 			Name:  "image",
-			Value: ".*",
+			Usage: "Atajo para el requisito image_name=IMAGE",
 		},
 		cli.StringFlag{
 			Name:  "tag",
-			Value: ".*",
+			Usage: "Atajo para el requisito image_tag=TAG",
 		},
 	}
+
+	return append(flags, outputFlags()...)
 }
 
-func filterCmd(c *cli.Context) {
-	data := [][]string{}
-	stackMap, err := stackManager.Tagged(c.String("image"), c.String("tag"))
-	if err != nil {
-		util.Log.Fatalln(err)
+// filterSelector une el selector entregado como argumento con los atajos de los flags
+func filterSelector(c *cli.Context) (*cluster.Selector, error) {
+	var requirements []string
+	if expr := strings.TrimSpace(strings.Join(c.Args(), " ")); expr != "" {
+		requirements = append(requirements, expr)
+	}
+
+	if c.String("image") != "" {
+		requirements = append(requirements, "image_name="+c.String("image"))
 	}
 
-	for stackKey, containers := range stackMap {
-		for _, c := range containers {
-			var ports string
-			for key, val := range c.PublicPorts() {
-				ports = ports + strconv.FormatInt(val, 10) + "->" + strconv.FormatInt(key, 10) + " "
-			}
-			data = append(data, []string{stackKey, c.ContainerSwarmNode(), c.ContainerName(), c.ContainerImageName(), c.ContainerState(), ports})
-		}
+	if c.String("tag") != "" {
+		requirements = append(requirements, "image_tag="+c.String("tag"))
 	}
 
-	table := tablewriter.NewWriter(os.Stdout)
-	table.SetHeader([]string{"Stack", "Node", "Name", "Image", "Status", "Ports"})
+	if len(requirements) == 0 {
+		return nil, errors.New("Se debe indicar un selector. Por ejemplo: yale filter 'image_name=foo,image_tag!=1.2-*,state in (running,paused)'")
+	}
 
-	for _, v := range data {
-		table.Append(v)
+	return cluster.ParseSelector(strings.Join(requirements, ","))
+}
+
+func filterBefore(c *cli.Context) error {
+	if _, err := filterSelector(c); err != nil {
+		return errors.New(fmt.Sprintf("Selector invalido: %s", err))
+	}
+
+	return outputBefore(c)
+}
+
+func filterCmd(c *cli.Context) {
+	selector, _ := filterSelector(c)
+	stackMap, err := stackManager.Select(selector)
+	if err != nil {
+		util.Log.Fatalln(err)
+	}
+
+	if err := printContainers(c, stackMap, nil); err != nil {
+		util.Log.Fatalln(err)
 	}
-	table.Render()
 }
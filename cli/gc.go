@@ -0,0 +1,144 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/ch3lo/yale/cluster"
+	"github.com/ch3lo/yale/util"
+	"github.com/codegangsta/cli"
+	"github.com/olekukonko/tablewriter"
+)
+
+func gcFlags() []cli.Flag {
+	return []cli.Flag{
+		cli.IntFlag{
+			Name:  "keep",
+			Value: 3,
+			Usage: "Cantidad de tags mas recientes que se conservan por imagen. El tag desplegado actualmente nunca se remueve",
+		},
+		cli.StringFlag{
+			Name:  "image",
+			Usage: "Limita la recoleccion a la imagen indicada. Por defecto se consideran todas las imagenes desplegadas con yale",
+		},
+		cli.BoolFlag{
+			Name:  "dangling",
+			Usage: "Remueve tambien las imagenes sin tag del host, incluidas las que no fueron desplegadas con yale",
+		},
+		cli.BoolFlag{
+			Name:  "dry-run",
+			Usage: "Lista lo que se removeria sin remover nada",
+		},
+		cli.BoolFlag{
+			Name:  "yes, y",
+			Usage: "Remueve sin solicitar confirmacion",
+		},
+	}
+}
+
+func gcBefore(c *cli.Context) error {
+	if c.Int("keep") < 0 {
+		return errors.New("Valor del parámetro keep invalido")
+	}
+
+	return nil
+}
+
+func gcConfig(c *cli.Context) cluster.GCConfig {
+	return cluster.GCConfig{
+		Keep:      c.Int("keep"),
+		ImageName: c.String("image"),
+		Dangling:  c.Bool("dangling"),
+		DryRun:    c.Bool("dry-run"),
+	}
+}
+
+// renderGarbage muestra los elementos de la recoleccion de basura de cada stack y entrega la
+// cantidad de stacks o elementos con errores
+func renderGarbage(results map[string]cluster.GCResult, removedLabel string) int {
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Stack", "Type", "Name", "Image", "Result"})
+
+	failed := 0
+	for _, stackKey := range stackManager.StackKeys() {
+		result := results[stackKey]
+		if result.Err != nil {
+			table.Append([]string{stackKey, "", "", "", result.Err.Error()})
+			failed++
+			continue
+		}
+
+		for _, item := range result.Removed {
+			outcome := removedLabel
+			if item.Err != nil {
+				outcome = item.Err.Error()
+				failed++
+			}
+			table.Append([]string{stackKey, item.Kind, item.Name, item.Image, outcome})
+		}
+
+		for _, item := range result.Skipped {
+			table.Append([]string{stackKey, item.Kind, item.Name, item.Image, "conservado (" + item.Reason + ")"})
+		}
+	}
+	table.Render()
+
+	return failed
+}
+
+// collectDeployGarbage es el hook posterior al despliegue. Sus errores no afectan el resultado del despliegue
+func collectDeployGarbage(sm *cluster.StackManager, image string, keep int) {
+	util.Log.Infof("Iniciando la recoleccion de basura de la imagen %s conservando %d tags", image, keep)
+	results := sm.CollectGarbage(cluster.GCConfig{Keep: keep, ImageName: image})
+	for _, stackKey := range sm.StackKeys() {
+		result := results[stackKey]
+		if result.Err != nil {
+			util.Log.Warnf("No se pudo realizar la recoleccion de basura del stack %s: %s", stackKey, result.Err)
+			continue
+		}
+
+		for _, item := range result.Removed {
+			if item.Err != nil {
+				util.Log.Warnf("No se pudo remover %s del stack %s: %s", item.Name, stackKey, item.Err)
+			}
+		}
+	}
+}
+
+func gcCmd(c *cli.Context) {
+	config := gcConfig(c)
+
+	plan := config
+	plan.DryRun = true
+	results := stackManager.CollectGarbage(plan)
+
+	total := 0
+	for _, result := range results {
+		total += len(result.Removed)
+	}
+
+	if failed := renderGarbage(results, "por remover"); failed > 0 {
+		util.Log.Errorf("No se pudo calcular la recoleccion de basura en %d stacks", failed)
+		os.Exit(1)
+	}
+
+	if config.DryRun {
+		return
+	}
+
+	if total == 0 {
+		fmt.Println("No hay contenedores ni imagenes para remover")
+		return
+	}
+
+	if !c.Bool("yes") && !confirm(fmt.Sprintf("¿Remover %d contenedores e imagenes?", total)) {
+		fmt.Println("Recoleccion de basura cancelada")
+		return
+	}
+
+	if failed := renderGarbage(stackManager.CollectGarbage(config), "removido"); failed > 0 {
+		util.Log.Errorf("No se pudieron remover %d contenedores o imagenes", failed)
+		os.Exit(1)
+	}
+}
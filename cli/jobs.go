@@ -0,0 +1,347 @@
+package cli
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// JOB_QUEUED     El trabajo espera que termine la operacion en curso de su ambiente
+// JOB_RUNNING    El trabajo esta en ejecucion
+// JOB_SUCCEEDED  El trabajo terminó correctamente
+// JOB_PARTIAL    El despliegue terminó con stacks fallidos aceptados por la politica de fallo
+// JOB_FAILED     El trabajo falló
+// JOB_CANCELED   El trabajo se canceló por el API, por su tiempo maximo o por el termino del servidor
+type jobStatus int
+
+const (
+	JOB_QUEUED jobStatus = 1 + iota
+	JOB_RUNNING
+	JOB_SUCCEEDED
+	JOB_PARTIAL
+	JOB_FAILED
+	JOB_CANCELED
+)
+
+var jobStatusNames = [...]string{
+	"JOB_QUEUED",
+	"JOB_RUNNING",
+	"JOB_SUCCEEDED",
+	"JOB_PARTIAL",
+	"JOB_FAILED",
+	"JOB_CANCELED",
+}
+
+func (s jobStatus) String() string {
+	return jobStatusNames[s-1]
+}
+
+func (s jobStatus) finished() bool {
+	return s > JOB_RUNNING
+}
+
+// jobKeepAlive es el intervalo de los comentarios que mantienen abierta la conexion de eventos
+const jobKeepAlive = 15 * time.Second
+
+// jobEvent es un evento del trabajo tal como se envia por Server-Sent Events. id es su posicion
+// en el trabajo, lo que permite retomar la conexion con el header Last-Event-ID
+type jobEvent struct {
+	id   int
+	name string
+	data []byte
+}
+
+// jobView es la representacion JSON de un trabajo
+type jobView struct {
+	Id          string      `json:"id"`
+	Kind        string      `json:"kind"`
+	Environment string      `json:"environment"`
+	Status      string      `json:"status"`
+	CreatedAt   time.Time   `json:"created_at"`
+	StartedAt   *time.Time  `json:"started_at,omitempty"`
+	FinishedAt  *time.Time  `json:"finished_at,omitempty"`
+	Error       string      `json:"error,omitempty"`
+	Result      interface{} `json:"result,omitempty"`
+}
+
+// jobRunner ejecuta la operacion de un trabajo y entrega su resultado, estado final y error
+type jobRunner func(ctx context.Context) (interface{}, jobStatus, error)
+
+// job es una operacion asincrona del API. Sus eventos se guardan para que los clientes que se
+// conectan tarde reciban el progreso completo
+type job struct {
+	id          string
+	kind        string
+	environment string
+	created     time.Time
+	run         jobRunner
+	mutex       sync.Mutex
+	status      jobStatus
+	started     time.Time
+	finished    time.Time
+	result      interface{}
+	err         string
+	cancel      func()
+	events      []jobEvent
+	changed     chan struct{}
+}
+
+func newJobId() string {
+	id := make([]byte, 8)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+func newJob(kind string, environment string, run jobRunner) *job {
+	j := &job{
+		id:          newJobId(),
+		kind:        kind,
+		environment: environment,
+		created:     time.Now(),
+		run:         run,
+		status:      JOB_QUEUED,
+		changed:     make(chan struct{}),
+	}
+	j.publishLocked("status", j.viewLocked())
+
+	return j
+}
+
+func (j *job) viewLocked() jobView {
+	view := jobView{
+		Id:          j.id,
+		Kind:        j.kind,
+		Environment: j.environment,
+		Status:      j.status.String(),
+		CreatedAt:   j.created.UTC(),
+		Error:       j.err,
+		Result:      j.result,
+	}
+
+	if !j.started.IsZero() {
+		started := j.started.UTC()
+		view.StartedAt = &started
+	}
+
+	if !j.finished.IsZero() {
+		finished := j.finished.UTC()
+		view.FinishedAt = &finished
+	}
+
+	return view
+}
+
+func (j *job) view() jobView {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	return j.viewLocked()
+}
+
+func (j *job) getStatus() jobStatus {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	return j.status
+}
+
+// publishLocked agrega un evento y despierta a los clientes conectados
+func (j *job) publishLocked(name string, value interface{}) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		data, _ = json.Marshal(map[string]string{"error": err.Error()})
+	}
+
+	j.events = append(j.events, jobEvent{id: len(j.events), name: name, data: data})
+	close(j.changed)
+	j.changed = make(chan struct{})
+}
+
+func (j *job) publish(name string, value interface{}) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	j.publishLocked(name, value)
+}
+
+// start marca el trabajo en ejecucion. Entrega false si se canceló mientras estaba en cola
+func (j *job) start(cancel func()) bool {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	if j.status != JOB_QUEUED {
+		return false
+	}
+
+	j.status = JOB_RUNNING
+	j.started = time.Now()
+	j.cancel = cancel
+	j.publishLocked("status", j.viewLocked())
+
+	return true
+}
+
+// finish registra el resultado del trabajo. Se ignora si el trabajo ya terminó
+func (j *job) finish(status jobStatus, result interface{}, err error) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	if j.status.finished() {
+		return
+	}
+
+	j.status = status
+	j.finished = time.Now()
+	j.result = result
+	j.cancel = nil
+	if err != nil {
+		j.err = err.Error()
+	}
+	j.publishLocked("status", j.viewLocked())
+}
+
+// requestCancel cancela el trabajo. Uno en cola termina de inmediato y uno en ejecucion cancela
+// su contexto, por lo que termina al completar el rollback. Entrega false si ya habia terminado
+func (j *job) requestCancel() bool {
+	j.mutex.Lock()
+	status, cancel := j.status, j.cancel
+	j.mutex.Unlock()
+
+	switch {
+	case status == JOB_QUEUED:
+		j.finish(JOB_CANCELED, nil, errors.New("Trabajo cancelado antes de iniciar"))
+	case status == JOB_RUNNING && cancel != nil:
+		cancel()
+	default:
+		return false
+	}
+
+	return true
+}
+
+// eventsFrom entrega los eventos desde la posicion entregada, el canal que se cierra con el
+// proximo evento y si el trabajo ya terminó
+func (j *job) eventsFrom(next int) ([]jobEvent, <-chan struct{}, bool) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	var events []jobEvent
+	if next < len(j.events) {
+		events = j.events[next:]
+	}
+
+	return events, j.changed, j.status.finished()
+}
+
+// stream envia los eventos del trabajo con Server-Sent Events hasta que termina o el cliente se
+// desconecta. El ultimo evento es siempre el estado final del trabajo
+func (j *job) stream(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, errors.New("La conexion no soporta streaming"))
+		return
+	}
+
+	next := 0
+	if last, err := strconv.Atoi(r.Header.Get("Last-Event-ID")); err == nil {
+		next = last + 1
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	keepAlive := time.NewTicker(jobKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		events, changed, finished := j.eventsFrom(next)
+		for _, event := range events {
+			fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.id, event.name, event.data)
+			next = event.id + 1
+		}
+		flusher.Flush()
+
+		if finished {
+			return
+		}
+
+		select {
+		case <-changed:
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// jobStore guarda los trabajos del servidor. Se conservan como maximo history trabajos
+// terminados, descartando los mas antiguos
+type jobStore struct {
+	mutex   sync.Mutex
+	jobs    map[string]*job
+	order   []*job
+	history int
+}
+
+func newJobStore(history int) *jobStore {
+	return &jobStore{jobs: make(map[string]*job), history: history}
+}
+
+func (s *jobStore) add(j *job) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.jobs[j.id] = j
+	s.order = append(s.order, j)
+
+	finished := 0
+	for _, stored := range s.order {
+		if stored.getStatus().finished() {
+			finished++
+		}
+	}
+
+	var kept []*job
+	for _, stored := range s.order {
+		if finished > s.history && stored.getStatus().finished() {
+			delete(s.jobs, stored.id)
+			finished--
+			continue
+		}
+		kept = append(kept, stored)
+	}
+	s.order = kept
+}
+
+func (s *jobStore) get(id string) (*job, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	j, ok := s.jobs[id]
+	return j, ok
+}
+
+// list entrega los trabajos del ambiente entregado, o de todos si esta vacio, del mas reciente al mas antiguo
+func (s *jobStore) list(environment string) []jobView {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	views := []jobView{}
+	for k := len(s.order) - 1; k >= 0; k-- {
+		if environment == "" || s.order[k].environment == environment {
+			views = append(views, s.order[k].view())
+		}
+	}
+
+	return views
+}
@@ -2,15 +2,15 @@ package cli
 
 import (
 	"os"
-	"strconv"
+	"sort"
 
+	"github.com/ch3lo/yale/service"
 	"github.com/ch3lo/yale/util"
 	"github.com/codegangsta/cli"
-	"github.com/olekukonko/tablewriter"
 )
 
 func listFlags() []cli.Flag {
-	return []cli.Flag{
+	flags := []cli.Flag{
 		cli.StringFlag{
 			Name:  "image-filter, if",
 			Value: ".*",
@@ -32,30 +32,54 @@ func listFlags() []cli.Flag {
 			Usage: "Expresion regultar para filtrar contenedores por el estado del contenedor",
 		},
 	}
+
+	flags = append(flags, outputFlags()...)
+	return append(flags, watchFlags()...)
 }
 
-func listCmd(c *cli.Context) {
-	data := [][]string{}
+func sortedStackKeys(stackMap map[string][]*service.DockerService) []string {
+	var keys []string
+	for k := range stackMap {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// renderContainers imprime una tabla con los contenedores de cada stack
+func renderContainers(stackMap map[string][]*service.DockerService) {
+	renderViews(os.Stdout, containerViews(stackMap, "stack"), "table", "", nil)
+}
+
+func listContainers(c *cli.Context, tracker *changeTracker) error {
 	stackMap, err := stackManager.SearchContainers(c.String("if"), c.String("tf"), c.String("cf"))
 	if err != nil {
-		util.Log.Fatalln(err)
+		return err
 	}
 
-	for stackKey, containers := range stackMap {
-		for _, c := range containers {
-			var ports string
-			for key, val := range c.PublicPorts() {
-				ports = ports + strconv.FormatInt(val, 10) + "->" + strconv.FormatInt(key, 10) + " "
-			}
-			data = append(data, []string{stackKey, c.ContainerSwarmNode(), c.ContainerName(), c.ContainerImageName(), c.ContainerState(), ports})
-		}
+	return printContainers(c, stackMap, tracker)
+}
+
+func listBefore(c *cli.Context) error {
+	if err := outputBefore(c); err != nil {
+		return err
 	}
 
-	table := tablewriter.NewWriter(os.Stdout)
-	table.SetHeader([]string{"Stack", "Node", "Name", "Image", "Status", "Ports"})
+	if err := checkFilters(c.String("if"), c.String("tf"), c.String("cf")); err != nil {
+		return err
+	}
+
+	return watchBefore(c)
+}
 
-	for _, v := range data {
-		table.Append(v)
+func listCmd(c *cli.Context) {
+	if c.Bool("watch") {
+		watch(c, func(tracker *changeTracker) error { return listContainers(c, tracker) })
+		return
+	}
+
+	if err := listContainers(c, nil); err != nil {
+		util.Log.Fatalln(err)
 	}
-	table.Render()
 }
@@ -0,0 +1,191 @@
+package cli
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ch3lo/yale/helper"
+	"github.com/ch3lo/yale/service"
+	"github.com/ch3lo/yale/util"
+	"github.com/codegangsta/cli"
+)
+
+func logsFlags() []cli.Flag {
+	flags := []cli.Flag{
+		cli.BoolFlag{
+			Name:  "follow, f",
+			Usage: "Sigue los logs de los contenedores",
+		},
+		cli.StringFlag{
+			Name:  "since",
+			Usage: "Muestra los logs desde un instante RFC3339, un timestamp unix o una duracion relativa. Por ejemplo 10m",
+		},
+		cli.StringFlag{
+			Name:  "tail",
+			Value: "all",
+			Usage: "Cantidad de lineas finales que se muestran de cada contenedor",
+		},
+		cli.BoolFlag{
+			Name:  "timestamps, t",
+			Usage: "Muestra el timestamp de cada linea",
+		},
+		cli.StringFlag{
+			Name:  "grep",
+			Usage: "Expresion regular que deben cumplir las lineas que se muestran",
+		},
+		cli.BoolFlag{
+			Name:  "invert",
+			Usage: "Muestra las lineas que no cumplen con la expresion regular de grep",
+		},
+	}
+
+	return append(selectionFlags(), flags...)
+}
+
+// parseSince convierte el valor del flag since a segundos unix
+func parseSince(since string, now time.Time) (int64, error) {
+	if since == "" {
+		return 0, nil
+	}
+
+	if d, err := time.ParseDuration(since); err == nil {
+		return now.Add(-d).Unix(), nil
+	}
+
+	if t, err := time.Parse(time.RFC3339, since); err == nil {
+		return t.Unix(), nil
+	}
+
+	if unix, err := strconv.ParseInt(since, 10, 64); err == nil {
+		return unix, nil
+	}
+
+	return 0, errors.New("Valor del parámetro since invalido")
+}
+
+func logsBefore(c *cli.Context) error {
+	if err := requireSelection(c); err != nil {
+		return err
+	}
+
+	if _, err := parseSince(c.String("since"), time.Now()); err != nil {
+		return err
+	}
+
+	if tail := c.String("tail"); tail != "all" {
+		if n, err := strconv.Atoi(tail); err != nil || n < 0 {
+			return errors.New("Valor del parámetro tail invalido")
+		}
+	}
+
+	if _, err := regexp.Compile(c.String("grep")); err != nil {
+		return errors.New(fmt.Sprintf("Valor del parámetro grep invalido: %s", err))
+	}
+
+	return nil
+}
+
+// lineWriter escribe cada linea completa en la salida con el prefijo del contenedor, descartando
+// las lineas que no cumplen el filtro. La salida se comparte entre contenedores, por lo que
+// cada linea se escribe de una vez
+type lineWriter struct {
+	prefix string
+	filter *regexp.Regexp
+	invert bool
+	output io.Writer
+	mutex  *sync.Mutex
+	buf    []byte
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for {
+		k := bytes.IndexByte(w.buf, '\n')
+		if k < 0 {
+			break
+		}
+		w.writeLine(w.buf[:k])
+		w.buf = w.buf[k+1:]
+	}
+
+	return len(p), nil
+}
+
+// Flush escribe la ultima linea si no termina en salto de linea
+func (w *lineWriter) Flush() {
+	if len(w.buf) > 0 {
+		w.writeLine(w.buf)
+		w.buf = nil
+	}
+}
+
+func (w *lineWriter) writeLine(line []byte) {
+	if w.filter != nil && w.filter.Match(line) == w.invert {
+		return
+	}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	fmt.Fprintf(w.output, "%s %s\n", w.prefix, line)
+}
+
+func logsCmd(c *cli.Context) {
+	stackMap, err := selectContainers(c)
+	if err != nil {
+		util.Log.Fatalln(err)
+	}
+
+	since, _ := parseSince(c.String("since"), time.Now())
+	config := helper.LogsConfig{
+		Follow:     c.Bool("follow"),
+		Since:      since,
+		Tail:       c.String("tail"),
+		Timestamps: c.Bool("timestamps"),
+	}
+
+	var filter *regexp.Regexp
+	if c.String("grep") != "" {
+		filter = regexp.MustCompile(c.String("grep"))
+	}
+
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	failed := 0
+
+	for _, stackKey := range sortedStackKeys(stackMap) {
+		for _, srv := range stackMap[stackKey] {
+			prefix := fmt.Sprintf("[%s] %s |", stackKey, srv.ContainerName())
+			stdout := &lineWriter{prefix: prefix, filter: filter, invert: c.Bool("invert"), output: os.Stdout, mutex: &mutex}
+			stderr := &lineWriter{prefix: prefix, filter: filter, invert: c.Bool("invert"), output: os.Stdout, mutex: &mutex}
+
+			wg.Add(1)
+			go func(stackKey string, srv *service.DockerService) {
+				defer wg.Done()
+				err := srv.Logs(config, stdout, stderr)
+				stdout.Flush()
+				stderr.Flush()
+
+				if err != nil {
+					mutex.Lock()
+					failed++
+					fmt.Fprintf(os.Stderr, "[%s] %s: %s\n", stackKey, srv.ContainerName(), err)
+					mutex.Unlock()
+				}
+			}(stackKey, srv)
+		}
+	}
+
+	wg.Wait()
+
+	if failed > 0 {
+		util.Log.Errorf("No se pudieron leer los logs de %d contenedores", failed)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,312 @@
+package cli
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/ch3lo/yale/cluster"
+	"github.com/ch3lo/yale/monitor"
+	"github.com/ch3lo/yale/service"
+	"github.com/ch3lo/yale/util"
+	"github.com/codegangsta/cli"
+	"github.com/pivotal-golang/bytefmt"
+)
+
+type monitorManifest struct {
+	Type     string `json:"type"`
+	Retries  int    `json:"retries"`
+	Request  string `json:"request"`
+	Expected string `json:"expected"`
+}
+
+// stackManifest modifica la configuracion de un stack. Los campos ausentes conservan la
+// configuracion del endpoint, por lo que un 0 o false explicito la reemplaza
+type stackManifest struct {
+	Weight    *int  `json:"weight"`
+	Instances *int  `json:"instances"`
+	Required  *bool `json:"required"`
+}
+
+// deployManifest describe un despliegue en formato JSON.
+// Los flags del comando deploy tienen precedencia sobre los valores del manifiesto.
+type deployManifest struct {
+	ServiceId      string                   `json:"service_id"`
+	Image          string                   `json:"image"`
+	Tag            string                   `json:"tag"`
+	Cpu            int                      `json:"cpu"`
+	Memory         string                   `json:"memory"`
+	LogDriver      string                   `json:"log_driver"`
+	EnvFiles       []string                 `json:"env_files"`
+	Envs           []string                 `json:"envs"`
+	Instances      int                      `json:"instances"`
+	TotalInstances int                      `json:"total_instances"`
+	Tolerance      float64                  `json:"tolerance"`
+	FailurePolicy  string                   `json:"failure_policy"`
+	Timeout        string                   `json:"timeout"`
+	Waves          [][]string               `json:"waves"`
+	WaveSoak       string                   `json:"wave_soak"`
+	WaveApproval   bool                     `json:"wave_approval"`
+	Smoke          monitorManifest          `json:"smoke"`
+	WarmUp         monitorManifest          `json:"warmup"`
+	Stacks         map[string]stackManifest `json:"stacks"`
+	GcKeep         int                      `json:"gc_keep"`
+}
+
+// loadDeployManifest decodifica el manifiesto sobre los valores de manifest. Los campos ausentes
+// en el archivo conservan su valor, por lo que un 0 o false explicito reemplaza al valor previo
+func loadDeployManifest(path string, manifest *deployManifest) error {
+	util.Log.Debugf("Cargando el manifiesto %s", path)
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return json.NewDecoder(file).Decode(manifest)
+}
+
+func mergeString(c *cli.Context, flag string, onlySet bool, value *string) {
+	if !onlySet || c.IsSet(flag) {
+		*value = c.String(flag)
+	}
+}
+
+func mergeInt(c *cli.Context, flag string, onlySet bool, value *int) {
+	if !onlySet || c.IsSet(flag) {
+		*value = c.Int(flag)
+	}
+}
+
+func mergeBool(c *cli.Context, flag string, onlySet bool, value *bool) {
+	if !onlySet || c.IsSet(flag) {
+		*value = c.Bool(flag)
+	}
+}
+
+func mergeFloat64(c *cli.Context, flag string, onlySet bool, value *float64) {
+	if !onlySet || c.IsSet(flag) {
+		*value = c.Float64(flag)
+	}
+}
+
+// mergeFlags asigna los flags del comando deploy al manifiesto. Con onlySet se asignan solo
+// los flags entregados explicitamente, de lo contrario tambien sus valores por defecto
+func (m *deployManifest) mergeFlags(c *cli.Context, onlySet bool) {
+	mergeString(c, "service-id", onlySet, &m.ServiceId)
+	mergeString(c, "image", onlySet, &m.Image)
+	mergeString(c, "tag", onlySet, &m.Tag)
+	mergeInt(c, "cpu", onlySet, &m.Cpu)
+	mergeString(c, "memory", onlySet, &m.Memory)
+	mergeString(c, "log-driver", onlySet, &m.LogDriver)
+	mergeInt(c, "instances", onlySet, &m.Instances)
+	mergeInt(c, "total-instances", onlySet, &m.TotalInstances)
+	mergeFloat64(c, "tolerance", onlySet, &m.Tolerance)
+	mergeString(c, "failure-policy", onlySet, &m.FailurePolicy)
+	mergeString(c, "timeout", onlySet, &m.Timeout)
+	mergeString(c, "wave-soak", onlySet, &m.WaveSoak)
+	mergeBool(c, "wave-approval", onlySet, &m.WaveApproval)
+	mergeInt(c, "smoke-retries", onlySet, &m.Smoke.Retries)
+	mergeString(c, "smoke-type", onlySet, &m.Smoke.Type)
+	mergeString(c, "smoke-request", onlySet, &m.Smoke.Request)
+	mergeString(c, "smoke-expected", onlySet, &m.Smoke.Expected)
+	mergeString(c, "warmup-request", onlySet, &m.WarmUp.Request)
+	mergeString(c, "warmup-expected", onlySet, &m.WarmUp.Expected)
+	mergeInt(c, "gc-keep", onlySet, &m.GcKeep)
+}
+
+// deployManifestFromContext obtiene el manifiesto del flag manifest, si existe, y lo
+// completa con los flags del comando deploy. Los valores por defecto de los flags se asignan
+// antes de leer el manifiesto, de manera que solo los flags explicitos tienen precedencia
+func deployManifestFromContext(c *cli.Context) (*deployManifest, error) {
+	manifest := new(deployManifest)
+	manifest.mergeFlags(c, false)
+	if c.String("manifest") != "" {
+		if err := loadDeployManifest(c.String("manifest"), manifest); err != nil {
+			return nil, err
+		}
+		manifest.mergeFlags(c, true)
+	}
+
+	manifest.EnvFiles = append(manifest.EnvFiles, c.StringSlice("env-file")...)
+	manifest.Envs = append(manifest.Envs, c.StringSlice("env")...)
+	if c.IsSet("wave") {
+		manifest.Waves = nil
+		for _, wave := range c.StringSlice("wave") {
+			var stacks []string
+			for _, stackKey := range strings.Split(wave, ",") {
+				stacks = append(stacks, strings.TrimSpace(stackKey))
+			}
+			manifest.Waves = append(manifest.Waves, stacks)
+		}
+	}
+
+	return manifest, nil
+}
+
+// setDefaults asigna los valores por defecto de los flags del comando deploy. Se utiliza con los
+// manifiestos que no se reciben por linea de comandos y se aplica antes de decodificarlos, de
+// manera que un 0 explicito en el manifiesto reemplaza al valor por defecto
+func (m *deployManifest) setDefaults() {
+	m.LogDriver = "syslog"
+	m.Tolerance = 0.5
+	m.FailurePolicy = "all"
+	m.Smoke.Retries = 10
+	m.Smoke.Type = "http"
+	m.Smoke.Expected = ".*"
+	m.WarmUp.Expected = ".*"
+}
+
+// validate verifica los valores del manifiesto comunes a los comandos que despliegan contenedores
+func (m *deployManifest) validate() error {
+	if m.Smoke.Request == "" {
+		return errors.New("El endpoint de Smoke Test esta vacio")
+	}
+
+	if m.Memory != "" {
+		if _, err := bytefmt.ToMegabytes(m.Memory); err != nil {
+			return errors.New("Valor del parámetro memory invalido")
+		}
+
+	}
+
+	if _, err := regexp.Compile(m.Smoke.Expected); err != nil {
+		return errors.New(fmt.Sprintf("Valor del parámetro smoke-expected invalido: %s", err))
+	}
+
+	if _, err := regexp.Compile(m.WarmUp.Expected); err != nil {
+		return errors.New(fmt.Sprintf("Valor del parámetro warmup-expected invalido: %s", err))
+	}
+
+	for _, file := range m.EnvFiles {
+		if err := util.FileExists(file); err != nil {
+			return errors.New(fmt.Sprintf("El archivo %s con variables de entorno no existe", file))
+		}
+	}
+
+	if _, err := cluster.GetFailurePolicy(m.FailurePolicy); err != nil {
+		return err
+	}
+
+	if m.WaveSoak != "" {
+		if _, err := time.ParseDuration(m.WaveSoak); err != nil {
+			return errors.New("Valor del parámetro wave-soak invalido")
+		}
+	}
+
+	if m.Timeout != "" {
+		if _, err := time.ParseDuration(m.Timeout); err != nil {
+			return errors.New("Valor del parámetro timeout invalido")
+		}
+	}
+
+	if m.GcKeep < 0 {
+		return errors.New("Valor del parámetro gc-keep invalido")
+	}
+
+	return nil
+}
+
+func (m *deployManifest) configureStacks(sm *cluster.StackManager) error {
+	configs := sm.StackConfigs()
+	for stackKey, stack := range m.Stacks {
+		stackConfig := configs[stackKey]
+		if stack.Weight != nil {
+			stackConfig.Weight = *stack.Weight
+		}
+		if stack.Instances != nil {
+			stackConfig.Instances = stack.Instances
+		}
+		if stack.Required != nil {
+			stackConfig.Required = *stack.Required
+		}
+
+		if err := sm.ConfigureStack(stackKey, stackConfig); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m *deployManifest) serviceConfig() (service.ServiceConfig, error) {
+	envs, err := util.ParseMultiFileLinesToArray(m.EnvFiles)
+	if err != nil {
+		return service.ServiceConfig{}, err
+	}
+
+	for _, v := range m.Envs {
+		envs = append(envs, v)
+	}
+
+	serviceConfig := service.ServiceConfig{
+		ServiceId: m.ServiceId,
+		CpuShares: m.Cpu,
+		Envs:      envs,
+		ImageName: m.Image,
+		LogDriver: m.LogDriver,
+		Tag:       m.Tag,
+	}
+
+	if m.Memory != "" {
+		megabytes, _ := bytefmt.ToMegabytes(m.Memory)
+		memory := megabytes * 1024 * 1024
+		serviceConfig.Memory = int64(memory)
+	}
+
+	return serviceConfig, nil
+}
+
+func (m *deployManifest) smokeConfig() monitor.MonitorConfig {
+	return monitor.MonitorConfig{
+		Retries:  m.Smoke.Retries,
+		Type:     monitor.GetMonitor(m.Smoke.Type),
+		Request:  m.Smoke.Request,
+		Expected: m.Smoke.Expected,
+	}
+}
+
+func (m *deployManifest) warmUpConfig() monitor.MonitorConfig {
+	return monitor.MonitorConfig{
+		Retries:  1,
+		Type:     monitor.HTTP,
+		Request:  m.WarmUp.Request,
+		Expected: m.WarmUp.Expected,
+	}
+}
+
+func (m *deployManifest) deployConfig() cluster.DeployConfig {
+	policy, _ := cluster.GetFailurePolicy(m.FailurePolicy)
+	deployConfig := cluster.DeployConfig{
+		Instances: cluster.InstancesConfig{
+			PerStack: m.Instances,
+			Total:    m.TotalInstances,
+		},
+		Tolerance: m.Tolerance,
+		Policy:    policy,
+		Waves:     cluster.WaveConfig{Waves: m.Waves},
+	}
+
+	if m.WaveSoak != "" {
+		deployConfig.Waves.Soak, _ = time.ParseDuration(m.WaveSoak)
+	}
+
+	if m.WaveApproval {
+		deployConfig.Waves.Approval = approveWave
+	}
+
+	return deployConfig
+}
+
+// scaleConfig es la configuracion del escalamiento. Los stacks sin cantidad de instancias
+// en el manifiesto mantienen sus instancias actuales
+func (m *deployManifest) scaleConfig() cluster.DeployConfig {
+	deployConfig := m.deployConfig()
+	deployConfig.Instances.Keep = true
+
+	return deployConfig
+}
@@ -0,0 +1,95 @@
+package cli
+
+import (
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/ch3lo/yale/cluster"
+	"github.com/ch3lo/yale/helper"
+	"github.com/ch3lo/yale/helper/dockertest"
+	"github.com/codegangsta/cli"
+)
+
+// newDeployContext crea el contexto del comando deploy con los argumentos entregados
+func newDeployContext(t *testing.T, args ...string) *cli.Context {
+	set := flag.NewFlagSet("deploy", flag.ContinueOnError)
+	for _, f := range deployFlags() {
+		f.Apply(set)
+	}
+
+	if err := set.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+
+	return cli.NewContext(nil, set, nil)
+}
+
+func TestDeployManifestFromContext(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "manifest.json")
+	data := `{"image": "app", "instances": 0, "tolerance": 0, "smoke": {"retries": 0}}`
+	if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name      string
+		args      []string
+		instances int
+		tolerance float64
+		retries   int
+		image     string
+	}{
+		{"sin manifiesto", nil, 1, 0.5, 10, ""},
+		{"ceros explicitos del manifiesto", []string{"--manifest", path}, 0, 0, 0, "app"},
+		{"flags explicitos", []string{"--manifest", path, "--instances", "3", "--tolerance", "0.2", "--image", "otra"}, 3, 0.2, 0, "otra"},
+	}
+
+	for _, c := range cases {
+		manifest, err := deployManifestFromContext(newDeployContext(t, c.args...))
+		if err != nil {
+			t.Fatalf("%s: %s", c.name, err)
+		}
+
+		if manifest.Instances != c.instances || manifest.Tolerance != c.tolerance || manifest.Smoke.Retries != c.retries || manifest.Image != c.image {
+			t.Errorf("%s: manifiesto inesperado %#v", c.name, manifest)
+		}
+	}
+}
+
+func TestConfigureStacksAppliesExplicitZeros(t *testing.T) {
+	sm := cluster.NewStackManager()
+	instances := 2
+	config := cluster.StackConfig{Weight: 2, Instances: &instances, Required: true}
+	if err := sm.AppendStack("a", helper.NewDockerHelperFromClient(dockertest.NewEngine("a"), ""), config); err != nil {
+		t.Fatal(err)
+	}
+	if err := sm.AppendStack("b", helper.NewDockerHelperFromClient(dockertest.NewEngine("b"), ""), config); err != nil {
+		t.Fatal(err)
+	}
+
+	var manifest deployManifest
+	data := `{"stacks": {"a": {"weight": 0, "instances": 0, "required": false}}}`
+	if err := json.Unmarshal([]byte(data), &manifest); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := manifest.configureStacks(sm); err != nil {
+		t.Fatal(err)
+	}
+
+	configs := sm.StackConfigs()
+	if a := configs["a"]; a.Weight != 0 || a.Instances == nil || *a.Instances != 0 || a.Required {
+		t.Errorf("Configuracion inesperada del stack a %+v", a)
+	}
+
+	if b := configs["b"]; b.Weight != 2 || b.Instances == nil || *b.Instances != 2 || !b.Required {
+		t.Errorf("Se modificó la configuracion del stack b %+v", b)
+	}
+
+	if targets := sm.Targets(cluster.InstancesConfig{PerStack: 1}); targets["a"] != 0 || targets["b"] != 2 {
+		t.Errorf("Objetivos inesperados %v", targets)
+	}
+}
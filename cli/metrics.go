@@ -0,0 +1,50 @@
+package cli
+
+import (
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/ch3lo/yale/metrics"
+	"github.com/ch3lo/yale/util"
+)
+
+// metricsPushTimeout es el tiempo maximo del envio de las metricas al Pushgateway
+const metricsPushTimeout = 10 * time.Second
+
+var (
+	metricsGateway string
+	metricsJob     string
+)
+
+// pushMetrics publica los gauges de la ejecucion en el Pushgateway, si se configuró uno. Cada envio
+// reemplaza el grupo, por lo que los contadores nunca superarian 1; los gauges describen el ultimo
+// despliegue (momento, resultado y duracion). Se agrupan por servicio y host para que las
+// ejecuciones de otros servicios no los reemplacen
+func pushMetrics(image string) {
+	if metricsGateway == "" {
+		return
+	}
+
+	hostname, _ := os.Hostname()
+	grouping := map[string]string{"service": image, "instance": hostname}
+	if err := metrics.Default.Gauges().Push(metricsGateway, metricsJob, grouping, metricsPushTimeout); err != nil {
+		util.Log.Errorf("No se pudieron publicar las metricas en el Pushgateway %s: %s", metricsGateway, err)
+		return
+	}
+
+	util.Log.Debugf("Se publicaron las metricas en el Pushgateway %s", metricsGateway)
+}
+
+// serveMetrics expone las metricas en /metrics de la direccion entregada, para los procesos de larga duracion
+func serveMetrics(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Default.Handler())
+
+	util.Log.Infof("Exponiendo las metricas en http://%s/metrics", addr)
+	go func() {
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			util.Log.Errorf("No se pudieron exponer las metricas en %s: %s", addr, err)
+		}
+	}()
+}
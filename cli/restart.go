@@ -0,0 +1,112 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"os"
+
+	"github.com/ch3lo/yale/cluster"
+	"github.com/ch3lo/yale/monitor"
+	"github.com/ch3lo/yale/service"
+	"github.com/ch3lo/yale/util"
+	"github.com/codegangsta/cli"
+	"github.com/olekukonko/tablewriter"
+)
+
+func restartFlags() []cli.Flag {
+	flags := []cli.Flag{
+		cli.IntFlag{
+			Name:  "batch-size",
+			Value: 1,
+			Usage: "Cantidad de contenedores que se reinician a la vez en cada stack",
+		},
+		cli.IntFlag{
+			Name:  "stop-timeout",
+			Value: 10,
+			Usage: "Segundos que tiene el contenedor para detenerse antes de ser terminado",
+		},
+	}
+
+	flags = append(selectionFlags(), flags...)
+	return append(flags, pickFlags(deployFlags(),
+		"timeout",
+		"smoke-retries",
+		"smoke-type",
+		"smoke-request",
+		"smoke-expected",
+	)...)
+}
+
+func restartBefore(c *cli.Context) error {
+	if err := requireSelection(c); err != nil {
+		return err
+	}
+
+	if c.String("smoke-request") == "" {
+		return errors.New("El endpoint de Smoke Test esta vacio")
+	}
+
+	if c.Int("batch-size") < 1 {
+		return errors.New("Valor del parámetro batch-size invalido")
+	}
+
+	if c.Int("stop-timeout") < 0 {
+		return errors.New("Valor del parámetro stop-timeout invalido")
+	}
+
+	return nil
+}
+
+func restartCmd(c *cli.Context) {
+	if _, err := selectContainers(c); err != nil {
+		util.Log.Fatalln(err)
+	}
+
+	config := cluster.RestartConfig{
+		BatchSize: c.Int("batch-size"),
+		Timeout:   uint(c.Int("stop-timeout")),
+		Smoke: monitor.MonitorConfig{
+			Retries:  c.Int("smoke-retries"),
+			Type:     monitor.GetMonitor(c.String("smoke-type")),
+			Request:  c.String("smoke-request"),
+			Expected: c.String("smoke-expected"),
+		},
+	}
+
+	ctx, cancel := deployContext(context.Background(), c.String("timeout"))
+	defer cancel()
+
+	handleDeploySigTerm(cancel)
+	results := stackManager.RollingRestart(ctx, config)
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Stack", "Name", "Result"})
+
+	failed := false
+	for _, stackKey := range stackManager.StackKeys() {
+		result := results[stackKey]
+		appendRows := func(services []*service.DockerService, status string) {
+			for _, srv := range services {
+				table.Append([]string{stackKey, srv.ContainerName(), status})
+			}
+		}
+		appendRows(result.Restarted, "reiniciado")
+		appendRows(result.Failed, "fallido")
+		appendRows(result.Unverified, "sin verificar")
+		appendRows(result.Pending, "pendiente")
+
+		if result.Err != nil {
+			util.Log.Errorf("Reinicio del stack %s con errores: %s", stackKey, result.Err)
+			failed = true
+		}
+	}
+	table.Render()
+
+	if ctx.Err() == context.Canceled {
+		os.Exit(exitDeployCanceled)
+	} else if ctx.Err() == context.DeadlineExceeded {
+		os.Exit(exitDeployTimeout)
+	} else if failed {
+		os.Exit(exitDeployFailed)
+	}
+}
@@ -0,0 +1,105 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/ch3lo/yale/cluster"
+	"github.com/ch3lo/yale/util"
+	"github.com/codegangsta/cli"
+)
+
+// pickFlags selecciona por nombre los flags de otro comando
+func pickFlags(flags []cli.Flag, names ...string) []cli.Flag {
+	var picked []cli.Flag
+	for _, name := range names {
+		for _, flag := range flags {
+			if flag.GetName() == name {
+				picked = append(picked, flag)
+			}
+		}
+	}
+
+	return picked
+}
+
+func scaleFlags() []cli.Flag {
+	flags := []cli.Flag{
+		cli.IntFlag{
+			Name:  "instances",
+			Usage: "Total de servicios que se quieren obtener en cada uno de los stack.",
+		},
+	}
+
+	return append(flags, pickFlags(deployFlags(),
+		"manifest",
+		"service-id",
+		"image",
+		"tag",
+		"total-instances",
+		"tolerance",
+		"failure-policy",
+		"timeout",
+		"wave",
+		"wave-soak",
+		"wave-approval",
+		"smoke-retries",
+		"smoke-type",
+		"smoke-request",
+		"smoke-expected",
+		"warmup-request",
+		"warmup-expected",
+		"output",
+	)...)
+}
+
+func scaleBefore(c *cli.Context) error {
+	manifest, err := deployManifestFromContext(c)
+	if err != nil {
+		return errors.New(fmt.Sprintf("No se pudo cargar el manifiesto: %s", err))
+	}
+
+	if manifest.ServiceId == "" && (manifest.Image == "" || manifest.Tag == "") {
+		return errors.New("Se debe indicar el service-id o la imagen y el TAG del servicio")
+	}
+
+	if !c.IsSet("instances") && manifest.Instances == 0 && manifest.TotalInstances == 0 && len(manifest.Stacks) == 0 {
+		return errors.New("Se debe indicar la cantidad de instancias")
+	}
+
+	if err := checkDeployOutput(c.String("output")); err != nil {
+		return err
+	}
+
+	if err := manifest.validate(); err != nil {
+		return err
+	}
+
+	return manifest.configureStacks(stackManager)
+}
+
+func scaleCmd(c *cli.Context) {
+	manifest, err := deployManifestFromContext(c)
+	if err != nil {
+		util.Log.Fatalln("No se pudo cargar el manifiesto", err)
+	}
+
+	selector := cluster.ServiceSelector{
+		ServiceId: manifest.ServiceId,
+		ImageName: manifest.Image,
+		Tag:       manifest.Tag,
+	}
+
+	deployConfig := manifest.scaleConfig()
+
+	ctx, cancel := deployContext(context.Background(), manifest.Timeout)
+	defer cancel()
+
+	handleDeploySigTerm(cancel)
+	ok := stackManager.Scale(ctx, selector, manifest.smokeConfig(), manifest.warmUpConfig(), deployConfig)
+	if ok && c.GlobalString("state-file") != "" {
+		recordScale(c.GlobalString("state-file"), manifest, stackManager.Targets(deployConfig.Instances), stackManager.Result().Succeeded)
+	}
+	finishDeploy(ctx, ok, manifest.Image, deployConfig.Instances, c.String("output"))
+}
@@ -0,0 +1,1043 @@
+package cli
+
+import (
+	"bufio"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/ch3lo/yale/cluster"
+	"github.com/ch3lo/yale/metrics"
+	"github.com/ch3lo/yale/monitor"
+	"github.com/ch3lo/yale/service"
+	"github.com/ch3lo/yale/util"
+	"github.com/ch3lo/yale/webhook"
+	"github.com/codegangsta/cli"
+)
+
+const (
+	// environmentQueueSize es la cantidad de trabajos que pueden esperar en cada ambiente
+	environmentQueueSize = 32
+	// serveShutdownTimeout es el tiempo que se espera que terminen las conexiones al detener el servidor
+	serveShutdownTimeout = 10 * time.Second
+	// maxRequestBody es el tamaño maximo del cuerpo de un request
+	maxRequestBody = 1 << 20
+)
+
+var environmentName = regexp.MustCompile("^[a-zA-Z0-9_.-]+$")
+
+// serveEnvironments son los ambientes que atiende el comando serve, configurados en serveBefore
+var serveEnvironments map[string]*environment
+
+func serveFlags() []cli.Flag {
+	return []cli.Flag{
+		cli.StringFlag{
+			Name:   "listen",
+			Value:  ":8080",
+			Usage:  "Direccion donde escucha el API",
+			EnvVar: "YALE_API_LISTEN",
+		},
+		cli.StringFlag{
+			Name:  "listen-cert",
+			Usage: "Certificado con que el API atiende por HTTPS. Requiere listen-key",
+		},
+		cli.StringFlag{
+			Name:  "listen-key",
+			Usage: "Llave del certificado del API",
+		},
+		cli.StringSliceFlag{
+			Name:   "token",
+			Usage:  "Token aceptado en el header Authorization: Bearer. Se puede repetir",
+			EnvVar: "YALE_API_TOKEN",
+		},
+		cli.StringFlag{
+			Name:  "token-file",
+			Usage: "Archivo con un token aceptado por linea",
+		},
+		cli.BoolFlag{
+			Name:  "no-auth",
+			Usage: "Atiende los requests sin autenticacion. Solo para pruebas locales",
+		},
+		cli.BoolFlag{
+			Name:  "metrics-public",
+			Usage: "Atiende /metrics sin autenticacion, para que Prometheus lo consulte sin token. Por defecto /metrics exige el mismo token que el API",
+		},
+		cli.StringFlag{
+			Name:   "environments",
+			Usage:  "Archivo JSON con los ambientes, de la forma {\"nombre\": {\"endpoints\": [\"[nombre=]url[,weight=N]\"], \"state_file\": \"ruta\"}}. Los endpoints globales forman el ambiente default",
+			EnvVar: "YALE_ENVIRONMENTS",
+		},
+		cli.IntFlag{
+			Name:  "job-history",
+			Value: 100,
+			Usage: "Cantidad de trabajos terminados que se conservan para su consulta",
+		},
+	}
+}
+
+// environmentConfig es un ambiente del archivo de ambientes
+type environmentConfig struct {
+	Endpoints []string `json:"endpoints"`
+	StateFile string   `json:"state_file"`
+}
+
+func loadEnvironmentConfigs(path string) (map[string]environmentConfig, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	configs := make(map[string]environmentConfig)
+	if err := json.NewDecoder(file).Decode(&configs); err != nil {
+		return nil, err
+	}
+
+	return configs, nil
+}
+
+// loadEnvironments crea un stack manager por ambiente. Los endpoints globales, si se
+// entregaron, forman el ambiente default con el archivo de estado global
+func loadEnvironments(c *cli.Context) (map[string]*environment, error) {
+	environments := make(map[string]*environment)
+	resilience, err := resilienceConfig(c)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(stackManager.StackKeys()) > 0 {
+		reader, err := newStackManager(c, c.GlobalStringSlice("endpoint"), resilience)
+		if err != nil {
+			return nil, err
+		}
+		environments["default"] = newEnvironment("default", stackManager, reader, c.GlobalString("state-file"))
+	}
+
+	if c.String("environments") == "" {
+		return environments, nil
+	}
+
+	configs, err := loadEnvironmentConfigs(c.String("environments"))
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("No se pudo cargar el archivo de ambientes: %s", err))
+	}
+
+	for name, config := range configs {
+		if !environmentName.MatchString(name) {
+			return nil, errors.New(fmt.Sprintf("Nombre de ambiente %s invalido", name))
+		}
+
+		if _, ok := environments[name]; ok {
+			return nil, errors.New(fmt.Sprintf("El ambiente %s ya existe", name))
+		}
+
+		if len(config.Endpoints) == 0 {
+			return nil, errors.New(fmt.Sprintf("El ambiente %s no tiene endpoints", name))
+		}
+
+		util.Log.Infof("Configurando el ambiente %s", name)
+		sm, err := newStackManager(c, config.Endpoints, resilience)
+		if err != nil {
+			return nil, err
+		}
+		sm.Observe(webhooks.Notify)
+		reader, err := newStackManager(c, config.Endpoints, resilience)
+		if err != nil {
+			return nil, err
+		}
+		environments[name] = newEnvironment(name, sm, reader, config.StateFile)
+	}
+
+	return environments, nil
+}
+
+// serveTokens obtiene los tokens del flag token y del archivo token-file
+func serveTokens(c *cli.Context) ([]string, error) {
+	var tokens []string
+	for _, token := range c.StringSlice("token") {
+		if token = strings.TrimSpace(token); token != "" {
+			tokens = append(tokens, token)
+		}
+	}
+
+	if path := c.String("token-file"); path != "" {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("No se pudo abrir el archivo de tokens: %s", err))
+		}
+		defer file.Close()
+
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			if token := strings.TrimSpace(scanner.Text()); token != "" && !strings.HasPrefix(token, "#") {
+				tokens = append(tokens, token)
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	return tokens, nil
+}
+
+func serveBefore(c *cli.Context) error {
+	tokens, err := serveTokens(c)
+	if err != nil {
+		return err
+	}
+
+	if len(tokens) == 0 && !c.Bool("no-auth") {
+		return errors.New("Se debe indicar al menos un token con --token o --token-file, o desactivar la autenticacion con --no-auth")
+	}
+
+	if (c.String("listen-cert") == "") != (c.String("listen-key") == "") {
+		return errors.New("Se deben indicar tanto listen-cert como listen-key")
+	}
+
+	if c.Int("job-history") < 0 {
+		return errors.New("Valor del parámetro job-history invalido")
+	}
+
+	if serveEnvironments, err = loadEnvironments(c); err != nil {
+		return err
+	}
+
+	if len(serveEnvironments) == 0 {
+		return errors.New("Se debe configurar al menos un ambiente con los endpoints globales o con --environments")
+	}
+
+	return nil
+}
+
+// environment es un conjunto de stacks que se opera con un mismo stack manager. Las
+// operaciones se ejecutan de a una, en el orden en que se reciben. Las consultas usan otro
+// stack manager con los mismos endpoints, de manera que no esperan a la operacion en curso
+type environment struct {
+	name      string
+	manager   *cluster.StackManager
+	reader    *cluster.StackManager
+	stacks    map[string]cluster.StackConfig
+	stateFile string
+	queue     chan *job
+	readMutex sync.Mutex
+	current   *job
+	observed  sync.Mutex
+}
+
+func newEnvironment(name string, manager *cluster.StackManager, reader *cluster.StackManager, stateFile string) *environment {
+	e := &environment{
+		name:      name,
+		manager:   manager,
+		reader:    reader,
+		stacks:    manager.StackConfigs(),
+		stateFile: stateFile,
+		queue:     make(chan *job, environmentQueueSize),
+	}
+	manager.Observe(e.observe)
+
+	return e
+}
+
+// observe es un DeployObserver que publica los eventos del despliegue en el trabajo en curso
+func (e *environment) observe(event cluster.DeployEvent) {
+	e.observed.Lock()
+	j := e.current
+	e.observed.Unlock()
+
+	if j != nil {
+		j.publish("deploy", webhook.NewPayload(newJobId(), event))
+	}
+}
+
+func (e *environment) setCurrent(j *job) {
+	e.observed.Lock()
+	defer e.observed.Unlock()
+
+	e.current = j
+}
+
+func (e *environment) currentJob() *job {
+	e.observed.Lock()
+	defer e.observed.Unlock()
+
+	return e.current
+}
+
+// prepare descarta el resultado y la configuracion de la operacion anterior
+func (e *environment) prepare() {
+	e.manager.Reset()
+	e.manager.RestoreStackConfigs(e.stacks)
+}
+
+// work ejecuta los trabajos del ambiente hasta que se cancela el contexto. Los trabajos que
+// quedan en cola se cancelan
+func (e *environment) work(ctx context.Context, done *sync.WaitGroup) {
+	defer done.Done()
+
+	for {
+		select {
+		case <-ctx.Done():
+			for {
+				select {
+				case j := <-e.queue:
+					j.requestCancel()
+				default:
+					return
+				}
+			}
+		case j := <-e.queue:
+			e.execute(ctx, j)
+		}
+	}
+}
+
+func (e *environment) execute(ctx context.Context, j *job) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	if !j.start(cancel) {
+		return
+	}
+
+	e.prepare()
+	e.setCurrent(j)
+	defer e.setCurrent(nil)
+
+	util.Log.Infof("Iniciando el trabajo %s (%s) en el ambiente %s", j.id, j.kind, e.name)
+	result, status, err := j.run(ctx)
+	if err != nil {
+		util.Log.Errorf("El trabajo %s terminó con estado %s: %s", j.id, status, err)
+	} else {
+		util.Log.Infof("El trabajo %s terminó con estado %s", j.id, status)
+	}
+	j.finish(status, result, err)
+}
+
+// query ejecuta una consulta con el stack manager de lectura del ambiente. Las consultas se
+// ejecutan de a una, ya que el stack manager guarda los contenedores de la ultima busqueda
+func (e *environment) query(run func(sm *cluster.StackManager) error) error {
+	e.readMutex.Lock()
+	defer e.readMutex.Unlock()
+
+	e.reader.Reset()
+	return run(e.reader)
+}
+
+// deployJobStatus clasifica el resultado de un despliegue con los mismos criterios que los
+// codigos de salida del comando deploy
+func deployJobStatus(ctx context.Context, ok bool, resume deployResume) (jobStatus, error) {
+	if !ok {
+		switch ctx.Err() {
+		case context.DeadlineExceeded:
+			return JOB_CANCELED, errors.New("Despliegue cancelado por tiempo maximo")
+		case context.Canceled:
+			return JOB_CANCELED, errors.New("Despliegue cancelado")
+		}
+
+		return JOB_FAILED, errors.New("Despliegue con errores")
+	}
+
+	if len(resume.Failed) > 0 {
+		return JOB_PARTIAL, errors.New(fmt.Sprintf("Despliegue con stacks fallidos %v", resume.Failed))
+	}
+
+	return JOB_SUCCEEDED, nil
+}
+
+// checkManifest verifica un manifiesto recibido por el API. No se aceptan archivos de
+// variables de entorno, ya que permitirian leer cualquier archivo del servidor
+func (e *environment) checkManifest(m *deployManifest) error {
+	if m.WaveApproval {
+		return errors.New("La aprobacion de olas no esta disponible en el API")
+	}
+
+	if len(m.EnvFiles) > 0 {
+		return errors.New("Los archivos de variables de entorno no estan disponibles en el API, se deben entregar en envs")
+	}
+
+	for stackKey := range m.Stacks {
+		if _, ok := e.stacks[stackKey]; !ok {
+			return errors.New(fmt.Sprintf("El stack %s no existe en el ambiente %s", stackKey, e.name))
+		}
+	}
+
+	return m.validate()
+}
+
+func (e *environment) deploy(ctx context.Context, m *deployManifest) (interface{}, jobStatus, error) {
+	if err := m.configureStacks(e.manager); err != nil {
+		return nil, JOB_FAILED, err
+	}
+
+	serviceConfig, err := m.serviceConfig()
+	if err != nil {
+		return nil, JOB_FAILED, errors.New(fmt.Sprintf("No se pudo procesar el archivo con variables de entorno: %s", err))
+	}
+
+	deployConfig := m.deployConfig()
+	ctx, cancel := deployContext(ctx, m.Timeout)
+	defer cancel()
+
+	ok := e.manager.Deploy(ctx, serviceConfig, m.smokeConfig(), m.warmUpConfig(), deployConfig)
+	if ok && e.stateFile != "" {
+		recordDeploy(e.stateFile, m, serviceConfig, e.manager.Targets(deployConfig.Instances), e.manager.Result().Succeeded)
+	}
+	if ok && m.GcKeep > 0 {
+		collectDeployGarbage(e.manager, m.Image, m.GcKeep)
+	}
+
+	resume := newDeployResume(e.manager, ok, deployConfig.Instances)
+	status, err := deployJobStatus(ctx, ok, resume)
+	return resume, status, err
+}
+
+func (e *environment) scale(ctx context.Context, m *deployManifest) (interface{}, jobStatus, error) {
+	if err := m.configureStacks(e.manager); err != nil {
+		return nil, JOB_FAILED, err
+	}
+
+	selector := cluster.ServiceSelector{
+		ServiceId: m.ServiceId,
+		ImageName: m.Image,
+		Tag:       m.Tag,
+	}
+
+	deployConfig := m.scaleConfig()
+	ctx, cancel := deployContext(ctx, m.Timeout)
+	defer cancel()
+
+	ok := e.manager.Scale(ctx, selector, m.smokeConfig(), m.warmUpConfig(), deployConfig)
+	if ok && e.stateFile != "" {
+		recordScale(e.stateFile, m, e.manager.Targets(deployConfig.Instances), e.manager.Result().Succeeded)
+	}
+
+	resume := newDeployResume(e.manager, ok, deployConfig.Instances)
+	status, err := deployJobStatus(ctx, ok, resume)
+	return resume, status, err
+}
+
+// undeployRequest selecciona los contenedores que se remueven con las mismas reglas que los
+// flags del comando undeploy
+type undeployRequest struct {
+	ImageFilter string   `json:"image_filter"`
+	TagFilter   string   `json:"tag_filter"`
+	CnameFilter string   `json:"cname_filter"`
+	Labels      []string `json:"labels"`
+	StopTimeout int      `json:"stop_timeout"`
+}
+
+// undeployResult es el resultado de remover un contenedor
+type undeployResult struct {
+	Stack string `json:"stack"`
+	Name  string `json:"name"`
+	Image string `json:"image"`
+	Error string `json:"error,omitempty"`
+}
+
+func (e *environment) undeploy(req undeployRequest) (interface{}, jobStatus, error) {
+	stackMap, err := e.manager.SearchContainers(req.ImageFilter, req.TagFilter, req.CnameFilter, req.Labels...)
+	if err != nil {
+		return nil, JOB_FAILED, err
+	}
+
+	outcomes := undeployStacks(stackMap, uint(req.StopTimeout))
+
+	results := []undeployResult{}
+	failed := 0
+	for _, stackKey := range sortedStackKeys(stackMap) {
+		for _, outcome := range outcomes[stackKey] {
+			result := undeployResult{
+				Stack: stackKey,
+				Name:  strings.TrimPrefix(outcome.service.ContainerName(), "/"),
+				Image: outcome.service.ContainerImageName(),
+			}
+			if outcome.err != nil {
+				result.Error = outcome.err.Error()
+				failed++
+			}
+			results = append(results, result)
+		}
+	}
+
+	if failed > 0 {
+		return results, JOB_FAILED, errors.New(fmt.Sprintf("No se pudieron remover %d contenedores", failed))
+	}
+
+	return results, JOB_SUCCEEDED, nil
+}
+
+// apiServer atiende el API HTTP de yale. Las operaciones que modifican los stacks se
+// ejecutan como trabajos asincronos y las consultas se responden de inmediato
+type apiServer struct {
+	ctx           context.Context
+	environments  map[string]*environment
+	jobs          *jobStore
+	tokens        []string
+	metrics       http.Handler
+	metricsPublic bool
+}
+
+func newApiServer(ctx context.Context, environments map[string]*environment, tokens []string, history int, metricsPublic bool) *apiServer {
+	return &apiServer{
+		ctx:           ctx,
+		environments:  environments,
+		jobs:          newJobStore(history),
+		tokens:        tokens,
+		metrics:       metrics.Default.Handler(),
+		metricsPublic: metricsPublic,
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// decodeBody decodifica el cuerpo JSON del request. Los campos desconocidos son un error, de
+// manera que un error de tipeo en el manifiesto no pase inadvertido
+func decodeBody(r *http.Request, value interface{}) error {
+	decoder := json.NewDecoder(io.LimitReader(r.Body, maxRequestBody))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(value); err != nil {
+		return errors.New(fmt.Sprintf("Cuerpo del request invalido: %s", err))
+	}
+
+	return nil
+}
+
+// authorized verifica el token del header Authorization. Sin tokens configurados no se exige autenticacion
+func (s *apiServer) authorized(r *http.Request) bool {
+	if len(s.tokens) == 0 {
+		return true
+	}
+
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return false
+	}
+
+	given := []byte(strings.TrimSpace(strings.TrimPrefix(header, "Bearer ")))
+	for _, token := range s.tokens {
+		if subtle.ConstantTimeCompare(given, []byte(token)) == 1 {
+			return true
+		}
+	}
+
+	return false
+}
+
+func unauthorized(w http.ResponseWriter) {
+	w.Header().Set("WWW-Authenticate", `Bearer realm="yale"`)
+	writeError(w, http.StatusUnauthorized, errors.New("Token invalido o ausente"))
+}
+
+// allow verifica el metodo del request, respondiendo 405 si no es el esperado
+func allow(w http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method == method {
+		return true
+	}
+
+	w.Header().Set("Allow", method)
+	writeError(w, http.StatusMethodNotAllowed, errors.New(fmt.Sprintf("Metodo %s no permitido", r.Method)))
+	return false
+}
+
+// ServeHTTP enruta los requests:
+//
+//	GET    /healthz
+//	GET    /metrics (sin autenticacion con --metrics-public)
+//	GET    /v1/environments
+//	POST   /v1/environments/{env}/deploy|scale|rollback|undeploy
+//	GET    /v1/environments/{env}/containers|status
+//	GET    /v1/jobs
+//	GET    /v1/jobs/{id}
+//	DELETE /v1/jobs/{id}
+//	GET    /v1/jobs/{id}/events
+func (s *apiServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/healthz":
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+		return
+	case "/metrics":
+		if !s.metricsPublic && !s.authorized(r) {
+			unauthorized(w)
+			return
+		}
+		s.metrics.ServeHTTP(w, r)
+		return
+	}
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) < 2 || parts[0] != "v1" {
+		writeError(w, http.StatusNotFound, errors.New("Recurso no encontrado"))
+		return
+	}
+
+	if !s.authorized(r) {
+		unauthorized(w)
+		return
+	}
+
+	switch {
+	case parts[1] == "environments" && len(parts) == 2:
+		if allow(w, r, "GET") {
+			s.listEnvironments(w)
+		}
+	case parts[1] == "environments" && len(parts) == 4:
+		env, ok := s.environments[parts[2]]
+		if !ok {
+			writeError(w, http.StatusNotFound, errors.New(fmt.Sprintf("El ambiente %s no existe", parts[2])))
+			return
+		}
+		s.environmentAction(w, r, env, parts[3])
+	case parts[1] == "jobs" && len(parts) == 2:
+		if allow(w, r, "GET") {
+			writeJSON(w, http.StatusOK, s.jobs.list(r.URL.Query().Get("environment")))
+		}
+	case parts[1] == "jobs" && (len(parts) == 3 || len(parts) == 4 && parts[3] == "events"):
+		j, ok := s.jobs.get(parts[2])
+		if !ok {
+			writeError(w, http.StatusNotFound, errors.New(fmt.Sprintf("El trabajo %s no existe", parts[2])))
+			return
+		}
+		s.jobAction(w, r, j, len(parts) == 4)
+	default:
+		writeError(w, http.StatusNotFound, errors.New("Recurso no encontrado"))
+	}
+}
+
+func (s *apiServer) environmentAction(w http.ResponseWriter, r *http.Request, env *environment, action string) {
+	switch action {
+	case "deploy", "scale", "rollback", "undeploy":
+		if allow(w, r, "POST") {
+			s.submitAction(w, r, env, action)
+		}
+	case "containers":
+		if allow(w, r, "GET") {
+			s.containers(w, r, env)
+		}
+	case "status":
+		if allow(w, r, "GET") {
+			s.status(w, r, env)
+		}
+	default:
+		writeError(w, http.StatusNotFound, errors.New(fmt.Sprintf("Operacion %s desconocida", action)))
+	}
+}
+
+func (s *apiServer) jobAction(w http.ResponseWriter, r *http.Request, j *job, events bool) {
+	if events {
+		if allow(w, r, "GET") {
+			j.stream(w, r)
+		}
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		writeJSON(w, http.StatusOK, j.view())
+	case "DELETE":
+		if !j.requestCancel() {
+			writeError(w, http.StatusConflict, errors.New(fmt.Sprintf("El trabajo %s ya terminó", j.id)))
+			return
+		}
+		util.Log.Warnf("Se solicitó la cancelacion del trabajo %s", j.id)
+		writeJSON(w, http.StatusAccepted, j.view())
+	default:
+		w.Header().Set("Allow", "GET, DELETE")
+		writeError(w, http.StatusMethodNotAllowed, errors.New(fmt.Sprintf("Metodo %s no permitido", r.Method)))
+	}
+}
+
+// environmentView describe un ambiente y la configuracion base de sus stacks
+type environmentView struct {
+	Name       string             `json:"name"`
+	Stacks     []environmentStack `json:"stacks"`
+	StateFile  bool               `json:"state_file"`
+	CurrentJob string             `json:"current_job,omitempty"`
+	QueuedJobs int                `json:"queued_jobs"`
+}
+
+type environmentStack struct {
+	Name      string `json:"name"`
+	Weight    int    `json:"weight"`
+	Instances *int   `json:"instances"`
+	Required  bool   `json:"required"`
+}
+
+func (s *apiServer) listEnvironments(w http.ResponseWriter) {
+	var names []string
+	for name := range s.environments {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	views := []environmentView{}
+	for _, name := range names {
+		env := s.environments[name]
+		view := environmentView{Name: name, Stacks: []environmentStack{}, StateFile: env.stateFile != "", QueuedJobs: len(env.queue)}
+		if j := env.currentJob(); j != nil {
+			view.CurrentJob = j.id
+		}
+
+		var stackKeys []string
+		for stackKey := range env.stacks {
+			stackKeys = append(stackKeys, stackKey)
+		}
+		sort.Strings(stackKeys)
+
+		for _, stackKey := range stackKeys {
+			config := env.stacks[stackKey]
+			view.Stacks = append(view.Stacks, environmentStack{Name: stackKey, Weight: config.Weight, Instances: config.Instances, Required: config.Required})
+		}
+		views = append(views, view)
+	}
+
+	writeJSON(w, http.StatusOK, views)
+}
+
+// rollbackRequest identifica el servicio que vuelve a su despliegue anterior registrado en el
+// estado deseado. Si se entrega Tag se despliega ese tag con el manifiesto actual
+type rollbackRequest struct {
+	ServiceId string `json:"service_id"`
+	Image     string `json:"image"`
+	Tag       string `json:"tag"`
+	Timeout   string `json:"timeout"`
+}
+
+// rollbackManifest obtiene el manifiesto de destino del rollback desde el estado deseado
+func (e *environment) rollbackManifest(req rollbackRequest) (*deployManifest, error) {
+	if e.stateFile == "" {
+		return nil, errors.New(fmt.Sprintf("El ambiente %s no tiene archivo de estado deseado", e.name))
+	}
+
+	if req.ServiceId == "" && req.Image == "" {
+		return nil, errors.New("Se debe indicar el service_id o la imagen del servicio")
+	}
+
+	state, err := loadDesiredState(e.stateFile)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("No se pudo cargar el estado deseado: %s", err))
+	}
+
+	key := desiredServiceKey(req.ServiceId, req.Image)
+	current, ok := state.Services[key]
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("El servicio %s no tiene estado deseado registrado", key))
+	}
+
+	var manifest deployManifest
+	switch {
+	case req.Tag != "":
+		manifest = current.Manifest
+		manifest.Tag = req.Tag
+	case current.Previous != nil:
+		manifest = current.Previous.Manifest
+	default:
+		return nil, errors.New(fmt.Sprintf("El servicio %s no tiene un despliegue anterior registrado", key))
+	}
+
+	manifest.WaveApproval = false
+	if req.Timeout != "" {
+		manifest.Timeout = req.Timeout
+	}
+
+	return &manifest, nil
+}
+
+// actionRunner valida el request de una operacion y entrega la funcion que la ejecuta
+func (s *apiServer) actionRunner(r *http.Request, env *environment, action string) (jobRunner, error) {
+	switch action {
+	case "deploy", "scale":
+		manifest := new(deployManifest)
+		manifest.setDefaults()
+		if action == "deploy" {
+			manifest.Instances = 1
+		}
+		if err := decodeBody(r, manifest); err != nil {
+			return nil, err
+		}
+
+		if action == "scale" {
+			if manifest.ServiceId == "" && (manifest.Image == "" || manifest.Tag == "") {
+				return nil, errors.New("Se debe indicar el service_id o la imagen y el tag del servicio")
+			}
+			if manifest.Instances == 0 && manifest.TotalInstances == 0 && len(manifest.Stacks) == 0 {
+				return nil, errors.New("Se debe indicar la cantidad de instancias")
+			}
+			if err := env.checkManifest(manifest); err != nil {
+				return nil, err
+			}
+			return func(ctx context.Context) (interface{}, jobStatus, error) { return env.scale(ctx, manifest) }, nil
+		}
+
+		if manifest.Image == "" || manifest.Tag == "" {
+			return nil, errors.New("Se debe indicar la imagen y el tag del servicio")
+		}
+		if err := env.checkManifest(manifest); err != nil {
+			return nil, err
+		}
+		return func(ctx context.Context) (interface{}, jobStatus, error) { return env.deploy(ctx, manifest) }, nil
+	case "rollback":
+		var req rollbackRequest
+		if err := decodeBody(r, &req); err != nil {
+			return nil, err
+		}
+		manifest, err := env.rollbackManifest(req)
+		if err != nil {
+			return nil, err
+		}
+		if err := env.checkManifest(manifest); err != nil {
+			return nil, err
+		}
+		util.Log.Infof("Rollback del servicio %s al tag %s", desiredServiceKey(manifest.ServiceId, manifest.Image), manifest.Tag)
+		return func(ctx context.Context) (interface{}, jobStatus, error) { return env.deploy(ctx, manifest) }, nil
+	}
+
+	var req undeployRequest
+	if err := decodeBody(r, &req); err != nil {
+		return nil, err
+	}
+	if req.ImageFilter == "" && req.TagFilter == "" && req.CnameFilter == "" && len(req.Labels) == 0 {
+		return nil, errors.New("Se debe indicar al menos un filtro para seleccionar los contenedores")
+	}
+	for _, filter := range []*string{&req.ImageFilter, &req.TagFilter, &req.CnameFilter} {
+		if *filter == "" {
+			*filter = ".*"
+		}
+	}
+	if err := checkFilters(req.ImageFilter, req.TagFilter, req.CnameFilter); err != nil {
+		return nil, err
+	}
+	if req.StopTimeout < 0 {
+		return nil, errors.New("Valor del parámetro stop_timeout invalido")
+	}
+	if req.StopTimeout == 0 {
+		req.StopTimeout = 10
+	}
+	return func(ctx context.Context) (interface{}, jobStatus, error) { return env.undeploy(req) }, nil
+}
+
+// submitAction encola la operacion como un trabajo y responde 202 con el trabajo creado
+func (s *apiServer) submitAction(w http.ResponseWriter, r *http.Request, env *environment, action string) {
+	if s.ctx.Err() != nil {
+		writeError(w, http.StatusServiceUnavailable, errors.New("El servidor se esta deteniendo"))
+		return
+	}
+
+	run, err := s.actionRunner(r, env, action)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	j := newJob(action, env.name, run)
+	select {
+	case env.queue <- j:
+	default:
+		writeError(w, http.StatusServiceUnavailable, errors.New(fmt.Sprintf("La cola del ambiente %s esta llena", env.name)))
+		return
+	}
+	s.jobs.add(j)
+
+	util.Log.Infof("Se encoló el trabajo %s (%s) en el ambiente %s", j.id, action, env.name)
+	w.Header().Set("Location", "/v1/jobs/"+j.id)
+	writeJSON(w, http.StatusAccepted, j.view())
+}
+
+func queryValue(r *http.Request, key string, defaultValue string) string {
+	if value := r.URL.Query().Get(key); value != "" {
+		return value
+	}
+
+	return defaultValue
+}
+
+// containers lista los contenedores del ambiente con los filtros image_filter, tag_filter,
+// cname_filter y label, y el orden sort, iguales a los del comando list
+func (s *apiServer) containers(w http.ResponseWriter, r *http.Request, env *environment) {
+	sortKey := queryValue(r, "sort", "stack")
+	if _, ok := viewSortKeys[sortKey]; !ok {
+		writeError(w, http.StatusBadRequest, errors.New(fmt.Sprintf("No se puede ordenar por %s", sortKey)))
+		return
+	}
+
+	imageFilter, tagFilter, cnameFilter := queryValue(r, "image_filter", ".*"), queryValue(r, "tag_filter", ".*"), queryValue(r, "cname_filter", ".*")
+	if err := checkFilters(imageFilter, tagFilter, cnameFilter); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	var views []containerView
+	err := env.query(func(sm *cluster.StackManager) error {
+		stackMap, err := sm.SearchContainers(imageFilter, tagFilter, cnameFilter, r.URL.Query()["label"]...)
+		if err != nil {
+			return err
+		}
+
+		views = containerViews(stackMap, sortKey)
+		return nil
+	})
+
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, views)
+}
+
+// serviceStatusView es el estado de un servicio en los stacks del ambiente
+type serviceStatusView struct {
+	Stacks    []stackSummary       `json:"stacks"`
+	Instances []instanceStatusView `json:"instances"`
+}
+
+// status entrega el estado del servicio seleccionado con service_id o image y tag. Con
+// check=true ejecuta el smoke test registrado en el estado deseado contra cada instancia
+func (s *apiServer) status(w http.ResponseWriter, r *http.Request, env *environment) {
+	serviceId, image, tag := r.URL.Query().Get("service_id"), r.URL.Query().Get("image"), r.URL.Query().Get("tag")
+	if serviceId == "" && image == "" {
+		writeError(w, http.StatusBadRequest, errors.New("Se debe indicar el service_id o la imagen del servicio"))
+		return
+	}
+
+	check, _ := strconv.ParseBool(r.URL.Query().Get("check"))
+
+	var desired *desiredService
+	if env.stateFile != "" {
+		if state, err := loadDesiredState(env.stateFile); err != nil {
+			util.Log.Warnln("No se pudo cargar el estado deseado", err)
+		} else {
+			desired = state.Services[desiredServiceKey(serviceId, image)]
+		}
+	}
+
+	var smoke monitor.MonitorConfig
+	if check {
+		if desired == nil {
+			writeError(w, http.StatusBadRequest, errors.New("El servicio no tiene un smoke test registrado en el estado deseado"))
+			return
+		}
+		smoke = desired.Manifest.smokeConfig()
+	}
+
+	view := serviceStatusView{Instances: []instanceStatusView{}}
+	err := env.query(func(sm *cluster.StackManager) error {
+		stackMap, err := selectService(sm, serviceId, image, tag)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		statuses := make(map[*service.DockerService]*instanceStatus)
+		for stackKey, services := range stackMap {
+			for _, srv := range services {
+				status := newInstanceStatus(stackKey, srv, now)
+				statuses[srv] = &status
+			}
+		}
+
+		if check {
+			liveCheck(stackMap, statuses, smoke)
+		}
+
+		view.Stacks = summarizeStacks(sm.StackKeys(), stackMap, statuses, desired)
+		for _, stackKey := range sortedStackKeys(stackMap) {
+			for _, srv := range stackMap[stackKey] {
+				view.Instances = append(view.Instances, statuses[srv].view())
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, view)
+}
+
+func serveCmd(c *cli.Context) {
+	tokens, _ := serveTokens(c)
+	if len(tokens) == 0 {
+		util.Log.Warnln("El API no exige autenticacion")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		util.Log.Warnln("Se recibió una señal de término, cancelando los trabajos en curso")
+		cancel()
+		<-signals
+		util.Log.Errorln("Se recibió una segunda señal de término, terminando sin esperar el rollback")
+		os.Exit(exitDeployCanceled)
+	}()
+
+	var workers sync.WaitGroup
+	for _, env := range serveEnvironments {
+		workers.Add(1)
+		go env.work(ctx, &workers)
+	}
+
+	server := &http.Server{
+		Addr:              c.String("listen"),
+		Handler:           newApiServer(ctx, serveEnvironments, tokens, c.Int("job-history"), c.Bool("metrics-public")),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	listenErr := make(chan error, 1)
+	go func() {
+		if c.String("listen-cert") != "" {
+			listenErr <- server.ListenAndServeTLS(c.String("listen-cert"), c.String("listen-key"))
+		} else {
+			listenErr <- server.ListenAndServe()
+		}
+	}()
+
+	util.Log.Infof("Atendiendo el API en %s con %d ambientes", c.String("listen"), len(serveEnvironments))
+	select {
+	case err := <-listenErr:
+		cancel()
+		workers.Wait()
+		util.Log.Fatalln("No se pudo atender el API", err)
+	case <-ctx.Done():
+	}
+
+	// los trabajos cancelados terminan con su rollback antes de cerrar las conexiones, de
+	// manera que los clientes reciban su estado final
+	workers.Wait()
+	shutdown, cancelShutdown := context.WithTimeout(context.Background(), serveShutdownTimeout)
+	defer cancelShutdown()
+	if err := server.Shutdown(shutdown); err != nil {
+		server.Close()
+	}
+
+	closeWebhooks()
+	util.Log.Infoln("Servidor detenido")
+}
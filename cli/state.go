@@ -0,0 +1,162 @@
+package cli
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/ch3lo/yale/cluster"
+	"github.com/ch3lo/yale/service"
+	"github.com/ch3lo/yale/util"
+)
+
+// desiredService es el estado deseado de un servicio desplegado. El manifiesto se guarda con
+// las variables de entorno ya resueltas, de manera que no depende de los archivos originales.
+// Stacks tiene la cantidad de instancias deseada en cada stack y Previous el estado anterior al
+// ultimo despliegue de un tag distinto, que es el destino del rollback
+type desiredService struct {
+	Manifest  deployManifest  `json:"manifest"`
+	Stacks    map[string]int  `json:"stacks"`
+	UpdatedAt time.Time       `json:"updated_at"`
+	Previous  *desiredService `json:"previous,omitempty"`
+}
+
+// desiredState es el estado deseado de todos los servicios, indexado por service_id o, si el
+// servicio no tiene id, por el nombre de la imagen
+type desiredState struct {
+	Services map[string]*desiredService `json:"services"`
+}
+
+func desiredServiceKey(serviceId string, image string) string {
+	if serviceId != "" {
+		return serviceId
+	}
+
+	return image
+}
+
+// loadDesiredState carga el estado deseado. Si el archivo no existe se entrega un estado vacio
+func loadDesiredState(path string) (*desiredState, error) {
+	state := &desiredState{Services: make(map[string]*desiredService)}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, err
+	}
+
+	if state.Services == nil {
+		state.Services = make(map[string]*desiredService)
+	}
+
+	return state, nil
+}
+
+// save escribe el estado en un archivo temporal que luego se renombra, de manera que un
+// lector nunca vea un archivo a medio escribir
+func (d *desiredState) save(path string) error {
+	data, err := json.MarshalIndent(d, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".yale-state")
+	if err != nil {
+		return err
+	}
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+func (d *desiredState) keys() []string {
+	keys := make([]string, 0, len(d.Services))
+	for key := range d.Services {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+	return keys
+}
+
+// recordDeploy guarda el estado deseado luego de un despliegue. Solo se registran los stacks exitosos
+func recordDeploy(path string, manifest *deployManifest, serviceConfig service.ServiceConfig, targets map[string]int, succeeded []string) {
+	state, err := loadDesiredState(path)
+	if err != nil {
+		util.Log.Errorln("No se pudo cargar el estado deseado", err)
+		return
+	}
+
+	entry := &desiredService{
+		Manifest:  *manifest,
+		Stacks:    make(map[string]int),
+		UpdatedAt: time.Now(),
+	}
+	entry.Manifest.EnvFiles = nil
+	entry.Manifest.Envs = serviceConfig.Envs
+
+	for _, stackKey := range succeeded {
+		entry.Stacks[stackKey] = targets[stackKey]
+	}
+
+	key := desiredServiceKey(manifest.ServiceId, manifest.Image)
+	if current, ok := state.Services[key]; ok {
+		if current.Manifest.Tag != manifest.Tag {
+			entry.Previous = current
+			current.Previous = nil
+		} else {
+			entry.Previous = current.Previous
+		}
+	}
+
+	state.Services[key] = entry
+	if err := state.save(path); err != nil {
+		util.Log.Errorln("No se pudo guardar el estado deseado", err)
+	}
+}
+
+// recordScale actualiza las instancias deseadas de un servicio registrado luego de escalarlo
+func recordScale(path string, manifest *deployManifest, targets map[string]int, succeeded []string) {
+	state, err := loadDesiredState(path)
+	if err != nil {
+		util.Log.Errorln("No se pudo cargar el estado deseado", err)
+		return
+	}
+
+	key := desiredServiceKey(manifest.ServiceId, manifest.Image)
+	entry, ok := state.Services[key]
+	if !ok {
+		util.Log.Warnf("El servicio %s no tiene estado deseado registrado, no se actualiza", key)
+		return
+	}
+
+	for _, stackKey := range succeeded {
+		if targets[stackKey] != cluster.KeepInstances {
+			entry.Stacks[stackKey] = targets[stackKey]
+		}
+	}
+	entry.UpdatedAt = time.Now()
+
+	if err := state.save(path); err != nil {
+		util.Log.Errorln("No se pudo guardar el estado deseado", err)
+	}
+}
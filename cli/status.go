@@ -0,0 +1,340 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ch3lo/yale/cluster"
+	"github.com/ch3lo/yale/monitor"
+	"github.com/ch3lo/yale/service"
+	"github.com/ch3lo/yale/util"
+	"github.com/codegangsta/cli"
+	"github.com/olekukonko/tablewriter"
+	"github.com/pivotal-golang/bytefmt"
+)
+
+func statusFlags() []cli.Flag {
+	flags := []cli.Flag{
+		cli.BoolFlag{
+			Name:  "check",
+			Usage: "Ejecuta el smoke test una vez contra cada instancia en ejecucion",
+		},
+	}
+
+	flags = append(flags, watchFlags()...)
+	return append(flags, pickFlags(deployFlags(),
+		"service-id",
+		"image",
+		"tag",
+		"smoke-type",
+		"smoke-request",
+		"smoke-expected",
+	)...)
+}
+
+func statusBefore(c *cli.Context) error {
+	if c.String("service-id") == "" && c.String("image") == "" {
+		return errors.New("Se debe indicar el service-id o la imagen del servicio")
+	}
+
+	return watchBefore(c)
+}
+
+// selectService obtiene los contenedores del servicio por su service_id o por su imagen y tag
+func selectService(sm *cluster.StackManager, serviceId string, image string, tag string) (map[string][]*service.DockerService, error) {
+	if serviceId != "" {
+		return sm.SearchContainers(".*", ".*", ".*", "service_id="+serviceId)
+	}
+
+	tagFilter := ".*"
+	if tag != "" {
+		tagFilter = regexp.QuoteMeta(tag) + "$"
+	}
+
+	return sm.SearchContainers("^"+regexp.QuoteMeta(image), tagFilter, ".*", "image_name="+image)
+}
+
+// instanceStatus es el estado de una instancia del servicio
+type instanceStatus struct {
+	stack         string
+	name          string
+	tag           string
+	state         string
+	health        string
+	uptime        string
+	restarts      int
+	cpu           string
+	memory        string
+	registratorId string
+	address       string
+	check         string
+}
+
+func formatUptime(d time.Duration) string {
+	d = d - d%time.Second
+	if d > 24*time.Hour {
+		return fmt.Sprintf("%dd%s", d/(24*time.Hour), d%(24*time.Hour))
+	}
+
+	return d.String()
+}
+
+func newInstanceStatus(stackKey string, srv *service.DockerService, now time.Time) instanceStatus {
+	container := srv.Container()
+	status := instanceStatus{
+		stack:         stackKey,
+		name:          srv.ContainerName(),
+		state:         srv.ContainerStatus(),
+		health:        "-",
+		uptime:        "-",
+		restarts:      container.RestartCount,
+		cpu:           "-",
+		memory:        "-",
+		registratorId: "-",
+		address:       "-",
+		check:         "-",
+	}
+
+	if container.Config != nil {
+		status.tag = container.Config.Labels["image_tag"]
+	}
+
+	if container.State.OOMKilled {
+		status.state += " (oom)"
+	}
+
+	if health := srv.ContainerHealth(); health != "" {
+		status.health = health
+	}
+
+	if container.State.Running {
+		status.uptime = formatUptime(now.Sub(container.State.StartedAt))
+	}
+
+	if container.HostConfig != nil {
+		if container.HostConfig.CPUShares > 0 {
+			status.cpu = strconv.FormatInt(container.HostConfig.CPUShares, 10)
+		}
+		if container.HostConfig.Memory > 0 {
+			status.memory = bytefmt.ByteSize(uint64(container.HostConfig.Memory))
+		}
+	}
+
+	if srv.ContainerSwarmNode() != "" {
+		status.registratorId = srv.RegistratorId()
+	}
+
+	if addr, err := srv.AddressAndPort(8080); err == nil {
+		status.address = addr
+	}
+
+	return status
+}
+
+// liveCheck ejecuta el smoke test una vez, en paralelo, contra cada instancia en ejecucion
+func liveCheck(stackMap map[string][]*service.DockerService, statuses map[*service.DockerService]*instanceStatus, config monitor.MonitorConfig) {
+	config.Retries = 1
+
+	var wg sync.WaitGroup
+	for _, services := range stackMap {
+		for _, srv := range services {
+			if !srv.CheckState(service.RUNNING) {
+				continue
+			}
+
+			wg.Add(1)
+			go func(srv *service.DockerService) {
+				defer wg.Done()
+				if srv.Check(context.Background(), monitor.NewMonitor(config)) {
+					statuses[srv].check = "ok"
+				} else {
+					statuses[srv].check = "fallido"
+				}
+			}(srv)
+		}
+	}
+	wg.Wait()
+}
+
+// instanceStatusView es la representacion JSON del estado de una instancia. Los valores
+// desconocidos quedan vacios
+type instanceStatusView struct {
+	Stack         string `json:"stack"`
+	Name          string `json:"name"`
+	Tag           string `json:"tag"`
+	State         string `json:"state"`
+	Health        string `json:"health,omitempty"`
+	Uptime        string `json:"uptime,omitempty"`
+	Restarts      int    `json:"restarts"`
+	Cpu           string `json:"cpu,omitempty"`
+	Memory        string `json:"memory,omitempty"`
+	RegistratorId string `json:"registrator_id,omitempty"`
+	Address       string `json:"address,omitempty"`
+	Check         string `json:"check,omitempty"`
+}
+
+func knownValue(value string) string {
+	if value == "-" {
+		return ""
+	}
+
+	return value
+}
+
+func (s instanceStatus) view() instanceStatusView {
+	return instanceStatusView{
+		Stack:         s.stack,
+		Name:          strings.TrimPrefix(s.name, "/"),
+		Tag:           s.tag,
+		State:         s.state,
+		Health:        knownValue(s.health),
+		Uptime:        knownValue(s.uptime),
+		Restarts:      s.restarts,
+		Cpu:           knownValue(s.cpu),
+		Memory:        knownValue(s.memory),
+		RegistratorId: knownValue(s.registratorId),
+		Address:       knownValue(s.address),
+		Check:         knownValue(s.check),
+	}
+}
+
+// stackSummary resume las instancias en ejecucion del servicio en un stack. Desired es la
+// cantidad registrada en el estado deseado, nil si no esta registrada
+type stackSummary struct {
+	Stack   string   `json:"stack"`
+	Running int      `json:"running"`
+	Desired *int     `json:"desired,omitempty"`
+	Tags    []string `json:"tags"`
+}
+
+func summarizeStacks(stackKeys []string, stackMap map[string][]*service.DockerService, statuses map[*service.DockerService]*instanceStatus, desired *desiredService) []stackSummary {
+	var summaries []stackSummary
+	for _, stackKey := range stackKeys {
+		summary := stackSummary{Stack: stackKey, Tags: []string{}}
+		tags := make(map[string]bool)
+		for _, srv := range stackMap[stackKey] {
+			if srv.CheckState(service.RUNNING) {
+				summary.Running++
+				tags[statuses[srv].tag] = true
+			}
+		}
+
+		for tag := range tags {
+			summary.Tags = append(summary.Tags, tag)
+		}
+		sort.Strings(summary.Tags)
+
+		if desired != nil {
+			if instances, ok := desired.Stacks[stackKey]; ok {
+				summary.Desired = &instances
+			}
+		}
+
+		summaries = append(summaries, summary)
+	}
+
+	return summaries
+}
+
+// signature resume los datos que se comparan entre refrescos del modo watch
+func (s instanceStatus) signature() string {
+	return s.state + "/" + s.health + "/" + s.tag + "/" + strconv.Itoa(s.restarts) + "/" + s.check
+}
+
+func renderStatus(c *cli.Context, tracker *changeTracker) error {
+	stackMap, err := selectService(stackManager, c.String("service-id"), c.String("image"), c.String("tag"))
+	if err != nil {
+		return err
+	}
+
+	// la cantidad deseada y el smoke test se obtienen del estado deseado si esta registrado
+	var desired *desiredService
+	if path := c.GlobalString("state-file"); path != "" {
+		if state, err := loadDesiredState(path); err != nil {
+			util.Log.Warnln("No se pudo cargar el estado deseado", err)
+		} else {
+			desired = state.Services[desiredServiceKey(c.String("service-id"), c.String("image"))]
+		}
+	}
+
+	now := time.Now()
+	statuses := make(map[*service.DockerService]*instanceStatus)
+	for stackKey, services := range stackMap {
+		for _, srv := range services {
+			status := newInstanceStatus(stackKey, srv, now)
+			statuses[srv] = &status
+		}
+	}
+
+	if c.Bool("check") {
+		smoke := monitor.MonitorConfig{
+			Type:     monitor.GetMonitor(c.String("smoke-type")),
+			Request:  c.String("smoke-request"),
+			Expected: c.String("smoke-expected"),
+		}
+		if smoke.Request == "" && desired != nil {
+			smoke = desired.Manifest.smokeConfig()
+		}
+
+		if smoke.Request == "" {
+			return errors.New("El endpoint de Smoke Test esta vacio")
+		}
+		liveCheck(stackMap, statuses, smoke)
+	}
+
+	summary := tablewriter.NewWriter(os.Stdout)
+	summary.SetHeader([]string{"Stack", "Running", "Desired", "Tags"})
+	for _, stack := range summarizeStacks(stackManager.StackKeys(), stackMap, statuses, desired) {
+		desiredInstances := "-"
+		if stack.Desired != nil {
+			desiredInstances = strconv.Itoa(*stack.Desired)
+		}
+
+		summary.Append([]string{stack.Stack, strconv.Itoa(stack.Running), desiredInstances, strings.Join(stack.Tags, ", ")})
+	}
+	summary.Render()
+
+	header := []string{"Stack", "Name", "Tag", "State", "Health", "Uptime", "Restarts", "CPU", "Memory", "Registrator Id", "Address", "Check"}
+	if tracker != nil {
+		header = append([]string{""}, header...)
+	}
+
+	instances := tablewriter.NewWriter(os.Stdout)
+	instances.SetHeader(header)
+	for _, stackKey := range sortedStackKeys(stackMap) {
+		for _, srv := range stackMap[stackKey] {
+			s := statuses[srv]
+			row := []string{s.stack, s.name, s.tag, s.state, s.health, s.uptime, strconv.Itoa(s.restarts), s.cpu, s.memory, s.registratorId, s.address, s.check}
+			if tracker != nil {
+				mark := " "
+				if tracker.changed(srv.Container().ID, s.signature()) {
+					mark = "*"
+				}
+				row = append([]string{mark}, row...)
+			}
+			instances.Append(row)
+		}
+	}
+	instances.Render()
+
+	return nil
+}
+
+func statusCmd(c *cli.Context) {
+	if c.Bool("watch") {
+		watch(c, func(tracker *changeTracker) error { return renderStatus(c, tracker) })
+		return
+	}
+
+	if err := renderStatus(c, nil); err != nil {
+		util.Log.Fatalln(err)
+	}
+}
@@ -0,0 +1,81 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ch3lo/yale/tracing"
+	"github.com/ch3lo/yale/util"
+	"github.com/ch3lo/yale/version"
+	"github.com/codegangsta/cli"
+)
+
+// tracingFlushTimeout es el tiempo maximo que se espera la exportacion de los spans pendientes al terminar
+const tracingFlushTimeout = 10 * time.Second
+
+var tracer *tracing.Tracer
+
+// parseTraceHeaders procesa los headers del collector con el formato clave=valor
+func parseTraceHeaders(specs []string) (map[string]string, error) {
+	headers := make(map[string]string)
+	for _, spec := range specs {
+		kv := strings.SplitN(spec, "=", 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" {
+			return nil, errors.New(fmt.Sprintf("Header de trazas %s invalido, se espera clave=valor", spec))
+		}
+		headers[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+	}
+
+	return headers, nil
+}
+
+// setupTracing configura el tracer por defecto si se entrega un endpoint de trazas, con el flag
+// trace-endpoint o con la url base del collector en OTEL_EXPORTER_OTLP_ENDPOINT
+func setupTracing(c *cli.Context) error {
+	endpoint := c.String("trace-endpoint")
+	if base := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"); endpoint == "" && base != "" {
+		endpoint = tracing.TracesEndpoint(base)
+	}
+
+	if endpoint == "" {
+		return nil
+	}
+
+	headers, err := parseTraceHeaders(c.StringSlice("trace-header"))
+	if err != nil {
+		return err
+	}
+
+	exporter, err := tracing.NewExporter(endpoint, headers, tracingFlushTimeout)
+	if err != nil {
+		return err
+	}
+
+	hostname, _ := os.Hostname()
+	config := tracing.Config{
+		ServiceName: c.String("trace-service"),
+		Resource: []tracing.Attribute{
+			tracing.Attr("service.version", version.VERSION),
+			tracing.Attr("host.name", hostname),
+		},
+		Exporter: exporter,
+	}
+
+	if tracer, err = tracing.NewTracer(config); err != nil {
+		return err
+	}
+	tracing.SetDefault(tracer)
+	util.Log.Infof("Exportando las trazas a %s", endpoint)
+
+	return nil
+}
+
+// closeTracing espera la exportacion de los spans pendientes antes de terminar el proceso
+func closeTracing() {
+	if tracer != nil {
+		tracer.Shutdown(tracingFlushTimeout)
+	}
+}
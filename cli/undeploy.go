@@ -0,0 +1,184 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"sync"
+
+	"github.com/ch3lo/yale/service"
+	"github.com/ch3lo/yale/util"
+	"github.com/codegangsta/cli"
+	"github.com/olekukonko/tablewriter"
+)
+
+// selectionFlags son los flags para seleccionar contenedores en todos los stacks
+func selectionFlags() []cli.Flag {
+	return []cli.Flag{
+		cli.StringFlag{
+			Name:  "image-filter, if",
+			Value: ".*",
+			Usage: "Expresion regular para filtrar contenedores por el nombre de la imagen",
+		},
+		cli.StringFlag{
+			Name:  "tag-filter, tf",
+			Value: ".*",
+			Usage: "Expresion regular para filtrar contenedores por el tag",
+		},
+		cli.StringFlag{
+			Name:  "cname-filter, cf",
+			Value: ".*",
+			Usage: "Expresion regular para filtrar contenedores por el nombre del contenedor",
+		},
+		cli.StringSliceFlag{
+			Name:  "label, lb",
+			Usage: "Label que deben tener los contenedores en formato KEY=VALUE. Se puede repetir",
+		},
+	}
+}
+
+// checkFilters verifica que los filtros sean expresiones regulares validas, ya que la busqueda
+// de contenedores las compila con regexp.MustCompile
+func checkFilters(filters ...string) error {
+	for _, filter := range filters {
+		if _, err := regexp.Compile(filter); err != nil {
+			return errors.New(fmt.Sprintf("Filtro %s invalido: %s", filter, err))
+		}
+	}
+
+	return nil
+}
+
+func requireSelection(c *cli.Context) error {
+	if !isSetAny(c, "image-filter", "if", "tag-filter", "tf", "cname-filter", "cf", "label", "lb") {
+		return errors.New("Se debe indicar al menos un filtro para seleccionar los contenedores")
+	}
+
+	return checkFilters(c.String("if"), c.String("tf"), c.String("cf"))
+}
+
+func selectContainers(c *cli.Context) (map[string][]*service.DockerService, error) {
+	return stackManager.SearchContainers(c.String("if"), c.String("tf"), c.String("cf"), c.StringSlice("label")...)
+}
+
+func undeployFlags() []cli.Flag {
+	flags := []cli.Flag{
+		cli.IntFlag{
+			Name:  "stop-timeout",
+			Value: 10,
+			Usage: "Segundos que tiene el contenedor para detenerse antes de ser terminado",
+		},
+		cli.BoolFlag{
+			Name:  "yes, y",
+			Usage: "Remueve los contenedores sin solicitar confirmacion",
+		},
+	}
+
+	return append(selectionFlags(), flags...)
+}
+
+func isSetAny(c *cli.Context, names ...string) bool {
+	for _, name := range names {
+		if c.IsSet(name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func undeployBefore(c *cli.Context) error {
+	if err := requireSelection(c); err != nil {
+		return err
+	}
+
+	if c.Int("stop-timeout") < 0 {
+		return errors.New("Valor del parámetro stop-timeout invalido")
+	}
+
+	return nil
+}
+
+type undeployOutcome struct {
+	stack   string
+	service *service.DockerService
+	err     error
+}
+
+// undeployStacks remueve los contenedores de cada stack en paralelo
+func undeployStacks(stackMap map[string][]*service.DockerService, timeout uint) map[string][]undeployOutcome {
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	outcomes := make(map[string][]undeployOutcome)
+
+	for stackKey, services := range stackMap {
+		wg.Add(1)
+		go func(stackKey string, services []*service.DockerService) {
+			defer wg.Done()
+			var stackOutcomes []undeployOutcome
+			for _, srv := range services {
+				err := srv.UndeployWithTimeout(timeout)
+				stackOutcomes = append(stackOutcomes, undeployOutcome{stack: stackKey, service: srv, err: err})
+			}
+
+			mutex.Lock()
+			outcomes[stackKey] = stackOutcomes
+			mutex.Unlock()
+		}(stackKey, services)
+	}
+
+	wg.Wait()
+	return outcomes
+}
+
+func undeployCmd(c *cli.Context) {
+	stackMap, err := selectContainers(c)
+	if err != nil {
+		util.Log.Fatalln(err)
+	}
+
+	total := 0
+	for _, services := range stackMap {
+		total += len(services)
+	}
+
+	if total == 0 {
+		fmt.Println("No se encontraron contenedores")
+		return
+	}
+
+	renderContainers(stackMap)
+
+	if !c.Bool("yes") && !confirm(fmt.Sprintf("¿Remover %d contenedores?", total)) {
+		fmt.Println("Undeploy cancelado")
+		return
+	}
+
+	outcomes := undeployStacks(stackMap, uint(c.Int("stop-timeout")))
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Stack", "Name", "Image", "Result"})
+
+	failed := 0
+	for _, stackKey := range sortedStackKeys(stackMap) {
+		stackFailed := 0
+		for _, outcome := range outcomes[stackKey] {
+			result := "removido"
+			if outcome.err != nil {
+				result = outcome.err.Error()
+				stackFailed++
+			}
+			table.Append([]string{stackKey, outcome.service.ContainerName(), outcome.service.ContainerImageName(), result})
+		}
+
+		util.Log.Infof("Undeploy del stack %s: %d removidos, %d con errores", stackKey, len(outcomes[stackKey])-stackFailed, stackFailed)
+		failed += stackFailed
+	}
+	table.Render()
+
+	if failed > 0 {
+		util.Log.Errorf("No se pudieron remover %d contenedores", failed)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,263 @@
+package cli
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/ch3lo/yale/service"
+	"github.com/codegangsta/cli"
+	"github.com/olekukonko/tablewriter"
+)
+
+// portView es un puerto publicado de un contenedor
+type portView struct {
+	Private int64 `json:"private"`
+	Public  int64 `json:"public"`
+}
+
+// containerView es la representacion de un contenedor en la salida de los comandos. Los
+// nombres de los campos son estables, ya que los utilizan los formatos json, yaml y template
+type containerView struct {
+	Stack     string            `json:"stack"`
+	Id        string            `json:"id"`
+	Node      string            `json:"node"`
+	Name      string            `json:"name"`
+	Image     string            `json:"image"`
+	Tag       string            `json:"tag"`
+	ServiceId string            `json:"service_id"`
+	State     string            `json:"state"`
+	Status    string            `json:"status"`
+	Created   time.Time         `json:"created"`
+	Ports     []portView        `json:"ports"`
+	Labels    map[string]string `json:"labels"`
+}
+
+type portsByPrivate []portView
+
+func (p portsByPrivate) Len() int           { return len(p) }
+func (p portsByPrivate) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+func (p portsByPrivate) Less(i, j int) bool { return p[i].Private < p[j].Private }
+
+func newContainerView(stackKey string, srv *service.DockerService) containerView {
+	container := srv.Container()
+	view := containerView{
+		Stack:   stackKey,
+		Id:      container.ID,
+		Node:    srv.ContainerSwarmNode(),
+		Name:    strings.TrimPrefix(srv.ContainerName(), "/"),
+		Image:   srv.ContainerImageName(),
+		State:   srv.ContainerStatus(),
+		Status:  srv.ContainerState(),
+		Created: srv.ContainerCreated(),
+		Ports:   []portView{},
+		Labels:  map[string]string{},
+	}
+
+	if container.Config != nil && container.Config.Labels != nil {
+		view.Labels = container.Config.Labels
+		view.Tag = view.Labels["image_tag"]
+		view.ServiceId = view.Labels["service_id"]
+	}
+
+	for private, public := range srv.PublicPorts() {
+		view.Ports = append(view.Ports, portView{Private: private, Public: public})
+	}
+	sort.Sort(portsByPrivate(view.Ports))
+
+	return view
+}
+
+// signature resume los datos que se comparan entre refrescos del modo watch
+func (v containerView) signature() string {
+	return v.State + "/" + v.Tag
+}
+
+func (v containerView) portsString() string {
+	var ports []string
+	for _, port := range v.Ports {
+		ports = append(ports, strconv.FormatInt(port.Public, 10)+"->"+strconv.FormatInt(port.Private, 10))
+	}
+
+	return strings.Join(ports, " ")
+}
+
+func shortId(id string) string {
+	if len(id) > 12 {
+		return id[:12]
+	}
+
+	return id
+}
+
+// viewSortKeys son los campos por los que se pueden ordenar las vistas
+var viewSortKeys = map[string]func(a, b containerView) bool{
+	"stack":   func(a, b containerView) bool { return a.Stack < b.Stack },
+	"node":    func(a, b containerView) bool { return a.Node < b.Node },
+	"name":    func(a, b containerView) bool { return a.Name < b.Name },
+	"image":   func(a, b containerView) bool { return a.Image < b.Image },
+	"tag":     func(a, b containerView) bool { return a.Tag < b.Tag },
+	"state":   func(a, b containerView) bool { return a.State < b.State },
+	"status":  func(a, b containerView) bool { return a.Status < b.Status },
+	"created": func(a, b containerView) bool { return a.Created.Before(b.Created) },
+}
+
+// viewSorter ordena por el campo entregado y luego por stack y nombre, de manera que el
+// resultado sea estable entre ejecuciones
+type viewSorter struct {
+	views []containerView
+	less  func(a, b containerView) bool
+}
+
+func (s viewSorter) Len() int      { return len(s.views) }
+func (s viewSorter) Swap(i, j int) { s.views[i], s.views[j] = s.views[j], s.views[i] }
+func (s viewSorter) Less(i, j int) bool {
+	a, b := s.views[i], s.views[j]
+	if s.less(a, b) != s.less(b, a) {
+		return s.less(a, b)
+	}
+	if a.Stack != b.Stack {
+		return a.Stack < b.Stack
+	}
+	return a.Name < b.Name
+}
+
+// containerViews construye las vistas de los contenedores de todos los stacks ordenadas por el campo entregado
+func containerViews(stackMap map[string][]*service.DockerService, sortKey string) []containerView {
+	views := []containerView{}
+	for stackKey, services := range stackMap {
+		for _, srv := range services {
+			views = append(views, newContainerView(stackKey, srv))
+		}
+	}
+
+	less, ok := viewSortKeys[sortKey]
+	if !ok {
+		less = viewSortKeys["stack"]
+	}
+	sort.Sort(viewSorter{views: views, less: less})
+
+	return views
+}
+
+func outputFlags() []cli.Flag {
+	return []cli.Flag{
+		cli.StringFlag{
+			Name:  "output, o",
+			Value: "table",
+			Usage: "Formato de salida. table | wide | json | yaml | template",
+		},
+		cli.StringFlag{
+			Name:  "template",
+			Usage: "Template de Go que se aplica a cada contenedor con --output template. Por ejemplo '{{.Stack}} {{.Name}}'",
+		},
+		cli.StringFlag{
+			Name:  "sort",
+			Value: "stack",
+			Usage: "Campo por el que se ordenan los contenedores. stack | node | name | image | tag | state | status | created",
+		},
+		cli.BoolFlag{
+			Name:  "quiet, q",
+			Usage: "Muestra solo los ids de los contenedores",
+		},
+	}
+}
+
+func outputBefore(c *cli.Context) error {
+	switch c.String("output") {
+	case "table", "wide", "json", "yaml":
+	case "template":
+		if c.String("template") == "" {
+			return errors.New("Se debe indicar el template con --template")
+		}
+		if _, err := template.New("output").Parse(c.String("template")); err != nil {
+			return errors.New(fmt.Sprintf("Template invalido: %s", err))
+		}
+	default:
+		return errors.New(fmt.Sprintf("Formato de salida %s desconocido", c.String("output")))
+	}
+
+	if _, ok := viewSortKeys[c.String("sort")]; !ok {
+		return errors.New(fmt.Sprintf("No se puede ordenar por %s", c.String("sort")))
+	}
+
+	return nil
+}
+
+// renderViews escribe las vistas en el formato entregado. Si se entrega un tracker, las tablas
+// marcan los contenedores que cambiaron desde el refresco anterior
+func renderViews(w io.Writer, views []containerView, output string, tmpl string, tracker *changeTracker) error {
+	switch output {
+	case "json":
+		encoded, err := json.MarshalIndent(views, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(w, string(encoded))
+		return err
+	case "yaml":
+		return writeYAML(w, views)
+	case "template":
+		t, err := template.New("output").Parse(tmpl)
+		if err != nil {
+			return err
+		}
+		for _, view := range views {
+			if err := t.Execute(w, view); err != nil {
+				return err
+			}
+			fmt.Fprintln(w)
+		}
+		return nil
+	}
+
+	header := []string{"Stack", "Node", "Name", "Image", "Status", "Ports"}
+	if output == "wide" {
+		header = []string{"Stack", "Node", "Id", "Name", "Image", "Service Id", "Created", "Status", "Ports"}
+	}
+	if tracker != nil {
+		header = append([]string{""}, header...)
+	}
+
+	table := tablewriter.NewWriter(w)
+	table.SetHeader(header)
+	for _, v := range views {
+		row := []string{v.Stack, v.Node, v.Name, v.Image, v.Status, v.portsString()}
+		if output == "wide" {
+			row = []string{v.Stack, v.Node, shortId(v.Id), v.Name, v.Image, v.ServiceId, v.Created.Format(time.RFC3339), v.Status, v.portsString()}
+		}
+
+		if tracker != nil {
+			mark := " "
+			if tracker.changed(v.Id, v.signature()) {
+				mark = "*"
+			}
+			row = append([]string{mark}, row...)
+		}
+		table.Append(row)
+	}
+	table.Render()
+
+	return nil
+}
+
+// printContainers escribe los contenedores de acuerdo a los flags de salida del comando
+func printContainers(c *cli.Context, stackMap map[string][]*service.DockerService, tracker *changeTracker) error {
+	views := containerViews(stackMap, c.String("sort"))
+
+	if c.Bool("quiet") {
+		for _, view := range views {
+			fmt.Println(view.Id)
+		}
+		return nil
+	}
+
+	return renderViews(os.Stdout, views, c.String("output"), c.String("template"), tracker)
+}
@@ -0,0 +1,142 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/ch3lo/yale/util"
+	"github.com/codegangsta/cli"
+)
+
+func watchFlags() []cli.Flag {
+	return []cli.Flag{
+		cli.BoolFlag{
+			Name:  "watch, w",
+			Usage: "Refresca la salida periodicamente y cuando Docker notifica eventos de los contenedores",
+		},
+		cli.StringFlag{
+			Name:  "watch-interval",
+			Value: "2s",
+			Usage: "Tiempo entre refrescos del modo watch",
+		},
+	}
+}
+
+func watchBefore(c *cli.Context) error {
+	if !c.Bool("watch") {
+		return nil
+	}
+
+	if d, err := time.ParseDuration(c.String("watch-interval")); err != nil || d <= 0 {
+		return errors.New("Valor del parámetro watch-interval invalido")
+	}
+
+	return nil
+}
+
+// changeTracker recuerda la firma de cada contenedor entre refrescos para destacar los que
+// cambiaron. Un tracker nil no destaca ningun contenedor
+type changeTracker struct {
+	previous map[string]string
+	current  map[string]string
+	first    bool
+}
+
+func newChangeTracker() *changeTracker {
+	return &changeTracker{
+		previous: make(map[string]string),
+		current:  make(map[string]string),
+		first:    true,
+	}
+}
+
+// changed registra la firma del contenedor e indica si es nuevo o si cambio desde el refresco anterior
+func (t *changeTracker) changed(id string, signature string) bool {
+	if t == nil {
+		return false
+	}
+
+	t.current[id] = signature
+	previous, ok := t.previous[id]
+	return !t.first && (!ok || previous != signature)
+}
+
+// commit cierra el refresco y entrega la cantidad de contenedores que ya no existen
+func (t *changeTracker) commit() int {
+	removed := 0
+	for id := range t.previous {
+		if _, ok := t.current[id]; !ok {
+			removed++
+		}
+	}
+
+	t.previous = t.current
+	t.current = make(map[string]string)
+	t.first = false
+
+	return removed
+}
+
+// watch ejecuta refresh en cada intervalo o cuando Docker notifica un evento de los contenedores,
+// redibujando la pantalla, hasta recibir una señal de término
+func watch(c *cli.Context, refresh func(tracker *changeTracker) error) {
+	interval, _ := time.ParseDuration(c.String("watch-interval"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		cancel()
+	}()
+
+	trigger := make(chan struct{}, 1)
+	if events, err := stackManager.Events(ctx); err != nil {
+		util.Log.Warnln("No se pudo seguir los eventos de Docker, solo se refresca por intervalo.", err)
+	} else {
+		go func() {
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-events:
+					select {
+					case trigger <- struct{}{}:
+					default:
+					}
+				}
+			}
+		}()
+	}
+
+	tracker := newChangeTracker()
+	for {
+		stackManager.Reset()
+
+		// se limpia la pantalla y se vuelve al inicio para redibujar en el mismo lugar
+		fmt.Print("\033[H\033[2J")
+		fmt.Printf("Cada %s: %s    (* cambio desde el refresco anterior)\n\n", interval, time.Now().Format(time.RFC1123))
+
+		if err := refresh(tracker); err != nil {
+			fmt.Println(err)
+		}
+
+		if removed := tracker.commit(); removed > 0 {
+			fmt.Printf("%d contenedores ya no existen\n", removed)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-trigger:
+		case <-time.After(interval):
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/ch3lo/yale/webhook"
+	"github.com/codegangsta/cli"
+)
+
+// webhookFlushTimeout es el tiempo maximo que se espera la entrega de los eventos pendientes al terminar
+const webhookFlushTimeout = 30 * time.Second
+
+var webhooks *webhook.Dispatcher
+
+var chatFormat = regexp.MustCompile("^([a-zA-Z]+)=(.+)$")
+
+// parseWebhook procesa un webhook con el formato url[,secret=S][,events=TIPO]...
+// La opcion events se puede repetir para recibir varios tipos de evento
+func parseWebhook(spec string, defaults webhook.Target) (webhook.Target, error) {
+	parts := strings.Split(spec, ",")
+	target := defaults
+	target.URL = strings.TrimSpace(parts[0])
+	target.Events = nil
+
+	if target.URL == "" {
+		return target, errors.New(fmt.Sprintf("El webhook %s no tiene url", spec))
+	}
+
+	for _, opt := range parts[1:] {
+		kv := strings.SplitN(strings.TrimSpace(opt), "=", 2)
+		if len(kv) != 2 {
+			return target, errors.New(fmt.Sprintf("Opcion %s del webhook %s invalida", opt, target.URL))
+		}
+
+		switch kv[0] {
+		case "secret":
+			target.Secret = kv[1]
+		case "events":
+			eventType, err := webhook.NormalizeEvent(kv[1])
+			if err != nil {
+				return target, err
+			}
+			target.Events = append(target.Events, eventType)
+		default:
+			return target, errors.New(fmt.Sprintf("Opcion %s del webhook %s desconocida", kv[0], target.URL))
+		}
+	}
+
+	return target, nil
+}
+
+// parseChat procesa un destino de chat con el formato [slack|teams|generic=]url[,template=ARCHIVO]
+// Si no se entrega el formato se asume slack
+func parseChat(spec string, defaults webhook.Target) (webhook.Target, error) {
+	parts := strings.Split(spec, ",")
+	target := defaults
+	target.URL = strings.TrimSpace(parts[0])
+	target.Format = webhook.FORMAT_SLACK
+
+	if result := chatFormat.FindStringSubmatch(target.URL); result != nil {
+		var err error
+		if target.Format, err = webhook.ParseFormat(result[1]); err != nil || target.Format == webhook.FORMAT_EVENT {
+			return target, errors.New(fmt.Sprintf("Formato del chat %s invalido, se espera slack, teams o generic", result[1]))
+		}
+		target.URL = result[2]
+	}
+
+	if target.URL == "" {
+		return target, errors.New(fmt.Sprintf("El chat %s no tiene url", spec))
+	}
+
+	for _, opt := range parts[1:] {
+		kv := strings.SplitN(strings.TrimSpace(opt), "=", 2)
+		if len(kv) != 2 {
+			return target, errors.New(fmt.Sprintf("Opcion %s del chat %s invalida", opt, target.URL))
+		}
+
+		switch kv[0] {
+		case "template":
+			target.Templates = kv[1]
+		default:
+			return target, errors.New(fmt.Sprintf("Opcion %s del chat %s desconocida", kv[0], target.URL))
+		}
+	}
+
+	return target, nil
+}
+
+// setupWebhooks configura los destinos de los eventos de despliegue, incluidos los chats, y los
+// suscribe al stackManager
+func setupWebhooks(c *cli.Context) error {
+	defaults := webhook.Target{
+		Secret:  c.String("webhook-secret"),
+		Retries: c.Int("webhook-retries"),
+		Backoff: time.Second,
+	}
+
+	var err error
+	if defaults.Timeout, err = time.ParseDuration(c.String("webhook-timeout")); err != nil || defaults.Timeout <= 0 {
+		return errors.New("Valor del parámetro webhook-timeout invalido")
+	}
+
+	if defaults.Retries < 0 {
+		return errors.New("Los reintentos de los webhooks no pueden ser negativos")
+	}
+
+	var targets []webhook.Target
+	for _, spec := range c.StringSlice("webhook") {
+		target, err := parseWebhook(spec, defaults)
+		if err != nil {
+			return err
+		}
+		targets = append(targets, target)
+	}
+
+	chatDefaults := defaults
+	chatDefaults.Secret = ""
+	chatDefaults.Templates = c.String("chat-template")
+	for _, spec := range c.StringSlice("chat") {
+		target, err := parseChat(spec, chatDefaults)
+		if err != nil {
+			return err
+		}
+		targets = append(targets, target)
+	}
+
+	if webhooks, err = webhook.NewDispatcher(targets); err != nil {
+		return err
+	}
+	stackManager.Observe(webhooks.Notify)
+
+	return nil
+}
+
+// closeWebhooks espera la entrega de los eventos pendientes antes de terminar el proceso
+func closeWebhooks() {
+	if webhooks != nil {
+		webhooks.Close(webhookFlushTimeout)
+	}
+}
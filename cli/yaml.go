@@ -0,0 +1,218 @@
+package cli
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// writeYAML escribe el valor en formato YAML. Solo soporta lo necesario para las vistas de
+// yale: structs con tags json, slices, mapas con llaves string, strings, numeros, booleanos y
+// time.Time. Los strings siempre se escriben entre comillas dobles para evitar ambiguedades
+func writeYAML(w io.Writer, value interface{}) error {
+	var b bytes.Buffer
+	if err := encodeYAML(&b, reflect.ValueOf(value), 0, false); err != nil {
+		return err
+	}
+
+	_, err := b.WriteTo(w)
+	return err
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+func yamlScalar(v reflect.Value) (string, bool) {
+	if v.Type() == timeType {
+		return strconv.Quote(v.Interface().(time.Time).Format(time.RFC3339)), true
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		return strconv.Quote(v.String()), true
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool()), true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(v.Uint(), 10), true
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'g', -1, 64), true
+	}
+
+	return "", false
+}
+
+// yamlField es un campo de un struct o una llave de un mapa
+type yamlField struct {
+	name  string
+	value reflect.Value
+}
+
+func yamlFields(v reflect.Value) ([]yamlField, error) {
+	var fields []yamlField
+
+	switch v.Kind() {
+	case reflect.Struct:
+		for k := 0; k < v.NumField(); k++ {
+			field := v.Type().Field(k)
+			if field.PkgPath != "" {
+				continue
+			}
+
+			name := field.Name
+			if tag := strings.Split(field.Tag.Get("json"), ",")[0]; tag == "-" {
+				continue
+			} else if tag != "" {
+				name = tag
+			}
+			fields = append(fields, yamlField{name: name, value: v.Field(k)})
+		}
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return nil, errors.New(fmt.Sprintf("yaml: mapa con llaves %s no soportado", v.Type().Key()))
+		}
+
+		var keys []string
+		for _, key := range v.MapKeys() {
+			keys = append(keys, key.String())
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			fields = append(fields, yamlField{name: key, value: v.MapIndex(reflect.ValueOf(key).Convert(v.Type().Key()))})
+		}
+	}
+
+	return fields, nil
+}
+
+var plainYAMLKey = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_./-]*$`)
+
+// reservedYAMLKey son las palabras que un parser YAML 1.1 interpreta como booleanos o null
+var reservedYAMLKey = regexp.MustCompile(`^(?i:y|n|yes|no|true|false|on|off|null)$`)
+
+// yamlKey escribe la llave sin comillas cuando no es ambigua
+func yamlKey(key string) string {
+	if plainYAMLKey.MatchString(key) && !reservedYAMLKey.MatchString(key) {
+		return key
+	}
+
+	return strconv.Quote(key)
+}
+
+func isYAMLCollection(v reflect.Value) bool {
+	if v.Type() == timeType {
+		return false
+	}
+
+	switch v.Kind() {
+	case reflect.Struct, reflect.Map, reflect.Slice, reflect.Array:
+		return true
+	}
+
+	return false
+}
+
+func isYAMLEmpty(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Map, reflect.Slice, reflect.Array:
+		return v.Len() == 0
+	}
+
+	return false
+}
+
+func yamlEmpty(v reflect.Value) string {
+	if v.Kind() == reflect.Map {
+		return "{}"
+	}
+
+	return "[]"
+}
+
+// encodeYAML escribe el valor con la indentacion entregada. inline indica que la primera linea
+// continua una linea ya iniciada, como ocurre con los elementos de una lista
+func encodeYAML(b *bytes.Buffer, v reflect.Value, indent int, inline bool) error {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			b.WriteString("null\n")
+			return nil
+		}
+		v = v.Elem()
+	}
+
+	if scalar, ok := yamlScalar(v); ok {
+		b.WriteString(scalar + "\n")
+		return nil
+	}
+
+	pad := strings.Repeat("  ", indent)
+
+	switch v.Kind() {
+	case reflect.Slice, reflect.Array:
+		if v.Len() == 0 {
+			b.WriteString("[]\n")
+			return nil
+		}
+
+		for k := 0; k < v.Len(); k++ {
+			if k > 0 || !inline {
+				b.WriteString(pad)
+			}
+			b.WriteString("- ")
+			if err := encodeYAML(b, v.Index(k), indent+1, true); err != nil {
+				return err
+			}
+		}
+	case reflect.Struct, reflect.Map:
+		fields, err := yamlFields(v)
+		if err != nil {
+			return err
+		}
+
+		if len(fields) == 0 {
+			b.WriteString("{}\n")
+			return nil
+		}
+
+		for k, field := range fields {
+			if k > 0 || !inline {
+				b.WriteString(pad)
+			}
+			b.WriteString(yamlKey(field.name) + ":")
+
+			value := field.value
+			for (value.Kind() == reflect.Ptr || value.Kind() == reflect.Interface) && !value.IsNil() {
+				value = value.Elem()
+			}
+
+			if isYAMLCollection(value) && !isYAMLEmpty(value) {
+				b.WriteString("\n")
+				if err := encodeYAML(b, value, indent+1, false); err != nil {
+					return err
+				}
+				continue
+			}
+
+			if isYAMLCollection(value) {
+				b.WriteString(" " + yamlEmpty(value) + "\n")
+				continue
+			}
+
+			b.WriteString(" ")
+			if err := encodeYAML(b, value, indent+1, true); err != nil {
+				return err
+			}
+		}
+	default:
+		return errors.New(fmt.Sprintf("yaml: tipo %s no soportado", v.Type()))
+	}
+
+	return nil
+}
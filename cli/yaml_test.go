@@ -0,0 +1,251 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// yamlLine es una linea no vacia del documento con su indentacion
+type yamlLine struct {
+	indent  int
+	content string
+}
+
+// yamlNumber son los numeros que un parser YAML interpreta sin comillas
+var yamlNumber = regexp.MustCompile(`^[-+]?(\.[0-9]+|[0-9]+(\.[0-9]*)?)([eE][-+]?[0-9]+)?$`)
+
+// resolveYAMLScalar interpreta un escalar como lo hace un parser YAML 1.1, de manera que las
+// llaves y valores ambiguos escritos sin comillas cambian de tipo
+func resolveYAMLScalar(text string) (interface{}, error) {
+	switch {
+	case strings.HasPrefix(text, `"`):
+		return strconv.Unquote(text)
+	case text == "[]":
+		return []interface{}{}, nil
+	case text == "{}":
+		return map[string]interface{}{}, nil
+	case text == "~" || strings.EqualFold(text, "null"):
+		return nil, nil
+	case reservedYAMLKey.MatchString(text):
+		switch strings.ToLower(text) {
+		case "y", "yes", "true", "on":
+			return true, nil
+		}
+		return false, nil
+	case yamlNumber.MatchString(text):
+		return strconv.ParseFloat(text, 64)
+	}
+
+	return text, nil
+}
+
+// splitYAMLKey separa la llave y el resto de la linea de un mapa
+func splitYAMLKey(content string) (string, string, error) {
+	if strings.HasPrefix(content, `"`) {
+		quoted, err := strconv.QuotedPrefix(content)
+		if err != nil {
+			return "", "", err
+		}
+		key, _ := strconv.Unquote(quoted)
+		rest := content[len(quoted):]
+		if !strings.HasPrefix(rest, ":") {
+			return "", "", errors.New(fmt.Sprintf("falta ':' luego de la llave %s", quoted))
+		}
+		return key, strings.TrimSpace(rest[1:]), nil
+	}
+
+	colon := strings.Index(content, ":")
+	if colon < 0 {
+		return "", "", errors.New(fmt.Sprintf("linea sin llave %q", content))
+	}
+
+	key, err := resolveYAMLScalar(content[:colon])
+	if err != nil {
+		return "", "", err
+	}
+	if _, ok := key.(string); !ok {
+		return "", "", errors.New(fmt.Sprintf("la llave %q se interpreta como %#v", content[:colon], key))
+	}
+
+	return key.(string), strings.TrimSpace(content[colon+1:]), nil
+}
+
+// parseYAMLBlock decodifica el subconjunto de YAML que escribe writeYAML: mapas y listas en
+// bloque, colecciones vacias y escalares
+func parseYAMLBlock(lines []yamlLine, i int, indent int) (interface{}, int, error) {
+	if strings.HasPrefix(lines[i].content, "- ") {
+		list := []interface{}{}
+		for i < len(lines) && lines[i].indent == indent && strings.HasPrefix(lines[i].content, "- ") {
+			lines[i] = yamlLine{indent: indent + 2, content: lines[i].content[2:]}
+			value, next, err := parseYAMLValue(lines, i, indent+2)
+			if err != nil {
+				return nil, 0, err
+			}
+			list = append(list, value)
+			i = next
+		}
+		return list, i, nil
+	}
+
+	mapping := map[string]interface{}{}
+	for i < len(lines) && lines[i].indent == indent {
+		key, rest, err := splitYAMLKey(lines[i].content)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		if _, ok := mapping[key]; ok {
+			return nil, 0, errors.New(fmt.Sprintf("llave %q repetida", key))
+		}
+
+		if rest != "" {
+			if mapping[key], err = resolveYAMLScalar(rest); err != nil {
+				return nil, 0, err
+			}
+			i++
+			continue
+		}
+
+		if i+1 >= len(lines) || lines[i+1].indent <= indent {
+			return nil, 0, errors.New(fmt.Sprintf("la llave %q no tiene valor", key))
+		}
+
+		value, next, err := parseYAMLBlock(lines, i+1, lines[i+1].indent)
+		if err != nil {
+			return nil, 0, err
+		}
+		mapping[key] = value
+		i = next
+	}
+
+	return mapping, i, nil
+}
+
+// parseYAMLValue decodifica el valor de la linea, que puede ser un escalar o el inicio de una coleccion
+func parseYAMLValue(lines []yamlLine, i int, indent int) (interface{}, int, error) {
+	content := lines[i].content
+	if strings.HasPrefix(content, "- ") {
+		return parseYAMLBlock(lines, i, indent)
+	}
+
+	if _, _, err := splitYAMLKey(content); err == nil {
+		return parseYAMLBlock(lines, i, indent)
+	}
+
+	value, err := resolveYAMLScalar(content)
+	return value, i + 1, err
+}
+
+func parseYAML(document string) (interface{}, error) {
+	var lines []yamlLine
+	for _, line := range strings.Split(document, "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		content := strings.TrimLeft(line, " ")
+		lines = append(lines, yamlLine{indent: len(line) - len(content), content: content})
+	}
+
+	if len(lines) == 0 {
+		return nil, errors.New("documento vacio")
+	}
+
+	value, next, err := parseYAMLValue(lines, 0, 0)
+	if err == nil && next != len(lines) {
+		err = errors.New(fmt.Sprintf("linea %q inesperada", lines[next].content))
+	}
+
+	return value, err
+}
+
+func TestYAMLKey(t *testing.T) {
+	cases := []struct {
+		key      string
+		expected string
+	}{
+		{"stack", "stack"},
+		{"service_id", "service_id"},
+		{"com.docker.compose/version", "com.docker.compose/version"},
+		{"true", `"true"`},
+		{"False", `"False"`},
+		{"null", `"null"`},
+		{"NULL", `"NULL"`},
+		{"yes", `"yes"`},
+		{"no", `"no"`},
+		{"on", `"on"`},
+		{"OFF", `"OFF"`},
+		{"y", `"y"`},
+		{"n", `"n"`},
+		{"yesterday", "yesterday"},
+		{"online", "online"},
+		{"1abc", `"1abc"`},
+		{"", `""`},
+		{"con espacio", `"con espacio"`},
+		{"key: value", `"key: value"`},
+	}
+
+	for _, c := range cases {
+		if key := yamlKey(c.key); key != c.expected {
+			t.Errorf("La llave %q se escribió %s, se esperaba %s", c.key, key, c.expected)
+		}
+	}
+}
+
+func TestYAMLRoundTripsContainerViews(t *testing.T) {
+	created := time.Date(2026, 10, 18, 12, 30, 0, 0, time.UTC)
+	cases := []struct {
+		name  string
+		views []containerView
+	}{
+		{"sin contenedores", []containerView{}},
+		{"sin puertos ni labels", []containerView{{Stack: "a", Id: "abc", Created: created, Ports: []portView{}, Labels: map[string]string{}}}},
+		{"con puertos", []containerView{{Stack: "a", Name: "app", Created: created, Ports: []portView{{Private: 8080, Public: 32768}, {Private: 9090, Public: 32769}}, Labels: map[string]string{}}}},
+		{"con labels reservados", []containerView{{Stack: "a", Created: created, Ports: []portView{}, Labels: map[string]string{
+			"true":  "false",
+			"null":  "null",
+			"yes":   "no",
+			"on":    "",
+			"Off":   "1",
+			"y":     "n",
+			"1.0":   "2",
+			"image": "app",
+		}}}},
+		{"con valores especiales", []containerView{
+			{Stack: "a", Name: "app: \"web\"", Image: "registry/app:1.0", Status: "Up 5 minutes (healthy)", Created: created, Ports: []portView{}, Labels: map[string]string{
+				"com.docker.compose/version": "1.0",
+				"descripcion":                "linea 1\nlinea 2\tcon tab y ñandú",
+				"- lista":                    "# comentario",
+			}},
+			{Stack: "b", Created: created, Ports: []portView{{Private: 80, Public: 80}}, Labels: map[string]string{"tag": "~"}},
+		}},
+	}
+
+	for _, c := range cases {
+		var b bytes.Buffer
+		if err := writeYAML(&b, c.views); err != nil {
+			t.Fatalf("%s: %s", c.name, err)
+		}
+
+		decoded, err := parseYAML(b.String())
+		if err != nil {
+			t.Errorf("%s: YAML invalido %s\n%s", c.name, err, b.String())
+			continue
+		}
+
+		data, _ := json.Marshal(c.views)
+		var expected interface{}
+		json.Unmarshal(data, &expected)
+
+		if !reflect.DeepEqual(decoded, expected) {
+			t.Errorf("%s: el YAML se decodificó como %#v, se esperaba %#v\n%s", c.name, decoded, expected, b.String())
+		}
+	}
+}
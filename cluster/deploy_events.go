@@ -0,0 +1,188 @@
+package cluster
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"github.com/ch3lo/yale/service"
+	"github.com/ch3lo/yale/tracing"
+)
+
+// EVENT_DEPLOY_STARTED    Comienza un despliegue o escalamiento
+// EVENT_SERVICE_STEP      Un contenedor nuevo avanzó de paso (STEP_CREATED -> STEP_SMOKE_READY -> ...)
+// EVENT_STACK_FINISHED    Un stack terminó su parte del despliegue
+// EVENT_ROLLBACK_STARTED  Comienza el rollback de un stack
+// EVENT_DEPLOY_FINISHED   Termina el despliegue, con su resultado
+type DeployEventType int
+
+const (
+	EVENT_DEPLOY_STARTED DeployEventType = 1 + iota
+	EVENT_SERVICE_STEP
+	EVENT_STACK_FINISHED
+	EVENT_ROLLBACK_STARTED
+	EVENT_DEPLOY_FINISHED
+)
+
+var deployEventType = [...]string{
+	"EVENT_DEPLOY_STARTED",
+	"EVENT_SERVICE_STEP",
+	"EVENT_STACK_FINISHED",
+	"EVENT_ROLLBACK_STARTED",
+	"EVENT_DEPLOY_FINISHED",
+}
+
+func (t DeployEventType) String() string {
+	return deployEventType[t-1]
+}
+
+// DeployEvent es un hito de un despliegue. DeployId agrupa los eventos de un mismo despliegue,
+// TraceId es la traza del despliegue si las trazas estan activas y los demas campos se
+// completan segun el tipo de evento
+type DeployEvent struct {
+	Type        DeployEventType
+	DeployId    string
+	TraceId     string
+	Time        time.Time
+	Operation   string
+	Image       string
+	Tag         string
+	Stacks      []string
+	Stack       string
+	ServiceId   string
+	ContainerId string
+	Step        string
+	Status      string
+	Ok          bool
+	Result      *DeployResult
+}
+
+// DeployObserver recibe los eventos del despliegue. Se invoca desde las gorutinas del
+// despliegue, por lo que no debe bloquear
+type DeployObserver func(event DeployEvent)
+
+type observers struct {
+	mutex     sync.Mutex
+	deployId  string
+	operation string
+	image     string
+	tag       string
+	span      *tracing.Span
+	list      []DeployObserver
+}
+
+// Observe registra un observador de los eventos de los despliegues
+func (sm *StackManager) Observe(observer DeployObserver) {
+	sm.observers.mutex.Lock()
+	defer sm.observers.mutex.Unlock()
+
+	sm.observers.list = append(sm.observers.list, observer)
+}
+
+func newDeployId() string {
+	id := make([]byte, 8)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// begin inicia un nuevo despliegue, cuyos eventos comparten el identificador. Entrega el
+// contexto con el span del despliegue, del que cuelgan los spans de los stacks y servicios
+func (sm *StackManager) begin(ctx context.Context, operation string, image string, tag string) context.Context {
+	deployId := newDeployId()
+	ctx, span := tracing.Start(ctx, operation+" "+image,
+		tracing.Attr("deploy.id", deployId),
+		tracing.Attr("deploy.operation", operation),
+		tracing.Attr("container.image", image),
+		tracing.Attr("container.tag", tag))
+
+	sm.observers.mutex.Lock()
+	sm.observers.deployId = deployId
+	sm.observers.operation = operation
+	sm.observers.image = image
+	sm.observers.tag = tag
+	sm.observers.span = span
+	sm.observers.mutex.Unlock()
+
+	for _, stack := range sm.stacks {
+		stack.trace = ctx
+	}
+
+	sm.emit(DeployEvent{Type: EVENT_DEPLOY_STARTED, Stacks: sm.StackKeys()})
+
+	return ctx
+}
+
+// finish notifica el termino del despliegue con su resultado y termina su span
+func (sm *StackManager) finish(ok bool) bool {
+	result := sm.Result()
+	sm.emit(DeployEvent{Type: EVENT_DEPLOY_FINISHED, Ok: ok, Result: &result})
+
+	sm.observers.mutex.Lock()
+	span := sm.observers.span
+	sm.observers.mutex.Unlock()
+
+	span.SetAttributes(
+		tracing.Attr("deploy.succeeded", len(result.Succeeded)),
+		tracing.Attr("deploy.failed", len(result.Failed)),
+		tracing.Attr("deploy.rolled_back", len(result.RolledBack)))
+	if !ok {
+		span.SetStatus(tracing.STATUS_ERROR, "El despliegue falló")
+	}
+	span.End()
+
+	return ok
+}
+
+// DeployId entrega el identificador del ultimo despliegue iniciado
+func (sm *StackManager) DeployId() string {
+	sm.observers.mutex.Lock()
+	defer sm.observers.mutex.Unlock()
+
+	return sm.observers.deployId
+}
+
+// TraceId entrega el identificador de la traza del ultimo despliegue iniciado, vacio si las
+// trazas estan desactivadas
+func (sm *StackManager) TraceId() string {
+	sm.observers.mutex.Lock()
+	defer sm.observers.mutex.Unlock()
+
+	return sm.observers.span.TraceId()
+}
+
+// emit completa el evento con los datos del despliegue en curso y lo entrega a los observadores
+func (sm *StackManager) emit(event DeployEvent) {
+	sm.observers.mutex.Lock()
+	event.DeployId = sm.observers.deployId
+	event.TraceId = sm.observers.span.TraceId()
+	event.Operation = sm.observers.operation
+	event.Image = sm.observers.image
+	event.Tag = sm.observers.tag
+	list := sm.observers.list
+	sm.observers.mutex.Unlock()
+
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	for _, observer := range list {
+		observer(event)
+	}
+}
+
+// emitStep notifica el paso de un contenedor nuevo del stack
+func (s *Stack) emitStep(dockerService *service.DockerService) {
+	event := DeployEvent{
+		Type:      EVENT_SERVICE_STEP,
+		Stack:     s.id,
+		ServiceId: dockerService.GetId(),
+		Step:      dockerService.GetStep().String(),
+	}
+	if container := dockerService.Container(); container != nil {
+		event.ContainerId = container.ID
+	}
+
+	s.emit(event)
+}
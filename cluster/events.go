@@ -0,0 +1,45 @@
+package cluster
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/ch3lo/yale/helper"
+)
+
+// StackEvent es un evento de un contenedor administrado por yale ocurrido en un stack
+type StackEvent struct {
+	Stack string
+	Event helper.ContainerEvent
+}
+
+// Events sigue en paralelo los eventos de los contenedores administrados por yale de todos
+// los stacks y los entrega en un unico canal hasta que se cancela el contexto
+func (sm *StackManager) Events(ctx context.Context) (<-chan StackEvent, error) {
+	merged := make(chan StackEvent, 100)
+
+	for stackKey, stack := range sm.stacks {
+		events, err := stack.dockerApiHelper.ContainerEvents(ctx)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("No se pudo obtener los eventos del stack %s: %s", stackKey, err))
+		}
+
+		go func(stackKey string, events <-chan helper.ContainerEvent) {
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case event := <-events:
+					select {
+					case merged <- StackEvent{Stack: stackKey, Event: event}:
+					case <-ctx.Done():
+						return
+					}
+				}
+			}
+		}(stackKey, events)
+	}
+
+	return merged, nil
+}
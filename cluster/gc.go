@@ -0,0 +1,295 @@
+package cluster
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/ch3lo/yale/helper"
+	"github.com/fsouza/go-dockerclient"
+)
+
+// GCConfig define la politica de retencion de la recoleccion de basura.
+// Keep es la cantidad de tags mas recientes que se conservan por cada label image_name, ademas
+// del tag desplegado actualmente que nunca se remueve.
+// ImageName limita la recoleccion a una imagen, vacio considera todas las imagenes desplegadas por yale.
+// Dangling indica si tambien se remueven las imagenes sin tag. No se limita a ImageName, ya que
+// una imagen sin tag no conserva su repositorio.
+// DryRun solo calcula lo que se removeria sin remover nada.
+type GCConfig struct {
+	Keep      int
+	ImageName string
+	Dangling  bool
+	DryRun    bool
+}
+
+// GCItem es un contenedor o imagen considerado por la recoleccion de basura.
+// Reason explica por que no se removio y Err el error al intentar removerlo
+type GCItem struct {
+	Kind   string
+	Id     string
+	Name   string
+	Image  string
+	Reason string
+	Err    error
+}
+
+// GCResult resume la recoleccion de basura de un stack
+type GCResult struct {
+	Removed []GCItem
+	Skipped []GCItem
+	Err     error
+}
+
+// imageHistory agrupa los contenedores e imagenes de un label image_name
+type imageHistory struct {
+	containers []docker.APIContainers
+	images     map[string]docker.APIImages
+	lastUsed   map[string]int64
+	current    string
+	currentAt  int64
+}
+
+func newImageHistory() *imageHistory {
+	return &imageHistory{
+		images:   make(map[string]docker.APIImages),
+		lastUsed: make(map[string]int64),
+	}
+}
+
+func (h *imageHistory) touch(tag string, created int64) {
+	if created > h.lastUsed[tag] || h.lastUsed[tag] == 0 {
+		h.lastUsed[tag] = created
+	}
+}
+
+// retained entrega los tags que se conservan: los keep tags mas recientes y el tag desplegado
+func (h *imageHistory) retained(keep int) map[string]bool {
+	tags := make([]string, 0, len(h.lastUsed))
+	for tag := range h.lastUsed {
+		tags = append(tags, tag)
+	}
+
+	sort.Sort(newestTagFirst{tags: tags, lastUsed: h.lastUsed})
+
+	kept := make(map[string]bool)
+	for k := 0; k < keep && k < len(tags); k++ {
+		kept[tags[k]] = true
+	}
+
+	if h.current != "" {
+		kept[h.current] = true
+	}
+
+	return kept
+}
+
+// newestTagFirst ordena los tags desde el utilizado mas recientemente
+type newestTagFirst struct {
+	tags     []string
+	lastUsed map[string]int64
+}
+
+func (n newestTagFirst) Len() int      { return len(n.tags) }
+func (n newestTagFirst) Swap(i, j int) { n.tags[i], n.tags[j] = n.tags[j], n.tags[i] }
+func (n newestTagFirst) Less(i, j int) bool {
+	if n.lastUsed[n.tags[i]] == n.lastUsed[n.tags[j]] {
+		return n.tags[i] > n.tags[j]
+	}
+	return n.lastUsed[n.tags[i]] > n.lastUsed[n.tags[j]]
+}
+
+// containersFirst ordena los elementos a remover dejando los contenedores antes que las
+// imagenes que utilizan
+type containersFirst []GCItem
+
+func (c containersFirst) Len() int      { return len(c) }
+func (c containersFirst) Swap(i, j int) { c[i], c[j] = c[j], c[i] }
+func (c containersFirst) Less(i, j int) bool {
+	if c[i].Kind != c[j].Kind {
+		return c[i].Kind == "container"
+	}
+	return c[i].Name < c[j].Name
+}
+
+func containerRunning(container docker.APIContainers) bool {
+	return strings.HasPrefix(container.Status, "Up") || strings.HasPrefix(container.Status, "Restarting")
+}
+
+func containerName(container docker.APIContainers) string {
+	if len(container.Names) == 0 {
+		return container.ID
+	}
+
+	return strings.TrimPrefix(container.Names[0], "/")
+}
+
+// splitRepoTag separa una referencia imagen:tag considerando que el registro puede tener puerto
+func splitRepoTag(repoTag string) (string, string) {
+	k := strings.LastIndex(repoTag, ":")
+	if k < 0 || strings.Contains(repoTag[k:], "/") {
+		return repoTag, "latest"
+	}
+
+	return repoTag[:k], repoTag[k+1:]
+}
+
+// gcPlan calcula los contenedores e imagenes que se remueven y los que se conservan
+func (s *Stack) gcPlan(config GCConfig) (remove []GCItem, skip []GCItem, err error) {
+	containers, err := s.dockerApiHelper.ListManagedContainers(config.ImageName)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	histories := make(map[string]*imageHistory)
+	if config.ImageName != "" {
+		histories[config.ImageName] = newImageHistory()
+	}
+
+	for _, container := range containers {
+		name := container.Labels["image_name"]
+		tag := container.Labels["image_tag"]
+		if histories[name] == nil {
+			histories[name] = newImageHistory()
+		}
+
+		h := histories[name]
+		h.containers = append(h.containers, container)
+		h.touch(tag, container.Created)
+		if containerRunning(container) && container.Created >= h.currentAt {
+			h.current = tag
+			h.currentAt = container.Created
+		}
+	}
+
+	images, err := s.dockerApiHelper.ListImages()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, image := range images {
+		for _, repoTag := range image.RepoTags {
+			name, tag := splitRepoTag(repoTag)
+			if h, ok := histories[name]; ok {
+				h.images[tag] = image
+				if _, ok := h.lastUsed[tag]; !ok {
+					h.touch(tag, image.Created)
+				}
+			}
+		}
+	}
+
+	for name, h := range histories {
+		kept := h.retained(config.Keep)
+		s.log.Debugf("Se conservan los tags %v de la imagen %s, el tag desplegado es %s", kept, name, h.current)
+
+		inUse := make(map[string]bool)
+		for _, container := range h.containers {
+			tag := container.Labels["image_tag"]
+			if kept[tag] {
+				continue
+			}
+
+			item := GCItem{Kind: "container", Id: container.ID, Name: containerName(container), Image: name + ":" + tag}
+			if containerRunning(container) {
+				item.Reason = "en ejecucion"
+				skip = append(skip, item)
+				inUse[tag] = true
+				continue
+			}
+
+			remove = append(remove, item)
+		}
+
+		for tag := range h.images {
+			if kept[tag] {
+				continue
+			}
+
+			item := GCItem{Kind: "image", Id: h.images[tag].ID, Name: name + ":" + tag, Image: name + ":" + tag}
+			if inUse[tag] {
+				item.Reason = "en uso"
+				skip = append(skip, item)
+				continue
+			}
+
+			remove = append(remove, item)
+		}
+	}
+
+	if config.Dangling {
+		dangling, err := s.dockerApiHelper.ListDanglingImages()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		for _, image := range dangling {
+			remove = append(remove, GCItem{Kind: "image", Id: image.ID, Name: image.ID, Image: "<none>"})
+		}
+	}
+
+	sort.Sort(containersFirst(remove))
+
+	return remove, skip, nil
+}
+
+// CollectGarbage remueve los contenedores detenidos y las imagenes de los tags que quedan fuera
+// de la politica de retencion. Los contenedores en ejecucion nunca se remueven
+func (s *Stack) CollectGarbage(config GCConfig) GCResult {
+	var result GCResult
+
+	remove, skip, err := s.gcPlan(config)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+
+	result.Skipped = skip
+	if config.DryRun {
+		result.Removed = remove
+		return result
+	}
+
+	for _, item := range remove {
+		if item.Kind == "container" {
+			item.Err = s.dockerApiHelper.RemoveContainer(item.Id)
+		} else {
+			// se remueve por nombre para no afectar otros tags de la misma imagen
+			item.Err = s.dockerApiHelper.RemoveImage(item.Name)
+		}
+
+		if item.Err == helper.ErrImageInUse {
+			item.Reason = "en uso"
+			item.Err = nil
+			result.Skipped = append(result.Skipped, item)
+			continue
+		}
+
+		result.Removed = append(result.Removed, item)
+	}
+
+	s.log.Infof("Recoleccion de basura: %d removidos, %d conservados", len(result.Removed), len(result.Skipped))
+	return result
+}
+
+// CollectGarbage ejecuta la recoleccion de basura en todos los stacks en paralelo
+func (sm *StackManager) CollectGarbage(config GCConfig) map[string]GCResult {
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	results := make(map[string]GCResult)
+
+	for stackKey, stack := range sm.stacks {
+		wg.Add(1)
+		go func(stackKey string, stack *Stack) {
+			defer wg.Done()
+			result := stack.CollectGarbage(config)
+
+			mutex.Lock()
+			results[stackKey] = result
+			mutex.Unlock()
+		}(stackKey, stack)
+	}
+
+	wg.Wait()
+	return results
+}
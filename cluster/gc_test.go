@@ -0,0 +1,109 @@
+package cluster
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/ch3lo/yale/helper"
+	"github.com/ch3lo/yale/helper/dockertest"
+)
+
+func TestImageHistoryRetained(t *testing.T) {
+	cases := []struct {
+		name     string
+		lastUsed map[string]int64
+		current  string
+		keep     int
+		expected []string
+	}{
+		{"sin tags", map[string]int64{}, "", 3, []string{}},
+		{"menos tags que keep", map[string]int64{"1.0": 10, "1.1": 20}, "", 3, []string{"1.0", "1.1"}},
+		{"conserva los mas recientes", map[string]int64{"1.0": 10, "1.1": 20, "1.2": 30, "1.3": 40}, "", 2, []string{"1.2", "1.3"}},
+		{"el tag desplegado se conserva fuera del corte", map[string]int64{"1.0": 10, "1.1": 20, "1.2": 30}, "1.0", 1, []string{"1.0", "1.2"}},
+		{"el tag desplegado dentro del corte", map[string]int64{"1.0": 10, "1.1": 20, "1.2": 30}, "1.2", 2, []string{"1.1", "1.2"}},
+		{"keep 0 conserva solo el desplegado", map[string]int64{"1.0": 10, "1.1": 20}, "1.1", 0, []string{"1.1"}},
+		{"keep 0 sin desplegado", map[string]int64{"1.0": 10, "1.1": 20}, "", 0, []string{}},
+		{"empates por nombre de tag", map[string]int64{"a": 10, "b": 10, "c": 10}, "", 2, []string{"b", "c"}},
+	}
+
+	for _, c := range cases {
+		h := newImageHistory()
+		h.lastUsed = c.lastUsed
+		h.current = c.current
+
+		kept := []string{}
+		for tag := range h.retained(c.keep) {
+			kept = append(kept, tag)
+		}
+		sort.Strings(kept)
+
+		if !reflect.DeepEqual(kept, c.expected) {
+			t.Errorf("%s: se conservan %v, se esperaban %v", c.name, kept, c.expected)
+		}
+	}
+}
+
+func TestSplitRepoTag(t *testing.T) {
+	cases := []struct {
+		repoTag string
+		name    string
+		tag     string
+	}{
+		{"app:1.0", "app", "1.0"},
+		{"registry.it.lan.com/app:1.1-abc", "registry.it.lan.com/app", "1.1-abc"},
+		{"registry:5000/app:2", "registry:5000/app", "2"},
+		{"registry:5000/app", "registry:5000/app", "latest"},
+		{"app", "app", "latest"},
+	}
+
+	for _, c := range cases {
+		if name, tag := splitRepoTag(c.repoTag); name != c.name || tag != c.tag {
+			t.Errorf("%s se separó en %s y %s, se esperaba %s y %s", c.repoTag, name, tag, c.name, c.tag)
+		}
+	}
+}
+
+func TestGCPlanKeepsRecentAndCurrentTags(t *testing.T) {
+	engine := dockertest.NewEngine("node-a")
+	sm := NewStackManager()
+	if err := sm.AppendStack("a", helper.NewDockerHelperFromClient(engine, ""), StackConfig{Weight: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	// todos los contenedores se crean en el mismo segundo, por lo que los tags se ordenan por nombre
+	for _, tag := range []string{"1.0", "1.1", "1.2", "1.3"} {
+		container, err := engine.Run(testImage+":"+tag, map[string]string{"image_name": testImage, "image_tag": tag})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tag != "1.0" {
+			engine.Crash(container.ID, 0)
+		}
+	}
+	engine.AddImage("otra/app:1.0")
+
+	remove, skip, err := sm.stacks["a"].gcPlan(GCConfig{Keep: 1, ImageName: testImage})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var removed []string
+	for _, item := range remove {
+		removed = append(removed, item.Kind+" "+item.Image)
+	}
+
+	expected := []string{
+		"container " + testImage + ":1.1",
+		"container " + testImage + ":1.2",
+		"image " + testImage + ":1.1",
+		"image " + testImage + ":1.2",
+	}
+	if !reflect.DeepEqual(removed, expected) {
+		t.Errorf("Se remueven %v, se esperaba %v", removed, expected)
+	}
+
+	if len(skip) != 0 {
+		t.Errorf("Se omiten %+v", skip)
+	}
+}
@@ -0,0 +1,137 @@
+package cluster
+
+import (
+	"sync"
+	"time"
+
+	"github.com/ch3lo/yale/metrics"
+)
+
+// deployBuckets son los limites de los histogramas de duracion de los despliegues, en segundos
+var deployBuckets = []float64{5, 15, 30, 60, 120, 300, 600, 1200, 1800}
+
+var (
+	deploysTotal = metrics.Default.NewCounterVec(
+		"yale_deploys_total",
+		"Despliegues terminados por operacion, servicio y resultado (succeeded, partial, canceled o failed)",
+		"operation", "service", "outcome")
+	deployDuration = metrics.Default.NewHistogramVec(
+		"yale_deploy_duration_seconds",
+		"Duracion de los despliegues por operacion y servicio",
+		deployBuckets,
+		"operation", "service")
+	deployLastTimestamp = metrics.Default.NewGaugeVec(
+		"yale_deploy_last_timestamp_seconds",
+		"Momento en que terminó el ultimo despliegue por operacion y servicio",
+		"operation", "service")
+	deployLastOutcome = metrics.Default.NewGaugeVec(
+		"yale_deploy_last_outcome",
+		"Resultado del ultimo despliegue por operacion y servicio: 1 en el resultado obtenido y 0 en los demas",
+		"operation", "service", "outcome")
+	deployLastDuration = metrics.Default.NewGaugeVec(
+		"yale_deploy_last_duration_seconds",
+		"Duracion del ultimo despliegue por operacion y servicio",
+		"operation", "service")
+	stackDeploysTotal = metrics.Default.NewCounterVec(
+		"yale_stack_deploys_total",
+		"Despliegues terminados en cada stack por operacion, servicio y estado",
+		"operation", "service", "stack", "status")
+	stackDeployDuration = metrics.Default.NewHistogramVec(
+		"yale_stack_deploy_duration_seconds",
+		"Duracion del despliegue en cada stack",
+		deployBuckets,
+		"service", "stack")
+	containersCreated = metrics.Default.NewCounterVec(
+		"yale_containers_created_total",
+		"Contenedores creados por los despliegues",
+		"service", "stack")
+	rollbacksTotal = metrics.Default.NewCounterVec(
+		"yale_rollbacks_total",
+		"Rollbacks iniciados en cada stack",
+		"service", "stack")
+	containersUndeployed = metrics.Default.NewCounterVec(
+		"yale_containers_undeployed_total",
+		"Contenedores removidos por rollback, escalamiento o reinicio",
+		"stack")
+)
+
+// deployOutcomes son los resultados posibles de un despliegue terminado
+var deployOutcomes = []string{"succeeded", "partial", "canceled", "failed"}
+
+// deployOutcome clasifica el resultado de un despliegue terminado
+func deployOutcome(ok bool, result *DeployResult) string {
+	if result == nil {
+		if ok {
+			return "succeeded"
+		}
+		return "failed"
+	}
+
+	switch {
+	case ok && len(result.Failed) > 0:
+		return "partial"
+	case ok:
+		return "succeeded"
+	case len(result.Failed) == 0 && len(result.Canceled) > 0:
+		return "canceled"
+	}
+
+	return "failed"
+}
+
+// deployMetrics registra las metricas de los despliegues a partir de sus eventos
+type deployMetrics struct {
+	mutex  sync.Mutex
+	starts map[string]time.Time
+}
+
+func newDeployMetrics() *deployMetrics {
+	return &deployMetrics{starts: make(map[string]time.Time)}
+}
+
+func (m *deployMetrics) started(deployId string) (time.Time, bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	start, ok := m.starts[deployId]
+	return start, ok
+}
+
+// observe es un DeployObserver
+func (m *deployMetrics) observe(event DeployEvent) {
+	switch event.Type {
+	case EVENT_DEPLOY_STARTED:
+		m.mutex.Lock()
+		m.starts[event.DeployId] = event.Time
+		m.mutex.Unlock()
+	case EVENT_SERVICE_STEP:
+		if event.Step == "STEP_CREATED" {
+			containersCreated.Inc(event.Image, event.Stack)
+		}
+	case EVENT_ROLLBACK_STARTED:
+		rollbacksTotal.Inc(event.Image, event.Stack)
+	case EVENT_STACK_FINISHED:
+		stackDeploysTotal.Inc(event.Operation, event.Image, event.Stack, event.Status)
+		if start, ok := m.started(event.DeployId); ok {
+			stackDeployDuration.Observe(event.Time.Sub(start).Seconds(), event.Image, event.Stack)
+		}
+	case EVENT_DEPLOY_FINISHED:
+		outcome := deployOutcome(event.Ok, event.Result)
+		deploysTotal.Inc(event.Operation, event.Image, outcome)
+		deployLastTimestamp.Set(float64(event.Time.UnixNano())/1e9, event.Operation, event.Image)
+		for _, o := range deployOutcomes {
+			value := 0.0
+			if o == outcome {
+				value = 1
+			}
+			deployLastOutcome.Set(value, event.Operation, event.Image, o)
+		}
+		if start, ok := m.started(event.DeployId); ok {
+			deployDuration.Observe(event.Time.Sub(start).Seconds(), event.Operation, event.Image)
+			deployLastDuration.Set(event.Time.Sub(start).Seconds(), event.Operation, event.Image)
+			m.mutex.Lock()
+			delete(m.starts, event.DeployId)
+			m.mutex.Unlock()
+		}
+	}
+}
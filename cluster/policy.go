@@ -0,0 +1,48 @@
+package cluster
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// POLICY_ALL         Si falla un stack se realiza rollback de todos los stacks
+// POLICY_QUORUM      El despliegue es exitoso si la mayoria de los stacks es exitoso. Solo se realiza rollback de los stacks fallidos
+// POLICY_BEST_EFFORT El despliegue es exitoso si al menos un stack es exitoso. Solo se realiza rollback de los stacks fallidos
+// En todas las politicas, la falla de un stack requerido implica el rollback de todos los stacks
+type FailurePolicy int
+
+const (
+	POLICY_ALL FailurePolicy = 1 + iota
+	POLICY_QUORUM
+	POLICY_BEST_EFFORT
+)
+
+var failurePolicy = [...]string{
+	"all",
+	"quorum",
+	"best-effort",
+}
+
+func (p FailurePolicy) String() string {
+	return failurePolicy[p-1]
+}
+
+func GetFailurePolicy(p string) (FailurePolicy, error) {
+	for k, v := range failurePolicy {
+		if strings.ToLower(p) == v {
+			return FailurePolicy(k + 1), nil
+		}
+	}
+
+	return POLICY_ALL, errors.New(fmt.Sprintf("Politica de fallo %s desconocida", p))
+}
+
+// DeployResult resume el estado final de los stacks luego de un despliegue
+type DeployResult struct {
+	Policy     string   `json:"Policy"`
+	Succeeded  []string `json:"Succeeded"`
+	Failed     []string `json:"Failed"`
+	Canceled   []string `json:"Canceled"`
+	RolledBack []string `json:"RolledBack"`
+}
@@ -0,0 +1,84 @@
+package cluster
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/ch3lo/yale/monitor"
+	"github.com/ch3lo/yale/service"
+	"github.com/ch3lo/yale/util"
+)
+
+// ReconcileResult resume la reconciliacion de un stack.
+// Running son las instancias en ejecucion encontradas y Removed los contenedores detenidos removidos
+type ReconcileResult struct {
+	Desired int
+	Running int
+	Removed int
+	Status  StackStatus
+	Err     error
+}
+
+// removeStopped remueve los contenedores cargados que ya no estan corriendo
+func (s *Stack) removeStopped() int {
+	removed := 0
+	for _, srv := range s.services {
+		if srv.Container() == nil || srv.Container().State.Running {
+			continue
+		}
+
+		s.log.Infof("Removiendo el contenedor detenido %s", srv.ContainerName())
+		if err := srv.UndeployWithTimeout(10); err != nil {
+			s.log.Errorln("No se pudo remover el contenedor detenido", err)
+			continue
+		}
+		removed++
+	}
+
+	return removed
+}
+
+// Reconcile lleva cada stack a la cantidad de instancias deseada del servicio. Los contenedores
+// detenidos se remueven y se reemplazan pasando por el smoke test y el warm up, igual que en un despliegue.
+// A diferencia de Deploy no se aplica la politica de fallo ni se realiza rollback, un stack fallido
+// se vuelve a reconciliar en la siguiente ejecucion
+func (sm *StackManager) Reconcile(ctx context.Context, serviceConfig service.ServiceConfig, smokeConfig monitor.MonitorConfig, warmConfig monitor.MonitorConfig, tolerance float64, targets map[string]int) map[string]ReconcileResult {
+	results := make(map[string]ReconcileResult)
+	started := 0
+
+	for stackKey, instances := range targets {
+		result := ReconcileResult{Desired: instances}
+		stack, ok := sm.stacks[stackKey]
+		if !ok {
+			result.Err = errors.New(fmt.Sprintf("El stack %s no esta configurado", stackKey))
+			results[stackKey] = result
+			continue
+		}
+
+		stack.reset()
+		if err := stack.LoadVersionContainers(serviceConfig.ImageName, serviceConfig.Tag); err != nil {
+			result.Err = err
+			results[stackKey] = result
+			continue
+		}
+
+		result.Running = stack.countServicesWithState(service.RUNNING)
+		result.Removed = stack.removeStopped()
+		results[stackKey] = result
+
+		started++
+		go stack.DeployCheckAndNotify(ctx, serviceConfig, smokeConfig, warmConfig, instances, tolerance)
+	}
+
+	for i := 0; i < started; i++ {
+		stackKey := <-sm.stackNotification
+		result := results[stackKey]
+		result.Status = sm.stacks[stackKey].GetStatus()
+		results[stackKey] = result
+		util.Log.Infof("Reconciliacion del stack %s: %d/%d instancias, %d contenedores detenidos removidos, estado %s",
+			stackKey, result.Running, result.Desired, result.Removed, result.Status)
+	}
+
+	return results
+}
@@ -0,0 +1,137 @@
+package cluster
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/ch3lo/yale/monitor"
+	"github.com/ch3lo/yale/service"
+	"github.com/ch3lo/yale/util"
+)
+
+// RestartConfig define el reinicio progresivo de los contenedores.
+// BatchSize es la cantidad de contenedores que se reinician a la vez en cada stack.
+// Timeout son los segundos que tiene el contenedor para detenerse antes de ser terminado.
+type RestartConfig struct {
+	BatchSize int
+	Timeout   uint
+	Smoke     monitor.MonitorConfig
+}
+
+// RestartResult resume el reinicio de un stack. Unverified son los contenedores reiniciados
+// cuyo smoke test se interrumpió por la cancelacion del reinicio, por lo que no se sabe si estan sanos
+type RestartResult struct {
+	Restarted  []*service.DockerService
+	Failed     []*service.DockerService
+	Unverified []*service.DockerService
+	Pending    []*service.DockerService
+	Err        error
+}
+
+// RollingRestart reinicia los contenedores en ejecucion del stack por lotes. Luego de cada
+// lote se ejecuta el smoke test sobre los contenedores reiniciados y, si alguno falla, se
+// detiene el reinicio
+func (s *Stack) RollingRestart(ctx context.Context, config RestartConfig) RestartResult {
+	var result RestartResult
+	services := s.ServicesWithState(service.RUNNING)
+	smokeMonitor := s.createMonitor(config.Smoke, "restart")
+
+	batchSize := config.BatchSize
+	if batchSize <= 0 {
+		batchSize = 1
+	}
+
+	for start := 0; start < len(services); start += batchSize {
+		if ctx.Err() != nil {
+			result.Pending = append(result.Pending, services[start:]...)
+			result.Err = ctx.Err()
+			return result
+		}
+
+		end := start + batchSize
+		if end > len(services) {
+			end = len(services)
+		}
+
+		batch := services[start:end]
+		s.log.Infof("Reiniciando el lote de contenedores %d-%d de %d", start+1, end, len(services))
+
+		var wg sync.WaitGroup
+		healthy := make([]bool, len(batch))
+		interrupted := make([]bool, len(batch))
+		for k := range batch {
+			wg.Add(1)
+			go func(k int) {
+				defer wg.Done()
+				if err := batch[k].Restart(config.Timeout); err != nil {
+					return
+				}
+				healthy[k] = batch[k].Check(ctx, smokeMonitor)
+				interrupted[k] = !healthy[k] && ctx.Err() != nil
+			}(k)
+		}
+		wg.Wait()
+
+		for k := range batch {
+			switch {
+			case healthy[k]:
+				result.Restarted = append(result.Restarted, batch[k])
+			case interrupted[k]:
+				result.Unverified = append(result.Unverified, batch[k])
+			default:
+				result.Failed = append(result.Failed, batch[k])
+			}
+		}
+
+		if len(result.Failed) > 0 {
+			result.Pending = append(result.Pending, services[end:]...)
+			result.Err = errors.New(fmt.Sprintf("Fallo el smoke test de %d contenedores reiniciados", len(result.Failed)))
+			if ctx.Err() != nil {
+				result.Err = ctx.Err()
+			}
+			s.log.Errorln("Se detiene el reinicio del stack.", result.Err)
+			return result
+		}
+
+		if len(result.Unverified) > 0 {
+			result.Pending = append(result.Pending, services[end:]...)
+			result.Err = ctx.Err()
+			s.log.Warnln("Se canceló el reinicio del stack.", result.Err)
+			return result
+		}
+	}
+
+	return result
+}
+
+// RollingRestart reinicia en paralelo los contenedores cargados de cada stack. Si falla un
+// stack se detiene el reinicio en todos los stacks
+func (sm *StackManager) RollingRestart(ctx context.Context, config RestartConfig) map[string]RestartResult {
+	restartCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	results := make(map[string]RestartResult)
+
+	for stackKey, stack := range sm.stacks {
+		wg.Add(1)
+		go func(stackKey string, stack *Stack) {
+			defer wg.Done()
+			result := stack.RollingRestart(restartCtx, config)
+			if len(result.Failed) > 0 && result.Err != context.Canceled {
+				util.Log.Errorf("Fallo el reinicio del stack %s, se detiene el reinicio en los demás stacks", stackKey)
+				cancel()
+			}
+
+			mutex.Lock()
+			results[stackKey] = result
+			mutex.Unlock()
+		}(stackKey, stack)
+	}
+
+	wg.Wait()
+	return results
+}
@@ -0,0 +1,68 @@
+package cluster
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"testing"
+
+	"github.com/ch3lo/yale/helper"
+	"github.com/ch3lo/yale/helper/dockertest"
+	"github.com/ch3lo/yale/monitor"
+)
+
+func TestRollingRestartReportsInterruptedChecksAsUnverified(t *testing.T) {
+	var restarting atomic.Bool
+	handlers := map[string]http.HandlerFunc{
+		// el smoke test del stack a falla durante el reinicio
+		"a": func(w http.ResponseWriter, r *http.Request) {
+			if restarting.Load() {
+				w.Write([]byte("error"))
+				return
+			}
+			healthy(w, r)
+		},
+		// el del stack b espera hasta que se cancela el reinicio
+		"b": func(w http.ResponseWriter, r *http.Request) {
+			if restarting.Load() {
+				<-r.Context().Done()
+				return
+			}
+			healthy(w, r)
+		},
+	}
+
+	tc := &testCluster{manager: NewStackManager(), engines: make(map[string]*dockertest.Engine), auth: authFile(t)}
+	defer tc.close()
+	for _, stackKey := range []string{"a", "b"} {
+		engine := dockertest.NewEngine("node-" + stackKey)
+		tc.engines[stackKey] = engine
+		tc.servers = append(tc.servers, smokeServer(t, engine, handlers[stackKey]))
+		if err := tc.manager.AppendStack(stackKey, helper.NewDockerHelperFromClient(engine, tc.auth), StackConfig{Weight: 1}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if !tc.deploy(1, 0.5, POLICY_ALL) {
+		t.Fatal("Fallo el despliegue inicial")
+	}
+
+	tc.manager.Reset()
+	for _, stackKey := range tc.manager.StackKeys() {
+		if err := tc.manager.stacks[stackKey].LoadVersionContainers(testImage, testTag); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	restarting.Store(true)
+	config := RestartConfig{BatchSize: 1, Smoke: monitor.MonitorConfig{Type: monitor.HTTP, Retries: 1, Request: testSmokeReq, Expected: "ok"}}
+	results := tc.manager.RollingRestart(context.Background(), config)
+
+	if a := results["a"]; len(a.Failed) != 1 || a.Err == nil {
+		t.Errorf("Resultado inesperado del stack a %+v", a)
+	}
+
+	if b := results["b"]; len(b.Failed) != 0 || len(b.Restarted) != 0 || len(b.Unverified)+len(b.Pending) != 1 || b.Err != context.Canceled {
+		t.Errorf("Resultado inesperado del stack b %+v", b)
+	}
+}
@@ -0,0 +1,266 @@
+package cluster
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/ch3lo/yale/service"
+)
+
+// SelectorOperator es la operacion de un requisito del selector
+type SelectorOperator int
+
+const (
+	SELECTOR_EQUALS SelectorOperator = 1 + iota
+	SELECTOR_NOT_EQUALS
+	SELECTOR_IN
+	SELECTOR_NOT_IN
+	SELECTOR_EXISTS
+	SELECTOR_NOT_EXISTS
+)
+
+var selectorOperator = [...]string{
+	"=",
+	"!=",
+	"in",
+	"notin",
+	"exists",
+	"!exists",
+}
+
+func (o SelectorOperator) String() string {
+	return selectorOperator[o-1]
+}
+
+// selectorFields son los atributos del contenedor que se pueden usar en un selector. Cualquier
+// otra llave se busca entre los labels del contenedor
+var selectorFields = []string{"id", "stack", "node", "name", "image", "tag", "state"}
+
+// Requirement es una condicion sobre un atributo o label del contenedor. Los valores aceptan
+// los comodines * y ?
+type Requirement struct {
+	Key      string
+	Operator SelectorOperator
+	Values   []string
+	patterns []*regexp.Regexp
+}
+
+// Selector es un conjunto de requisitos que deben cumplirse todos
+type Selector struct {
+	Requirements []Requirement
+}
+
+var selectorKey = regexp.MustCompile(`^[A-Za-z0-9_.\-/]+$`)
+
+func globPattern(glob string) *regexp.Regexp {
+	pattern := regexp.QuoteMeta(glob)
+	pattern = strings.Replace(pattern, `\*`, ".*", -1)
+	pattern = strings.Replace(pattern, `\?`, ".", -1)
+	return regexp.MustCompile("^" + pattern + "$")
+}
+
+// splitRequirements separa la expresion por las comas que no estan dentro de parentesis
+func splitRequirements(expr string) ([]string, error) {
+	var parts []string
+	depth := 0
+	start := 0
+
+	for k, r := range expr {
+		switch r {
+		case '(':
+			depth++
+			if depth > 1 {
+				return nil, errors.New("Parentesis anidados no soportados")
+			}
+		case ')':
+			depth--
+			if depth < 0 {
+				return nil, errors.New("Parentesis sin abrir")
+			}
+		case ',':
+			if depth == 0 {
+				parts = append(parts, expr[start:k])
+				start = k + 1
+			}
+		}
+	}
+
+	if depth != 0 {
+		return nil, errors.New("Parentesis sin cerrar")
+	}
+
+	return append(parts, expr[start:]), nil
+}
+
+var setRequirement = regexp.MustCompile(`^(\S+)\s+(in|notin)\s*\((.*)\)$`)
+
+func parseRequirement(part string) (Requirement, error) {
+	var req Requirement
+
+	if m := setRequirement.FindStringSubmatch(part); m != nil {
+		req.Key = m[1]
+		req.Operator = SELECTOR_IN
+		if m[2] == "notin" {
+			req.Operator = SELECTOR_NOT_IN
+		}
+		for _, value := range strings.Split(m[3], ",") {
+			if value = strings.TrimSpace(value); value != "" {
+				req.Values = append(req.Values, value)
+			}
+		}
+		if len(req.Values) == 0 {
+			return req, errors.New(fmt.Sprintf("El requisito %s no tiene valores", part))
+		}
+	} else if k := strings.Index(part, "!="); k >= 0 {
+		req.Key, req.Operator, req.Values = part[:k], SELECTOR_NOT_EQUALS, []string{part[k+2:]}
+	} else if k := strings.Index(part, "=="); k >= 0 {
+		req.Key, req.Operator, req.Values = part[:k], SELECTOR_EQUALS, []string{part[k+2:]}
+	} else if k := strings.Index(part, "="); k >= 0 {
+		req.Key, req.Operator, req.Values = part[:k], SELECTOR_EQUALS, []string{part[k+1:]}
+	} else if strings.HasPrefix(part, "!") {
+		req.Key, req.Operator = part[1:], SELECTOR_NOT_EXISTS
+	} else {
+		req.Key, req.Operator = part, SELECTOR_EXISTS
+	}
+
+	req.Key = strings.TrimSpace(req.Key)
+	if !selectorKey.MatchString(req.Key) {
+		return req, errors.New(fmt.Sprintf("Llave invalida en el requisito %s", part))
+	}
+
+	for k := range req.Values {
+		req.Values[k] = strings.TrimSpace(req.Values[k])
+		req.patterns = append(req.patterns, globPattern(req.Values[k]))
+	}
+
+	return req, nil
+}
+
+// ParseSelector interpreta una expresion como image_name=foo,image_tag!=1.2-*,state in (running,paused).
+// Los requisitos se separan por coma y se soportan los operadores =, ==, !=, in, notin, la
+// existencia de una llave (llave) y su ausencia (!llave). Las llaves id, stack, node, name,
+// image, tag y state son atributos del contenedor, el resto se busca en sus labels
+func ParseSelector(expr string) (*Selector, error) {
+	selector := new(Selector)
+	if strings.TrimSpace(expr) == "" {
+		return selector, nil
+	}
+
+	parts, err := splitRequirements(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, part := range parts {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			return nil, errors.New("El selector tiene un requisito vacio")
+		}
+
+		req, err := parseRequirement(part)
+		if err != nil {
+			return nil, err
+		}
+		selector.Requirements = append(selector.Requirements, req)
+	}
+
+	return selector, nil
+}
+
+func (r Requirement) matchesAny(value string) bool {
+	for _, pattern := range r.patterns {
+		if pattern.MatchString(value) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Matches indica si el requisito se cumple. fields entrega el valor de una llave y si existe
+func (r Requirement) Matches(fields func(key string) (string, bool)) bool {
+	value, exists := fields(r.Key)
+
+	switch r.Operator {
+	case SELECTOR_EXISTS:
+		return exists
+	case SELECTOR_NOT_EXISTS:
+		return !exists
+	case SELECTOR_EQUALS, SELECTOR_IN:
+		return exists && r.matchesAny(value)
+	case SELECTOR_NOT_EQUALS, SELECTOR_NOT_IN:
+		return !exists || !r.matchesAny(value)
+	}
+
+	return false
+}
+
+// Matches indica si se cumplen todos los requisitos del selector
+func (s *Selector) Matches(fields func(key string) (string, bool)) bool {
+	for _, req := range s.Requirements {
+		if !req.Matches(fields) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// serviceFields entrega los atributos y labels del contenedor de un servicio
+func serviceFields(stackKey string, srv *service.DockerService) func(key string) (string, bool) {
+	container := srv.Container()
+	labels := map[string]string{}
+	if container.Config != nil && container.Config.Labels != nil {
+		labels = container.Config.Labels
+	}
+
+	image, tag := srv.ContainerImageName(), ""
+	if k := strings.LastIndex(image, ":"); k >= 0 && !strings.Contains(image[k:], "/") {
+		image, tag = image[:k], image[k+1:]
+	}
+
+	attributes := map[string]string{
+		"id":    container.ID,
+		"stack": stackKey,
+		"node":  srv.ContainerSwarmNode(),
+		"name":  strings.TrimPrefix(srv.ContainerName(), "/"),
+		"image": image,
+		"tag":   tag,
+		"state": srv.ContainerStatus(),
+	}
+
+	return func(key string) (string, bool) {
+		for _, field := range selectorFields {
+			if key == field {
+				return attributes[key], true
+			}
+		}
+
+		value, ok := labels[key]
+		return value, ok
+	}
+}
+
+// Select entrega los contenedores de todos los stacks que cumplen con el selector. Se consideran
+// los contenedores en cualquier estado, de manera que state=created o state=dead tambien seleccionan
+func (sm *StackManager) Select(selector *Selector) (map[string][]*service.DockerService, error) {
+	for _, stack := range sm.stacks {
+		if err := stack.LoadAllContainers(); err != nil {
+			return nil, err
+		}
+	}
+
+	containers := make(map[string][]*service.DockerService)
+	for stackKey, stack := range sm.stacks {
+		containers[stackKey] = []*service.DockerService{}
+		for _, srv := range stack.services {
+			if selector.Matches(serviceFields(stackKey, srv)) {
+				containers[stackKey] = append(containers[stackKey], srv)
+			}
+		}
+	}
+
+	return containers, nil
+}
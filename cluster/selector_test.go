@@ -0,0 +1,151 @@
+package cluster
+
+import (
+	"testing"
+
+	"github.com/ch3lo/yale/helper"
+	"github.com/ch3lo/yale/helper/dockertest"
+	"github.com/fsouza/go-dockerclient"
+)
+
+func TestParseSelector(t *testing.T) {
+	cases := []struct {
+		expr         string
+		requirements []Requirement
+		invalid      bool
+	}{
+		{expr: "", requirements: nil},
+		{expr: "image_name=foo", requirements: []Requirement{{Key: "image_name", Operator: SELECTOR_EQUALS, Values: []string{"foo"}}}},
+		{expr: "image_name==foo", requirements: []Requirement{{Key: "image_name", Operator: SELECTOR_EQUALS, Values: []string{"foo"}}}},
+		{expr: " tag != 1.2-* ", requirements: []Requirement{{Key: "tag", Operator: SELECTOR_NOT_EQUALS, Values: []string{"1.2-*"}}}},
+		{expr: "state in (running, paused)", requirements: []Requirement{{Key: "state", Operator: SELECTOR_IN, Values: []string{"running", "paused"}}}},
+		{expr: "stack notin (a,b),com.docker/role", requirements: []Requirement{
+			{Key: "stack", Operator: SELECTOR_NOT_IN, Values: []string{"a", "b"}},
+			{Key: "com.docker/role", Operator: SELECTOR_EXISTS},
+		}},
+		{expr: "!service_id,image_name=foo", requirements: []Requirement{
+			{Key: "service_id", Operator: SELECTOR_NOT_EXISTS},
+			{Key: "image_name", Operator: SELECTOR_EQUALS, Values: []string{"foo"}},
+		}},
+		{expr: "image_name=", requirements: []Requirement{{Key: "image_name", Operator: SELECTOR_EQUALS, Values: []string{""}}}},
+		{expr: "state in ()", invalid: true},
+		{expr: "state in (running", invalid: true},
+		{expr: "state in ((running))", invalid: true},
+		{expr: "state in running)", invalid: true},
+		{expr: "image_name=foo,", invalid: true},
+		{expr: "=foo", invalid: true},
+		{expr: "llave con espacio=foo", invalid: true},
+		{expr: "!", invalid: true},
+	}
+
+	for _, c := range cases {
+		selector, err := ParseSelector(c.expr)
+		if c.invalid {
+			if err == nil {
+				t.Errorf("El selector %q se aceptó como %+v", c.expr, selector.Requirements)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("El selector %q no se aceptó: %s", c.expr, err)
+			continue
+		}
+
+		if len(selector.Requirements) != len(c.requirements) {
+			t.Errorf("El selector %q tiene los requisitos %+v, se esperaban %+v", c.expr, selector.Requirements, c.requirements)
+			continue
+		}
+
+		for k, req := range selector.Requirements {
+			expected := c.requirements[k]
+			if req.Key != expected.Key || req.Operator != expected.Operator || !equalKeys(req.Values, expected.Values...) {
+				t.Errorf("El requisito %d del selector %q es %+v, se esperaba %+v", k, c.expr, req, expected)
+			}
+		}
+	}
+}
+
+func TestSelectorMatches(t *testing.T) {
+	values := map[string]string{
+		"name":       "app_1",
+		"tag":        "1.2-abc",
+		"state":      "running",
+		"image_name": "registry/app",
+	}
+	fields := func(key string) (string, bool) {
+		value, ok := values[key]
+		return value, ok
+	}
+
+	cases := []struct {
+		expr    string
+		matches bool
+	}{
+		{"", true},
+		{"name=app_1", true},
+		{"name=app_2", false},
+		{"name=app_?", true},
+		{"name=app_??", false},
+		{"tag=1.2-*", true},
+		{"tag=1.2", false},
+		{"tag!=1.2-*", false},
+		{"tag!=1.3-*", true},
+		{"image_name=registry/*", true},
+		{"image_name=*app", true},
+		{"image_name=r.gistry/app", false},
+		{"state in (paused,running)", true},
+		{"state in (paused,exited)", false},
+		{"state notin (paused,exited)", true},
+		{"state notin (run*)", false},
+		{"service_id!=foo", true},
+		{"service_id notin (foo)", true},
+		{"service_id in (foo)", false},
+		{"service_id=*", false},
+		{"image_name", true},
+		{"service_id", false},
+		{"!service_id", true},
+		{"!image_name", false},
+		{"name=app_1,tag=1.2-*,state in (running)", true},
+		{"name=app_1,tag=1.3-*", false},
+	}
+
+	for _, c := range cases {
+		selector, err := ParseSelector(c.expr)
+		if err != nil {
+			t.Fatalf("El selector %q no se aceptó: %s", c.expr, err)
+		}
+
+		if matches := selector.Matches(fields); matches != c.matches {
+			t.Errorf("El selector %q entregó %t, se esperaba %t", c.expr, matches, c.matches)
+		}
+	}
+}
+
+func TestSelectIncludesCreatedContainers(t *testing.T) {
+	engine := dockertest.NewEngine("node-a")
+	sm := NewStackManager()
+	if err := sm.AppendStack("a", helper.NewDockerHelperFromClient(engine, ""), StackConfig{Weight: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	labels := map[string]string{"image_name": testImage, "image_tag": testTag}
+	if _, err := engine.Run(testImage+":"+testTag, labels); err != nil {
+		t.Fatal(err)
+	}
+
+	created, err := engine.CreateContainer(docker.CreateContainerOptions{Config: &docker.Config{Image: testImage + ":" + testTag, Labels: labels}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	selector, _ := ParseSelector("state=created,image_name=" + testImage)
+	containers, err := sm.Select(selector)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(containers["a"]) != 1 || containers["a"][0].Container().ID != created.ID {
+		t.Errorf("Se seleccionaron %d contenedores, se esperaba el contenedor creado %s", len(containers["a"]), created.ID)
+	}
+}
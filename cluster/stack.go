@@ -1,12 +1,19 @@
 package cluster
 
 import (
+	"context"
+	"regexp"
+	"sort"
+	"sync"
+
 	"github.com/Pallinder/go-randomdata"
 	log "github.com/Sirupsen/logrus"
 	"github.com/ch3lo/yale/helper"
 	"github.com/ch3lo/yale/monitor"
 	"github.com/ch3lo/yale/service"
+	"github.com/ch3lo/yale/tracing"
 	"github.com/ch3lo/yale/util"
+	"github.com/fsouza/go-dockerclient"
 )
 
 type StackStatus int
@@ -14,34 +21,55 @@ type StackStatus int
 const (
 	STACK_READY StackStatus = 1 + iota
 	STACK_FAILED
+	STACK_CANCELED
 )
 
 var stackStatus = [...]string{
 	"STACK_READY",
 	"STACK_FAILED",
+	"STACK_CANCELED",
 }
 
 func (s StackStatus) String() string {
 	return stackStatus[s-1]
 }
 
+// StackConfig define la participacion de un stack en el despliegue.
+// Weight es el peso usado al distribuir un total de instancias entre los stacks.
+// Instances fija la cantidad de instancias del stack, incluso 0. nil indica que no esta definida.
+// Required indica que el despliegue falla si este stack falla, sin importar la politica de fallo.
+type StackConfig struct {
+	Weight    int
+	Instances *int
+	Required  bool
+}
+
 type Stack struct {
 	id                    string
+	config                StackConfig
 	dockerApiHelper       *helper.DockerHelper
 	services              []*service.DockerService // refactorizar a interfaz service
 	serviceIdNotification chan string
-	stackNofitication     chan<- StackStatus
+	stackIdNotification   chan<- string
+	status                StackStatus
+	rolledBack            bool
+	running               sync.WaitGroup
 	smokeTestMonitor      monitor.Monitor
 	warmUpMonitor         monitor.Monitor
+	emit                  func(event DeployEvent)
+	trace                 context.Context
+	span                  *tracing.Span
 	log                   *log.Entry
 }
 
-func NewStack(stackKey string, stackNofitication chan<- StackStatus, dh *helper.DockerHelper) *Stack {
+func NewStack(stackKey string, stackIdNotification chan<- string, dh *helper.DockerHelper, config StackConfig) *Stack {
 	s := new(Stack)
 	s.id = stackKey
-	s.stackNofitication = stackNofitication
+	s.config = config
+	s.stackIdNotification = stackIdNotification
 	s.dockerApiHelper = dh
 	s.serviceIdNotification = make(chan string, 1000)
+	s.emit = func(event DeployEvent) {}
 
 	s.log = util.Log.WithFields(log.Fields{
 		"stack": stackKey,
@@ -50,6 +78,17 @@ func NewStack(stackKey string, stackNofitication chan<- StackStatus, dh *helper.
 	return s
 }
 
+// reset descarta los servicios y el estado de una operacion anterior, de manera de reutilizar
+// el stack en procesos de larga duracion
+func (s *Stack) reset() {
+	s.services = nil
+	s.status = 0
+	s.rolledBack = false
+	s.trace = nil
+	s.span = nil
+	s.serviceIdNotification = make(chan string, 1000)
+}
+
 func (s *Stack) createId() string {
 	for {
 		key := s.id + "_" + randomdata.Adjective()
@@ -67,41 +106,70 @@ func (s *Stack) createId() string {
 	}
 }
 
-func (s *Stack) createMonitor(config monitor.MonitorConfig) monitor.Monitor {
-	var mon monitor.Monitor
-
+func (s *Stack) createMonitor(config monitor.MonitorConfig, phase string) monitor.Monitor {
 	s.log.Infof("Creando monitor con mode [%s] y request [%s]", config.Type, config.Request)
-	if config.Type == monitor.TCP {
-		mon = new(monitor.TcpMonitor)
-	} else {
-		mon = new(monitor.HttpMonitor)
-	}
+	config.Phase = phase
+	return monitor.NewMonitor(config)
+}
 
-	mon.SetRetries(config.Retries)
-	mon.SetRequest(config.Request)
-	mon.SetExpected(config.Expected)
+// instanceRunner arranca el contenedor de un nuevo servicio del stack
+type instanceRunner func(ctx context.Context, dockerService *service.DockerService)
 
-	return mon
+// DeployCheckAndNotify despliega las instancias faltantes y notifica el estado final del stack.
+// Si el contexto se cancela, espera que terminen las operaciones en curso y notifica STACK_CANCELED
+func (s *Stack) DeployCheckAndNotify(ctx context.Context, serviceConfig service.ServiceConfig, smokeConfig monitor.MonitorConfig, warmConfig monitor.MonitorConfig, instances int, tolerance float64) {
+	run := func(ctx context.Context, dockerService *service.DockerService) {
+		dockerService.Run(ctx, serviceConfig)
+	}
+	s.checkAndNotify(ctx, run, smokeConfig, warmConfig, instances, tolerance)
+}
+
+// ScaleCheckAndNotify lleva el stack a la cantidad de instancias entregada. Las nuevas
+// instancias se crean con la configuracion del contenedor template
+func (s *Stack) ScaleCheckAndNotify(ctx context.Context, template *docker.Container, smokeConfig monitor.MonitorConfig, warmConfig monitor.MonitorConfig, instances int, tolerance float64) {
+	run := func(ctx context.Context, dockerService *service.DockerService) {
+		dockerService.RunFrom(ctx, template)
+	}
+	s.checkAndNotify(ctx, run, smokeConfig, warmConfig, instances, tolerance)
 }
 
-func (s *Stack) DeployCheckAndNotify(serviceConfig service.ServiceConfig, smokeConfig monitor.MonitorConfig, warmConfig monitor.MonitorConfig, instances int, tolerance float64) {
+func (s *Stack) checkAndNotify(ctx context.Context, run instanceRunner, smokeConfig monitor.MonitorConfig, warmConfig monitor.MonitorConfig, instances int, tolerance float64) {
 	currentContainers := s.countServicesWithState(service.RUNNING)
 
+	ctx, span := tracing.Start(ctx, "stack "+s.id,
+		tracing.Attr("stack.id", s.id),
+		tracing.Attr("stack.instances", instances),
+		tracing.Attr("stack.current_instances", currentContainers))
+	s.trace = ctx
+	s.span = span
+
 	if currentContainers == instances {
 		s.log.Infoln("El Stack ya estaba desplegado. Omitiendo...")
 		s.setStatus(STACK_READY)
 	} else if currentContainers < instances {
 		diff := instances - currentContainers
 		s.log.Printf("El Stack tenia %d instancias. Se desplegaran %d instancias más.", currentContainers, diff)
-		s.smokeTestMonitor = s.createMonitor(smokeConfig)
-		s.warmUpMonitor = s.createMonitor(warmConfig)
+		s.smokeTestMonitor = s.createMonitor(smokeConfig, "smoke")
+		s.warmUpMonitor = s.createMonitor(warmConfig, "warmup")
 
-		for i := 1; i <= diff; i++ {
+		// Al terminar la verificacion se cancelan los chequeos que aun estan en curso
+		stackCtx, cancel := context.WithCancel(ctx)
+		for i := 1; i <= diff && stackCtx.Err() == nil; i++ {
 			s.log.Debugf("Desplegando instancia número %d", i)
-			s.deployOneInstance(serviceConfig)
+			s.deployOneInstance(stackCtx, run)
+		}
+
+		result := s.checkInstances(stackCtx, run, diff, tolerance)
+		cancel()
+		s.running.Wait()
+
+		if ctx.Err() != nil {
+			s.log.Warnln("Se canceló el despliegue del Stack", ctx.Err())
+			s.setStatus(STACK_CANCELED)
+			return
 		}
 
-		if s.checkInstances(serviceConfig, diff, tolerance) {
+		if result {
 			s.setStatus(STACK_READY)
 			return
 		}
@@ -115,45 +183,114 @@ func (s *Stack) DeployCheckAndNotify(serviceConfig service.ServiceConfig, smokeC
 	}
 }
 
+// runAsync ejecuta la operacion en una gorutina registrada, de manera de poder esperar su termino
+func (s *Stack) runAsync(operation func()) {
+	s.running.Add(1)
+	go func() {
+		defer s.running.Done()
+		operation()
+	}()
+}
+
+// setStatus registra el estado final del stack, termina su span y lo notifica
 func (s *Stack) setStatus(status StackStatus) {
-	s.stackNofitication <- status
+	s.status = status
+	if s.span != nil {
+		s.span.SetAttributes(tracing.Attr("stack.status", status.String()))
+		if status != STACK_READY {
+			s.span.SetStatus(tracing.STATUS_ERROR, status.String())
+		}
+		s.span.End()
+		s.span = nil
+	}
+	s.emit(DeployEvent{Type: EVENT_STACK_FINISHED, Stack: s.id, Status: status.String(), Ok: status == STACK_READY})
+	s.stackIdNotification <- s.id
+}
+
+func (s *Stack) GetStatus() StackStatus {
+	return s.status
 }
 
 func (s *Stack) addNewService(dockerService *service.DockerService) {
 	s.services = append(s.services, dockerService)
 }
 
-func (s *Stack) deployOneInstance(serviceConfig service.ServiceConfig) {
+func (s *Stack) deployOneInstance(ctx context.Context, run instanceRunner) {
 	dockerService := service.NewDockerService(s.createId(), s.dockerApiHelper, s.serviceIdNotification)
 	s.addNewService(dockerService)
-	dockerService.Run(serviceConfig)
+	run(ctx, dockerService)
+}
+
+// traceContext entrega el contexto de la traza de la operacion en curso del stack
+func (s *Stack) traceContext() context.Context {
+	if s.trace == nil {
+		return context.Background()
+	}
+
+	return s.trace
 }
 
-func (s *Stack) undeployInstance(serviceId string) {
+// docker entrega el helper del stack que registra las llamadas en la traza de la operacion en curso
+func (s *Stack) docker() *helper.DockerHelper {
+	return s.dockerApiHelper.WithContext(s.traceContext())
+}
+
+func (s *Stack) undeployInstance(ctx context.Context, serviceId string) {
 	dockerService := s.getService(serviceId)
-	dockerService.Undeploy()
+	dockerService.UndeployContext(ctx)
+	containersUndeployed.Inc(s.id)
 }
 
 func (s *Stack) Rollback() {
 	s.log.Infof("Comenzando Rollback en el Stack")
+	s.rolledBack = true
+	s.emit(DeployEvent{Type: EVENT_ROLLBACK_STARTED, Stack: s.id})
+
+	ctx, span := tracing.Start(s.traceContext(), "stack rollback", tracing.Attr("stack.id", s.id))
+	defer span.End()
+
 	for _, srv := range s.services {
 		if !srv.Loaded() {
-			s.undeployInstance(srv.GetId())
+			s.undeployInstance(ctx, srv.GetId())
 		}
 	}
 }
 
+// youngestFirst ordena los servicios desde el contenedor creado mas recientemente
+type youngestFirst []*service.DockerService
+
+func (y youngestFirst) Len() int      { return len(y) }
+func (y youngestFirst) Swap(i, j int) { y[i], y[j] = y[j], y[i] }
+func (y youngestFirst) Less(i, j int) bool {
+	return y[i].ContainerCreated().After(y[j].ContainerCreated())
+}
+
+// UndeployInstances remueve la cantidad de servicios en ejecucion entregada, partiendo por los mas nuevos
 func (s *Stack) UndeployInstances(total int) {
+	services := s.ServicesWithState(service.RUNNING)
+	sort.Sort(youngestFirst(services))
+
 	undeployed := 0
-	for _, srv := range s.services {
+	for _, srv := range services {
 		if undeployed == total {
 			return
 		}
-		s.undeployInstance(srv.GetId())
+		s.undeployInstance(s.traceContext(), srv.GetId())
 		undeployed++
 	}
 }
 
+// Template entrega el contenedor en ejecucion mas reciente del stack, o nil si no existe
+func (s *Stack) Template() *docker.Container {
+	services := s.ServicesWithState(service.RUNNING)
+	if len(services) == 0 {
+		return nil
+	}
+
+	sort.Sort(youngestFirst(services))
+	return services[0].Container()
+}
+
 func (s *Stack) getService(serviceId string) *service.DockerService {
 	for key, _ := range s.services {
 		if s.services[key].GetId() == serviceId {
@@ -192,28 +329,38 @@ func (s *Stack) countServicesWithState(state service.State) int {
 	return len(s.ServicesWithState(state))
 }
 
-func (s *Stack) checkInstances(serviceConfig service.ServiceConfig, totalInstances int, tolerance float64) bool {
+func (s *Stack) checkInstances(ctx context.Context, run instanceRunner, totalInstances int, tolerance float64) bool {
 	for {
+		if ctx.Err() != nil {
+			return false
+		}
+
 		s.log.Infoln("Esperando notificación de los servicios")
-		serviceId := <-s.serviceIdNotification
+		var serviceId string
+		select {
+		case <-ctx.Done():
+			return false
+		case serviceId = <-s.serviceIdNotification:
+		}
 		s.log.Infoln("Notificación recibida del Servicio", serviceId)
 
 		dockerService := s.getService(serviceId) // que pasa si dockerService es nil?
 		s.log.Infof("Notificación del Servicio %s tiene estado %s", serviceId, dockerService.GetStep())
+		s.emitStep(dockerService)
 
 		okInstances := s.countServicesWithStep(service.STEP_WARM_READY)
 
 		if dockerService.GetStep() == service.STEP_CREATED {
 			s.log.Debugf("Notificación de Servicio %s creado... Iniciando Healthy Check", dockerService.GetId())
-			go dockerService.RunSmokeTest(s.smokeTestMonitor)
+			s.runAsync(func() { dockerService.RunSmokeTest(ctx, s.smokeTestMonitor) })
 		} else if dockerService.GetStep() == service.STEP_SMOKE_READY {
 			s.log.Debugf("Notificación de Servicio %s con Smoke Test exitoso... Iniciando Warm UP", dockerService.GetId())
-			go dockerService.RunWarmUp(s.warmUpMonitor)
+			s.runAsync(func() { dockerService.RunWarmUp(ctx, s.warmUpMonitor) })
 		} else if dockerService.GetStep() == service.STEP_WARM_READY {
 			s.log.Debugf("Notificación de Servicio %s Listo", dockerService.GetId())
 		} else if dockerService.GetStep() == service.STEP_FAILED {
 			s.log.Errorf("Notificación de Servicio %s con errores", dockerService.GetId())
-			s.undeployInstance(dockerService.GetId())
+			s.undeployInstance(ctx, dockerService.GetId())
 
 			failedInstances := s.countServicesWithStep(service.STEP_FAILED)
 
@@ -222,7 +369,7 @@ func (s *Stack) checkInstances(serviceConfig service.ServiceConfig, totalInstanc
 			s.log.Debugf("La tolerancia de fallo es %f servicios, superado este valor el deploy fallará", maxFailedServices)
 			if float64(failedInstances) < maxFailedServices {
 				s.log.Debugf("Tolerancia aceptada... Iniciando el despliegue de una nueva instancia")
-				s.deployOneInstance(serviceConfig)
+				s.deployOneInstance(ctx, run)
 			} else {
 				return false
 			}
@@ -233,33 +380,80 @@ func (s *Stack) checkInstances(serviceConfig service.ServiceConfig, totalInstanc
 			return true
 		}
 	}
-
-	okInstances := s.countServicesWithStep(service.STEP_WARM_READY)
-	failedInstances := s.countServicesWithStep(service.STEP_FAILED)
-
-	s.log.Infof("Resumen de Servicios: OK %d - ERROR %d - TOTAL %d (TOLERANCIA %f)", okInstances, failedInstances, totalInstances, tolerance)
-	return false
 }
 
-func (s *Stack) LoadFilteredContainers(imageNameFilter string, tagFilter string, containerNameFilter string) error {
-	util.Log.Debugf("Cargando contenedores con filtros: imagen %s - nombre contenedor %s", imageNameFilter, containerNameFilter)
+// LoadFilteredContainers carga los contenedores que cumplen con las expresiones regulares
+// y que tienen todos los labels entregados, en formato key=value
+func (s *Stack) LoadFilteredContainers(imageNameFilter string, tagFilter string, containerNameFilter string, labels ...string) error {
+	util.Log.Debugf("Cargando contenedores con filtros: imagen %s - nombre contenedor %s - labels %v", imageNameFilter, containerNameFilter, labels)
 	filter := helper.NewContainerFilter()
 	//filter.GetStep() = []string{"running"}
 	filter.ImageRegexp = imageNameFilter
 	filter.TagRegexp = tagFilter
 	filter.NameRegexp = containerNameFilter
+	filter.Labels = labels
+
+	return s.loadListed(s.docker().ListContainers(filter))
+}
+
+// LoadAllContainers carga los contenedores en cualquier estado, incluidos created y dead
+func (s *Stack) LoadAllContainers() error {
+	util.Log.Debugln("Cargando los contenedores en cualquier estado")
+	filter := helper.NewContainerFilter()
+	filter.Status = nil
+
+	return s.loadListed(s.docker().ListContainers(filter))
+}
+
+// loadListed inspecciona y carga los contenedores del listado
+func (s *Stack) loadListed(containers []docker.APIContainers, err error) error {
+	if err != nil {
+		return err
+	}
+
+	for k := range containers {
+		c, err := s.docker().ContainerInspect(containers[k].ID)
+		if err != nil {
+			return err
+		}
+		s.appendLoaded(containers[k], c)
+	}
+
+	return nil
+}
+
+// LoadVersionContainers carga los contenedores de la imagen y tag exactos. El nombre y el tag se
+// comparan completos, de manera que el tag 1.0 no incluya a los contenedores del tag 1.0.1
+func (s *Stack) LoadVersionContainers(imageName string, tag string) error {
+	return s.LoadFilteredContainers("^"+regexp.QuoteMeta(imageName), regexp.QuoteMeta(tag)+"$", ".*")
+}
+
+// appendLoaded agrega el servicio del contenedor listado e inspeccionado
+func (s *Stack) appendLoaded(listed docker.APIContainers, container *docker.Container) {
+	srv := service.NewFromContainer(s.createId(), s.dockerApiHelper, container, s.serviceIdNotification)
+	srv.SetContainerHealth(helper.ContainerHealth(listed))
+	srv.SetContainerDead(helper.ContainerDead(listed))
+	s.services = append(s.services, srv)
+}
+
+// LoadServiceContainers carga los contenedores en ejecucion con el label service_id entregado
+func (s *Stack) LoadServiceContainers(serviceId string) error {
+	util.Log.Debugf("Cargando contenedores del servicio %s", serviceId)
+	filter := helper.NewContainerFilter()
+	filter.Status = []string{"running"}
+	filter.Labels = []string{"service_id=" + serviceId}
 
-	containers, err := s.dockerApiHelper.ListContainers(filter)
+	containers, err := s.docker().ListContainers(filter)
 	if err != nil {
 		return err
 	}
 
 	for k := range containers {
-		c, err := s.dockerApiHelper.ContainerInspect(containers[k].ID)
+		c, err := s.docker().ContainerInspect(containers[k].ID)
 		if err != nil {
 			return err
 		}
-		s.services = append(s.services, service.NewFromContainer(s.createId(), s.dockerApiHelper, c, s.serviceIdNotification))
+		s.appendLoaded(containers[k], c)
 	}
 
 	return nil
@@ -268,17 +462,17 @@ func (s *Stack) LoadFilteredContainers(imageNameFilter string, tagFilter string,
 func (s *Stack) LoadTaggedContainers(imageName string, tag string) error {
 	util.Log.Debugf("Cargando contenedores filtrando por TAG con filtros: imagen %s - tag %s", imageName, tag)
 
-	containers, err := s.dockerApiHelper.ListTaggedContainers(imageName, tag)
+	containers, err := s.docker().ListTaggedContainers(imageName, tag)
 	if err != nil {
 		return err
 	}
 
 	for k := range containers {
-		c, err := s.dockerApiHelper.ContainerInspect(containers[k].ID)
+		c, err := s.docker().ContainerInspect(containers[k].ID)
 		if err != nil {
 			return err
 		}
-		s.services = append(s.services, service.NewFromContainer(s.createId(), s.dockerApiHelper, c, s.serviceIdNotification))
+		s.appendLoaded(containers[k], c)
 	}
 
 	return nil
@@ -1,21 +1,55 @@
 package cluster
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
 	"github.com/ch3lo/yale/helper"
 	"github.com/ch3lo/yale/monitor"
 	"github.com/ch3lo/yale/service"
 	"github.com/ch3lo/yale/util"
+	"github.com/fsouza/go-dockerclient"
 )
 
+// InstancesConfig define la cantidad de instancias que se desplegaran en los stacks.
+// Si Total es mayor a 0 se distribuye entre los stacks de acuerdo a su peso,
+// en caso contrario cada stack despliega PerStack instancias.
+// Los stacks con una cantidad fija de instancias no participan de la distribucion.
+// Con Keep, si no se indica PerStack ni Total, los stacks sin cantidad fija mantienen
+// sus instancias actuales.
+type InstancesConfig struct {
+	PerStack int
+	Total    int
+	Keep     bool
+}
+
+// KeepInstances es el objetivo de los stacks que mantienen su cantidad de instancias actual
+const KeepInstances = -1
+
+// DeployConfig agrupa los parametros del despliegue entre stacks
+type DeployConfig struct {
+	Instances InstancesConfig
+	Tolerance float64
+	Policy    FailurePolicy
+	Waves     WaveConfig
+}
+
 type StackManager struct {
 	stacks            map[string]*Stack
-	stackNotification chan StackStatus
+	stackNotification chan string
+	policy            FailurePolicy
+	touched           []string
+	observers         observers
 }
 
 func NewStackManager() *StackManager {
 	sm := new(StackManager)
 	sm.stacks = make(map[string]*Stack)
-	sm.stackNotification = make(chan StackStatus, 100)
+	sm.stackNotification = make(chan string, 100)
+	sm.policy = POLICY_ALL
+	sm.Observe(newDeployMetrics().observe)
 
 	return sm
 }
@@ -39,50 +73,341 @@ func (sm *StackManager) createId() string {
 	}
 }
 
-func (sm *StackManager) AppendStack(dh *helper.DockerHelper) {
-	key := sm.createId()
+// AppendStack agrega un stack con el nombre entregado. Si el nombre esta vacio
+// se le asigna una letra que no este en uso
+func (sm *StackManager) AppendStack(name string, dh *helper.DockerHelper, config StackConfig) error {
+	key := name
+	if key == "" {
+		key = sm.createId()
+	} else if _, ok := sm.stacks[key]; ok {
+		return errors.New(fmt.Sprintf("El stack %s ya existe", key))
+	}
+
 	util.Log.Infof("API configurada y mapeada a la llave %s", key)
-	sm.stacks[key] = NewStack(key, sm.stackNotification, dh)
+	sm.stacks[key] = NewStack(key, sm.stackNotification, dh, config)
+	sm.stacks[key].emit = sm.emit
+
+	return nil
 }
 
-func (sm *StackManager) Deploy(serviceConfig service.ServiceConfig, smokeConfig monitor.MonitorConfig, warmConfig monitor.MonitorConfig, instances int, tolerance float64) bool {
-	for stackKey, _ := range sm.stacks {
-		if err := sm.stacks[stackKey].LoadFilteredContainers(serviceConfig.ImageName, serviceConfig.Tag, ".*"); err != nil {
-			util.Log.Errorf("Se produjo un error en el stack %s. ", stackKey, err.Error())
-			return false
+// ConfigureStack reemplaza la configuracion del stack
+func (sm *StackManager) ConfigureStack(stackKey string, config StackConfig) error {
+	stack, ok := sm.stacks[stackKey]
+	if !ok {
+		return errors.New(fmt.Sprintf("El stack %s no existe", stackKey))
+	}
+
+	stack.config = config
+
+	return nil
+}
+
+// StackConfigs entrega la configuracion actual de cada stack
+func (sm *StackManager) StackConfigs() map[string]StackConfig {
+	configs := make(map[string]StackConfig)
+	for stackKey, stack := range sm.stacks {
+		configs[stackKey] = stack.config
+	}
+
+	return configs
+}
+
+// RestoreStackConfigs reemplaza la configuracion de los stacks por la entregada, descartando lo
+// definido con ConfigureStack. Los procesos de larga duracion la utilizan para que la
+// configuracion de un despliegue no afecte a los siguientes
+func (sm *StackManager) RestoreStackConfigs(configs map[string]StackConfig) {
+	for stackKey, config := range configs {
+		if stack, ok := sm.stacks[stackKey]; ok {
+			stack.config = config
+		}
+	}
+}
+
+// StackKeys entrega los nombres de los stacks ordenados alfabeticamente
+func (sm *StackManager) StackKeys() []string {
+	var keys []string
+	for k := range sm.stacks {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// Targets calcula la cantidad de instancias que le corresponde a cada stack.
+// La distribucion por peso utiliza el metodo del resto mayor, por lo que la suma
+// de las instancias de los stacks con peso es igual al total restante.
+func (sm *StackManager) Targets(config InstancesConfig) map[string]int {
+	targets := make(map[string]int)
+	remaining := config.Total
+	totalWeight := 0
+	var weighted []string
+
+	for _, stackKey := range sm.StackKeys() {
+		stackConfig := sm.stacks[stackKey].config
+		if stackConfig.Instances != nil {
+			targets[stackKey] = *stackConfig.Instances
+			remaining -= *stackConfig.Instances
+		} else if config.Total > 0 {
+			targets[stackKey] = 0
+			weighted = append(weighted, stackKey)
+			totalWeight += stackConfig.Weight
+		} else if config.Keep && config.PerStack == 0 {
+			targets[stackKey] = KeepInstances
+		} else {
+			targets[stackKey] = config.PerStack
 		}
 	}
 
+	if remaining <= 0 || totalWeight == 0 {
+		return targets
+	}
+
+	assigned := 0
+	remainders := make(map[string]int)
+	for _, stackKey := range weighted {
+		share := remaining * sm.stacks[stackKey].config.Weight
+		targets[stackKey] = share / totalWeight
+		remainders[stackKey] = share % totalWeight
+		assigned += targets[stackKey]
+	}
+
+	for ; assigned < remaining; assigned++ {
+		selected := ""
+		for _, stackKey := range weighted {
+			if selected == "" || remainders[stackKey] > remainders[selected] {
+				selected = stackKey
+			}
+		}
+		targets[selected]++
+		remainders[selected] = -1
+	}
+
+	return targets
+}
+
+// ServiceSelector identifica los contenedores de un servicio desplegado, ya sea por
+// imagen y tag o por el label service_id
+type ServiceSelector struct {
+	ServiceId string
+	ImageName string
+	Tag       string
+}
+
+// stackStarter inicia el despliegue de un stack, el que debe notificar su estado al terminar
+type stackStarter func(ctx context.Context, stackKey string, instances int)
+
+// Deploy despliega el servicio en los stacks. Si el contexto se cancela, se cancelan los
+// chequeos en curso, se espera que terminen las llamadas a Docker y se realiza el rollback
+// de los stacks que participaron antes de retornar
+func (sm *StackManager) Deploy(ctx context.Context, serviceConfig service.ServiceConfig, smokeConfig monitor.MonitorConfig, warmConfig monitor.MonitorConfig, deployConfig DeployConfig) bool {
+	ctx = sm.begin(ctx, "deploy", serviceConfig.ImageName, serviceConfig.Tag)
 	for stackKey, _ := range sm.stacks {
-		go sm.stacks[stackKey].DeployCheckAndNotify(serviceConfig, smokeConfig, warmConfig, instances, tolerance)
+		if err := sm.stacks[stackKey].LoadVersionContainers(serviceConfig.ImageName, serviceConfig.Tag); err != nil {
+			util.Log.Errorf("Se produjo un error en el stack %s. %s", stackKey, err.Error())
+			return sm.finish(false)
+		}
+	}
+
+	start := func(ctx context.Context, stackKey string, instances int) {
+		sm.stacks[stackKey].DeployCheckAndNotify(ctx, serviceConfig, smokeConfig, warmConfig, instances, deployConfig.Tolerance)
+	}
+
+	return sm.finish(sm.deployWaves(ctx, deployConfig, start))
+}
+
+// Scale lleva los stacks a la cantidad de instancias configurada sin redesplegar el servicio.
+// Las nuevas instancias clonan la configuracion del contenedor mas reciente del mismo stack
+// o, si el stack no tiene contenedores, de otro stack. Al reducir se remueven los contenedores mas nuevos
+func (sm *StackManager) Scale(ctx context.Context, selector ServiceSelector, smokeConfig monitor.MonitorConfig, warmConfig monitor.MonitorConfig, deployConfig DeployConfig) bool {
+	ctx = sm.begin(ctx, "scale", selector.ImageName, selector.Tag)
+	for stackKey, stack := range sm.stacks {
+		var err error
+		if selector.ServiceId != "" {
+			err = stack.LoadServiceContainers(selector.ServiceId)
+		} else {
+			err = stack.LoadVersionContainers(selector.ImageName, selector.Tag)
+		}
+
+		if err != nil {
+			util.Log.Errorf("Se produjo un error en el stack %s. %s", stackKey, err.Error())
+			return sm.finish(false)
+		}
 	}
 
-	for i := 0; i < len(sm.stacks); i++ {
-		stackStatus := <-sm.stackNotification
-		util.Log.Infoln("Se recibió notificación del Stack con estado", stackStatus)
-		if stackStatus == STACK_FAILED {
-			util.Log.Errorln("Fallo el stack, se procederá a realizar Rollback")
+	var defaultTemplate *docker.Container
+	for _, stackKey := range sm.StackKeys() {
+		if defaultTemplate = sm.stacks[stackKey].Template(); defaultTemplate != nil {
+			break
+		}
+	}
+
+	if defaultTemplate == nil {
+		util.Log.Errorln("No existen contenedores del servicio que sirvan de plantilla")
+		return sm.finish(false)
+	}
+
+	start := func(ctx context.Context, stackKey string, instances int) {
+		if instances == KeepInstances {
+			instances = sm.stacks[stackKey].countServicesWithState(service.RUNNING)
+		}
+		template := sm.stacks[stackKey].Template()
+		if template == nil {
+			template = defaultTemplate
+		}
+		sm.stacks[stackKey].ScaleCheckAndNotify(ctx, template, smokeConfig, warmConfig, instances, deployConfig.Tolerance)
+	}
+
+	return sm.finish(sm.deployWaves(ctx, deployConfig, start))
+}
+
+func (sm *StackManager) deployWaves(ctx context.Context, deployConfig DeployConfig, start stackStarter) bool {
+	sm.policy = deployConfig.Policy
+	sm.touched = nil
+
+	waves, err := sm.buildWaves(deployConfig.Waves)
+	if err != nil {
+		util.Log.Errorln(err)
+		return false
+	}
+
+	targets := sm.Targets(deployConfig.Instances)
+	for _, stackKey := range sm.StackKeys() {
+		if targets[stackKey] == KeepInstances {
+			util.Log.Infof("El stack %s mantendrá sus instancias actuales", stackKey)
+			continue
+		}
+		util.Log.Infof("El stack %s tendrá %d instancias (peso %d)", stackKey, targets[stackKey], sm.stacks[stackKey].config.Weight)
+	}
+
+	// Si falla un stack se cancelan los demas stacks de la ola antes del rollback
+	deployCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	util.Log.Infof("Desplegando %d olas con politica de fallo %s", len(waves), sm.policy)
+	okStacks := 0
+	for i, wave := range waves {
+		if i > 0 && !sm.waitWave(deployCtx, deployConfig.Waves, i+1, wave) {
+			util.Log.Errorln("Despliegue abortado, se procederá a realizar Rollback de las olas desplegadas")
+			sm.Rollback()
+			return false
+		}
+
+		util.Log.Infof("Desplegando la ola %d con los stacks %v", i+1, wave)
+		sm.touched = append(sm.touched, wave...)
+		for _, stackKey := range wave {
+			go start(deployCtx, stackKey, targets[stackKey])
+		}
+
+		aborted := false
+		for range wave {
+			stackKey := <-sm.stackNotification
+			stack := sm.stacks[stackKey]
+			util.Log.Infof("Se recibió notificación del Stack %s con estado %s", stackKey, stack.GetStatus())
+			switch {
+			case stack.GetStatus() == STACK_READY:
+				okStacks++
+			case stack.GetStatus() == STACK_CANCELED:
+				aborted = true
+			case stack.config.Required || sm.policy == POLICY_ALL:
+				util.Log.Errorf("Fallo el stack %s, se cancelarán los demás stacks de la ola", stackKey)
+				aborted = true
+				cancel()
+			default:
+				util.Log.Errorf("Fallo el stack %s, se procederá a realizar Rollback del stack", stackKey)
+				stack.Rollback()
+			}
+		}
+
+		if aborted {
+			util.Log.Errorln("Despliegue abortado, se procederá a realizar Rollback")
 			sm.Rollback()
 			return false
 		}
 	}
+
+	if !sm.policyAccepted(okStacks) {
+		util.Log.Errorf("No se cumplió la politica de fallo %s con %d/%d stacks exitosos, se procederá a realizar Rollback", sm.policy, okStacks, len(sm.stacks))
+		sm.Rollback()
+		return false
+	}
+
 	util.Log.Infoln("Proceso de deploy OK")
 	return true
 }
 
-func (sm *StackManager) DeployedContainers() []*service.DockerService {
-	var containers []*service.DockerService
+func (sm *StackManager) policyAccepted(okStacks int) bool {
+	switch sm.policy {
+	case POLICY_QUORUM:
+		return okStacks > len(sm.stacks)/2
+	case POLICY_BEST_EFFORT:
+		return okStacks > 0
+	}
+
+	return okStacks == len(sm.stacks)
+}
+
+// Result entrega el estado de los stacks luego del despliegue
+func (sm *StackManager) Result() DeployResult {
+	result := DeployResult{
+		Policy:     sm.policy.String(),
+		Succeeded:  []string{},
+		Failed:     []string{},
+		Canceled:   []string{},
+		RolledBack: []string{},
+	}
+
+	for _, stackKey := range sm.StackKeys() {
+		stack := sm.stacks[stackKey]
+		if stack.GetStatus() == STACK_READY && !stack.rolledBack {
+			result.Succeeded = append(result.Succeeded, stackKey)
+		} else if stack.GetStatus() == STACK_FAILED {
+			result.Failed = append(result.Failed, stackKey)
+		} else if stack.GetStatus() == STACK_CANCELED {
+			result.Canceled = append(result.Canceled, stackKey)
+		}
+
+		if stack.rolledBack {
+			result.RolledBack = append(result.RolledBack, stackKey)
+		}
+	}
+
+	return result
+}
+
+// CallStats entrega el resumen de las llamadas al API de Docker de cada stack
+func (sm *StackManager) CallStats() map[string]helper.CallStats {
+	stats := make(map[string]helper.CallStats)
+	for stackKey, stack := range sm.stacks {
+		stats[stackKey] = stack.dockerApiHelper.CallStats()
+	}
+
+	return stats
+}
+
+func (sm *StackManager) DeployedContainers() map[string][]*service.DockerService {
+	containers := make(map[string][]*service.DockerService)
 
 	for stackKey, _ := range sm.stacks {
-		containers = append(containers, sm.stacks[stackKey].ServicesWithStep(service.STEP_WARM_READY)...)
+		if sm.stacks[stackKey].rolledBack {
+			continue
+		}
+		containers[stackKey] = append(containers[stackKey], sm.stacks[stackKey].ServicesWithStep(service.STEP_WARM_READY)...)
 	}
 
 	return containers
 }
 
-func (sm *StackManager) SearchContainers(imageNameFilter string, tagFilter string, containerNameFilter string) (map[string][]*service.DockerService, error) {
+// Reset descarta los servicios cargados en los stacks, de manera de volver a consultarlos
+func (sm *StackManager) Reset() {
+	for _, stack := range sm.stacks {
+		stack.reset()
+	}
+}
+
+func (sm *StackManager) SearchContainers(imageNameFilter string, tagFilter string, containerNameFilter string, labels ...string) (map[string][]*service.DockerService, error) {
 	for stackKey, _ := range sm.stacks {
-		if err := sm.stacks[stackKey].LoadFilteredContainers(imageNameFilter, tagFilter, containerNameFilter); err != nil {
+		if err := sm.stacks[stackKey].LoadFilteredContainers(imageNameFilter, tagFilter, containerNameFilter, labels...); err != nil {
 			return nil, err
 		}
 	}
@@ -110,9 +435,10 @@ func (sm *StackManager) Tagged(image string, tag string) (map[string][]*service.
 	return containers, nil
 }
 
+// Rollback remueve los contenedores creados en los stacks que participaron del despliegue
 func (sm *StackManager) Rollback() {
 	util.Log.Infoln("Iniciando el Rollback")
-	for stack, _ := range sm.stacks {
+	for _, stack := range sm.touched {
 		sm.stacks[stack].Rollback()
 	}
 }
@@ -0,0 +1,659 @@
+package cluster
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ch3lo/yale/helper"
+	"github.com/ch3lo/yale/helper/dockertest"
+	"github.com/ch3lo/yale/monitor"
+	"github.com/ch3lo/yale/service"
+	"github.com/ch3lo/yale/tracing"
+	"github.com/fsouza/go-dockerclient"
+)
+
+const (
+	testImage    = "registry.it.lan.com/app"
+	testTag      = "1.1-abc"
+	testOldTag   = "1.0-xyz"
+	testSmokeReq = "/health"
+)
+
+// authFile crea un archivo de credenciales con el registro que utiliza PullImage
+func authFile(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "yale")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(dir, "dockercfg")
+	cfg := `{"https://registry.it.lan.com": {"auth": "dXNlcjpwYXNz", "email": "user@lan.com"}}`
+	if err := ioutil.WriteFile(path, []byte(cfg), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+// smokeServer levanta el endpoint del smoke test y lo publica como puerto 8080 de los contenedores del motor
+func smokeServer(t *testing.T, engine *dockertest.Engine, handler http.HandlerFunc) *httptest.Server {
+	server := httptest.NewServer(handler)
+
+	host, port, err := net.SplitHostPort(server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	public, _ := strconv.ParseInt(port, 10, 64)
+	engine.MapPort(8080, host, public)
+
+	return server
+}
+
+func healthy(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte("ok"))
+}
+
+type testCluster struct {
+	manager *StackManager
+	engines map[string]*dockertest.Engine
+	servers []*httptest.Server
+	auth    string
+}
+
+func newTestCluster(t *testing.T, stacks ...string) *testCluster {
+	tc := &testCluster{
+		manager: NewStackManager(),
+		engines: make(map[string]*dockertest.Engine),
+		auth:    authFile(t),
+	}
+
+	for _, stackKey := range stacks {
+		engine := dockertest.NewEngine("node-" + stackKey)
+		tc.engines[stackKey] = engine
+		tc.servers = append(tc.servers, smokeServer(t, engine, healthy))
+
+		if err := tc.manager.AppendStack(stackKey, helper.NewDockerHelperFromClient(engine, tc.auth), StackConfig{Weight: 1}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return tc
+}
+
+func (tc *testCluster) close() {
+	for _, server := range tc.servers {
+		server.Close()
+	}
+	os.RemoveAll(filepath.Dir(tc.auth))
+}
+
+func (tc *testCluster) deploy(instances int, tolerance float64, policy FailurePolicy) bool {
+	serviceConfig := service.ServiceConfig{ImageName: testImage, Tag: testTag}
+	smokeConfig := monitor.MonitorConfig{Type: monitor.HTTP, Retries: 1, Request: testSmokeReq, Expected: "ok"}
+	deployConfig := DeployConfig{
+		Instances: InstancesConfig{PerStack: instances},
+		Tolerance: tolerance,
+		Policy:    policy,
+	}
+
+	return tc.manager.Deploy(context.Background(), serviceConfig, smokeConfig, monitor.MonitorConfig{}, deployConfig)
+}
+
+// running cuenta los contenedores en ejecucion del tag en el stack
+func (tc *testCluster) running(t *testing.T, stackKey string, tag string) int {
+	containers, err := tc.engines[stackKey].ListContainers(docker.ListContainersOptions{
+		Filters: map[string][]string{"label": []string{"image_name=" + testImage, "image_tag=" + tag}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return len(containers)
+}
+
+// total cuenta los contenedores del tag en el stack en cualquier estado
+func (tc *testCluster) total(t *testing.T, stackKey string, tag string) int {
+	containers, err := tc.engines[stackKey].ListContainers(docker.ListContainersOptions{
+		All:     true,
+		Filters: map[string][]string{"label": []string{"image_name=" + testImage, "image_tag=" + tag}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return len(containers)
+}
+
+func (tc *testCluster) runOld(t *testing.T, stackKey string) {
+	image := testImage + ":" + testOldTag
+	labels := map[string]string{"image_name": testImage, "image_tag": testOldTag}
+	if _, err := tc.engines[stackKey].Run(image, labels); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func equalKeys(a []string, b ...string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for k := range a {
+		if a[k] != b[k] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func TestDeploySuccess(t *testing.T) {
+	tc := newTestCluster(t, "a", "b")
+	defer tc.close()
+
+	if !tc.deploy(2, 0, POLICY_ALL) {
+		t.Fatal("Se esperaba un despliegue exitoso")
+	}
+
+	for _, stackKey := range []string{"a", "b"} {
+		if running := tc.running(t, stackKey, testTag); running != 2 {
+			t.Errorf("El stack %s tiene %d instancias, se esperaban 2", stackKey, running)
+		}
+	}
+
+	result := tc.manager.Result()
+	if !equalKeys(result.Succeeded, "a", "b") || len(result.RolledBack) != 0 {
+		t.Errorf("Resultado inesperado %#v", result)
+	}
+
+	if deployed := tc.manager.DeployedContainers(); len(deployed["a"]) != 2 || len(deployed["b"]) != 2 {
+		t.Errorf("Contenedores desplegados inesperados %#v", deployed)
+	}
+}
+
+func TestDeploySkipsDeployedStack(t *testing.T) {
+	tc := newTestCluster(t, "a")
+	defer tc.close()
+
+	if !tc.deploy(2, 0, POLICY_ALL) {
+		t.Fatal("Se esperaba un despliegue exitoso")
+	}
+
+	tc.manager.Reset()
+	if !tc.deploy(2, 0, POLICY_ALL) {
+		t.Fatal("Se esperaba que el segundo despliegue fuera exitoso")
+	}
+
+	if total := tc.total(t, "a", testTag); total != 2 {
+		t.Errorf("El stack tiene %d contenedores, se esperaban 2", total)
+	}
+}
+
+func TestDeployWithinTolerance(t *testing.T) {
+	tc := newTestCluster(t, "a")
+	defer tc.close()
+
+	// con 4 instancias y tolerancia 0.5 se aceptan menos de 2 fallas
+	tc.engines["a"].FailPull(testImage+":"+testTag, 1)
+
+	if !tc.deploy(4, 0.5, POLICY_ALL) {
+		t.Fatal("Se esperaba que la falla fuera tolerada")
+	}
+
+	if running := tc.running(t, "a", testTag); running != 4 {
+		t.Errorf("El stack tiene %d instancias, se esperaban 4", running)
+	}
+}
+
+func TestDeployBeyondToleranceRollsBack(t *testing.T) {
+	tc := newTestCluster(t, "a")
+	defer tc.close()
+	tc.runOld(t, "a")
+
+	tc.engines["a"].FailPull(testImage+":"+testTag, 2)
+
+	if tc.deploy(4, 0.5, POLICY_ALL) {
+		t.Fatal("Se esperaba que el despliegue fallara al superar la tolerancia")
+	}
+
+	if total := tc.total(t, "a", testTag); total != 0 {
+		t.Errorf("El rollback dejó %d contenedores de la nueva version", total)
+	}
+
+	if running := tc.running(t, "a", testOldTag); running != 1 {
+		t.Errorf("El rollback afectó la version anterior, quedan %d instancias", running)
+	}
+
+	result := tc.manager.Result()
+	if !equalKeys(result.Failed, "a") || !equalKeys(result.RolledBack, "a") {
+		t.Errorf("Resultado inesperado %#v", result)
+	}
+}
+
+func TestDeployCrashRollsBackAllStacks(t *testing.T) {
+	tc := newTestCluster(t, "a", "b")
+	defer tc.close()
+	tc.runOld(t, "a")
+	tc.runOld(t, "b")
+
+	// los contenedores del stack b se caen al recibir el smoke test
+	engine := tc.engines["b"]
+	crashing := smokeServer(t, engine, func(w http.ResponseWriter, r *http.Request) {
+		engine.CrashImage(testImage+":"+testTag, 137)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	defer crashing.Close()
+
+	if tc.deploy(2, 0, POLICY_ALL) {
+		t.Fatal("Se esperaba que el despliegue fallara")
+	}
+
+	for _, stackKey := range []string{"a", "b"} {
+		if total := tc.total(t, stackKey, testTag); total != 0 {
+			t.Errorf("El rollback dejó %d contenedores de la nueva version en el stack %s", total, stackKey)
+		}
+		if running := tc.running(t, stackKey, testOldTag); running != 1 {
+			t.Errorf("El rollback afectó la version anterior del stack %s, quedan %d instancias", stackKey, running)
+		}
+	}
+
+	result := tc.manager.Result()
+	if !equalKeys(result.Failed, "b") || !equalKeys(result.RolledBack, "a", "b") {
+		t.Errorf("Resultado inesperado %#v", result)
+	}
+}
+
+func TestDeployBestEffortKeepsHealthyStacks(t *testing.T) {
+	tc := newTestCluster(t, "a", "b")
+	defer tc.close()
+
+	tc.engines["b"].FailPull(testImage+":"+testTag, -1)
+
+	if !tc.deploy(1, 0, POLICY_BEST_EFFORT) {
+		t.Fatal("Se esperaba que la politica best-effort aceptara el despliegue")
+	}
+
+	if running := tc.running(t, "a", testTag); running != 1 {
+		t.Errorf("El stack a tiene %d instancias, se esperaba 1", running)
+	}
+
+	if total := tc.total(t, "b", testTag); total != 0 {
+		t.Errorf("El stack b quedó con %d contenedores de la nueva version", total)
+	}
+
+	result := tc.manager.Result()
+	if !equalKeys(result.Succeeded, "a") || !equalKeys(result.RolledBack, "b") {
+		t.Errorf("Resultado inesperado %#v", result)
+	}
+}
+
+func TestDeployRetriesTransientErrors(t *testing.T) {
+	tc := newTestCluster(t, "a")
+	defer tc.close()
+
+	dh := tc.manager.stacks["a"].dockerApiHelper
+	config := helper.DefaultResilienceConfig()
+	config.Backoff = time.Millisecond
+	config.MaxBackoff = time.Millisecond
+	dh.SetResilience(config)
+
+	// el daemon responde 503 a las primeras consultas de contenedores
+	tc.engines["a"].FailCall("ListContainers", 2)
+	tc.engines["a"].FailCall("InspectContainer", 1)
+
+	if !tc.deploy(1, 0, POLICY_ALL) {
+		t.Fatal("Se esperaba que los reintentos ocultaran los errores transitorios")
+	}
+
+	if stats := tc.manager.CallStats()["a"]; stats.Retries != 3 || stats.Failures != 0 {
+		t.Errorf("Resumen de llamadas inesperado %#v", stats)
+	}
+}
+
+// eventRecorder registra los eventos del despliegue, que llegan desde varias gorutinas
+type eventRecorder struct {
+	mutex  sync.Mutex
+	events []DeployEvent
+}
+
+func (r *eventRecorder) observe(event DeployEvent) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.events = append(r.events, event)
+}
+
+func (r *eventRecorder) types() []string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	var types []string
+	for _, event := range r.events {
+		name := event.Type.String()
+		if event.Type == EVENT_SERVICE_STEP {
+			name = event.Step
+		}
+		types = append(types, name)
+	}
+
+	return types
+}
+
+func TestDeployEmitsEvents(t *testing.T) {
+	tc := newTestCluster(t, "a")
+	defer tc.close()
+
+	recorder := &eventRecorder{}
+	tc.manager.Observe(recorder.observe)
+
+	if !tc.deploy(1, 0, POLICY_ALL) {
+		t.Fatal("Se esperaba un despliegue exitoso")
+	}
+
+	types := recorder.types()
+	if !equalKeys(types, "EVENT_DEPLOY_STARTED", "STEP_CREATED", "STEP_SMOKE_READY", "STEP_WARM_READY", "EVENT_STACK_FINISHED", "EVENT_DEPLOY_FINISHED") {
+		t.Fatalf("Eventos inesperados %v", types)
+	}
+
+	for _, event := range recorder.events {
+		if event.DeployId != tc.manager.DeployId() || event.Image != testImage || event.Tag != testTag {
+			t.Errorf("Evento sin los datos del despliegue %#v", event)
+		}
+	}
+
+	last := recorder.events[len(recorder.events)-1]
+	if !last.Ok || last.Result == nil || !equalKeys(last.Result.Succeeded, "a") {
+		t.Errorf("Evento de termino inesperado %#v", last)
+	}
+}
+
+func TestRollbackEmitsEvents(t *testing.T) {
+	tc := newTestCluster(t, "a")
+	defer tc.close()
+
+	recorder := &eventRecorder{}
+	tc.manager.Observe(recorder.observe)
+	tc.engines["a"].FailPull(testImage+":"+testTag, -1)
+
+	if tc.deploy(1, 0, POLICY_ALL) {
+		t.Fatal("Se esperaba que el despliegue fallara")
+	}
+
+	types := recorder.types()
+	if !equalKeys(types, "EVENT_DEPLOY_STARTED", "STEP_FAILED", "EVENT_STACK_FINISHED", "EVENT_ROLLBACK_STARTED", "EVENT_DEPLOY_FINISHED") {
+		t.Fatalf("Eventos inesperados %v", types)
+	}
+
+	if stack := recorder.events[2]; stack.Ok || stack.Status != "STACK_FAILED" || stack.Stack != "a" {
+		t.Errorf("Evento de stack inesperado %#v", stack)
+	}
+}
+
+func TestDeployRecordsMetrics(t *testing.T) {
+	tc := newTestCluster(t, "a")
+	defer tc.close()
+
+	succeeded := deploysTotal.Value("deploy", testImage, "succeeded")
+	failed := deploysTotal.Value("deploy", testImage, "failed")
+	created := containersCreated.Value(testImage, "a")
+	rollbacks := rollbacksTotal.Value(testImage, "a")
+	durations := deployDuration.Count("deploy", testImage)
+
+	if !tc.deploy(2, 0, POLICY_ALL) {
+		t.Fatal("Se esperaba un despliegue exitoso")
+	}
+
+	if delta := deploysTotal.Value("deploy", testImage, "succeeded") - succeeded; delta != 1 {
+		t.Errorf("Se registraron %v despliegues exitosos, se esperaba 1", delta)
+	}
+
+	if deployLastOutcome.Value("deploy", testImage, "succeeded") != 1 || deployLastOutcome.Value("deploy", testImage, "failed") != 0 {
+		t.Errorf("Se esperaba que el ultimo despliegue quedara como exitoso")
+	}
+
+	if delta := containersCreated.Value(testImage, "a") - created; delta != 2 {
+		t.Errorf("Se registraron %v contenedores creados, se esperaban 2", delta)
+	}
+
+	broken := newTestCluster(t, "a")
+	defer broken.close()
+	broken.engines["a"].FailPull(testImage+":"+testTag, -1)
+
+	if broken.deploy(1, 0, POLICY_ALL) {
+		t.Fatal("Se esperaba que el despliegue fallara")
+	}
+
+	if delta := deploysTotal.Value("deploy", testImage, "failed") - failed; delta != 1 {
+		t.Errorf("Se registraron %v despliegues fallidos, se esperaba 1", delta)
+	}
+
+	if deployLastOutcome.Value("deploy", testImage, "failed") != 1 || deployLastOutcome.Value("deploy", testImage, "succeeded") != 0 {
+		t.Errorf("Se esperaba que el ultimo despliegue quedara como fallido")
+	}
+
+	if delta := rollbacksTotal.Value(testImage, "a") - rollbacks; delta != 1 {
+		t.Errorf("Se registraron %v rollbacks, se esperaba 1", delta)
+	}
+
+	if delta := deployDuration.Count("deploy", testImage) - durations; delta != 2 {
+		t.Errorf("Se registraron %d duraciones, se esperaban 2", delta)
+	}
+}
+
+// spanRecorder guarda los spans exportados por el tracer de la prueba
+type spanRecorder struct {
+	mutex sync.Mutex
+	spans []tracing.SpanData
+}
+
+func (r *spanRecorder) Export(resource []tracing.Attribute, spans []tracing.SpanData) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.spans = append(r.spans, spans...)
+	return nil
+}
+
+func TestDeployTraces(t *testing.T) {
+	recorder := &spanRecorder{}
+	tracer, err := tracing.NewTracer(tracing.Config{ServiceName: "yale", Exporter: recorder})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracing.SetDefault(tracer)
+	defer tracing.SetDefault(nil)
+
+	tc := newTestCluster(t, "a")
+	defer tc.close()
+
+	if !tc.deploy(1, 0, POLICY_ALL) {
+		t.Fatal("Se esperaba un despliegue exitoso")
+	}
+	tracer.Shutdown(5 * time.Second)
+
+	traceId := tc.manager.TraceId()
+	if traceId == "" {
+		t.Fatal("El despliegue no tiene traza")
+	}
+
+	names := make(map[string]bool)
+	for _, span := range recorder.spans {
+		if span.TraceId.String() != traceId {
+			t.Errorf("El span %s pertenece a otra traza", span.Name)
+		}
+		names[span.Name] = true
+	}
+
+	for _, name := range []string{"deploy " + testImage, "stack a", "service create", "docker PullImage", "docker CreateContainer", "docker StartContainer", "service smoke_test", "monitor http"} {
+		if !names[name] {
+			t.Errorf("No se registró el span %s, spans %v", name, names)
+		}
+	}
+}
+
+func TestRestoreStackConfigs(t *testing.T) {
+	tc := newTestCluster(t, "a", "b")
+	defer tc.close()
+
+	base := tc.manager.StackConfigs()
+	instances := 2
+	if err := tc.manager.ConfigureStack("a", StackConfig{Weight: 3, Instances: &instances, Required: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	if config := tc.manager.StackConfigs()["a"]; config.Weight != 3 || config.Instances == nil || *config.Instances != 2 || !config.Required {
+		t.Fatalf("Configuracion inesperada del stack a %+v", config)
+	}
+
+	tc.manager.RestoreStackConfigs(base)
+	for stackKey, config := range tc.manager.StackConfigs() {
+		if config != (StackConfig{Weight: 1}) {
+			t.Errorf("El stack %s quedó con la configuracion %+v", stackKey, config)
+		}
+	}
+}
+
+func TestScaleKeepsUnlistedStacks(t *testing.T) {
+	tc := newTestCluster(t, "a", "b", "c")
+	defer tc.close()
+
+	if !tc.deploy(2, 0.5, POLICY_ALL) {
+		t.Fatal("Fallo el despliegue inicial")
+	}
+	tc.manager.Reset()
+
+	instances := 3
+	if err := tc.manager.ConfigureStack("a", StackConfig{Weight: 1, Instances: &instances}); err != nil {
+		t.Fatal(err)
+	}
+
+	selector := ServiceSelector{ImageName: testImage, Tag: testTag}
+	smokeConfig := monitor.MonitorConfig{Type: monitor.HTTP, Retries: 1, Request: testSmokeReq, Expected: "ok"}
+	deployConfig := DeployConfig{Instances: InstancesConfig{Keep: true}, Tolerance: 0.5, Policy: POLICY_ALL}
+	if !tc.manager.Scale(context.Background(), selector, smokeConfig, monitor.MonitorConfig{}, deployConfig) {
+		t.Fatal("Fallo el escalamiento")
+	}
+
+	expected := map[string]int{"a": 3, "b": 2, "c": 2}
+	for stackKey, instances := range expected {
+		if running := tc.running(t, stackKey, testTag); running != instances {
+			t.Errorf("El stack %s tiene %d instancias, se esperaban %d", stackKey, running, instances)
+		}
+	}
+
+	if targets := tc.manager.Targets(deployConfig.Instances); targets["a"] != 3 || targets["b"] != KeepInstances || targets["c"] != KeepInstances {
+		t.Errorf("Objetivos inesperados %v", targets)
+	}
+}
+
+func TestScaleIgnoresSimilarTags(t *testing.T) {
+	tc := newTestCluster(t, "a")
+	defer tc.close()
+
+	if !tc.deploy(1, 0.5, POLICY_ALL) {
+		t.Fatal("Fallo el despliegue inicial")
+	}
+	tc.manager.Reset()
+
+	similar := testTag + ".1"
+	labels := map[string]string{"image_name": testImage, "image_tag": similar}
+	if _, err := tc.engines["a"].Run(testImage+":"+similar, labels); err != nil {
+		t.Fatal(err)
+	}
+
+	selector := ServiceSelector{ImageName: testImage, Tag: testTag}
+	smokeConfig := monitor.MonitorConfig{Type: monitor.HTTP, Retries: 1, Request: testSmokeReq, Expected: "ok"}
+	deployConfig := DeployConfig{Instances: InstancesConfig{PerStack: 1}, Tolerance: 0.5, Policy: POLICY_ALL}
+	if !tc.manager.Scale(context.Background(), selector, smokeConfig, monitor.MonitorConfig{}, deployConfig) {
+		t.Fatal("Fallo el escalamiento")
+	}
+
+	if running := tc.running(t, "a", testTag); running != 1 {
+		t.Errorf("El tag %s tiene %d instancias, se esperaba 1", testTag, running)
+	}
+
+	if running := tc.running(t, "a", similar); running != 1 {
+		t.Errorf("El escalamiento removió el contenedor del tag %s", similar)
+	}
+}
+
+func TestTargets(t *testing.T) {
+	fixed := func(instances int) *int { return &instances }
+
+	cases := []struct {
+		name     string
+		stacks   map[string]StackConfig
+		config   InstancesConfig
+		expected map[string]int
+	}{
+		{"instancias por stack",
+			map[string]StackConfig{"a": {Weight: 1}, "b": {Weight: 3}},
+			InstancesConfig{PerStack: 2},
+			map[string]int{"a": 2, "b": 2}},
+		{"total con pesos iguales",
+			map[string]StackConfig{"a": {Weight: 1}, "b": {Weight: 1}, "c": {Weight: 1}},
+			InstancesConfig{Total: 7},
+			map[string]int{"a": 3, "b": 2, "c": 2}},
+		{"total con resto mayor",
+			map[string]StackConfig{"a": {Weight: 1}, "b": {Weight: 2}, "c": {Weight: 4}},
+			InstancesConfig{Total: 10},
+			map[string]int{"a": 1, "b": 3, "c": 6}},
+		{"total menor a la cantidad de stacks",
+			map[string]StackConfig{"a": {Weight: 1}, "b": {Weight: 1}, "c": {Weight: 1}},
+			InstancesConfig{Total: 1},
+			map[string]int{"a": 1, "b": 0, "c": 0}},
+		{"stack sin peso",
+			map[string]StackConfig{"a": {Weight: 0}, "b": {Weight: 1}},
+			InstancesConfig{Total: 4},
+			map[string]int{"a": 0, "b": 4}},
+		{"cantidades fijas con pesos",
+			map[string]StackConfig{"a": {Weight: 1, Instances: fixed(3)}, "b": {Weight: 1}, "c": {Weight: 3}},
+			InstancesConfig{Total: 7},
+			map[string]int{"a": 3, "b": 1, "c": 3}},
+		{"cantidades fijas que superan el total",
+			map[string]StackConfig{"a": {Weight: 1, Instances: fixed(5)}, "b": {Weight: 1}},
+			InstancesConfig{Total: 4},
+			map[string]int{"a": 5, "b": 0}},
+		{"cantidad fija 0",
+			map[string]StackConfig{"a": {Weight: 1, Instances: fixed(0)}, "b": {Weight: 1}},
+			InstancesConfig{PerStack: 2},
+			map[string]int{"a": 0, "b": 2}},
+		{"sin pesos",
+			map[string]StackConfig{"a": {Weight: 0}, "b": {Weight: 0}},
+			InstancesConfig{Total: 3},
+			map[string]int{"a": 0, "b": 0}},
+		{"mantener instancias",
+			map[string]StackConfig{"a": {Weight: 1, Instances: fixed(2)}, "b": {Weight: 1}},
+			InstancesConfig{Keep: true},
+			map[string]int{"a": 2, "b": KeepInstances}},
+		{"mantener instancias con instancias por stack",
+			map[string]StackConfig{"a": {Weight: 1}, "b": {Weight: 1}},
+			InstancesConfig{PerStack: 1, Keep: true},
+			map[string]int{"a": 1, "b": 1}},
+	}
+
+	for _, c := range cases {
+		sm := NewStackManager()
+		for stackKey, config := range c.stacks {
+			if err := sm.AppendStack(stackKey, helper.NewDockerHelperFromClient(dockertest.NewEngine(stackKey), ""), config); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		targets := sm.Targets(c.config)
+		for stackKey, instances := range c.expected {
+			if targets[stackKey] != instances {
+				t.Errorf("%s: objetivos %v, se esperaban %v", c.name, targets, c.expected)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package cluster
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ch3lo/yale/util"
+)
+
+// WaveApproval se consulta antes de desplegar cada ola a partir de la segunda.
+// Si retorna false se aborta el despliegue. Debe retornar false en cuanto se cancele ctx.
+type WaveApproval func(ctx context.Context, wave int, stacks []string) bool
+
+// WaveConfig define el orden de despliegue de los stacks.
+// Cada ola se despliega una vez que la ola anterior termino. Los stacks que no
+// forman parte de ninguna ola se despliegan en una ultima ola.
+// Soak es el tiempo de espera entre olas.
+type WaveConfig struct {
+	Waves    [][]string
+	Soak     time.Duration
+	Approval WaveApproval
+}
+
+// buildWaves valida las olas configuradas y agrega la ola con los stacks restantes
+func (sm *StackManager) buildWaves(config WaveConfig) ([][]string, error) {
+	var waves [][]string
+	assigned := make(map[string]bool)
+
+	for _, wave := range config.Waves {
+		var stacks []string
+		for _, stackKey := range wave {
+			if _, ok := sm.stacks[stackKey]; !ok {
+				return nil, errors.New(fmt.Sprintf("El stack %s de la ola no existe", stackKey))
+			}
+
+			if assigned[stackKey] {
+				return nil, errors.New(fmt.Sprintf("El stack %s esta en mas de una ola", stackKey))
+			}
+
+			assigned[stackKey] = true
+			stacks = append(stacks, stackKey)
+		}
+
+		if len(stacks) > 0 {
+			waves = append(waves, stacks)
+		}
+	}
+
+	var remaining []string
+	for _, stackKey := range sm.StackKeys() {
+		if !assigned[stackKey] {
+			remaining = append(remaining, stackKey)
+		}
+	}
+
+	if len(remaining) > 0 {
+		waves = append(waves, remaining)
+	}
+
+	return waves, nil
+}
+
+// waitWave aplica el tiempo de espera y la aprobacion antes de desplegar una ola.
+// Retorna false si no se aprueba la ola o si el contexto se cancela durante la espera
+func (sm *StackManager) waitWave(ctx context.Context, config WaveConfig, wave int, stacks []string) bool {
+	if config.Soak > 0 {
+		util.Log.Infof("Esperando %s antes de desplegar la ola %d", config.Soak, wave)
+		select {
+		case <-ctx.Done():
+			util.Log.Warnln("Se canceló la espera de la ola", wave)
+			return false
+		case <-time.After(config.Soak):
+		}
+	}
+
+	if config.Approval != nil {
+		if !config.Approval(ctx, wave, stacks) {
+			if ctx.Err() != nil {
+				util.Log.Warnln("Se canceló la aprobación de la ola", wave)
+			} else {
+				util.Log.Warnf("No se aprobó el despliegue de la ola %d", wave)
+			}
+			return false
+		}
+	}
+
+	return ctx.Err() == nil
+}
@@ -0,0 +1,373 @@
+package e2e
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/ch3lo/yale/webhook"
+	"github.com/fsouza/go-dockerclient"
+)
+
+const (
+	newTag = "1.1-abc"
+	oldTag = "1.0-xyz"
+)
+
+// deployOutput es el resumen JSON que imprime el comando deploy
+type deployOutput struct {
+	DeployId   string
+	TraceId    string
+	Succeeded  []string
+	Failed     []string
+	RolledBack []string
+	Containers []struct {
+		Stack      string
+		RegisterId string
+		Address    string
+	}
+}
+
+func parseDeploy(t *testing.T, res result) deployOutput {
+	var output deployOutput
+	if err := json.Unmarshal([]byte(res.lastLine()), &output); err != nil {
+		t.Fatalf("Resumen del despliegue invalido %q: %s", res.stdout, err)
+	}
+
+	return output
+}
+
+func deployArgs(extra ...string) []string {
+	args := []string{
+		"deploy",
+		"--image", image,
+		"--tag", newTag,
+		"--smoke-request", "/health",
+		"--smoke-expected", "ok",
+		"--smoke-retries", "1",
+		"--output", "summary",
+	}
+
+	return append(args, extra...)
+}
+
+func TestDeployAcrossEndpoints(t *testing.T) {
+	h := newHarness(t, "a", "b")
+	defer h.close()
+	h.httpTarget("a", healthy)
+	h.httpTarget("b", healthy)
+
+	res := h.run(deployArgs("--instances", "2")...)
+	if res.code != 0 {
+		t.Fatalf("El despliegue terminó con codigo %d: %s", res.code, res.stderr)
+	}
+
+	output := parseDeploy(t, res)
+	if strings.Join(output.Succeeded, ",") != "a,b" {
+		t.Errorf("Stacks exitosos inesperados %v", output.Succeeded)
+	}
+
+	if len(output.Containers) != 4 {
+		t.Errorf("El resumen tiene %d contenedores, se esperaban 4", len(output.Containers))
+	}
+
+	for _, name := range []string{"a", "b"} {
+		if running := h.containers(name, newTag, false); running != 2 {
+			t.Errorf("El endpoint %s tiene %d instancias, se esperaban 2", name, running)
+		}
+	}
+}
+
+func TestDeployWithTcpSmokeTest(t *testing.T) {
+	h := newHarness(t, "a")
+	defer h.close()
+	h.tcpTarget("a")
+
+	res := h.run(deployArgs("--instances", "1", "--smoke-type", "tcp", "--output", "containers")...)
+	if res.code != 0 {
+		t.Fatalf("El despliegue terminó con codigo %d: %s", res.code, res.stderr)
+	}
+
+	// el formato containers es el listado de contenedores que imprimian las versiones anteriores
+	var containers []map[string]string
+	if err := json.Unmarshal([]byte(res.lastLine()), &containers); err != nil || len(containers) != 1 || len(containers[0]) != 2 || containers[0]["Address"] == "" {
+		t.Errorf("Listado de contenedores inesperado %q", res.lastLine())
+	}
+
+	if running := h.containers("a", newTag, false); running != 1 {
+		t.Errorf("El endpoint tiene %d instancias, se esperaba 1", running)
+	}
+}
+
+func TestDeployLogDriver(t *testing.T) {
+	for _, tc := range []struct {
+		args   []string
+		driver string
+	}{
+		{nil, "syslog"},
+		{[]string{"--log-driver", "json-file"}, "json-file"},
+	} {
+		h := newHarness(t, "a")
+		h.httpTarget("a", healthy)
+
+		res := h.run(deployArgs(append([]string{"--instances", "1"}, tc.args...)...)...)
+		if res.code != 0 {
+			h.close()
+			t.Fatalf("El despliegue terminó con codigo %d: %s", res.code, res.stderr)
+		}
+
+		containers, err := h.endpoint("a").engine.ListContainers(docker.ListContainersOptions{})
+		if err != nil || len(containers) != 1 {
+			h.close()
+			t.Fatalf("El endpoint tiene %d contenedores, se esperaba 1: %v", len(containers), err)
+		}
+
+		container, err := h.endpoint("a").engine.InspectContainer(containers[0].ID)
+		if err != nil {
+			h.close()
+			t.Fatal(err)
+		}
+
+		if container.HostConfig.LogConfig.Type != tc.driver {
+			t.Errorf("El contenedor usa el driver de logs %q, se esperaba %s", container.HostConfig.LogConfig.Type, tc.driver)
+		}
+		h.close()
+	}
+}
+
+func TestDeployRollbackWhenContainersCrash(t *testing.T) {
+	h := newHarness(t, "a", "b")
+	defer h.close()
+	h.runContainer("a", oldTag)
+	h.runContainer("b", oldTag)
+
+	// los contenedores del endpoint b se caen al recibir el smoke test, lo que yale detecta
+	// por el stream de eventos
+	engine := h.endpoint("b").engine
+	h.httpTarget("a", healthy)
+	h.httpTarget("b", func(w http.ResponseWriter, r *http.Request) {
+		engine.CrashImage(image+":"+newTag, 137)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+
+	res := h.run(deployArgs("--instances", "1")...)
+	if res.code != 1 {
+		t.Fatalf("El despliegue terminó con codigo %d, se esperaba 1", res.code)
+	}
+
+	output := parseDeploy(t, res)
+	if strings.Join(output.Failed, ",") != "b" || strings.Join(output.RolledBack, ",") != "a,b" {
+		t.Errorf("Resumen inesperado %#v", output)
+	}
+
+	for _, name := range []string{"a", "b"} {
+		if total := h.containers(name, newTag, true); total != 0 {
+			t.Errorf("El rollback dejó %d contenedores de la nueva version en el endpoint %s", total, name)
+		}
+		if running := h.containers(name, oldTag, false); running != 1 {
+			t.Errorf("El rollback afectó la version anterior del endpoint %s", name)
+		}
+	}
+}
+
+func TestDeployWithWrongCredentials(t *testing.T) {
+	h := newHarness(t, "a")
+	defer h.close()
+	h.httpTarget("a", healthy)
+	h.auth = h.writeAuth(username, "wrong")
+
+	res := h.run(deployArgs("--instances", "1")...)
+	if res.code != 1 {
+		t.Fatalf("El despliegue terminó con codigo %d, se esperaba 1", res.code)
+	}
+
+	if total := h.containers("a", newTag, true); total != 0 {
+		t.Errorf("Se crearon %d contenedores sin descargar la imagen", total)
+	}
+}
+
+func TestListAndFilterAcrossEndpoints(t *testing.T) {
+	h := newHarness(t, "a", "b")
+	defer h.close()
+	h.runContainer("a", oldTag)
+	h.runContainer("b", oldTag)
+	newer := h.runContainer("b", newTag)
+
+	res := h.run("list", "--output", "json")
+	if res.code != 0 {
+		t.Fatalf("El listado terminó con codigo %d: %s", res.code, res.stderr)
+	}
+
+	var views []struct {
+		Stack string `json:"stack"`
+		Id    string `json:"id"`
+		Tag   string `json:"tag"`
+		State string `json:"state"`
+	}
+	if err := json.Unmarshal([]byte(res.stdout), &views); err != nil {
+		t.Fatalf("Listado invalido %q: %s", res.stdout, err)
+	}
+
+	if len(views) != 3 {
+		t.Fatalf("El listado tiene %d contenedores, se esperaban 3", len(views))
+	}
+
+	for _, view := range views {
+		if view.State != "running" {
+			t.Errorf("El contenedor %s tiene estado %s", view.Id, view.State)
+		}
+	}
+
+	// un filtro invalido se informa como error del comando y no como panic
+	if res = h.run("undeploy", "--yes", "--if", "("); res.code != 1 || !strings.Contains(res.stdout+res.stderr, "Filtro ( invalido") {
+		t.Errorf("El undeploy con un filtro invalido terminó con codigo %d: %s", res.code, res.stderr)
+	}
+
+	res = h.run("filter", "--quiet", "image_tag="+newTag+",stack=b")
+	if res.code != 0 {
+		t.Fatalf("El filtro terminó con codigo %d: %s", res.code, res.stderr)
+	}
+
+	if strings.TrimSpace(res.stdout) != newer.ID {
+		t.Errorf("El filtro entregó %q, se esperaba %s", res.stdout, newer.ID)
+	}
+}
+
+func TestDeployWebhookEvents(t *testing.T) {
+	h := newHarness(t, "a")
+	defer h.close()
+	h.httpTarget("a", healthy)
+
+	// el receptor valida la firma de cada evento recibido
+	var mutex sync.Mutex
+	var received []webhook.Payload
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		timestamp, _ := strconv.ParseInt(r.Header.Get(webhook.HeaderTimestamp), 10, 64)
+		if !webhook.Verify("s3cret", timestamp, body, r.Header.Get(webhook.HeaderSignature)) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		var payload webhook.Payload
+		json.Unmarshal(body, &payload)
+		mutex.Lock()
+		received = append(received, payload)
+		mutex.Unlock()
+	}))
+	defer receiver.Close()
+
+	sink := filepath.Join(h.dir, "events.jsonl")
+	h.global = []string{
+		"--webhook-secret", "s3cret",
+		"--webhook", receiver.URL + ",events=deploy_started,events=deploy_finished",
+		"--webhook", "file://" + sink,
+	}
+
+	res := h.run(deployArgs("--instances", "1")...)
+	if res.code != 0 {
+		t.Fatalf("El despliegue terminó con codigo %d: %s", res.code, res.stderr)
+	}
+	output := parseDeploy(t, res)
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if len(received) != 2 || received[0].Type != "EVENT_DEPLOY_STARTED" || received[1].Type != "EVENT_DEPLOY_FINISHED" {
+		t.Fatalf("El receptor recibió %#v", received)
+	}
+
+	if received[1].DeployId != output.DeployId || received[1].Ok == nil || !*received[1].Ok {
+		t.Errorf("Evento de termino inesperado %#v", received[1])
+	}
+
+	data, err := ioutil.ReadFile(sink)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var types []string
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		var payload webhook.Payload
+		if err := json.Unmarshal([]byte(line), &payload); err != nil {
+			t.Fatalf("Linea invalida %q: %s", line, err)
+		}
+		if payload.Type == "EVENT_SERVICE_STEP" {
+			types = append(types, payload.Step)
+		} else {
+			types = append(types, payload.Type)
+		}
+	}
+
+	expected := "EVENT_DEPLOY_STARTED,STEP_CREATED,STEP_SMOKE_READY,STEP_WARM_READY,EVENT_STACK_FINISHED,EVENT_DEPLOY_FINISHED"
+	if strings.Join(types, ",") != expected {
+		t.Errorf("El archivo tiene los eventos %v", types)
+	}
+}
+
+func TestDeployTraceExport(t *testing.T) {
+	h := newHarness(t, "a")
+	defer h.close()
+	h.httpTarget("a", healthy)
+
+	traces := filepath.Join(h.dir, "traces.jsonl")
+	h.global = []string{"--trace-endpoint", "file://" + traces, "--trace-service", "yale-e2e"}
+
+	res := h.run(deployArgs("--instances", "1")...)
+	if res.code != 0 {
+		t.Fatalf("El despliegue terminó con codigo %d: %s", res.code, res.stderr)
+	}
+
+	output := parseDeploy(t, res)
+	if len(output.TraceId) != 32 {
+		t.Fatalf("El resumen no tiene la traza del despliegue %#v", output)
+	}
+
+	data, err := ioutil.ReadFile(traces)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// cada linea es un ExportTraceServiceRequest de OTLP/JSON
+	names := make(map[string]bool)
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		var request struct {
+			ResourceSpans []struct {
+				ScopeSpans []struct {
+					Spans []struct {
+						TraceId string
+						Name    string
+					}
+				}
+			}
+		}
+		if err := json.Unmarshal([]byte(line), &request); err != nil {
+			t.Fatalf("Linea invalida %q: %s", line, err)
+		}
+
+		for _, resource := range request.ResourceSpans {
+			for _, scope := range resource.ScopeSpans {
+				for _, span := range scope.Spans {
+					if span.TraceId == output.TraceId {
+						names[span.Name] = true
+					}
+				}
+			}
+		}
+	}
+
+	for _, name := range []string{"deploy " + image, "stack a", "service create", "docker CreateContainer", "service smoke_test", "monitor http"} {
+		if !names[name] {
+			t.Errorf("La traza no tiene el span %s, spans %v", name, names)
+		}
+	}
+
+	if !strings.Contains(string(data), `"stringValue":"yale-e2e"`) {
+		t.Error("Las trazas no tienen el nombre del servicio")
+	}
+}
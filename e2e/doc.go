@@ -0,0 +1,6 @@
+// Package e2e contiene las pruebas de punta a punta del binario yale. Las pruebas compilan el
+// binario y lo ejecutan contra servidores locales que implementan el API de Docker en memoria
+// (dockertest.Server), con los smoke tests apuntando a targets HTTP y TCP locales.
+//
+// Se omiten con go test -short.
+package e2e
@@ -0,0 +1,317 @@
+package e2e
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/ch3lo/yale/helper/dockertest"
+	"github.com/fsouza/go-dockerclient"
+)
+
+const (
+	registry = "registry.it.lan.com"
+	image    = registry + "/app"
+	username = "deployer"
+	password = "s3cret"
+)
+
+// yaleBinary es la ruta del binario compilado por TestMain
+var yaleBinary string
+
+func TestMain(m *testing.M) {
+	flag.Parse()
+	if testing.Short() {
+		os.Exit(m.Run())
+	}
+
+	dir, err := ioutil.TempDir("", "yale-e2e")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	yaleBinary = filepath.Join(dir, "yale")
+	build := exec.Command("go", "build", "-o", yaleBinary, "github.com/ch3lo/yale")
+	build.Stdout = os.Stderr
+	build.Stderr = os.Stderr
+	if err := build.Run(); err != nil {
+		fmt.Fprintln(os.Stderr, "No se pudo compilar yale:", err)
+		os.RemoveAll(dir)
+		os.Exit(1)
+	}
+
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// endpoint es un daemon de Docker falso, con su motor en memoria y su servidor HTTP
+type endpoint struct {
+	name   string
+	engine *dockertest.Engine
+	server *dockertest.Server
+}
+
+// harness levanta los endpoints y targets de una prueba y ejecuta el binario contra ellos
+type harness struct {
+	t         *testing.T
+	dir       string
+	auth      string
+	global    []string
+	endpoints []*endpoint
+	closers   []func()
+}
+
+func newHarness(t *testing.T, names ...string) *harness {
+	if testing.Short() {
+		t.Skip("Se omiten las pruebas de punta a punta en modo short")
+	}
+
+	dir, err := ioutil.TempDir("", "yale-e2e")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := &harness{t: t, dir: dir}
+	h.auth = h.writeAuth(username, password)
+
+	for _, name := range names {
+		engine := dockertest.NewEngine("node-" + name)
+		server := dockertest.NewServer(engine)
+		server.RequireAuth(username, password)
+		h.endpoints = append(h.endpoints, &endpoint{name: name, engine: engine, server: server})
+		h.closers = append(h.closers, server.Close)
+	}
+
+	return h
+}
+
+func (h *harness) close() {
+	if h.t.Failed() {
+		h.logs()
+	}
+
+	for k := len(h.closers) - 1; k >= 0; k-- {
+		h.closers[k]()
+	}
+	os.RemoveAll(h.dir)
+}
+
+// writeAuth escribe un archivo de credenciales del registro en formato dockercfg
+func (h *harness) writeAuth(user string, pass string) string {
+	path := filepath.Join(h.dir, "dockercfg-"+user)
+	auth := base64.StdEncoding.EncodeToString([]byte(user + ":" + pass))
+	cfg := fmt.Sprintf(`{"https://%s": {"auth": "%s", "email": "%s@lan.com"}}`, registry, auth, user)
+	if err := ioutil.WriteFile(path, []byte(cfg), 0600); err != nil {
+		h.t.Fatal(err)
+	}
+
+	return path
+}
+
+func (h *harness) endpoint(name string) *endpoint {
+	for _, ep := range h.endpoints {
+		if ep.name == name {
+			return ep
+		}
+	}
+
+	h.t.Fatalf("El endpoint %s no existe", name)
+	return nil
+}
+
+func splitHostPort(t *testing.T, addr string) (string, int64) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	public, err := strconv.ParseInt(port, 10, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return host, public
+}
+
+// httpTarget levanta un servicio HTTP y lo publica como el puerto 8080 de los contenedores del endpoint
+func (h *harness) httpTarget(name string, handler http.HandlerFunc) {
+	server := httptest.NewServer(handler)
+	h.closers = append(h.closers, server.Close)
+
+	host, port := splitHostPort(h.t, server.Listener.Addr().String())
+	h.endpoint(name).engine.MapPort(8080, host, port)
+}
+
+// tcpTarget levanta un servicio TCP que acepta y cierra las conexiones y lo publica como el
+// puerto 8080 de los contenedores del endpoint
+func (h *harness) tcpTarget(name string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	h.closers = append(h.closers, func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	host, port := splitHostPort(h.t, listener.Addr().String())
+	h.endpoint(name).engine.MapPort(8080, host, port)
+}
+
+func healthy(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte("ok"))
+}
+
+// result es la salida de una ejecucion del binario
+type result struct {
+	stdout string
+	stderr string
+	code   int
+}
+
+// lastLine entrega la ultima linea no vacia de la salida estandar
+func (r result) lastLine() string {
+	lines := strings.Split(strings.TrimSpace(r.stdout), "\n")
+	return lines[len(lines)-1]
+}
+
+// globalArgs son los flags globales con los endpoints de la prueba
+func (h *harness) globalArgs() []string {
+	global := []string{"--auth-file", h.auth, "--log-level", "debug"}
+	for _, ep := range h.endpoints {
+		global = append(global, "--endpoint", ep.name+"="+ep.server.URL())
+	}
+
+	return append(global, h.global...)
+}
+
+// run ejecuta yale con los endpoints y flags globales de la prueba. Los logs se escriben en yale.log dentro del
+// directorio temporal y se muestran al cerrar el harness si la prueba falla
+func (h *harness) run(args ...string) result {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, yaleBinary, append(h.globalArgs(), args...)...)
+	cmd.Dir = h.dir
+	cmd.Env = append(os.Environ(), "DOCKER_HOST=")
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	res := result{}
+	if err := cmd.Run(); err != nil {
+		exitErr, ok := err.(*exec.ExitError)
+		if !ok {
+			h.t.Fatal(err)
+		}
+		res.code = exitErr.Sys().(syscall.WaitStatus).ExitStatus()
+	}
+	res.stdout, res.stderr = stdout.String(), stderr.String()
+
+	h.t.Logf("yale %s terminó con codigo %d", strings.Join(args, " "), res.code)
+	return res
+}
+
+// serve inicia yale serve en segundo plano con los flags entregados y espera que responda. Entrega
+// la url del API. El servidor se detiene con una señal de término al cerrar el harness
+func (h *harness) serve(args ...string) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+
+	cmd := exec.Command(yaleBinary, append(h.globalArgs(), append([]string{"serve", "--listen", addr}, args...)...)...)
+	cmd.Dir = h.dir
+	cmd.Env = append(os.Environ(), "DOCKER_HOST=")
+	if err := cmd.Start(); err != nil {
+		h.t.Fatal(err)
+	}
+
+	exited := make(chan struct{})
+	go func() {
+		cmd.Wait()
+		close(exited)
+	}()
+
+	h.closers = append(h.closers, func() {
+		cmd.Process.Signal(syscall.SIGTERM)
+		select {
+		case <-exited:
+		case <-time.After(30 * time.Second):
+			cmd.Process.Kill()
+		}
+	})
+
+	url := "http://" + addr
+	for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); time.Sleep(50 * time.Millisecond) {
+		select {
+		case <-exited:
+			h.t.Fatal("yale serve terminó antes de atender el API")
+		default:
+		}
+
+		if response, err := http.Get(url + "/healthz"); err == nil {
+			response.Body.Close()
+			return url
+		}
+	}
+
+	h.t.Fatal("yale serve no respondió a tiempo")
+	return ""
+}
+
+// logs muestra el log del binario, util para diagnosticar una prueba fallida
+func (h *harness) logs() {
+	if data, err := ioutil.ReadFile(filepath.Join(h.dir, "yale.log")); err == nil {
+		h.t.Log(string(data))
+	}
+}
+
+// containers cuenta los contenedores de la imagen con el tag entregado en el endpoint. Si all
+// es false solo cuenta los que estan en ejecucion
+func (h *harness) containers(name string, tag string, all bool) int {
+	containers, err := h.endpoint(name).engine.ListContainers(docker.ListContainersOptions{
+		All:     all,
+		Filters: map[string][]string{"label": []string{"image_name=" + image, "image_tag=" + tag}},
+	})
+	if err != nil {
+		h.t.Fatal(err)
+	}
+
+	return len(containers)
+}
+
+// runContainer crea un contenedor en ejecucion como si lo hubiera desplegado yale
+func (h *harness) runContainer(name string, tag string) *docker.Container {
+	container, err := h.endpoint(name).engine.Run(image+":"+tag, map[string]string{"image_name": image, "image_tag": tag})
+	if err != nil {
+		h.t.Fatal(err)
+	}
+
+	return container
+}
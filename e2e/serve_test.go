@@ -0,0 +1,345 @@
+package e2e
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ch3lo/yale/webhook"
+)
+
+const apiToken = "t0ken"
+
+// apiClient ejecuta los requests al API de yale serve
+type apiClient struct {
+	t     *testing.T
+	url   string
+	token string
+}
+
+// call ejecuta el request y decodifica la respuesta JSON en out, si no es nil. Entrega el codigo de respuesta
+func (a apiClient) call(method string, path string, body interface{}, out interface{}) int {
+	var reader *bytes.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			a.t.Fatal(err)
+		}
+		reader = bytes.NewReader(data)
+	} else {
+		reader = bytes.NewReader(nil)
+	}
+
+	request, err := http.NewRequest(method, a.url+path, reader)
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	if a.token != "" {
+		request.Header.Set("Authorization", "Bearer "+a.token)
+	}
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	defer response.Body.Close()
+
+	data, _ := ioutil.ReadAll(response.Body)
+	if out != nil {
+		if err := json.Unmarshal(data, out); err != nil {
+			a.t.Fatalf("Respuesta invalida de %s %s %q: %s", method, path, data, err)
+		}
+	}
+
+	return response.StatusCode
+}
+
+// apiJob es el trabajo que entrega el API
+type apiJob struct {
+	Id     string
+	Kind   string
+	Status string
+	Error  string
+	Result json.RawMessage
+}
+
+// sseEvent es un evento recibido por Server-Sent Events
+type sseEvent struct {
+	name string
+	data string
+}
+
+// submit encola la operacion y espera su termino siguiendo sus eventos. Entrega el estado final
+// del trabajo y los eventos de despliegue recibidos
+func (a apiClient) submit(path string, body interface{}) (apiJob, []webhook.Payload) {
+	var job apiJob
+	if code := a.call("POST", path, body, &job); code != http.StatusAccepted {
+		a.t.Fatalf("POST %s respondió %d", path, code)
+	}
+
+	return a.follow(job)
+}
+
+// follow espera el termino del trabajo siguiendo sus eventos
+func (a apiClient) follow(job apiJob) (apiJob, []webhook.Payload) {
+	request, _ := http.NewRequest("GET", a.url+"/v1/jobs/"+job.Id+"/events", nil)
+	request.Header.Set("Authorization", "Bearer "+a.token)
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	defer response.Body.Close()
+
+	if response.Header.Get("Content-Type") != "text/event-stream" {
+		a.t.Fatalf("Content-Type inesperado %s", response.Header.Get("Content-Type"))
+	}
+
+	var events []sseEvent
+	var current sseEvent
+	scanner := bufio.NewScanner(response.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "":
+			if current.name != "" {
+				events = append(events, current)
+			}
+			current = sseEvent{}
+		case strings.HasPrefix(line, "event: "):
+			current.name = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			current.data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+
+	var payloads []webhook.Payload
+	for _, event := range events {
+		switch event.name {
+		case "status":
+			json.Unmarshal([]byte(event.data), &job)
+		case "deploy":
+			var payload webhook.Payload
+			json.Unmarshal([]byte(event.data), &payload)
+			payloads = append(payloads, payload)
+		}
+	}
+
+	if len(events) == 0 || events[len(events)-1].name != "status" {
+		a.t.Fatalf("El stream del trabajo %s no terminó con su estado: %#v", job.Id, events)
+	}
+
+	return job, payloads
+}
+
+func deployRequest(tag string) map[string]interface{} {
+	return map[string]interface{}{
+		"image":     image,
+		"tag":       tag,
+		"instances": 2,
+		"smoke":     map[string]interface{}{"request": "/health", "expected": "ok", "retries": 1},
+	}
+}
+
+func TestServeRequiresToken(t *testing.T) {
+	h := newHarness(t, "a")
+	defer h.close()
+
+	api := apiClient{t: t, url: h.serve("--token", apiToken)}
+	if code := api.call("POST", "/v1/environments/default/deploy", deployRequest(newTag), nil); code != http.StatusUnauthorized {
+		t.Errorf("Sin token respondió %d, se esperaba 401", code)
+	}
+
+	if code := api.call("GET", "/metrics", nil, nil); code != http.StatusUnauthorized {
+		t.Errorf("Las metricas sin token respondieron %d, se esperaba 401", code)
+	}
+
+	api.token = "otro"
+	if code := api.call("GET", "/v1/environments", nil, nil); code != http.StatusUnauthorized {
+		t.Errorf("Con un token invalido respondió %d, se esperaba 401", code)
+	}
+
+	api.token = apiToken
+	if code := api.call("GET", "/metrics", nil, nil); code != http.StatusOK {
+		t.Errorf("Las metricas con token respondieron %d", code)
+	}
+
+	var environments []struct {
+		Name   string
+		Stacks []struct{ Name string }
+	}
+	if code := api.call("GET", "/v1/environments", nil, &environments); code != http.StatusOK {
+		t.Fatalf("GET /v1/environments respondió %d", code)
+	}
+
+	if len(environments) != 1 || environments[0].Name != "default" || len(environments[0].Stacks) != 1 || environments[0].Stacks[0].Name != "a" {
+		t.Errorf("Ambientes inesperados %#v", environments)
+	}
+
+	request := deployRequest(newTag)
+	request["wave_approval"] = true
+	if code := api.call("POST", "/v1/environments/default/deploy", request, nil); code != http.StatusBadRequest {
+		t.Errorf("Con aprobacion de olas respondió %d, se esperaba 400", code)
+	}
+
+	request = deployRequest(newTag)
+	request["env_files"] = []string{"/etc/passwd"}
+	if code := api.call("POST", "/v1/environments/default/deploy", request, nil); code != http.StatusBadRequest {
+		t.Errorf("Con archivos de variables de entorno respondió %d, se esperaba 400", code)
+	}
+
+	request = deployRequest(newTag)
+	request["smoke"] = map[string]interface{}{"request": "/health", "expected": "("}
+	if code := api.call("POST", "/v1/environments/default/deploy", request, nil); code != http.StatusBadRequest {
+		t.Errorf("Con una respuesta esperada invalida respondió %d, se esperaba 400", code)
+	}
+
+	request = deployRequest(newTag)
+	request["warmup"] = map[string]interface{}{"request": "/health", "expected": "["}
+	if code := api.call("POST", "/v1/environments/default/scale", request, nil); code != http.StatusBadRequest {
+		t.Errorf("Con un warmup invalido respondió %d, se esperaba 400", code)
+	}
+
+	request = deployRequest(newTag)
+	request["instancias"] = 2
+	if code := api.call("POST", "/v1/environments/default/deploy", request, nil); code != http.StatusBadRequest {
+		t.Errorf("Con un campo desconocido respondió %d, se esperaba 400", code)
+	}
+
+	if code := api.call("GET", "/v1/environments/default/containers?tag_filter=(", nil, nil); code != http.StatusBadRequest {
+		t.Errorf("Con un filtro invalido respondió %d, se esperaba 400", code)
+	}
+
+	if code := api.call("POST", "/v1/environments/prod/deploy", deployRequest(newTag), nil); code != http.StatusNotFound {
+		t.Errorf("Con un ambiente desconocido respondió %d, se esperaba 404", code)
+	}
+}
+
+func TestServeDeployRollbackAndUndeploy(t *testing.T) {
+	h := newHarness(t, "a")
+	defer h.close()
+	h.httpTarget("a", healthy)
+	h.global = []string{"--state-file", filepath.Join(h.dir, "state.json")}
+
+	api := apiClient{t: t, url: h.serve("--token", apiToken), token: apiToken}
+
+	job, payloads := api.submit("/v1/environments/default/deploy", deployRequest(oldTag))
+	if job.Status != "JOB_SUCCEEDED" {
+		t.Fatalf("El despliegue terminó con estado %s: %s", job.Status, job.Error)
+	}
+
+	if len(payloads) == 0 || payloads[0].Type != "EVENT_DEPLOY_STARTED" || payloads[len(payloads)-1].Type != "EVENT_DEPLOY_FINISHED" {
+		t.Errorf("Eventos de despliegue inesperados %#v", payloads)
+	}
+
+	var resume deployOutput
+	if err := json.Unmarshal(job.Result, &resume); err != nil || strings.Join(resume.Succeeded, ",") != "a" || len(resume.Containers) != 2 {
+		t.Errorf("Resultado inesperado %s", job.Result)
+	}
+
+	if job, _ = api.submit("/v1/environments/default/deploy", deployRequest(newTag)); job.Status != "JOB_SUCCEEDED" {
+		t.Fatalf("El segundo despliegue terminó con estado %s: %s", job.Status, job.Error)
+	}
+
+	var status struct {
+		Stacks []struct {
+			Stack   string
+			Running int
+			Desired *int
+			Tags    []string
+		}
+		Instances []struct{ Tag string }
+	}
+	if code := api.call("GET", "/v1/environments/default/status?image="+image+"&tag="+newTag, nil, &status); code != http.StatusOK {
+		t.Fatalf("GET status respondió %d", code)
+	}
+
+	if len(status.Stacks) != 1 || status.Stacks[0].Running != 2 || status.Stacks[0].Desired == nil || *status.Stacks[0].Desired != 2 || len(status.Instances) != 2 {
+		t.Errorf("Estado inesperado %#v", status)
+	}
+
+	// el rollback vuelve al tag del despliegue anterior registrado en el estado deseado
+	if job, _ = api.submit("/v1/environments/default/rollback", map[string]string{"image": image}); job.Status != "JOB_SUCCEEDED" {
+		t.Fatalf("El rollback terminó con estado %s: %s", job.Status, job.Error)
+	}
+
+	if running := h.containers("a", oldTag, false); running != 2 {
+		t.Errorf("Luego del rollback hay %d instancias de la version anterior, se esperaban 2", running)
+	}
+
+	var containers []struct{ Tag string }
+	if code := api.call("GET", "/v1/environments/default/containers?tag_filter="+newTag, nil, &containers); code != http.StatusOK || len(containers) != 2 {
+		t.Errorf("GET containers respondió %d con %d contenedores", code, len(containers))
+	}
+
+	if job, _ = api.submit("/v1/environments/default/undeploy", map[string]interface{}{"tag_filter": newTag, "stop_timeout": 1}); job.Status != "JOB_SUCCEEDED" {
+		t.Fatalf("El undeploy terminó con estado %s: %s", job.Status, job.Error)
+	}
+
+	if total := h.containers("a", newTag, true); total != 0 {
+		t.Errorf("El undeploy dejó %d contenedores", total)
+	}
+
+	if code := api.call("DELETE", "/v1/jobs/"+job.Id, nil, nil); code != http.StatusConflict {
+		t.Errorf("Cancelar un trabajo terminado respondió %d, se esperaba 409", code)
+	}
+
+	var jobs []apiJob
+	if code := api.call("GET", "/v1/jobs?environment=default", nil, &jobs); code != http.StatusOK || len(jobs) != 4 || jobs[0].Kind != "undeploy" {
+		t.Errorf("GET /v1/jobs respondió %d con %#v", code, jobs)
+	}
+}
+
+func TestServeQueriesWhileDeploying(t *testing.T) {
+	h := newHarness(t, "a")
+	defer h.close()
+
+	// el smoke test queda esperando hasta que se cancela el despliegue
+	checking := make(chan struct{}, 1)
+	h.httpTarget("a", func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case checking <- struct{}{}:
+		default:
+		}
+		<-r.Context().Done()
+	})
+
+	api := apiClient{t: t, url: h.serve("--token", apiToken), token: apiToken}
+
+	var job apiJob
+	if code := api.call("POST", "/v1/environments/default/deploy", deployRequest(newTag), &job); code != http.StatusAccepted {
+		t.Fatalf("POST deploy respondió %d", code)
+	}
+
+	select {
+	case <-checking:
+	case <-time.After(30 * time.Second):
+		t.Fatal("El despliegue no inició el smoke test")
+	}
+
+	var containers []struct{ Tag string }
+	if code := api.call("GET", "/v1/environments/default/containers", nil, &containers); code != http.StatusOK || len(containers) == 0 {
+		t.Errorf("GET containers durante el despliegue respondió %d con %d contenedores", code, len(containers))
+	}
+
+	if code := api.call("GET", "/v1/environments/default/status?image="+image, nil, nil); code != http.StatusOK {
+		t.Errorf("GET status durante el despliegue respondió %d", code)
+	}
+
+	if code := api.call("DELETE", "/v1/jobs/"+job.Id, nil, nil); code != http.StatusAccepted {
+		t.Fatalf("Cancelar el despliegue respondió %d", code)
+	}
+
+	if job, _ = api.follow(job); job.Status != "JOB_CANCELED" {
+		t.Errorf("El despliegue cancelado terminó con estado %s: %s", job.Status, job.Error)
+	}
+
+	if total := h.containers("a", newTag, true); total != 0 {
+		t.Errorf("El rollback dejó %d contenedores", total)
+	}
+}
@@ -0,0 +1,77 @@
+package helper
+
+import (
+	"context"
+
+	"github.com/ch3lo/yale/tracing"
+	"github.com/fsouza/go-dockerclient"
+)
+
+// DockerClient son las operaciones del API de Docker que utiliza el helper. *docker.Client
+// la implementa, lo que permite reemplazarla por un motor en memoria en las pruebas
+type DockerClient interface {
+	ListContainers(opts docker.ListContainersOptions) ([]docker.APIContainers, error)
+	InspectContainer(id string) (*docker.Container, error)
+	CreateContainer(opts docker.CreateContainerOptions) (*docker.Container, error)
+	StartContainer(id string, hostConfig *docker.HostConfig) error
+	StopContainer(id string, timeout uint) error
+	RestartContainer(id string, timeout uint) error
+	RemoveContainer(opts docker.RemoveContainerOptions) error
+	Logs(opts docker.LogsOptions) error
+
+	ListImages(opts docker.ListImagesOptions) ([]docker.APIImages, error)
+	PullImage(opts docker.PullImageOptions, auth docker.AuthConfiguration) error
+	RemoveImage(name string) error
+
+	CreateExec(opts docker.CreateExecOptions) (*docker.Exec, error)
+	StartExec(id string, opts docker.StartExecOptions) error
+	InspectExec(id string) (*docker.ExecInspect, error)
+
+	AddEventListener(listener chan<- *docker.APIEvents) error
+	RemoveEventListener(listener chan *docker.APIEvents) error
+}
+
+var _ DockerClient = (*docker.Client)(nil)
+
+// NewDockerHelperFromClient crea el helper sobre un cliente ya configurado. Las llamadas se
+// protegen con DefaultResilienceConfig, que se puede reemplazar con SetResilience
+func NewDockerHelperFromClient(client DockerClient, authCfg string) *DockerHelper {
+	dh := new(DockerHelper)
+	dh.authConfigPath = authCfg
+	dh.resilient = newResilientClient(client, DefaultResilienceConfig())
+	dh.client = dh.resilient.withContext(context.Background())
+
+	return dh
+}
+
+// SetResilience reemplaza la configuracion de reintentos, tiempo maximo y circuit breaker del endpoint
+func (dh *DockerHelper) SetResilience(config ResilienceConfig) {
+	dh.resilient.configure(config)
+}
+
+// CallStats entrega el resumen de las llamadas al API de Docker del endpoint
+func (dh *DockerHelper) CallStats() CallStats {
+	return dh.resilient.Stats()
+}
+
+// BreakerState entrega el estado del circuit breaker del endpoint
+func (dh *DockerHelper) BreakerState() BreakerState {
+	return dh.resilient.breaker.State()
+}
+
+// WithContext entrega un helper cuyas llamadas al API de Docker se dejan de esperar al cancelar
+// el contexto y, si el contexto pertenece a una traza, se registran como spans hijos de su span.
+// Comparte el cliente, los reintentos y el circuit breaker del endpoint, pero no el registro de
+// los eventos, que se deben seguir con el helper original
+func (dh *DockerHelper) WithContext(ctx context.Context) *DockerHelper {
+	client := dh.resilient.withContext(ctx)
+	if tracing.FromContext(ctx) != nil {
+		client = &tracedClient{DockerClient: client, ctx: ctx}
+	}
+
+	return &DockerHelper{
+		client:         client,
+		resilient:      dh.resilient,
+		authConfigPath: dh.authConfigPath,
+	}
+}
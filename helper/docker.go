@@ -2,22 +2,30 @@ package helper
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"io"
 	"io/ioutil"
+	"net/http"
 	"os"
 	"regexp"
 	"strconv"
+	"strings"
+	"sync"
 
 	"github.com/ch3lo/yale/util"
 	"github.com/fsouza/go-dockerclient"
 )
 
+// ErrImageInUse indica que la imagen no se puede remover porque algun contenedor la utiliza
+var ErrImageInUse = errors.New("La imagen esta siendo utilizada por un contenedor")
+
 type containerFilter struct {
 	NameRegexp  string
 	Status      []string
 	ImageRegexp string
 	TagRegexp   string
+	Labels      []string
 }
 
 func NewContainerFilter() *containerFilter {
@@ -30,39 +38,32 @@ func NewContainerFilter() *containerFilter {
 }
 
 type DockerHelper struct {
-	client         *docker.Client
+	client         DockerClient
+	resilient      *resilientClient
 	authConfigPath string
+	metaMutex      sync.Mutex
+	containerMeta  map[string]containerMeta
 }
 
 func NewDockerHelper(apiEndpoint string, authCfg string) (*DockerHelper, error) {
-	dh := new(DockerHelper)
-	dh.authConfigPath = authCfg
-
-	var err error
-	dh.client, err = docker.NewClient(apiEndpoint)
+	client, err := docker.NewClient(apiEndpoint)
 	if err != nil {
 		return nil, err
 	}
 
-	return dh, nil
+	return NewDockerHelperFromClient(client, authCfg), nil
 }
 
 func NewDockerTlsVerifyHelper(apiEndpoint string, authCfg string, cert string, key string, ca string) (*DockerHelper, error) {
-	dh := new(DockerHelper)
-	dh.authConfigPath = authCfg
-	var err error
-	dh.client, err = docker.NewTLSClient(apiEndpoint, cert, key, ca)
+	client, err := docker.NewTLSClient(apiEndpoint, cert, key, ca)
 	if err != nil {
 		return nil, err
 	}
 
-	return dh, nil
+	return NewDockerHelperFromClient(client, authCfg), nil
 }
 
 func NewDockerTlsHelper(apiEndpoint string, authCfg string, cert string, key string) (*DockerHelper, error) {
-	dh := new(DockerHelper)
-	dh.authConfigPath = authCfg
-
 	certPEMBlock, err := ioutil.ReadFile(cert)
 	if err != nil {
 		return nil, err
@@ -74,12 +75,12 @@ func NewDockerTlsHelper(apiEndpoint string, authCfg string, cert string, key str
 
 	var caPEMCert []byte
 
-	dh.client, err = docker.NewTLSClientFromBytes(apiEndpoint, certPEMBlock, keyPEMBlock, caPEMCert)
+	client, err := docker.NewTLSClientFromBytes(apiEndpoint, certPEMBlock, keyPEMBlock, caPEMCert)
 	if err != nil {
 		return nil, err
 	}
 
-	return dh, nil
+	return NewDockerHelperFromClient(client, authCfg), nil
 }
 
 func (dh *DockerHelper) authConfig(registry string) (docker.AuthConfiguration, error) {
@@ -106,10 +107,41 @@ func (dh *DockerHelper) authConfig(registry string) (docker.AuthConfiguration, e
 	return docker.AuthConfiguration{}, errors.New("No se encontraron las credenciales de autenticación")
 }
 
+// healthStatus obtiene el estado del healthcheck del sufijo que Docker agrega al estado del
+// contenedor en el listado, por ejemplo "Up 5 minutes (healthy)"
+var healthStatus = regexp.MustCompile(`\((healthy|unhealthy|health: starting)\)$`)
+
+// ContainerHealth entrega el estado del healthcheck del contenedor del listado: healthy,
+// unhealthy o starting. Entrega un string vacio si el contenedor no tiene healthcheck
+func ContainerHealth(container docker.APIContainers) string {
+	match := healthStatus.FindStringSubmatch(container.Status)
+	if match == nil {
+		return ""
+	}
+
+	return strings.TrimPrefix(match[1], "health: ")
+}
+
+// ContainerDead indica si el listado informa que el contenedor esta en estado dead, ya que la
+// inspeccion del contenedor no lo incluye
+func ContainerDead(container docker.APIContainers) bool {
+	return container.Status == "Dead"
+}
+
+// ListContainers entrega los contenedores que cumplen con el filtro. Un filtro sin estados
+// entrega los contenedores en cualquier estado, incluidos created y dead
 func (dh *DockerHelper) ListContainers(filter *containerFilter) ([]docker.APIContainers, error) {
 	util.Log.Debugln("Obteniendo el listado de contenedores")
 
-	containers, err := dh.client.ListContainers(docker.ListContainersOptions{Filters: map[string][]string{"status": filter.Status}})
+	filters := map[string][]string{}
+	if len(filter.Status) > 0 {
+		filters["status"] = filter.Status
+	}
+	if len(filter.Labels) > 0 {
+		filters["label"] = filter.Labels
+	}
+
+	containers, err := dh.client.ListContainers(docker.ListContainersOptions{All: len(filter.Status) == 0, Filters: filters})
 
 	if err != nil {
 		return nil, err
@@ -130,16 +162,79 @@ func (dh *DockerHelper) ListContainers(filter *containerFilter) ([]docker.APICon
 	return filteredContainers, nil
 }
 
+// ListTaggedContainers entrega los contenedores con los labels image_name e image_tag entregados.
+// Docker no soporta filtrar por dos labels a la vez, por lo que el tag se filtra en el cliente.
+// Un tag vacio no filtra por tag
 func (dh *DockerHelper) ListTaggedContainers(image string, tag string) ([]docker.APIContainers, error) {
-	filter := map[string][]string{"label": []string{"image_name=" + image}} // no funciona con 2 tags
-	util.Log.Debugf("Obteniendo el listado de contenedores con filtro %#v", filter)
+	filter := map[string][]string{"label": []string{"image_name=" + image}}
+	util.Log.Debugf("Obteniendo el listado de contenedores con filtro %#v y tag %s", filter, tag)
 	containers, err := dh.client.ListContainers(docker.ListContainersOptions{All: true, Filters: filter})
 
 	if err != nil {
 		return nil, err
 	}
 
-	return containers, nil
+	var tagged []docker.APIContainers
+	for _, container := range containers {
+		if tag == "" || container.Labels["image_tag"] == tag {
+			tagged = append(tagged, container)
+		}
+	}
+
+	return tagged, nil
+}
+
+// ListManagedContainers entrega los contenedores creados por yale en cualquier estado. Si se
+// entrega el nombre de una imagen solo se consideran los contenedores de esa imagen
+func (dh *DockerHelper) ListManagedContainers(image string) ([]docker.APIContainers, error) {
+	label := "image_name"
+	if image != "" {
+		label += "=" + image
+	}
+
+	filter := map[string][]string{"label": []string{label}}
+	util.Log.Debugf("Obteniendo el listado de contenedores con filtro %#v", filter)
+	return dh.client.ListContainers(docker.ListContainersOptions{All: true, Filters: filter})
+}
+
+func (dh *DockerHelper) ListImages() ([]docker.APIImages, error) {
+	util.Log.Debugln("Obteniendo el listado de imagenes")
+	return dh.client.ListImages(docker.ListImagesOptions{})
+}
+
+// ListDanglingImages entrega las imagenes que no tienen tag
+func (dh *DockerHelper) ListDanglingImages() ([]docker.APIImages, error) {
+	util.Log.Debugln("Obteniendo el listado de imagenes sin tag")
+	filter := map[string][]string{"dangling": []string{"true"}}
+	return dh.client.ListImages(docker.ListImagesOptions{Filters: filter})
+}
+
+// RemoveImage remueve la imagen por nombre o id. Si la imagen no existe no se considera un error
+func (dh *DockerHelper) RemoveImage(image string) error {
+	util.Log.Infoln("Removiendo la imagen", image)
+	err := dh.client.RemoveImage(image)
+	if err == docker.ErrNoSuchImage {
+		util.Log.Infoln("No se encontró la imagen", image)
+		return nil
+	}
+
+	if e, ok := err.(*docker.Error); ok && e.Status == http.StatusConflict {
+		return ErrImageInUse
+	}
+
+	return err
+}
+
+// RemoveContainer remueve un contenedor detenido
+func (dh *DockerHelper) RemoveContainer(containerId string) error {
+	util.Log.Infoln("Removiendo el contenedor", containerId)
+	err := dh.client.RemoveContainer(docker.RemoveContainerOptions{ID: containerId})
+	if _, ok := err.(*docker.NoSuchContainer); ok {
+		util.Log.Infoln("No se encontró el contenedor", containerId)
+		return nil
+	}
+
+	return err
 }
 
 func (dh *DockerHelper) PullImage(imageName string) error {
@@ -166,19 +261,34 @@ func (dh *DockerHelper) PullImage(imageName string) error {
 	return nil
 }
 
-func (dh *DockerHelper) CreateAndRun(containerOpts docker.CreateContainerOptions) (*docker.Container, error) {
+// CreateAndRun descarga la imagen, crea el contenedor y lo arranca. El contexto solo se
+// verifica antes de descargar la imagen y de crear el contenedor, de manera que un
+// contenedor creado siempre termina arrancado e inspeccionado. Las llamadas a Docker
+// se registran en la traza del contexto.
+func (dh *DockerHelper) CreateAndRun(ctx context.Context, containerOpts docker.CreateContainerOptions) (*docker.Container, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	dh = dh.WithContext(ctx)
 
 	err := dh.PullImage(containerOpts.Config.Image)
 	if err != nil {
 		return nil, err
 	}
 
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
 	util.Log.Infoln("Creando el contenedor con imagen", containerOpts.Config.Image)
 	container, err := dh.client.CreateContainer(containerOpts)
 	if err != nil {
 		return nil, err
 	}
 
+	// el contenedor creado se arranca e inspecciona aunque se cancele el contexto
+	dh = dh.WithContext(context.WithoutCancel(ctx))
+
 	util.Log.Infoln("Contenedor creado... Se inicia el proceso de arranque", container.ID)
 	err = dh.client.StartContainer(container.ID, nil)
 	if err != nil {
@@ -231,6 +341,22 @@ func (dh *DockerHelper) ContainerAddress(containerId string, internalPort int64)
 	return "", errors.New("No se encontró el puerto interno del contenedor")
 }
 
+// RestartContainer reinicia el contenedor y entrega sus datos actualizados
+func (dh *DockerHelper) RestartContainer(containerId string, timeout uint) (*docker.Container, error) {
+	util.Log.Infoln("Reiniciando el contenedor", containerId)
+
+	// Un valor de 0 sera interpretado como por defecto
+	if timeout == 0 {
+		timeout = 10
+	}
+
+	if err := dh.client.RestartContainer(containerId, timeout); err != nil {
+		return nil, err
+	}
+
+	return dh.ContainerInspect(containerId)
+}
+
 func (dh *DockerHelper) UndeployContainer(containerId string, remove bool, timeout uint) error {
 
 	util.Log.Infoln("Se está iniciando el proceso de undeploy del contenedor", containerId)
@@ -0,0 +1,47 @@
+package helper_test
+
+import (
+	"testing"
+
+	"github.com/ch3lo/yale/helper"
+	"github.com/fsouza/go-dockerclient"
+)
+
+func TestContainerHealth(t *testing.T) {
+	cases := []struct {
+		status string
+		health string
+	}{
+		{"Up 5 minutes (healthy)", "healthy"},
+		{"Up 2 seconds (health: starting)", "starting"},
+		{"Up About an hour (unhealthy)", "unhealthy"},
+		{"Up 5 minutes", ""},
+		{"Exited (1) 3 minutes ago", ""},
+		{"", ""},
+	}
+
+	for _, c := range cases {
+		if health := helper.ContainerHealth(docker.APIContainers{Status: c.status}); health != c.health {
+			t.Errorf("El estado %q entregó el healthcheck %q, se esperaba %q", c.status, health, c.health)
+		}
+	}
+}
+
+func TestContainerDead(t *testing.T) {
+	cases := []struct {
+		status string
+		dead   bool
+	}{
+		{"Dead", true},
+		{"Up 5 minutes", false},
+		{"Exited (137) 3 minutes ago", false},
+		{"Created", false},
+		{"", false},
+	}
+
+	for _, c := range cases {
+		if dead := helper.ContainerDead(docker.APIContainers{Status: c.status}); dead != c.dead {
+			t.Errorf("El estado %q entregó dead %t, se esperaba %t", c.status, dead, c.dead)
+		}
+	}
+}
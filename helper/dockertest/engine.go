@@ -0,0 +1,765 @@
+// Package dockertest provee un motor de Docker en memoria que implementa helper.DockerClient,
+// de manera de probar el despliegue sin un daemon de Docker
+package dockertest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ch3lo/yale/helper"
+	"github.com/fsouza/go-dockerclient"
+)
+
+// ExecHandler entrega la salida y el codigo de salida de un comando ejecutado en un contenedor
+type ExecHandler func(container *docker.Container, cmd []string) (stdout string, stderr string, exitCode int)
+
+type fakeExec struct {
+	containerId string
+	cmd         []string
+	exitCode    int
+}
+
+// Engine es un motor de Docker en memoria. Los contenedores no ejecutan procesos, solo cambian
+// de estado y notifican los mismos eventos que el daemon. Se pueden simular fallas al descargar
+// o arrancar imagenes, caidas de contenedores y puertos publicados en direcciones reales
+type Engine struct {
+	mutex         sync.Mutex
+	node          string
+	sequence      int
+	nextPort      int64
+	containers    map[string]*docker.Container
+	images        map[string]string
+	pullFailures  map[string]int
+	startFailures map[string]int
+	callFailures  map[string]int
+	exposed       []int64
+	ports         map[int64]docker.PortBinding
+	logs          map[string][]string
+	execs         map[string]*fakeExec
+	listeners     []chan<- *docker.APIEvents
+	execHandler   ExecHandler
+}
+
+var _ helper.DockerClient = (*Engine)(nil)
+
+// NewEngine crea un motor vacio cuyos contenedores se asignan al nodo entregado. Por defecto
+// los contenedores exponen el puerto 8080
+func NewEngine(node string) *Engine {
+	return &Engine{
+		node:          node,
+		nextPort:      32768,
+		containers:    make(map[string]*docker.Container),
+		images:        make(map[string]string),
+		pullFailures:  make(map[string]int),
+		startFailures: make(map[string]int),
+		callFailures:  make(map[string]int),
+		exposed:       []int64{8080},
+		ports:         make(map[int64]docker.PortBinding),
+		logs:          make(map[string][]string),
+		execs:         make(map[string]*fakeExec),
+	}
+}
+
+func (e *Engine) newId() string {
+	e.sequence++
+	sum := sha256.Sum256([]byte(e.node + "/" + strconv.Itoa(e.sequence)))
+	return hex.EncodeToString(sum[:])
+}
+
+func imageRef(image string) string {
+	if k := strings.LastIndex(image, ":"); k < 0 || strings.Contains(image[k:], "/") {
+		return image + ":latest"
+	}
+
+	return image
+}
+
+// emit notifica el evento a los listeners. Se asume que el mutex esta tomado. Un listener
+// lleno pierde el evento, igual que un cliente lento del daemon
+func (e *Engine) emit(status string, id string, from string) {
+	event := &docker.APIEvents{Status: status, ID: id, From: from, Time: time.Now().Unix()}
+	for _, listener := range e.listeners {
+		select {
+		case listener <- event:
+		default:
+		}
+	}
+}
+
+// AddImage registra una imagen como ya descargada
+func (e *Engine) AddImage(image string) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if _, ok := e.images[imageRef(image)]; !ok {
+		e.images[imageRef(image)] = e.newId()
+	}
+}
+
+// FailPull hace fallar las siguientes descargas de la imagen. Un valor negativo falla siempre
+func (e *Engine) FailPull(image string, times int) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	e.pullFailures[imageRef(image)] = times
+}
+
+// FailStart hace fallar el arranque de los siguientes contenedores de la imagen. Un valor
+// negativo falla siempre
+func (e *Engine) FailStart(image string, times int) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	e.startFailures[imageRef(image)] = times
+}
+
+// FailCall hace fallar las siguientes llamadas al metodo con un 503, como un daemon sobrecargado.
+// Aplica a ListContainers, InspectContainer y ListImages. Un valor negativo falla siempre
+func (e *Engine) FailCall(method string, times int) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	e.callFailures[method] = times
+}
+
+// unavailable consume una falla programada del metodo. Se llama con el mutex tomado
+func (e *Engine) unavailable(method string) error {
+	if remaining := e.callFailures[method]; remaining != 0 {
+		e.callFailures[method] = remaining - 1
+		return &docker.Error{Status: http.StatusServiceUnavailable, Message: "Service Unavailable"}
+	}
+
+	return nil
+}
+
+// Expose define los puertos privados que exponen los contenedores al publicar todos los puertos
+func (e *Engine) Expose(ports ...int64) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	e.exposed = ports
+}
+
+// MapPort publica el puerto privado de todos los contenedores en la direccion entregada, por
+// ejemplo la de un httptest.Server que responde los smoke tests
+func (e *Engine) MapPort(private int64, ip string, public int64) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	e.ports[private] = docker.PortBinding{HostIP: ip, HostPort: strconv.FormatInt(public, 10)}
+}
+
+// HandleExec define la respuesta de los comandos ejecutados en los contenedores
+func (e *Engine) HandleExec(handler ExecHandler) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	e.execHandler = handler
+}
+
+// Log agrega una linea a los logs del contenedor
+func (e *Engine) Log(id string, line string) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	e.logs[id] = append(e.logs[id], line)
+}
+
+// Run crea y arranca un contenedor de la imagen, descargandola si no existe
+func (e *Engine) Run(image string, labels map[string]string) (*docker.Container, error) {
+	e.AddImage(image)
+
+	container, err := e.CreateContainer(docker.CreateContainerOptions{
+		Config:     &docker.Config{Image: image, Labels: labels},
+		HostConfig: &docker.HostConfig{PublishAllPorts: true},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := e.StartContainer(container.ID, nil); err != nil {
+		return nil, err
+	}
+
+	return e.InspectContainer(container.ID)
+}
+
+func (e *Engine) stop(container *docker.Container, exitCode int) {
+	container.State.Running = false
+	container.State.Paused = false
+	container.State.Restarting = false
+	container.State.Pid = 0
+	container.State.ExitCode = exitCode
+	container.State.FinishedAt = time.Now()
+	container.NetworkSettings.Ports = nil
+}
+
+// Crash detiene el contenedor con el codigo de salida entregado y notifica el evento die
+func (e *Engine) Crash(id string, exitCode int) error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	container, ok := e.containers[id]
+	if !ok {
+		return &docker.NoSuchContainer{ID: id}
+	}
+
+	if !container.State.Running {
+		return &docker.ContainerNotRunning{ID: id}
+	}
+
+	e.stop(container, exitCode)
+	e.emit("die", id, container.Config.Image)
+
+	return nil
+}
+
+// CrashImage detiene todos los contenedores en ejecucion de la imagen y entrega cuantos se detuvieron
+func (e *Engine) CrashImage(image string, exitCode int) int {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	crashed := 0
+	for id, container := range e.containers {
+		if container.State.Running && imageRef(container.Config.Image) == imageRef(image) {
+			e.stop(container, exitCode)
+			e.emit("die", id, container.Config.Image)
+			crashed++
+		}
+	}
+
+	return crashed
+}
+
+// containersByCreation ordena los contenedores desde el mas antiguo
+type containersByCreation []*docker.Container
+
+func (c containersByCreation) Len() int           { return len(c) }
+func (c containersByCreation) Swap(i, j int)      { c[i], c[j] = c[j], c[i] }
+func (c containersByCreation) Less(i, j int) bool { return c[i].Created.Before(c[j].Created) }
+
+func (e *Engine) sortedContainers() []*docker.Container {
+	var containers []*docker.Container
+	for _, container := range e.containers {
+		containers = append(containers, container)
+	}
+	sort.Sort(containersByCreation(containers))
+
+	return containers
+}
+
+// Containers entrega una copia de todos los contenedores del motor ordenados por creacion
+func (e *Engine) Containers() []*docker.Container {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	var containers []*docker.Container
+	for _, container := range e.sortedContainers() {
+		containers = append(containers, copyContainer(container))
+	}
+
+	return containers
+}
+
+func copyContainer(container *docker.Container) *docker.Container {
+	c := *container
+	if container.Config != nil {
+		config := *container.Config
+		c.Config = &config
+	}
+	if container.HostConfig != nil {
+		hostConfig := *container.HostConfig
+		c.HostConfig = &hostConfig
+	}
+	if container.NetworkSettings != nil {
+		settings := *container.NetworkSettings
+		c.NetworkSettings = &settings
+	}
+
+	return &c
+}
+
+// containerStatus entrega el estado con los nombres de los filtros de Docker
+func containerStatus(container *docker.Container) string {
+	switch {
+	case container.State.Restarting:
+		return "restarting"
+	case container.State.Paused:
+		return "paused"
+	case container.State.Running:
+		return "running"
+	case container.State.StartedAt.IsZero():
+		return "created"
+	}
+
+	return "exited"
+}
+
+func apiStatus(container *docker.Container) string {
+	switch containerStatus(container) {
+	case "running":
+		return "Up " + time.Since(container.State.StartedAt).String()
+	case "paused":
+		return "Up " + time.Since(container.State.StartedAt).String() + " (Paused)"
+	case "restarting":
+		return "Restarting"
+	case "created":
+		return "Created"
+	}
+
+	return fmt.Sprintf("Exited (%d) %s ago", container.State.ExitCode, time.Since(container.State.FinishedAt))
+}
+
+func matchesLabels(labels map[string]string, filters []string) bool {
+	for _, filter := range filters {
+		key, value := filter, ""
+		hasValue := false
+		if k := strings.Index(filter, "="); k >= 0 {
+			key, value, hasValue = filter[:k], filter[k+1:], true
+		}
+
+		current, ok := labels[key]
+		if !ok || (hasValue && current != value) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func matchesStatus(status string, filters []string) bool {
+	for _, filter := range filters {
+		if filter == status {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ListContainers soporta los filtros status y label. Sin filtro de estado y sin All solo se
+// entregan los contenedores en ejecucion
+func (e *Engine) ListContainers(opts docker.ListContainersOptions) ([]docker.APIContainers, error) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if err := e.unavailable("ListContainers"); err != nil {
+		return nil, err
+	}
+
+	var containers []docker.APIContainers
+	for _, container := range e.sortedContainers() {
+		status := containerStatus(container)
+		if filters, ok := opts.Filters["status"]; ok {
+			if !matchesStatus(status, filters) {
+				continue
+			}
+		} else if !opts.All && status != "running" {
+			continue
+		}
+
+		if !matchesLabels(container.Config.Labels, opts.Filters["label"]) {
+			continue
+		}
+
+		containers = append(containers, docker.APIContainers{
+			ID:      container.ID,
+			Image:   container.Config.Image,
+			Created: container.Created.Unix(),
+			Status:  apiStatus(container),
+			Ports:   container.NetworkSettings.PortMappingAPI(),
+			Names:   []string{container.Name},
+			Labels:  container.Config.Labels,
+		})
+	}
+
+	return containers, nil
+}
+
+func (e *Engine) InspectContainer(id string) (*docker.Container, error) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if err := e.unavailable("InspectContainer"); err != nil {
+		return nil, err
+	}
+
+	container, ok := e.containers[id]
+	if !ok {
+		return nil, &docker.NoSuchContainer{ID: id}
+	}
+
+	return copyContainer(container), nil
+}
+
+func (e *Engine) CreateContainer(opts docker.CreateContainerOptions) (*docker.Container, error) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if opts.Config == nil || opts.Config.Image == "" {
+		return nil, &docker.Error{Status: http.StatusInternalServerError, Message: "No image was specified"}
+	}
+
+	if _, ok := e.images[imageRef(opts.Config.Image)]; !ok {
+		return nil, docker.ErrNoSuchImage
+	}
+
+	id := e.newId()
+	name := opts.Name
+	if name == "" {
+		name = "container_" + strconv.Itoa(e.sequence)
+	}
+
+	config := *opts.Config
+	if config.Hostname == "" {
+		config.Hostname = id[:12]
+	}
+
+	hostConfig := docker.HostConfig{}
+	if opts.HostConfig != nil {
+		hostConfig = *opts.HostConfig
+	}
+
+	container := &docker.Container{
+		ID:              id,
+		Name:            "/" + strings.TrimPrefix(name, "/"),
+		Created:         time.Now(),
+		Image:           e.images[imageRef(config.Image)],
+		Config:          &config,
+		HostConfig:      &hostConfig,
+		NetworkSettings: &docker.NetworkSettings{IPAddress: "172.17.0." + strconv.Itoa(1+e.sequence%254)},
+		Node:            &docker.SwarmNode{Name: e.node, IP: "127.0.0.1", Addr: "127.0.0.1:2375"},
+	}
+	e.containers[id] = container
+	e.emit("create", id, config.Image)
+
+	return copyContainer(container), nil
+}
+
+// publish asigna los puertos publicados del contenedor. Los puertos mapeados con MapPort
+// utilizan la direccion configurada y el resto un puerto consecutivo del host
+func (e *Engine) publish(container *docker.Container) {
+	ports := make(map[docker.Port][]docker.PortBinding)
+	if !container.HostConfig.PublishAllPorts {
+		container.NetworkSettings.Ports = ports
+		return
+	}
+
+	for _, private := range e.exposed {
+		binding, ok := e.ports[private]
+		if !ok {
+			binding = docker.PortBinding{HostIP: "0.0.0.0", HostPort: strconv.FormatInt(e.nextPort, 10)}
+			e.nextPort++
+		}
+		ports[docker.Port(strconv.FormatInt(private, 10)+"/tcp")] = []docker.PortBinding{binding}
+	}
+	container.NetworkSettings.Ports = ports
+}
+
+func (e *Engine) StartContainer(id string, hostConfig *docker.HostConfig) error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	container, ok := e.containers[id]
+	if !ok {
+		return &docker.NoSuchContainer{ID: id}
+	}
+
+	if container.State.Running {
+		return &docker.ContainerAlreadyRunning{ID: id}
+	}
+
+	ref := imageRef(container.Config.Image)
+	if remaining := e.startFailures[ref]; remaining != 0 {
+		e.startFailures[ref] = remaining - 1
+		return &docker.Error{Status: http.StatusInternalServerError, Message: "Cannot start container " + id}
+	}
+
+	if hostConfig != nil {
+		container.HostConfig = hostConfig
+	}
+
+	container.State = docker.State{Running: true, Pid: 1000 + e.sequence, StartedAt: time.Now()}
+	e.publish(container)
+	e.emit("start", id, container.Config.Image)
+
+	return nil
+}
+
+func (e *Engine) StopContainer(id string, timeout uint) error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	container, ok := e.containers[id]
+	if !ok {
+		return &docker.NoSuchContainer{ID: id}
+	}
+
+	if !container.State.Running {
+		return &docker.ContainerNotRunning{ID: id}
+	}
+
+	e.stop(container, 143)
+	e.emit("die", id, container.Config.Image)
+	e.emit("stop", id, container.Config.Image)
+
+	return nil
+}
+
+func (e *Engine) RestartContainer(id string, timeout uint) error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	container, ok := e.containers[id]
+	if !ok {
+		return &docker.NoSuchContainer{ID: id}
+	}
+
+	if container.State.Running {
+		e.stop(container, 143)
+		e.emit("die", id, container.Config.Image)
+	}
+
+	container.RestartCount++
+	container.State = docker.State{Running: true, Pid: 1000 + e.sequence, StartedAt: time.Now()}
+	e.publish(container)
+	e.emit("start", id, container.Config.Image)
+	e.emit("restart", id, container.Config.Image)
+
+	return nil
+}
+
+func (e *Engine) RemoveContainer(opts docker.RemoveContainerOptions) error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	container, ok := e.containers[opts.ID]
+	if !ok {
+		return &docker.NoSuchContainer{ID: opts.ID}
+	}
+
+	if container.State.Running && !opts.Force {
+		return &docker.Error{Status: http.StatusConflict, Message: "You cannot remove a running container " + opts.ID}
+	}
+
+	delete(e.containers, opts.ID)
+	delete(e.logs, opts.ID)
+	e.emit("destroy", opts.ID, container.Config.Image)
+
+	return nil
+}
+
+func (e *Engine) Logs(opts docker.LogsOptions) error {
+	e.mutex.Lock()
+	_, ok := e.containers[opts.Container]
+	lines := append([]string{}, e.logs[opts.Container]...)
+	e.mutex.Unlock()
+
+	if !ok {
+		return &docker.NoSuchContainer{ID: opts.Container}
+	}
+
+	if opts.Tail != "" && opts.Tail != "all" {
+		if tail, err := strconv.Atoi(opts.Tail); err == nil && tail < len(lines) {
+			lines = lines[len(lines)-tail:]
+		}
+	}
+
+	if !opts.Stdout || opts.OutputStream == nil {
+		return nil
+	}
+
+	for _, line := range lines {
+		if _, err := io.WriteString(opts.OutputStream, line+"\n"); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (e *Engine) ListImages(opts docker.ListImagesOptions) ([]docker.APIImages, error) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if err := e.unavailable("ListImages"); err != nil {
+		return nil, err
+	}
+
+	// las imagenes del motor siempre tienen tag, por lo que no existen imagenes dangling
+	if dangling, ok := opts.Filters["dangling"]; ok && len(dangling) > 0 && dangling[0] == "true" {
+		return []docker.APIImages{}, nil
+	}
+
+	var refs []string
+	for ref := range e.images {
+		refs = append(refs, ref)
+	}
+	sort.Strings(refs)
+
+	var images []docker.APIImages
+	for _, ref := range refs {
+		images = append(images, docker.APIImages{ID: e.images[ref], RepoTags: []string{ref}})
+	}
+
+	return images, nil
+}
+
+func (e *Engine) PullImage(opts docker.PullImageOptions, auth docker.AuthConfiguration) error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	image := opts.Repository
+	if opts.Tag != "" {
+		image += ":" + opts.Tag
+	}
+	ref := imageRef(image)
+
+	if remaining := e.pullFailures[ref]; remaining != 0 {
+		e.pullFailures[ref] = remaining - 1
+		return &docker.Error{Status: http.StatusNotFound, Message: "Error: image " + ref + " not found"}
+	}
+
+	if _, ok := e.images[ref]; !ok {
+		e.images[ref] = e.newId()
+	}
+
+	if opts.OutputStream != nil {
+		fmt.Fprintf(opts.OutputStream, "Status: Downloaded newer image for %s\n", ref)
+	}
+	e.emit("pull", ref, "")
+
+	return nil
+}
+
+func (e *Engine) RemoveImage(name string) error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	ref := imageRef(name)
+	id, ok := e.images[ref]
+	if !ok {
+		for r, imageId := range e.images {
+			if imageId == name {
+				ref, id, ok = r, imageId, true
+			}
+		}
+	}
+
+	if !ok {
+		return docker.ErrNoSuchImage
+	}
+
+	for _, container := range e.containers {
+		if container.Image == id {
+			return &docker.Error{Status: http.StatusConflict, Message: "conflict: unable to remove repository reference " + name}
+		}
+	}
+
+	delete(e.images, ref)
+	e.emit("untag", id, "")
+	e.emit("delete", id, "")
+
+	return nil
+}
+
+func (e *Engine) CreateExec(opts docker.CreateExecOptions) (*docker.Exec, error) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	container, ok := e.containers[opts.Container]
+	if !ok {
+		return nil, &docker.NoSuchContainer{ID: opts.Container}
+	}
+
+	if !container.State.Running {
+		return nil, &docker.Error{Status: http.StatusConflict, Message: "Container " + opts.Container + " is not running"}
+	}
+
+	id := e.newId()
+	e.execs[id] = &fakeExec{containerId: opts.Container, cmd: opts.Cmd}
+
+	return &docker.Exec{ID: id}, nil
+}
+
+func (e *Engine) StartExec(id string, opts docker.StartExecOptions) error {
+	e.mutex.Lock()
+	exec, ok := e.execs[id]
+	var container *docker.Container
+	if ok {
+		if c, exists := e.containers[exec.containerId]; exists {
+			container = copyContainer(c)
+		}
+	}
+	handler := e.execHandler
+	e.mutex.Unlock()
+
+	if !ok {
+		return errors.New("No such exec instance " + id)
+	}
+
+	if container == nil {
+		return &docker.NoSuchContainer{ID: exec.containerId}
+	}
+
+	stdout, stderr, exitCode := "", "", 0
+	if handler != nil {
+		stdout, stderr, exitCode = handler(container, exec.cmd)
+	}
+
+	if opts.OutputStream != nil {
+		io.WriteString(opts.OutputStream, stdout)
+	}
+	if opts.ErrorStream != nil {
+		io.WriteString(opts.ErrorStream, stderr)
+	}
+
+	e.mutex.Lock()
+	exec.exitCode = exitCode
+	e.mutex.Unlock()
+
+	return nil
+}
+
+func (e *Engine) InspectExec(id string) (*docker.ExecInspect, error) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	exec, ok := e.execs[id]
+	if !ok {
+		return nil, errors.New("No such exec instance " + id)
+	}
+
+	return &docker.ExecInspect{ID: id, ExitCode: exec.exitCode}, nil
+}
+
+func (e *Engine) AddEventListener(listener chan<- *docker.APIEvents) error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	e.listeners = append(e.listeners, listener)
+	return nil
+}
+
+func (e *Engine) RemoveEventListener(listener chan *docker.APIEvents) error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	for k, l := range e.listeners {
+		if l == listener {
+			e.listeners = append(e.listeners[:k], e.listeners[k+1:]...)
+			break
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,341 @@
+package dockertest
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+// apiVersion es la version del API de Docker que informa el servidor
+const apiVersion = "1.21"
+
+var versionPrefix = regexp.MustCompile(`^/v[0-9.]+/`)
+
+// Server expone un Engine con el subconjunto del API HTTP de Docker que utiliza yale, de manera
+// de ejecutar el binario contra endpoints locales. Implementa version, auth, events,
+// containers/json, create, start, stop, restart, inspect y delete, images/json, create y delete
+type Server struct {
+	Engine *Engine
+
+	server    *httptest.Server
+	closed    chan struct{}
+	closeOnce sync.Once
+	mutex     sync.Mutex
+	username  string
+	password  string
+}
+
+// NewServer levanta un servidor en un puerto local libre sobre el motor entregado
+func NewServer(engine *Engine) *Server {
+	s := &Server{
+		Engine: engine,
+		closed: make(chan struct{}),
+	}
+	s.server = httptest.NewServer(http.HandlerFunc(s.route))
+
+	return s
+}
+
+// URL entrega la direccion del servidor con el formato de DOCKER_HOST
+func (s *Server) URL() string {
+	return "tcp://" + s.server.Listener.Addr().String()
+}
+
+// Close termina los streams de eventos abiertos y detiene el servidor
+func (s *Server) Close() {
+	s.closeOnce.Do(func() { close(s.closed) })
+	s.server.Close()
+}
+
+// RequireAuth exige las credenciales entregadas al autenticar y al descargar imagenes
+func (s *Server) RequireAuth(username string, password string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.username = username
+	s.password = password
+}
+
+func (s *Server) authorized(auth docker.AuthConfiguration) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.username == "" || (auth.Username == s.username && auth.Password == s.password)
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
+
+// writeError traduce los errores del motor a las respuestas del daemon
+func writeError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	switch e := err.(type) {
+	case *docker.NoSuchContainer:
+		status = http.StatusNotFound
+	case *docker.ContainerAlreadyRunning, *docker.ContainerNotRunning:
+		w.WriteHeader(http.StatusNotModified)
+		return
+	case *docker.Error:
+		status = e.Status
+	default:
+		if err == docker.ErrNoSuchImage {
+			status = http.StatusNotFound
+		}
+	}
+
+	http.Error(w, err.Error(), status)
+}
+
+func (s *Server) route(w http.ResponseWriter, r *http.Request) {
+	path := versionPrefix.ReplaceAllString(r.URL.Path, "/")
+
+	switch {
+	case path == "/_ping":
+		w.Write([]byte("OK"))
+	case path == "/version":
+		writeJSON(w, http.StatusOK, map[string]string{"ApiVersion": apiVersion, "Version": "1.9.1-dockertest"})
+	case path == "/auth" && r.Method == "POST":
+		s.auth(w, r)
+	case path == "/events":
+		s.events(w, r)
+	case path == "/containers/json":
+		s.listContainers(w, r)
+	case path == "/containers/create" && r.Method == "POST":
+		s.createContainer(w, r)
+	case strings.HasPrefix(path, "/containers/"):
+		s.container(w, r, strings.TrimPrefix(path, "/containers/"))
+	case path == "/images/json":
+		s.listImages(w, r)
+	case path == "/images/create" && r.Method == "POST":
+		s.pullImage(w, r)
+	case strings.HasPrefix(path, "/images/") && r.Method == "DELETE":
+		s.removeImage(w, strings.TrimPrefix(path, "/images/"))
+	default:
+		http.Error(w, "page not found", http.StatusNotFound)
+	}
+}
+
+func (s *Server) auth(w http.ResponseWriter, r *http.Request) {
+	var auth docker.AuthConfiguration
+	if err := json.NewDecoder(r.Body).Decode(&auth); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if !s.authorized(auth) {
+		http.Error(w, "Wrong login/password, please try again", http.StatusUnauthorized)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{"Status": "Login Succeeded"})
+}
+
+// events transmite los eventos del motor hasta que el cliente se desconecta o se cierra el servidor
+func (s *Server) events(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	listener := make(chan *docker.APIEvents, 100)
+	s.Engine.AddEventListener(listener)
+	defer s.Engine.RemoveEventListener(listener)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	encoder := json.NewEncoder(w)
+	for {
+		select {
+		case <-s.closed:
+			return
+		case <-r.Context().Done():
+			return
+		case event := <-listener:
+			if err := encoder.Encode(event); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+func queryFilters(r *http.Request) (map[string][]string, error) {
+	filters := map[string][]string{}
+	if raw := r.URL.Query().Get("filters"); raw != "" {
+		if err := json.Unmarshal([]byte(raw), &filters); err != nil {
+			return nil, err
+		}
+	}
+
+	return filters, nil
+}
+
+func queryBool(r *http.Request, key string) bool {
+	value := r.URL.Query().Get(key)
+	return value == "1" || value == "true"
+}
+
+func (s *Server) listContainers(w http.ResponseWriter, r *http.Request) {
+	filters, err := queryFilters(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	containers, err := s.Engine.ListContainers(docker.ListContainersOptions{All: queryBool(r, "all"), Filters: filters})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if containers == nil {
+		containers = []docker.APIContainers{}
+	}
+	writeJSON(w, http.StatusOK, containers)
+}
+
+func (s *Server) createContainer(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		*docker.Config
+		HostConfig *docker.HostConfig
+	}
+	body.Config = new(docker.Config)
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	container, err := s.Engine.CreateContainer(docker.CreateContainerOptions{
+		Name:       r.URL.Query().Get("name"),
+		Config:     body.Config,
+		HostConfig: body.HostConfig,
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, map[string]interface{}{"Id": container.ID, "Warnings": nil})
+}
+
+func queryTimeout(r *http.Request) uint {
+	timeout, _ := strconv.ParseUint(r.URL.Query().Get("t"), 10, 32)
+	return uint(timeout)
+}
+
+// container atiende las operaciones sobre un contenedor, con la ruta {id}/accion o {id}
+func (s *Server) container(w http.ResponseWriter, r *http.Request, path string) {
+	parts := strings.SplitN(path, "/", 2)
+	id, action := parts[0], ""
+	if len(parts) == 2 {
+		action = parts[1]
+	}
+
+	var err error
+	switch {
+	case action == "json" && r.Method == "GET":
+		var container *docker.Container
+		if container, err = s.Engine.InspectContainer(id); err == nil {
+			writeJSON(w, http.StatusOK, container)
+			return
+		}
+	case action == "start" && r.Method == "POST":
+		var hostConfig *docker.HostConfig
+		if data, _ := ioutil.ReadAll(r.Body); len(data) > 0 {
+			json.Unmarshal(data, &hostConfig)
+		}
+		err = s.Engine.StartContainer(id, hostConfig)
+	case action == "stop" && r.Method == "POST":
+		err = s.Engine.StopContainer(id, queryTimeout(r))
+	case action == "restart" && r.Method == "POST":
+		err = s.Engine.RestartContainer(id, queryTimeout(r))
+	case action == "" && r.Method == "DELETE":
+		err = s.Engine.RemoveContainer(docker.RemoveContainerOptions{ID: id, Force: queryBool(r, "force")})
+	default:
+		http.Error(w, "page not found", http.StatusNotFound)
+		return
+	}
+
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) listImages(w http.ResponseWriter, r *http.Request) {
+	filters, err := queryFilters(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	images, err := s.Engine.ListImages(docker.ListImagesOptions{Filters: filters})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if images == nil {
+		images = []docker.APIImages{}
+	}
+	writeJSON(w, http.StatusOK, images)
+}
+
+// pullImage responde con el stream de mensajes JSON del daemon. Los errores de la descarga
+// se informan en el stream, igual que en Docker
+func (s *Server) pullImage(w http.ResponseWriter, r *http.Request) {
+	var auth docker.AuthConfiguration
+	if header := r.Header.Get("X-Registry-Auth"); header != "" {
+		if data, err := base64.URLEncoding.DecodeString(header); err == nil {
+			json.Unmarshal(data, &auth)
+		}
+	}
+
+	image := r.URL.Query().Get("fromImage")
+	if tag := r.URL.Query().Get("tag"); tag != "" {
+		image += ":" + tag
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	encoder := json.NewEncoder(w)
+	encoder.Encode(map[string]string{"status": "Pulling repository " + image})
+
+	if !s.authorized(auth) {
+		message := "unauthorized: authentication required"
+		encoder.Encode(map[string]interface{}{"errorDetail": map[string]string{"message": message}, "error": message})
+		return
+	}
+
+	if err := s.Engine.PullImage(docker.PullImageOptions{Repository: image}, auth); err != nil {
+		encoder.Encode(map[string]interface{}{"errorDetail": map[string]string{"message": err.Error()}, "error": err.Error()})
+		return
+	}
+
+	encoder.Encode(map[string]string{"status": "Status: Downloaded newer image for " + imageRef(image)})
+}
+
+func (s *Server) removeImage(w http.ResponseWriter, name string) {
+	if err := s.Engine.RemoveImage(name); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, []map[string]string{{"Untagged": name}})
+}
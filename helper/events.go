@@ -0,0 +1,229 @@
+package helper
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/ch3lo/yale/util"
+	"github.com/fsouza/go-dockerclient"
+)
+
+// ContainerEvent es un evento de Docker de un contenedor administrado por yale
+type ContainerEvent struct {
+	ID     string
+	Status string
+	Image  string
+	Name   string
+	Labels map[string]string
+	Time   time.Time
+}
+
+const (
+	// eventsRetryInitialWait es la espera antes del primer intento de volver a suscribirse a los eventos
+	eventsRetryInitialWait = time.Second
+	// eventsRetryMaxWait es la espera maxima entre intentos de suscripcion a los eventos
+	eventsRetryMaxWait = time.Minute
+)
+
+func isDeathStatus(status string) bool {
+	return status == "die" || status == "oom"
+}
+
+// IsDeath indica si el evento corresponde a la caida del contenedor
+func (e ContainerEvent) IsDeath() bool {
+	return isDeathStatus(e.Status)
+}
+
+// Events entrega los eventos del daemon de Docker hasta que se cancela el contexto
+func (dh *DockerHelper) Events(ctx context.Context) (<-chan *docker.APIEvents, error) {
+	listener := make(chan *docker.APIEvents, 100)
+	if err := dh.client.AddEventListener(listener); err != nil {
+		return nil, err
+	}
+
+	go func() {
+		<-ctx.Done()
+		if err := dh.client.RemoveEventListener(listener); err != nil {
+			util.Log.Warnln("No se pudo remover el listener de eventos", err)
+		}
+	}()
+
+	return listener, nil
+}
+
+// containerMeta son los datos de un contenedor necesarios para describir sus eventos
+type containerMeta struct {
+	name   string
+	labels map[string]string
+}
+
+// inspectMeta entrega el nombre y los labels del contenedor. Los eventos de Docker no incluyen
+// labels, por lo que se inspecciona el contenedor y se guarda el resultado, ya que luego de
+// removido no se puede inspeccionar. Solo se guardan los contenedores creados por yale
+func (dh *DockerHelper) inspectMeta(containerId string) (containerMeta, bool) {
+	dh.metaMutex.Lock()
+	meta, ok := dh.containerMeta[containerId]
+	dh.metaMutex.Unlock()
+
+	if ok {
+		return meta, true
+	}
+
+	container, err := dh.client.InspectContainer(containerId)
+	if err != nil {
+		return containerMeta{}, false
+	}
+
+	meta = containerMeta{name: strings.TrimPrefix(container.Name, "/")}
+	if container.Config != nil {
+		meta.labels = container.Config.Labels
+	}
+
+	if meta.labels["image_name"] != "" {
+		dh.metaMutex.Lock()
+		if dh.containerMeta == nil {
+			dh.containerMeta = make(map[string]containerMeta)
+		}
+		dh.containerMeta[containerId] = meta
+		dh.metaMutex.Unlock()
+	}
+
+	return meta, true
+}
+
+func (dh *DockerHelper) forgetMeta(containerId string) {
+	dh.metaMutex.Lock()
+	defer dh.metaMutex.Unlock()
+	delete(dh.containerMeta, containerId)
+}
+
+// resubscribeEvents vuelve a suscribirse a los eventos del daemon luego de esperar wait, que
+// se duplica en cada intento hasta eventsRetryMaxWait. Reintenta hasta lograrlo o hasta que
+// se cancela el contexto. La suscripcion termina al cancelar el contexto o la funcion entregada
+func (dh *DockerHelper) resubscribeEvents(ctx context.Context, wait *time.Duration) (<-chan *docker.APIEvents, context.CancelFunc, bool) {
+	for {
+		util.Log.Warnf("Se reintenta la suscripción a los eventos de Docker en %s", *wait)
+		select {
+		case <-ctx.Done():
+			return nil, nil, false
+		case <-time.After(*wait):
+		}
+
+		if *wait *= 2; *wait > eventsRetryMaxWait {
+			*wait = eventsRetryMaxWait
+		}
+
+		subscriptionCtx, cancel := context.WithCancel(ctx)
+		events, err := dh.Events(subscriptionCtx)
+		if err == nil {
+			return events, cancel, true
+		}
+		cancel()
+		util.Log.Warnln("No se pudo suscribir a los eventos de Docker", err)
+	}
+}
+
+// ContainerEvents entrega los eventos de los contenedores creados por yale, es decir, con el
+// label image_name, hasta que se cancela el contexto. Si el cliente de Docker cierra la
+// conexion de eventos, se vuelve a suscribir
+func (dh *DockerHelper) ContainerEvents(ctx context.Context) (<-chan ContainerEvent, error) {
+	subscriptionCtx, cancel := context.WithCancel(ctx)
+	events, err := dh.Events(subscriptionCtx)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	containerEvents := make(chan ContainerEvent, 100)
+	go func() {
+		defer func() { cancel() }()
+
+		wait := eventsRetryInitialWait
+		for {
+			var event *docker.APIEvents
+			var ok bool
+			select {
+			case <-ctx.Done():
+				return
+			case event, ok = <-events:
+			}
+
+			if !ok {
+				util.Log.Warnln("Se perdió la conexión con los eventos de Docker")
+				cancel()
+				resubscribed, resubscribedCancel, ok := dh.resubscribeEvents(ctx, &wait)
+				if !ok {
+					return
+				}
+				events, cancel = resubscribed, resubscribedCancel
+				continue
+			}
+			wait = eventsRetryInitialWait
+
+			if event == nil || event.ID == "" {
+				continue
+			}
+
+			meta, ok := dh.inspectMeta(event.ID)
+			if event.Status == "destroy" {
+				dh.forgetMeta(event.ID)
+			}
+
+			if !ok || meta.labels["image_name"] == "" {
+				continue
+			}
+
+			containerEvent := ContainerEvent{
+				ID:     event.ID,
+				Status: event.Status,
+				Image:  event.From,
+				Name:   meta.name,
+				Labels: meta.labels,
+				Time:   time.Unix(event.Time, 0),
+			}
+
+			select {
+			case containerEvents <- containerEvent:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return containerEvents, nil
+}
+
+// WaitContainerDeath entrega el estado del evento cuando el contenedor muere. El canal no
+// entrega valores si el contexto se cancela antes o si se pierde la conexion de eventos
+func (dh *DockerHelper) WaitContainerDeath(ctx context.Context, containerId string) (<-chan string, error) {
+	events, err := dh.Events(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	death := make(chan string, 1)
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case event, ok := <-events:
+				if !ok {
+					return
+				}
+
+				if event == nil || event.ID != containerId {
+					continue
+				}
+
+				if isDeathStatus(event.Status) {
+					death <- event.Status
+					return
+				}
+			}
+		}
+	}()
+
+	return death, nil
+}
@@ -0,0 +1,46 @@
+package helper
+
+import (
+	"bytes"
+
+	"github.com/ch3lo/yale/util"
+	"github.com/fsouza/go-dockerclient"
+)
+
+// ExecResult es el resultado de un comando ejecutado dentro de un contenedor
+type ExecResult struct {
+	ExitCode int
+	Stdout   string
+	Stderr   string
+}
+
+// Exec ejecuta el comando dentro del contenedor, espera su termino y entrega su salida y codigo de salida
+func (dh *DockerHelper) Exec(containerId string, cmd []string, user string) (ExecResult, error) {
+	util.Log.Infof("Ejecutando %v en el contenedor %s", cmd, containerId)
+	exec, err := dh.client.CreateExec(docker.CreateExecOptions{
+		Container:    containerId,
+		Cmd:          cmd,
+		User:         user,
+		AttachStdout: true,
+		AttachStderr: true,
+	})
+	if err != nil {
+		return ExecResult{}, err
+	}
+
+	var stdout, stderr bytes.Buffer
+	err = dh.client.StartExec(exec.ID, docker.StartExecOptions{
+		OutputStream: &stdout,
+		ErrorStream:  &stderr,
+	})
+	if err != nil {
+		return ExecResult{}, err
+	}
+
+	inspect, err := dh.client.InspectExec(exec.ID)
+	if err != nil {
+		return ExecResult{}, err
+	}
+
+	return ExecResult{ExitCode: inspect.ExitCode, Stdout: stdout.String(), Stderr: stderr.String()}, nil
+}
@@ -0,0 +1,84 @@
+package helper
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/ch3lo/yale/util"
+	"github.com/fsouza/go-dockerclient"
+)
+
+// LogsConfig define la lectura de los logs de un contenedor.
+// Since es el instante, en segundos unix, desde el que se leen los logs. Un valor 0 lee desde el inicio.
+// Tail es la cantidad de lineas finales que se leen, "all" lee todas.
+type LogsConfig struct {
+	Follow     bool
+	Since      int64
+	Tail       string
+	Timestamps bool
+}
+
+// readableLogDrivers son los drivers de logs que permiten leer los logs desde la API de Docker
+var readableLogDrivers = []string{"json-file", "journald"}
+
+// LogDriverError indica que el driver de logs del contenedor no permite leer los logs desde la API
+type LogDriverError struct {
+	Driver string
+	Config map[string]string
+}
+
+func (e *LogDriverError) Error() string {
+	msg := fmt.Sprintf("El driver de logs %s no permite leer los logs desde la API de Docker, solo lo permiten %s",
+		e.Driver, strings.Join(readableLogDrivers, " y "))
+
+	for _, key := range []string{"syslog-address", "gelf-address", "fluentd-address", "splunk-url"} {
+		if address, ok := e.Config[key]; ok {
+			return msg + ". Los logs se envian a " + address
+		}
+	}
+
+	return msg
+}
+
+// ContainerLogs escribe los logs del contenedor en las salidas entregadas. Si el driver de
+// logs del contenedor no permite leerlos retorna un LogDriverError
+func (dh *DockerHelper) ContainerLogs(containerId string, config LogsConfig, stdout io.Writer, stderr io.Writer) error {
+	container, err := dh.ContainerInspect(containerId)
+	if err != nil {
+		return err
+	}
+
+	if container.HostConfig == nil {
+		return errors.New("No se pudo obtener la configuracion de logs del contenedor")
+	}
+
+	logConfig := container.HostConfig.LogConfig
+	if logConfig.Type != "" {
+		readable := false
+		for _, driver := range readableLogDrivers {
+			if logConfig.Type == driver {
+				readable = true
+			}
+		}
+
+		if !readable {
+			return &LogDriverError{Driver: logConfig.Type, Config: logConfig.Config}
+		}
+	}
+
+	util.Log.Debugf("Leyendo los logs del contenedor %s con configuracion %#v", containerId, config)
+	return dh.client.Logs(docker.LogsOptions{
+		Container:    containerId,
+		OutputStream: stdout,
+		ErrorStream:  stderr,
+		Follow:       config.Follow,
+		Stdout:       true,
+		Stderr:       true,
+		Since:        config.Since,
+		Timestamps:   config.Timestamps,
+		Tail:         config.Tail,
+		RawTerminal:  container.Config != nil && container.Config.Tty,
+	})
+}
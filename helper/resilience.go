@@ -0,0 +1,504 @@
+package helper
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/ch3lo/yale/util"
+	"github.com/fsouza/go-dockerclient"
+)
+
+// ERROR_TRANSIENT  Error que puede desaparecer al reintentar, como una conexion rechazada o un 5xx
+// ERROR_PERMANENT  Error que se repetira al reintentar, como un contenedor inexistente o un 4xx
+type ErrorClass int
+
+const (
+	ERROR_TRANSIENT ErrorClass = 1 + iota
+	ERROR_PERMANENT
+)
+
+var errorClass = [...]string{
+	"ERROR_TRANSIENT",
+	"ERROR_PERMANENT",
+}
+
+func (e ErrorClass) String() string {
+	return errorClass[e-1]
+}
+
+// ErrCallTimeout indica que la llamada al API de Docker no respondio dentro del tiempo maximo
+var ErrCallTimeout = errors.New("La llamada al API de Docker supero el tiempo maximo")
+
+// ErrCircuitOpen indica que el endpoint fallo repetidamente y no se le envian llamadas hasta
+// que termine la espera del circuit breaker
+var ErrCircuitOpen = errors.New("El circuit breaker del endpoint esta abierto")
+
+// ClassifyError indica si el error de una llamada al API de Docker es transitorio. Los errores
+// desconocidos se consideran permanentes para no repetir operaciones que no corresponde
+func ClassifyError(err error) ErrorClass {
+	if err == nil {
+		return ERROR_PERMANENT
+	}
+
+	switch e := err.(type) {
+	case *docker.Error:
+		if e.Status >= 500 || e.Status == http.StatusRequestTimeout || e.Status == http.StatusTooManyRequests {
+			return ERROR_TRANSIENT
+		}
+		return ERROR_PERMANENT
+	case *url.Error:
+		return ClassifyError(e.Err)
+	case *net.OpError:
+		return ERROR_TRANSIENT
+	case net.Error:
+		if e.Timeout() {
+			return ERROR_TRANSIENT
+		}
+	}
+
+	switch err {
+	case ErrCallTimeout, ErrCircuitOpen, docker.ErrConnectionRefused, io.EOF, io.ErrUnexpectedEOF:
+		return ERROR_TRANSIENT
+	case context.Canceled, context.DeadlineExceeded:
+		return ERROR_PERMANENT
+	}
+
+	return ERROR_PERMANENT
+}
+
+// IsTransient indica si el error puede desaparecer al reintentar la llamada
+func IsTransient(err error) bool {
+	return err != nil && ClassifyError(err) == ERROR_TRANSIENT
+}
+
+// ResilienceConfig define como se protegen las llamadas al API de Docker de un endpoint.
+// Retries es la cantidad de reintentos de las llamadas idempotentes ante errores transitorios,
+// con una espera que parte en Backoff y se duplica hasta MaxBackoff.
+// Timeout es el tiempo maximo de cada llamada, un valor 0 no limita el tiempo.
+// Luego de BreakerThreshold errores transitorios consecutivos el endpoint rechaza las llamadas
+// durante BreakerCooldown, un valor 0 desactiva el circuit breaker.
+type ResilienceConfig struct {
+	Retries          int
+	Backoff          time.Duration
+	MaxBackoff       time.Duration
+	Timeout          time.Duration
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+}
+
+// DefaultResilienceConfig es la configuracion de los endpoints si no se define otra
+func DefaultResilienceConfig() ResilienceConfig {
+	return ResilienceConfig{
+		Retries:          3,
+		Backoff:          500 * time.Millisecond,
+		MaxBackoff:       5 * time.Second,
+		Timeout:          30 * time.Second,
+		BreakerThreshold: 5,
+		BreakerCooldown:  30 * time.Second,
+	}
+}
+
+func (c ResilienceConfig) backoff(attempt int) time.Duration {
+	wait := c.Backoff
+	for i := 0; i < attempt && wait < c.MaxBackoff; i++ {
+		wait *= 2
+	}
+
+	if c.MaxBackoff > 0 && wait > c.MaxBackoff {
+		return c.MaxBackoff
+	}
+
+	return wait
+}
+
+// CallStats resume las llamadas al API de Docker de un endpoint
+type CallStats struct {
+	Calls         int `json:"Calls"`
+	Retries       int `json:"Retries"`
+	Failures      int `json:"Failures"`
+	Timeouts      int `json:"Timeouts"`
+	Rejected      int `json:"Rejected"`
+	CircuitOpened int `json:"CircuitOpened"`
+}
+
+// BREAKER_CLOSED     Las llamadas se envian al endpoint
+// BREAKER_OPEN       Las llamadas se rechazan sin enviarse hasta que termine la espera
+// BREAKER_HALF_OPEN  Se envia una llamada de prueba que decide si el circuito se cierra o se vuelve a abrir
+type BreakerState int
+
+const (
+	BREAKER_CLOSED BreakerState = 1 + iota
+	BREAKER_OPEN
+	BREAKER_HALF_OPEN
+)
+
+var breakerState = [...]string{
+	"BREAKER_CLOSED",
+	"BREAKER_OPEN",
+	"BREAKER_HALF_OPEN",
+}
+
+func (s BreakerState) String() string {
+	return breakerState[s-1]
+}
+
+type circuitBreaker struct {
+	mutex    sync.Mutex
+	state    BreakerState
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+// allow indica si la llamada se puede enviar. Terminada la espera deja pasar una sola llamada de prueba
+func (b *circuitBreaker) allow(config ResilienceConfig) bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	switch b.state {
+	case BREAKER_OPEN:
+		if time.Since(b.openedAt) < config.BreakerCooldown {
+			return false
+		}
+		b.state = BREAKER_HALF_OPEN
+		b.probing = true
+		return true
+	case BREAKER_HALF_OPEN:
+		if b.probing {
+			return false
+		}
+		b.probing = true
+	}
+
+	return true
+}
+
+func (b *circuitBreaker) success() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.state = BREAKER_CLOSED
+	b.failures = 0
+	b.probing = false
+}
+
+// failure registra un error transitorio e indica si el circuito se abrio
+func (b *circuitBreaker) failure(config ResilienceConfig) bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.failures++
+	b.probing = false
+	if config.BreakerThreshold <= 0 {
+		return false
+	}
+
+	if b.state == BREAKER_HALF_OPEN || (b.state != BREAKER_OPEN && b.failures >= config.BreakerThreshold) {
+		b.state = BREAKER_OPEN
+		b.openedAt = time.Now()
+		return true
+	}
+
+	return false
+}
+
+// release libera la llamada de prueba sin registrar su resultado, como cuando se cancela su contexto
+func (b *circuitBreaker) release() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.probing = false
+}
+
+func (b *circuitBreaker) State() BreakerState {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.state == 0 {
+		return BREAKER_CLOSED
+	}
+
+	return b.state
+}
+
+// resilientClient protege las llamadas de un DockerClient con reintentos, tiempo maximo y
+// circuit breaker. Las llamadas se ejecutan con el contexto de un contextClient. Los streams
+// (eventos, logs y exec) se delegan sin proteccion
+type resilientClient struct {
+	DockerClient
+	configMutex sync.Mutex
+	config      ResilienceConfig
+	breaker     circuitBreaker
+	statsMutex  sync.Mutex
+	stats       CallStats
+}
+
+func newResilientClient(client DockerClient, config ResilienceConfig) *resilientClient {
+	return &resilientClient{DockerClient: client, config: config}
+}
+
+func (rc *resilientClient) configure(config ResilienceConfig) {
+	rc.configMutex.Lock()
+	defer rc.configMutex.Unlock()
+
+	rc.config = config
+}
+
+func (rc *resilientClient) currentConfig() ResilienceConfig {
+	rc.configMutex.Lock()
+	defer rc.configMutex.Unlock()
+
+	return rc.config
+}
+
+func (rc *resilientClient) count(update func(stats *CallStats)) {
+	rc.statsMutex.Lock()
+	defer rc.statsMutex.Unlock()
+
+	update(&rc.stats)
+}
+
+func (rc *resilientClient) Stats() CallStats {
+	rc.statsMutex.Lock()
+	defer rc.statsMutex.Unlock()
+
+	return rc.stats
+}
+
+// apiCall es una llamada al API de Docker. El resultado se entrega como valor de retorno y no
+// en variables compartidas, ya que una llamada que supera el tiempo maximo sigue en ejecucion
+type apiCall func() (interface{}, error)
+
+// alreadyDone indica si el error de un reintento muestra que un intento anterior, que no
+// respondio a tiempo, alcanzó a completar la operacion en segundo plano
+type alreadyDone func(err error) bool
+
+type callResult struct {
+	value interface{}
+	err   error
+}
+
+// withTimeout ejecuta la llamada con un tiempo maximo, o hasta que se cancela el contexto. El
+// cliente de Docker no permite cancelar una llamada, por lo que al vencer el tiempo o cancelarse
+// el contexto la llamada termina en segundo plano
+func withTimeout(ctx context.Context, timeout time.Duration, call apiCall) (interface{}, error) {
+	if timeout <= 0 && ctx.Done() == nil {
+		return call()
+	}
+
+	done := make(chan callResult, 1)
+	go func() {
+		value, err := call()
+		done <- callResult{value, err}
+	}()
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	select {
+	case result := <-done:
+		return result.value, result.err
+	case <-expired:
+		return nil, ErrCallTimeout
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// do ejecuta la llamada con el tiempo maximo entregado. Los errores transitorios de las llamadas
+// idempotentes se reintentan con espera exponencial, mientras el circuit breaker lo permita. Al
+// cancelar el contexto se deja de esperar la llamada y los reintentos, entregando el error del
+// contexto sin afectar al circuit breaker. Si done no es nil, los errores de los reintentos que
+// indican que la operacion ya se completó se consideran exitosos
+func (rc *resilientClient) do(ctx context.Context, name string, idempotent bool, timeout time.Duration, call apiCall, done alreadyDone) (interface{}, error) {
+	config := rc.currentConfig()
+	rc.count(func(stats *CallStats) { stats.Calls++ })
+
+	for attempt := 0; ; attempt++ {
+		if err := ctx.Err(); err != nil {
+			rc.count(func(stats *CallStats) { stats.Failures++ })
+			return nil, err
+		}
+
+		if config.BreakerThreshold > 0 && !rc.breaker.allow(config) {
+			rc.count(func(stats *CallStats) { stats.Rejected++ })
+			return nil, ErrCircuitOpen
+		}
+
+		value, err := withTimeout(ctx, timeout, call)
+		if attempt > 0 && done != nil && err != nil && done(err) {
+			util.Log.Infof("El reintento de %s indica que un intento anterior se completó: %s", name, err)
+			err = nil
+		}
+
+		if err != nil && err == ctx.Err() {
+			rc.breaker.release()
+			rc.count(func(stats *CallStats) { stats.Failures++ })
+			return nil, err
+		}
+
+		if !IsTransient(err) {
+			rc.breaker.success()
+			return value, err
+		}
+
+		if err == ErrCallTimeout {
+			rc.count(func(stats *CallStats) { stats.Timeouts++ })
+		}
+
+		if rc.breaker.failure(config) {
+			rc.count(func(stats *CallStats) { stats.CircuitOpened++ })
+			util.Log.Errorf("Se abrió el circuit breaker del endpoint por %s luego de fallar %s: %s", config.BreakerCooldown, name, err)
+		}
+
+		if !idempotent || attempt >= config.Retries {
+			rc.count(func(stats *CallStats) { stats.Failures++ })
+			return nil, err
+		}
+
+		wait := config.backoff(attempt)
+		util.Log.Warnf("Error transitorio en %s, reintento %d/%d en %s: %s", name, attempt+1, config.Retries, wait, err)
+		rc.count(func(stats *CallStats) { stats.Retries++ })
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+		}
+	}
+}
+
+// withContext entrega el cliente cuyas llamadas se protegen con los reintentos, el tiempo
+// maximo y el circuit breaker del endpoint, y se dejan de esperar al cancelar el contexto
+func (rc *resilientClient) withContext(ctx context.Context) DockerClient {
+	return &contextClient{resilientClient: rc, ctx: ctx}
+}
+
+// contextClient ejecuta las llamadas del resilientClient con el contexto de la operacion en curso
+type contextClient struct {
+	*resilientClient
+	ctx context.Context
+}
+
+// callTimeout es el tiempo maximo de una llamada. Las llamadas que detienen un contenedor lo
+// extienden con el tiempo que Docker espera que el contenedor termine
+func (rc *resilientClient) callTimeout(stopTimeout uint) time.Duration {
+	config := rc.currentConfig()
+	if config.Timeout <= 0 {
+		return 0
+	}
+
+	return config.Timeout + time.Duration(stopTimeout)*time.Second
+}
+
+func (cc *contextClient) ListContainers(opts docker.ListContainersOptions) ([]docker.APIContainers, error) {
+	value, err := cc.do(cc.ctx, "ListContainers", true, cc.callTimeout(0), func() (interface{}, error) {
+		return cc.DockerClient.ListContainers(opts)
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return value.([]docker.APIContainers), nil
+}
+
+func (cc *contextClient) InspectContainer(id string) (*docker.Container, error) {
+	value, err := cc.do(cc.ctx, "InspectContainer", true, cc.callTimeout(0), func() (interface{}, error) {
+		return cc.DockerClient.InspectContainer(id)
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return value.(*docker.Container), nil
+}
+
+// CreateContainer no se reintenta, no se limita en tiempo ni se deja de esperar al cancelar el
+// contexto, ya que podria dejar contenedores creados que nadie administra
+func (cc *contextClient) CreateContainer(opts docker.CreateContainerOptions) (*docker.Container, error) {
+	value, err := cc.do(context.WithoutCancel(cc.ctx), "CreateContainer", false, 0, func() (interface{}, error) {
+		return cc.DockerClient.CreateContainer(opts)
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return value.(*docker.Container), nil
+}
+
+func (cc *contextClient) StartContainer(id string, hostConfig *docker.HostConfig) error {
+	_, err := cc.do(cc.ctx, "StartContainer", true, cc.callTimeout(0), func() (interface{}, error) {
+		return nil, cc.DockerClient.StartContainer(id, hostConfig)
+	}, func(err error) bool {
+		_, running := err.(*docker.ContainerAlreadyRunning)
+		return running
+	})
+
+	return err
+}
+
+func (cc *contextClient) StopContainer(id string, timeout uint) error {
+	_, err := cc.do(cc.ctx, "StopContainer", true, cc.callTimeout(timeout), func() (interface{}, error) {
+		return nil, cc.DockerClient.StopContainer(id, timeout)
+	}, nil)
+
+	return err
+}
+
+func (cc *contextClient) RestartContainer(id string, timeout uint) error {
+	_, err := cc.do(cc.ctx, "RestartContainer", false, cc.callTimeout(timeout), func() (interface{}, error) {
+		return nil, cc.DockerClient.RestartContainer(id, timeout)
+	}, nil)
+
+	return err
+}
+
+func (cc *contextClient) RemoveContainer(opts docker.RemoveContainerOptions) error {
+	_, err := cc.do(cc.ctx, "RemoveContainer", true, cc.callTimeout(0), func() (interface{}, error) {
+		return nil, cc.DockerClient.RemoveContainer(opts)
+	}, func(err error) bool {
+		_, missing := err.(*docker.NoSuchContainer)
+		return missing
+	})
+
+	return err
+}
+
+func (cc *contextClient) ListImages(opts docker.ListImagesOptions) ([]docker.APIImages, error) {
+	value, err := cc.do(cc.ctx, "ListImages", true, cc.callTimeout(0), func() (interface{}, error) {
+		return cc.DockerClient.ListImages(opts)
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return value.([]docker.APIImages), nil
+}
+
+// PullImage se reintenta pero no se limita en tiempo, ya que la descarga depende del tamaño de la imagen
+func (cc *contextClient) PullImage(opts docker.PullImageOptions, auth docker.AuthConfiguration) error {
+	_, err := cc.do(cc.ctx, "PullImage", true, 0, func() (interface{}, error) {
+		return nil, cc.DockerClient.PullImage(opts, auth)
+	}, nil)
+
+	return err
+}
+
+func (cc *contextClient) RemoveImage(name string) error {
+	_, err := cc.do(cc.ctx, "RemoveImage", true, cc.callTimeout(0), func() (interface{}, error) {
+		return nil, cc.DockerClient.RemoveImage(name)
+	}, nil)
+
+	return err
+}
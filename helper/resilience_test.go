@@ -0,0 +1,300 @@
+package helper_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ch3lo/yale/helper"
+	"github.com/ch3lo/yale/helper/dockertest"
+	"github.com/fsouza/go-dockerclient"
+)
+
+func fastConfig() helper.ResilienceConfig {
+	return helper.ResilienceConfig{
+		Retries:          3,
+		Backoff:          time.Millisecond,
+		MaxBackoff:       4 * time.Millisecond,
+		Timeout:          time.Second,
+		BreakerThreshold: 5,
+		BreakerCooldown:  time.Hour,
+	}
+}
+
+func newHelper(config helper.ResilienceConfig) (*helper.DockerHelper, *dockertest.Engine) {
+	engine := dockertest.NewEngine("node")
+	dh := helper.NewDockerHelperFromClient(engine, "")
+	dh.SetResilience(config)
+
+	return dh, engine
+}
+
+func TestClassifyError(t *testing.T) {
+	cases := []struct {
+		err   error
+		class helper.ErrorClass
+	}{
+		{&docker.Error{Status: http.StatusServiceUnavailable}, helper.ERROR_TRANSIENT},
+		{&docker.Error{Status: http.StatusTooManyRequests}, helper.ERROR_TRANSIENT},
+		{&docker.Error{Status: http.StatusNotFound}, helper.ERROR_PERMANENT},
+		{&docker.Error{Status: http.StatusConflict}, helper.ERROR_PERMANENT},
+		{&docker.NoSuchContainer{ID: "abc"}, helper.ERROR_PERMANENT},
+		{docker.ErrNoSuchImage, helper.ERROR_PERMANENT},
+		{docker.ErrConnectionRefused, helper.ERROR_TRANSIENT},
+		{&net.OpError{Op: "dial", Err: errors.New("connection reset")}, helper.ERROR_TRANSIENT},
+		{io.ErrUnexpectedEOF, helper.ERROR_TRANSIENT},
+		{helper.ErrCallTimeout, helper.ERROR_TRANSIENT},
+		{errors.New("unauthorized: authentication required"), helper.ERROR_PERMANENT},
+	}
+
+	for _, c := range cases {
+		if class := helper.ClassifyError(c.err); class != c.class {
+			t.Errorf("El error %q se clasificó como %s, se esperaba %s", c.err, class, c.class)
+		}
+	}
+}
+
+func TestRetriesTransientErrors(t *testing.T) {
+	dh, engine := newHelper(fastConfig())
+	engine.Run("app:1.0", map[string]string{"image_name": "app"})
+	engine.FailCall("ListContainers", 2)
+
+	containers, err := dh.ListContainers(helper.NewContainerFilter())
+	if err != nil {
+		t.Fatalf("Se esperaba que los reintentos ocultaran el error: %s", err)
+	}
+
+	if len(containers) != 1 {
+		t.Errorf("Se listaron %d contenedores, se esperaba 1", len(containers))
+	}
+
+	if stats := dh.CallStats(); stats.Retries != 2 || stats.Failures != 0 {
+		t.Errorf("Resumen de llamadas inesperado %#v", stats)
+	}
+}
+
+func TestGivesUpAfterRetries(t *testing.T) {
+	dh, engine := newHelper(fastConfig())
+	engine.FailCall("ListContainers", -1)
+
+	if _, err := dh.ListContainers(helper.NewContainerFilter()); !helper.IsTransient(err) {
+		t.Fatalf("Se esperaba un error transitorio, se obtuvo %v", err)
+	}
+
+	if stats := dh.CallStats(); stats.Retries != 3 || stats.Failures != 1 {
+		t.Errorf("Resumen de llamadas inesperado %#v", stats)
+	}
+}
+
+func TestPermanentErrorIsNotRetried(t *testing.T) {
+	dh, _ := newHelper(fastConfig())
+
+	if _, err := dh.ContainerInspect("missing"); err == nil {
+		t.Fatal("Se esperaba un error al inspeccionar un contenedor inexistente")
+	}
+
+	if stats := dh.CallStats(); stats.Retries != 0 || stats.Failures != 0 {
+		t.Errorf("Resumen de llamadas inesperado %#v", stats)
+	}
+
+	if state := dh.BreakerState(); state != helper.BREAKER_CLOSED {
+		t.Errorf("Un error permanente cambió el circuit breaker a %s", state)
+	}
+}
+
+func TestCircuitBreakerRejectsCalls(t *testing.T) {
+	config := fastConfig()
+	config.Retries = 0
+	config.BreakerThreshold = 2
+	dh, engine := newHelper(config)
+	engine.FailCall("ListImages", 2)
+
+	for k := 0; k < 2; k++ {
+		if _, err := dh.ListImages(); !helper.IsTransient(err) {
+			t.Fatalf("Se esperaba un error transitorio, se obtuvo %v", err)
+		}
+	}
+
+	if state := dh.BreakerState(); state != helper.BREAKER_OPEN {
+		t.Fatalf("El circuit breaker esta %s, se esperaba abierto", state)
+	}
+
+	if _, err := dh.ListImages(); err != helper.ErrCircuitOpen {
+		t.Fatalf("Se esperaba que el circuit breaker rechazara la llamada, se obtuvo %v", err)
+	}
+
+	if stats := dh.CallStats(); stats.Rejected != 1 || stats.CircuitOpened != 1 {
+		t.Errorf("Resumen de llamadas inesperado %#v", stats)
+	}
+}
+
+func TestCircuitBreakerClosesAfterCooldown(t *testing.T) {
+	config := fastConfig()
+	config.Retries = 0
+	config.BreakerThreshold = 1
+	config.BreakerCooldown = 10 * time.Millisecond
+	dh, engine := newHelper(config)
+	engine.FailCall("ListImages", 1)
+
+	dh.ListImages()
+	if state := dh.BreakerState(); state != helper.BREAKER_OPEN {
+		t.Fatalf("El circuit breaker esta %s, se esperaba abierto", state)
+	}
+
+	time.Sleep(2 * config.BreakerCooldown)
+	if _, err := dh.ListImages(); err != nil {
+		t.Fatalf("Se esperaba que la llamada de prueba pasara: %s", err)
+	}
+
+	if state := dh.BreakerState(); state != helper.BREAKER_CLOSED {
+		t.Errorf("El circuit breaker esta %s, se esperaba cerrado", state)
+	}
+}
+
+// slowClient demora las llamadas de listado, como un daemon que no responde
+type slowClient struct {
+	*dockertest.Engine
+	delay time.Duration
+}
+
+func (c *slowClient) ListContainers(opts docker.ListContainersOptions) ([]docker.APIContainers, error) {
+	time.Sleep(c.delay)
+	return c.Engine.ListContainers(opts)
+}
+
+func TestCallTimeout(t *testing.T) {
+	config := fastConfig()
+	config.Retries = 1
+	config.Timeout = 10 * time.Millisecond
+	dh := helper.NewDockerHelperFromClient(&slowClient{Engine: dockertest.NewEngine("node"), delay: time.Second}, "")
+	dh.SetResilience(config)
+
+	start := time.Now()
+	if _, err := dh.ListContainers(helper.NewContainerFilter()); err != helper.ErrCallTimeout {
+		t.Fatalf("Se esperaba un error de tiempo maximo, se obtuvo %v", err)
+	}
+
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("La llamada demoró %s pese al tiempo maximo", elapsed)
+	}
+
+	if stats := dh.CallStats(); stats.Timeouts != 2 || stats.Retries != 1 {
+		t.Errorf("Resumen de llamadas inesperado %#v", stats)
+	}
+}
+
+func TestCanceledContextInterruptsCall(t *testing.T) {
+	config := fastConfig()
+	config.Timeout = time.Minute
+	dh := helper.NewDockerHelperFromClient(&slowClient{Engine: dockertest.NewEngine("node"), delay: time.Second}, "")
+	dh.SetResilience(config)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	if _, err := dh.WithContext(ctx).ListContainers(helper.NewContainerFilter()); err != context.DeadlineExceeded {
+		t.Fatalf("Se esperaba el error del contexto, se obtuvo %v", err)
+	}
+
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("La llamada demoró %s pese a cancelarse el contexto", elapsed)
+	}
+
+	if state := dh.BreakerState(); state != helper.BREAKER_CLOSED {
+		t.Errorf("La cancelacion cambió el circuit breaker a %s", state)
+	}
+}
+
+func TestCanceledContextInterruptsBackoff(t *testing.T) {
+	config := fastConfig()
+	config.Backoff = time.Minute
+	config.MaxBackoff = time.Minute
+	dh, engine := newHelper(config)
+	engine.FailCall("ListContainers", -1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	if _, err := dh.WithContext(ctx).ListContainers(helper.NewContainerFilter()); err != context.DeadlineExceeded {
+		t.Fatalf("Se esperaba el error del contexto, se obtuvo %v", err)
+	}
+
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("Los reintentos demoraron %s pese a cancelarse el contexto", elapsed)
+	}
+
+	if stats := dh.CallStats(); stats.Retries != 1 || stats.Failures != 1 {
+		t.Errorf("Resumen de llamadas inesperado %#v", stats)
+	}
+}
+
+// lateClient completa la primera llamada de arranque y de remocion pero responde tarde, como un
+// daemon que supera el tiempo maximo luego de ejecutar la operacion
+type lateClient struct {
+	*dockertest.Engine
+	delay time.Duration
+	mutex sync.Mutex
+	late  map[string]bool
+}
+
+func (c *lateClient) respondLate(call string) {
+	c.mutex.Lock()
+	first := !c.late[call]
+	c.late[call] = true
+	c.mutex.Unlock()
+
+	if first {
+		time.Sleep(c.delay)
+	}
+}
+
+func (c *lateClient) StartContainer(id string, hostConfig *docker.HostConfig) error {
+	err := c.Engine.StartContainer(id, hostConfig)
+	c.respondLate("StartContainer")
+	return err
+}
+
+func (c *lateClient) RemoveContainer(opts docker.RemoveContainerOptions) error {
+	err := c.Engine.RemoveContainer(opts)
+	c.respondLate("RemoveContainer")
+	return err
+}
+
+func TestRetryAfterCompletedTimeout(t *testing.T) {
+	config := fastConfig()
+	config.Timeout = 10 * time.Millisecond
+	auth := filepath.Join(t.TempDir(), "dockercfg")
+	if err := os.WriteFile(auth, []byte(`{"https://registry.it.lan.com": {"auth": "dXNlcjpwYXNz", "email": "user@lan.com"}}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	engine := dockertest.NewEngine("node")
+	dh := helper.NewDockerHelperFromClient(&lateClient{Engine: engine, delay: 100 * time.Millisecond, late: make(map[string]bool)}, auth)
+	dh.SetResilience(config)
+
+	container, err := dh.CreateAndRun(context.Background(), docker.CreateContainerOptions{Config: &docker.Config{Image: "app:1.0"}})
+	if err != nil {
+		t.Fatalf("El reintento del arranque falló pese a que el contenedor arrancó: %s", err)
+	}
+
+	if !container.State.Running {
+		t.Errorf("El contenedor no esta corriendo")
+	}
+
+	if err := dh.UndeployContainer(container.ID, true, 1); err != nil {
+		t.Fatalf("El reintento de la remocion falló pese a que el contenedor se removió: %s", err)
+	}
+
+	if stats := dh.CallStats(); stats.Timeouts != 2 || stats.Retries != 2 || stats.Failures != 0 {
+		t.Errorf("Resumen de llamadas inesperado %#v", stats)
+	}
+}
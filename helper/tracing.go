@@ -0,0 +1,113 @@
+package helper
+
+import (
+	"context"
+
+	"github.com/ch3lo/yale/tracing"
+	"github.com/fsouza/go-dockerclient"
+)
+
+// tracedClient registra cada llamada al API de Docker como un span hijo del span del contexto.
+// Se ubica sobre resilientClient, por lo que un span incluye los reintentos de la llamada
+type tracedClient struct {
+	DockerClient
+	ctx context.Context
+}
+
+func (tc *tracedClient) start(operation string, attrs ...tracing.Attribute) *tracing.Span {
+	_, span := tracing.StartChild(tc.ctx, "docker "+operation, append([]tracing.Attribute{tracing.Attr("docker.operation", operation)}, attrs...)...)
+	span.SetKind(tracing.SPAN_CLIENT)
+	return span
+}
+
+func finish(span *tracing.Span, err error) {
+	span.SetError(err)
+	span.End()
+}
+
+func (tc *tracedClient) ListContainers(opts docker.ListContainersOptions) ([]docker.APIContainers, error) {
+	span := tc.start("ListContainers")
+	containers, err := tc.DockerClient.ListContainers(opts)
+	span.SetAttributes(tracing.Attr("docker.containers", len(containers)))
+	finish(span, err)
+
+	return containers, err
+}
+
+func (tc *tracedClient) InspectContainer(id string) (*docker.Container, error) {
+	span := tc.start("InspectContainer", tracing.Attr("container.id", id))
+	container, err := tc.DockerClient.InspectContainer(id)
+	finish(span, err)
+
+	return container, err
+}
+
+func (tc *tracedClient) CreateContainer(opts docker.CreateContainerOptions) (*docker.Container, error) {
+	span := tc.start("CreateContainer")
+	if opts.Config != nil {
+		span.SetAttributes(tracing.Attr("container.image", opts.Config.Image))
+	}
+	container, err := tc.DockerClient.CreateContainer(opts)
+	if container != nil {
+		span.SetAttributes(tracing.Attr("container.id", container.ID))
+	}
+	finish(span, err)
+
+	return container, err
+}
+
+func (tc *tracedClient) StartContainer(id string, hostConfig *docker.HostConfig) error {
+	span := tc.start("StartContainer", tracing.Attr("container.id", id))
+	err := tc.DockerClient.StartContainer(id, hostConfig)
+	finish(span, err)
+
+	return err
+}
+
+func (tc *tracedClient) StopContainer(id string, timeout uint) error {
+	span := tc.start("StopContainer", tracing.Attr("container.id", id))
+	err := tc.DockerClient.StopContainer(id, timeout)
+	finish(span, err)
+
+	return err
+}
+
+func (tc *tracedClient) RestartContainer(id string, timeout uint) error {
+	span := tc.start("RestartContainer", tracing.Attr("container.id", id))
+	err := tc.DockerClient.RestartContainer(id, timeout)
+	finish(span, err)
+
+	return err
+}
+
+func (tc *tracedClient) RemoveContainer(opts docker.RemoveContainerOptions) error {
+	span := tc.start("RemoveContainer", tracing.Attr("container.id", opts.ID))
+	err := tc.DockerClient.RemoveContainer(opts)
+	finish(span, err)
+
+	return err
+}
+
+func (tc *tracedClient) ListImages(opts docker.ListImagesOptions) ([]docker.APIImages, error) {
+	span := tc.start("ListImages")
+	images, err := tc.DockerClient.ListImages(opts)
+	finish(span, err)
+
+	return images, err
+}
+
+func (tc *tracedClient) PullImage(opts docker.PullImageOptions, auth docker.AuthConfiguration) error {
+	span := tc.start("PullImage", tracing.Attr("container.image", opts.Repository))
+	err := tc.DockerClient.PullImage(opts, auth)
+	finish(span, err)
+
+	return err
+}
+
+func (tc *tracedClient) RemoveImage(name string) error {
+	span := tc.start("RemoveImage", tracing.Attr("container.image", name))
+	err := tc.DockerClient.RemoveImage(name)
+	finish(span, err)
+
+	return err
+}
@@ -0,0 +1,97 @@
+package metrics
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ContentType es el tipo del formato de texto de Prometheus
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// ErrEmptyJob indica que se intentó enviar metricas al Pushgateway sin nombre de job
+var ErrEmptyJob = errors.New("El job del Pushgateway no puede estar vacio")
+
+// Handler expone las metricas del registro, para montarlo en /metrics
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var buffer bytes.Buffer
+		if err := r.Write(&buffer); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", ContentType)
+		w.Write(buffer.Bytes())
+	})
+}
+
+// groupingPath arma la ruta del grupo en el Pushgateway. Los valores vacios o con / se codifican
+// en base64 con el sufijo @base64, como lo define el Pushgateway
+func groupingPath(job string, grouping map[string]string) string {
+	path := "/metrics/job/" + url.PathEscape(job)
+	if job == "" || strings.Contains(job, "/") {
+		path = "/metrics/job@base64/" + base64.RawURLEncoding.EncodeToString([]byte(job))
+	}
+
+	var labels []string
+	for label := range grouping {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+
+	for _, label := range labels {
+		value := grouping[label]
+		if value == "" || strings.Contains(value, "/") {
+			encoded := base64.RawURLEncoding.EncodeToString([]byte(value))
+			if encoded == "" {
+				encoded = "="
+			}
+			path += "/" + label + "@base64/" + encoded
+		} else {
+			path += "/" + label + "/" + url.PathEscape(value)
+		}
+	}
+
+	return path
+}
+
+// Push reemplaza las metricas del grupo en el Pushgateway con las del registro. Es la forma de
+// publicar las metricas de una ejecucion del CLI, que termina antes de que Prometheus la consulte
+func (r *Registry) Push(gateway string, job string, grouping map[string]string, timeout time.Duration) error {
+	if job == "" {
+		return ErrEmptyJob
+	}
+
+	var buffer bytes.Buffer
+	if err := r.Write(&buffer); err != nil {
+		return err
+	}
+
+	request, err := http.NewRequest("PUT", strings.TrimRight(gateway, "/")+groupingPath(job, grouping), &buffer)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", ContentType)
+
+	client := &http.Client{Timeout: timeout}
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		body, _ := ioutil.ReadAll(response.Body)
+		return errors.New(fmt.Sprintf("El Pushgateway respondió %s: %s", response.Status, strings.TrimSpace(string(body))))
+	}
+
+	return nil
+}
@@ -0,0 +1,343 @@
+// Package metrics implementa contadores, gauges e histogramas con etiquetas y los expone en el
+// formato de texto de Prometheus, ya sea por HTTP en los procesos de larga duracion o enviandolos
+// a un Pushgateway al terminar una ejecucion del CLI
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefBuckets son los limites por defecto de los histogramas, en segundos
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// family es una metrica registrada con todas sus series
+type family interface {
+	name() string
+	metricKind() string
+	write(buffer *bytes.Buffer)
+}
+
+// Registry agrupa las metricas que se exponen juntas
+type Registry struct {
+	mutex    sync.Mutex
+	families map[string]family
+}
+
+func NewRegistry() *Registry {
+	return &Registry{families: make(map[string]family)}
+}
+
+// Default es el registro donde los paquetes de yale declaran sus metricas
+var Default = NewRegistry()
+
+// Gauges entrega un registro con solo los gauges de este, que comparten sus series con el original.
+// Es lo que se publica en el Pushgateway al terminar una ejecucion del CLI, ya que cada envio
+// reemplaza el grupo y los contadores de una ejecucion no se acumularian con los de las anteriores
+func (r *Registry) Gauges() *Registry {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	gauges := NewRegistry()
+	for metricName, f := range r.families {
+		if f.metricKind() == "gauge" {
+			gauges.families[metricName] = f
+		}
+	}
+
+	return gauges
+}
+
+func (r *Registry) register(f family) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if _, ok := r.families[f.name()]; ok {
+		panic(fmt.Sprintf("La metrica %s ya esta registrada", f.name()))
+	}
+	r.families[f.name()] = f
+}
+
+// familyNames ordena las metricas por nombre para una salida estable
+type familyNames []family
+
+func (f familyNames) Len() int           { return len(f) }
+func (f familyNames) Swap(i, j int)      { f[i], f[j] = f[j], f[i] }
+func (f familyNames) Less(i, j int) bool { return f[i].name() < f[j].name() }
+
+// Write escribe todas las metricas en el formato de texto de Prometheus
+func (r *Registry) Write(w io.Writer) error {
+	r.mutex.Lock()
+	var families []family
+	for _, f := range r.families {
+		families = append(families, f)
+	}
+	r.mutex.Unlock()
+	sort.Sort(familyNames(families))
+
+	var buffer bytes.Buffer
+	for _, f := range families {
+		f.write(&buffer)
+	}
+
+	_, err := w.Write(buffer.Bytes())
+	return err
+}
+
+// desc describe una metrica y la forma en que se identifican sus series
+type desc struct {
+	metricName string
+	help       string
+	kind       string
+	labels     []string
+}
+
+func (d *desc) name() string {
+	return d.metricName
+}
+
+func (d *desc) metricKind() string {
+	return d.kind
+}
+
+func (d *desc) key(values []string) string {
+	if len(values) != len(d.labels) {
+		panic(fmt.Sprintf("La metrica %s tiene %d etiquetas y se entregaron %d valores", d.metricName, len(d.labels), len(values)))
+	}
+
+	return strings.Join(values, "\xff")
+}
+
+func (d *desc) header(buffer *bytes.Buffer) {
+	fmt.Fprintf(buffer, "# HELP %s %s\n", d.metricName, escapeHelp(d.help))
+	fmt.Fprintf(buffer, "# TYPE %s %s\n", d.metricName, d.kind)
+}
+
+// series escribe una linea con el valor de la serie. extra agrega una etiqueta al final, como le en los histogramas
+func (d *desc) series(buffer *bytes.Buffer, suffix string, values []string, extra string, value float64) {
+	buffer.WriteString(d.metricName + suffix)
+
+	var pairs []string
+	for k, label := range d.labels {
+		pairs = append(pairs, label+`="`+escapeValue(values[k])+`"`)
+	}
+	if extra != "" {
+		pairs = append(pairs, extra)
+	}
+
+	if len(pairs) > 0 {
+		buffer.WriteString("{" + strings.Join(pairs, ",") + "}")
+	}
+	buffer.WriteString(" " + formatFloat(value) + "\n")
+}
+
+func escapeHelp(help string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help)
+}
+
+func escapeValue(value string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`).Replace(value)
+}
+
+func formatFloat(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	}
+
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// sample es el valor de una serie de un contador o gauge
+type sample struct {
+	values []string
+	value  float64
+}
+
+// sortedKeys entrega las llaves de las series ordenadas, para una salida estable
+func sortedKeys(keys []string) []string {
+	sort.Strings(keys)
+	return keys
+}
+
+// CounterVec es un contador que solo aumenta, con una serie por combinacion de etiquetas
+type CounterVec struct {
+	desc
+	mutex   sync.Mutex
+	samples map[string]*sample
+}
+
+func (r *Registry) NewCounterVec(name string, help string, labels ...string) *CounterVec {
+	c := &CounterVec{
+		desc:    desc{metricName: name, help: help, kind: "counter", labels: labels},
+		samples: make(map[string]*sample),
+	}
+	r.register(c)
+
+	return c
+}
+
+// Add suma el valor a la serie de las etiquetas entregadas. Los valores negativos se ignoran
+func (c *CounterVec) Add(value float64, values ...string) {
+	if value < 0 {
+		return
+	}
+
+	key := c.key(values)
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	s, ok := c.samples[key]
+	if !ok {
+		s = &sample{values: append([]string{}, values...)}
+		c.samples[key] = s
+	}
+	s.value += value
+}
+
+func (c *CounterVec) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+// Value entrega el valor actual de la serie, 0 si no existe
+func (c *CounterVec) Value(values ...string) float64 {
+	key := c.key(values)
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if s, ok := c.samples[key]; ok {
+		return s.value
+	}
+
+	return 0
+}
+
+func (c *CounterVec) write(buffer *bytes.Buffer) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.header(buffer)
+	var keys []string
+	for key := range c.samples {
+		keys = append(keys, key)
+	}
+	for _, key := range sortedKeys(keys) {
+		c.series(buffer, "", c.samples[key].values, "", c.samples[key].value)
+	}
+}
+
+// GaugeVec es un valor que puede subir o bajar, con una serie por combinacion de etiquetas
+type GaugeVec struct {
+	CounterVec
+}
+
+func (r *Registry) NewGaugeVec(name string, help string, labels ...string) *GaugeVec {
+	g := &GaugeVec{CounterVec{
+		desc:    desc{metricName: name, help: help, kind: "gauge", labels: labels},
+		samples: make(map[string]*sample),
+	}}
+	r.register(g)
+
+	return g
+}
+
+func (g *GaugeVec) Set(value float64, values ...string) {
+	key := g.key(values)
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	g.samples[key] = &sample{values: append([]string{}, values...), value: value}
+}
+
+// histogramSample acumula las observaciones de una serie
+type histogramSample struct {
+	values []string
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// HistogramVec cuenta las observaciones en buckets acumulativos, con una serie por combinacion de etiquetas
+type HistogramVec struct {
+	desc
+	buckets []float64
+	mutex   sync.Mutex
+	samples map[string]*histogramSample
+}
+
+func (r *Registry) NewHistogramVec(name string, help string, buckets []float64, labels ...string) *HistogramVec {
+	sorted := append([]float64{}, buckets...)
+	sort.Float64s(sorted)
+
+	h := &HistogramVec{
+		desc:    desc{metricName: name, help: help, kind: "histogram", labels: labels},
+		buckets: sorted,
+		samples: make(map[string]*histogramSample),
+	}
+	r.register(h)
+
+	return h
+}
+
+func (h *HistogramVec) Observe(value float64, values ...string) {
+	key := h.key(values)
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	s, ok := h.samples[key]
+	if !ok {
+		s = &histogramSample{values: append([]string{}, values...), counts: make([]uint64, len(h.buckets))}
+		h.samples[key] = s
+	}
+
+	for k, bound := range h.buckets {
+		if value <= bound {
+			s.counts[k]++
+		}
+	}
+	s.count++
+	s.sum += value
+}
+
+// Count entrega la cantidad de observaciones de la serie, 0 si no existe
+func (h *HistogramVec) Count(values ...string) uint64 {
+	key := h.key(values)
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if s, ok := h.samples[key]; ok {
+		return s.count
+	}
+
+	return 0
+}
+
+func (h *HistogramVec) write(buffer *bytes.Buffer) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.header(buffer)
+	var keys []string
+	for key := range h.samples {
+		keys = append(keys, key)
+	}
+	for _, key := range sortedKeys(keys) {
+		s := h.samples[key]
+		for k, bound := range h.buckets {
+			h.series(buffer, "_bucket", s.values, `le="`+formatFloat(bound)+`"`, float64(s.counts[k]))
+		}
+		h.series(buffer, "_bucket", s.values, `le="+Inf"`, float64(s.count))
+		h.series(buffer, "_sum", s.values, "", s.sum)
+		h.series(buffer, "_count", s.values, "", float64(s.count))
+	}
+}
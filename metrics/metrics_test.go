@@ -0,0 +1,154 @@
+package metrics
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteCountersAndGauges(t *testing.T) {
+	r := NewRegistry()
+	deploys := r.NewCounterVec("yale_deploys_total", "Despliegues", "service", "outcome")
+	last := r.NewGaugeVec("yale_last", "Ultimo \\ despliegue\nterminado")
+
+	deploys.Inc("app", "failed")
+	deploys.Add(2, "app", "succeeded")
+	deploys.Add(-1, "app", "succeeded")
+	deploys.Inc(`a"b\c`, "failed")
+	last.Set(1.5)
+
+	var buffer bytes.Buffer
+	if err := r.Write(&buffer); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `# HELP yale_deploys_total Despliegues
+# TYPE yale_deploys_total counter
+yale_deploys_total{service="a\"b\\c",outcome="failed"} 1
+yale_deploys_total{service="app",outcome="failed"} 1
+yale_deploys_total{service="app",outcome="succeeded"} 2
+# HELP yale_last Ultimo \\ despliegue\nterminado
+# TYPE yale_last gauge
+yale_last 1.5
+`
+	if buffer.String() != expected {
+		t.Errorf("Salida inesperada\n%s", buffer.String())
+	}
+}
+
+func TestWriteHistogram(t *testing.T) {
+	r := NewRegistry()
+	latency := r.NewHistogramVec("latency_seconds", "Latencia", []float64{1, 0.1}, "type")
+
+	latency.Observe(0.05, "http")
+	latency.Observe(0.5, "http")
+	latency.Observe(3, "http")
+
+	var buffer bytes.Buffer
+	r.Write(&buffer)
+
+	expected := `# HELP latency_seconds Latencia
+# TYPE latency_seconds histogram
+latency_seconds_bucket{type="http",le="0.1"} 1
+latency_seconds_bucket{type="http",le="1"} 2
+latency_seconds_bucket{type="http",le="+Inf"} 3
+latency_seconds_sum{type="http"} 3.55
+latency_seconds_count{type="http"} 3
+`
+	if buffer.String() != expected {
+		t.Errorf("Salida inesperada\n%s", buffer.String())
+	}
+
+	if latency.Count("http") != 3 || latency.Count("tcp") != 0 {
+		t.Errorf("Cantidad de observaciones inesperada")
+	}
+}
+
+func TestGauges(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounterVec("yale_total", "Total").Inc()
+	last := r.NewGaugeVec("yale_last", "Ultimo")
+
+	gauges := r.Gauges()
+	last.Set(2)
+
+	var buffer bytes.Buffer
+	if err := gauges.Write(&buffer); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "# HELP yale_last Ultimo\n# TYPE yale_last gauge\nyale_last 2\n"
+	if buffer.String() != expected {
+		t.Errorf("Salida inesperada\n%s", buffer.String())
+	}
+}
+
+func TestDuplicateMetricPanics(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounterVec("yale_total", "Total")
+
+	defer func() {
+		if recover() == nil {
+			t.Error("Se esperaba un panic al registrar dos veces la metrica")
+		}
+	}()
+	r.NewGaugeVec("yale_total", "Total")
+}
+
+func TestHandler(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounterVec("yale_total", "Total").Inc()
+
+	recorder := httptest.NewRecorder()
+	r.Handler().ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+
+	if recorder.Header().Get("Content-Type") != ContentType || !strings.Contains(recorder.Body.String(), "yale_total 1\n") {
+		t.Errorf("Respuesta inesperada %v %q", recorder.Header(), recorder.Body.String())
+	}
+}
+
+func TestPush(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounterVec("yale_total", "Total").Inc()
+
+	var method, path, body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		data, _ := ioutil.ReadAll(req.Body)
+		method, path, body = req.Method, req.URL.EscapedPath(), string(data)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	grouping := map[string]string{"service": "registry.lan/app", "instance": "node1"}
+	if err := r.Push(server.URL+"/", "yale", grouping, time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	if method != "PUT" || path != "/metrics/job/yale/instance/node1/service@base64/cmVnaXN0cnkubGFuL2FwcA" {
+		t.Errorf("Envio inesperado %s %s", method, path)
+	}
+
+	if !strings.Contains(body, "yale_total 1\n") {
+		t.Errorf("Cuerpo inesperado %q", body)
+	}
+
+	if err := r.Push(server.URL, "", nil, time.Second); err != ErrEmptyJob {
+		t.Errorf("Se esperaba ErrEmptyJob, se obtuvo %v", err)
+	}
+}
+
+func TestPushError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		http.Error(w, "formato invalido", http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	err := NewRegistry().Push(server.URL, "yale", nil, time.Second)
+	if err == nil || !strings.Contains(err.Error(), "formato invalido") {
+		t.Errorf("Error inesperado %v", err)
+	}
+}
@@ -0,0 +1,39 @@
+package monitor
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/ch3lo/yale/tracing"
+)
+
+// attempt es un intento de un monitor. Al terminar se registra en las metricas y, si el
+// contexto pertenece a una traza, como un span
+type attempt struct {
+	phase       string
+	monitorType MonitorType
+	start       time.Time
+	span        *tracing.Span
+}
+
+func startAttempt(ctx context.Context, phase string, monitorType MonitorType, try int, addr string) *attempt {
+	name := strings.ToLower(monitorType.String())
+	_, span := tracing.StartChild(ctx, "monitor "+name,
+		tracing.Attr("monitor.phase", phase),
+		tracing.Attr("monitor.type", name),
+		tracing.Attr("monitor.attempt", try),
+		tracing.Attr("net.peer.address", addr))
+	span.SetKind(tracing.SPAN_CLIENT)
+
+	return &attempt{phase: phase, monitorType: monitorType, start: time.Now(), span: span}
+}
+
+// finish registra el resultado del intento. err describe los resultados distintos de ATTEMPT_OK
+func (a *attempt) finish(result string, err error) {
+	recordAttempt(a.phase, a.monitorType, result, a.start)
+
+	a.span.SetAttributes(tracing.Attr("monitor.result", result))
+	a.span.SetError(err)
+	a.span.End()
+}
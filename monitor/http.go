@@ -1,12 +1,15 @@
 package monitor
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"io/ioutil"
 	"net/http"
 	"regexp"
-	"time"
 
 	log "github.com/Sirupsen/logrus"
+	"github.com/ch3lo/yale/tracing"
 	"github.com/ch3lo/yale/util"
 )
 
@@ -14,23 +17,40 @@ type HttpMonitor struct {
 	request  string
 	expected string
 	retries  int
+	phase    string
 }
 
-func (h *HttpMonitor) Check(ref string, addr string) bool {
+func (h *HttpMonitor) Check(ctx context.Context, ref string, addr string) bool {
 	logger := util.Log.WithFields(log.Fields{
 		"ds": ref,
 	})
 
 	healthyEndpoint := "http://" + addr + h.request
 
-	expected, _ := regexp.Compile(h.expected)
+	expected, err := regexp.Compile(h.expected)
+	if err != nil {
+		logger.Errorf("Respuesta esperada %s invalida: %s", h.expected, err)
+		return false
+	}
 
 	try := 1
 	for h.retries == -1 || try <= h.retries {
 		logger.Infof("HTTP Check intento %d/%d", try, h.retries)
-		resp, err := http.Get(healthyEndpoint)
+		req, err := http.NewRequest("GET", healthyEndpoint, nil)
+		if err != nil {
+			logger.Errorln(err)
+			return false
+		}
+
+		a := startAttempt(ctx, h.phase, HTTP, try, addr)
+		if traceparent := a.span.Traceparent(); traceparent != "" {
+			req.Header.Set("traceparent", traceparent)
+		}
+
+		resp, err := http.DefaultClient.Do(req.WithContext(ctx))
 		if err == nil {
 			logger.Debugf("Se recibió respuesta del servidor con estado %d", resp.StatusCode)
+			a.span.SetAttributes(tracing.Attr("http.status_code", resp.StatusCode))
 
 			if resp.StatusCode == 200 {
 				logger.Debugln("Verificando la respuesta ...")
@@ -39,20 +59,28 @@ func (h *HttpMonitor) Check(ref string, addr string) bool {
 				result := false
 				if expected.MatchString(string(body)) {
 					logger.Infoln("Respuesta OK")
+					a.finish(ATTEMPT_OK, nil)
 					result = true
 				} else {
 					logger.Warnf("Respuesta con error %s", string(body))
+					a.finish(ATTEMPT_UNEXPECTED, errors.New("La respuesta no contiene lo esperado"))
 				}
 
 				resp.Body.Close()
 				return result
 			}
+			resp.Body.Close()
+			a.finish(ATTEMPT_UNEXPECTED, errors.New(fmt.Sprintf("Estado HTTP %d inesperado", resp.StatusCode)))
 		} else {
 			logger.Debugln(err)
+			a.finish(ATTEMPT_ERROR, err)
 		}
 
 		try++
-		time.Sleep(10 * 1e9)
+		if !waitRetry(ctx) {
+			logger.Warnln("HTTP Check cancelado")
+			return false
+		}
 	}
 
 	return false
@@ -70,6 +98,10 @@ func (http *HttpMonitor) SetRetries(retries int) {
 	http.retries = retries
 }
 
+func (http *HttpMonitor) SetPhase(phase string) {
+	http.phase = phase
+}
+
 func (http *HttpMonitor) Configured() bool {
 	if http.request != "" && http.retries != 0 {
 		return true
@@ -0,0 +1,40 @@
+package monitor
+
+import (
+	"strings"
+	"time"
+
+	"github.com/ch3lo/yale/metrics"
+)
+
+// ATTEMPT_OK          El servicio respondió lo esperado
+// ATTEMPT_UNEXPECTED  El servicio respondió con un estado o contenido distinto al esperado
+// ATTEMPT_ERROR       No se pudo conectar con el servicio o se canceló el intento
+const (
+	ATTEMPT_OK         = "ok"
+	ATTEMPT_UNEXPECTED = "unexpected"
+	ATTEMPT_ERROR      = "error"
+)
+
+var (
+	checkAttempts = metrics.Default.NewCounterVec(
+		"yale_monitor_attempts_total",
+		"Intentos de los monitores por fase, tipo y resultado",
+		"phase", "type", "result")
+	checkLatency = metrics.Default.NewHistogramVec(
+		"yale_monitor_attempt_duration_seconds",
+		"Duracion de los intentos de los monitores por fase y tipo",
+		metrics.DefBuckets,
+		"phase", "type")
+)
+
+// recordAttempt registra un intento del monitor que comenzó en start
+func recordAttempt(phase string, monitorType MonitorType, result string, start time.Time) {
+	if phase == "" {
+		phase = "check"
+	}
+
+	name := strings.ToLower(monitorType.String())
+	checkAttempts.Inc(phase, name, result)
+	checkLatency.Observe(time.Since(start).Seconds(), phase, name)
+}
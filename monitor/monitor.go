@@ -1,7 +1,9 @@
 package monitor
 
 import (
+	"context"
 	"strings"
+	"time"
 )
 
 type MonitorType int
@@ -28,17 +30,52 @@ func GetMonitor(t string) MonitorType {
 	return HTTP
 }
 
+// MonitorConfig define un monitor. Phase identifica su uso en las metricas, como smoke o warmup
 type MonitorConfig struct {
 	Type     MonitorType
 	Retries  int
 	Request  string
 	Expected string
+	Phase    string
 }
 
+// retryInterval es el tiempo de espera entre intentos de un monitor
+const retryInterval = 10 * time.Second
+
+// waitRetry espera el siguiente intento. Retorna false si el contexto se cancela durante la espera
+func waitRetry(ctx context.Context) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(retryInterval):
+		return true
+	}
+}
+
+// Monitor verifica el estado de un servicio. Check termina con resultado
+// fallido cuando el contexto se cancela
 type Monitor interface {
-	Check(ref string, addr string) bool
+	Check(ctx context.Context, ref string, addr string) bool
 	SetRequest(ep string)
 	SetExpected(ex string)
 	SetRetries(retries int)
+	SetPhase(phase string)
 	Configured() bool
 }
+
+// NewMonitor crea el monitor del tipo configurado
+func NewMonitor(config MonitorConfig) Monitor {
+	var mon Monitor
+	if config.Type == TCP {
+		mon = new(TcpMonitor)
+	} else {
+		mon = new(HttpMonitor)
+	}
+
+	mon.SetRetries(config.Retries)
+	mon.SetRequest(config.Request)
+	mon.SetExpected(config.Expected)
+	mon.SetPhase(config.Phase)
+
+	return mon
+}
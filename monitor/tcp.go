@@ -1,8 +1,8 @@
 package monitor
 
 import (
+	"context"
 	"net"
-	"time"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/ch3lo/yale/util"
@@ -12,9 +12,10 @@ type TcpMonitor struct {
 	request  string
 	expected string
 	retries  int
+	phase    string
 }
 
-func (tcp *TcpMonitor) Check(ref string, addr string) bool {
+func (tcp *TcpMonitor) Check(ctx context.Context, ref string, addr string) bool {
 	logger := util.Log.WithFields(log.Fields{
 		"ds": ref,
 	})
@@ -22,18 +23,25 @@ func (tcp *TcpMonitor) Check(ref string, addr string) bool {
 	try := 1
 	for tcp.retries == -1 || try <= tcp.retries {
 		logger.Infof("TCP Check intento %d/%d", try, tcp.retries)
-		conn, err := net.Dial("tcp", addr)
+		var dialer net.Dialer
+		a := startAttempt(ctx, tcp.phase, TCP, try, addr)
+		conn, err := dialer.DialContext(ctx, "tcp", addr)
 
 		if err == nil {
 			logger.Infoln("Se recibió respuesta del servidor", addr)
+			a.finish(ATTEMPT_OK, nil)
 			conn.Close()
 			return true
 		} else {
 			logger.Debugln(err)
+			a.finish(ATTEMPT_ERROR, err)
 		}
 
 		try++
-		time.Sleep(10 * 1e9)
+		if !waitRetry(ctx) {
+			logger.Warnln("TCP Check cancelado")
+			return false
+		}
 	}
 
 	return false
@@ -51,6 +59,10 @@ func (tcp *TcpMonitor) SetRetries(retries int) {
 	tcp.retries = retries
 }
 
+func (tcp *TcpMonitor) SetPhase(phase string) {
+	tcp.phase = phase
+}
+
 func (tcp *TcpMonitor) Configured() bool {
 	if tcp.retries != 0 {
 		return true
@@ -1,15 +1,20 @@
 package service
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"io"
 	"reflect"
 	"regexp"
 	"strconv"
+	"sync"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/ch3lo/yale/helper"
 	"github.com/ch3lo/yale/monitor"
+	"github.com/ch3lo/yale/tracing"
 	"github.com/ch3lo/yale/util"
 	"github.com/fsouza/go-dockerclient"
 )
@@ -57,11 +62,14 @@ func (s State) String() string {
 	return state[s-1]
 }
 
+// ServiceConfig define los contenedores del servicio. LogDriver es el driver de logs de
+// Docker, syslog si esta vacio
 type ServiceConfig struct {
 	ServiceId string
 	CpuShares int
 	Envs      []string
 	ImageName string
+	LogDriver string
 	Memory    int64
 	Tag       string
 }
@@ -76,7 +84,7 @@ func (s *ServiceConfig) Version() string {
 }
 
 func (s *ServiceConfig) String() string {
-	return fmt.Sprintf("ImageName: %s - Tag: %s - CpuShares: %d - Memory: %s - Envs: %s", s.ImageName, s.Tag, s.CpuShares, s.Memory, util.MaskEnv(s.Envs))
+	return fmt.Sprintf("ImageName: %s - Tag: %s - CpuShares: %d - Memory: %d - Envs: %s", s.ImageName, s.Tag, s.CpuShares, s.Memory, util.MaskEnv(s.Envs))
 }
 
 type DockerService struct {
@@ -84,9 +92,12 @@ type DockerService struct {
 	loaded          bool
 	state           State
 	step            Step
+	stepMutex       sync.Mutex
 	statusChannel   chan<- string
 	dockerApihelper *helper.DockerHelper
 	container       *docker.Container
+	health          string
+	dead            bool
 	log             *log.Entry
 }
 
@@ -148,12 +159,20 @@ func (ds *DockerService) bindPort(publish []string) map[docker.Port][]docker.Por
 	return portBindings
 }
 
+// setStep actualiza el paso y lo notifica. Los chequeos corren en sus propias gorutinas, por
+// lo que el paso se protege con un mutex
 func (ds *DockerService) setStep(status Step) {
+	ds.stepMutex.Lock()
 	ds.step = status
+	ds.stepMutex.Unlock()
+
 	ds.statusChannel <- ds.id
 }
 
 func (ds *DockerService) GetStep() Step {
+	ds.stepMutex.Lock()
+	defer ds.stepMutex.Unlock()
+
 	return ds.step
 }
 
@@ -180,13 +199,17 @@ func (ds *DockerService) CheckState(state State) bool {
 	return false
 }
 
-func (ds *DockerService) Run(serviceConfig ServiceConfig) {
+func (ds *DockerService) Run(ctx context.Context, serviceConfig ServiceConfig) {
 	ds.log.Infoln("Iniciando el despliegue del servicio")
 	labels := map[string]string{
 		"image_name": serviceConfig.ImageName,
 		"image_tag":  serviceConfig.Tag,
 	}
 
+	if serviceConfig.ServiceId != "" {
+		labels["service_id"] = serviceConfig.ServiceId
+	}
+
 	dockerConfig := docker.Config{
 		Image:  serviceConfig.ImageName + ":" + serviceConfig.Tag,
 		Env:    serviceConfig.Envs,
@@ -204,13 +227,17 @@ func (ds *DockerService) Run(serviceConfig ServiceConfig) {
 		PublishAllPorts: true,
 		Privileged:      false,
 		RestartPolicy:   docker.RestartPolicy{Name: "on-failure", MaximumRetryCount: 1},
-		LogConfig: docker.LogConfig{
+		LogConfig:       docker.LogConfig{Type: serviceConfig.LogDriver},
+	}
+
+	if serviceConfig.LogDriver == "" || serviceConfig.LogDriver == "syslog" {
+		dockerHostConfig.LogConfig = docker.LogConfig{
 			Type: "syslog",
 			Config: map[string]string{
 				"tag":             fmt.Sprintf("{{.ImageName}}|%s|{{.ID}}", sourcetype),
 				"syslog-facility": "local1",
 			},
-		},
+		}
 	}
 
 	if serviceConfig.Memory != 0 {
@@ -221,14 +248,48 @@ func (ds *DockerService) Run(serviceConfig ServiceConfig) {
 		Config:     &dockerConfig,
 		HostConfig: &dockerHostConfig}
 
+	ds.runContainer(ctx, opts)
+}
+
+// RunFrom despliega el servicio clonando la configuracion de un contenedor existente
+func (ds *DockerService) RunFrom(ctx context.Context, template *docker.Container) {
+	ds.log.Infoln("Iniciando el despliegue del servicio desde el contenedor", template.Name)
+
+	dockerConfig := *template.Config
+	// El hostname de Docker por defecto es el ID del contenedor original
+	dockerConfig.Hostname = ""
+
+	dockerHostConfig := docker.HostConfig{}
+	if template.HostConfig != nil {
+		dockerHostConfig = *template.HostConfig
+	}
+
+	opts := docker.CreateContainerOptions{
+		Config:     &dockerConfig,
+		HostConfig: &dockerHostConfig}
+
+	ds.runContainer(ctx, opts)
+}
+
+// startSpan inicia el span de un paso del ciclo de vida del servicio
+func (ds *DockerService) startSpan(ctx context.Context, step string) (context.Context, *tracing.Span) {
+	return tracing.Start(ctx, "service "+step, tracing.Attr("service.id", ds.id), tracing.Attr("service.step", step))
+}
+
+func (ds *DockerService) runContainer(ctx context.Context, opts docker.CreateContainerOptions) {
+	ctx, span := ds.startSpan(ctx, "create")
+	defer span.End()
+
 	var err error
-	ds.container, err = ds.dockerCli().CreateAndRun(opts)
+	ds.container, err = ds.dockerCli().CreateAndRun(ctx, opts)
 
 	if err != nil {
 		ds.log.Errorf("Se produjo un error al arrancar el contenedor: %s", err)
+		span.SetError(err)
 		ds.setStep(STEP_FAILED)
 		return
 	}
+	span.SetAttributes(tracing.Attr("container.id", ds.container.ID))
 
 	ds.log.Debugln("El contenedor arrancó exitosamente")
 	ds.log.Debugf("El contenedor esta asociado al ID de Registrator %s", ds.RegistratorId())
@@ -237,24 +298,48 @@ func (ds *DockerService) Run(serviceConfig ServiceConfig) {
 }
 
 func (ds *DockerService) Undeploy() {
+	ds.UndeployWithTimeout(10)
+}
+
+// UndeployWithTimeout detiene y remueve el contenedor del servicio. El contenedor tiene
+// timeout segundos para detenerse antes de ser terminado
+func (ds *DockerService) UndeployWithTimeout(timeout uint) error {
+	return ds.undeploy(context.Background(), timeout)
+}
+
+// UndeployContext remueve el contenedor como Undeploy, registrando el paso en la traza del contexto
+func (ds *DockerService) UndeployContext(ctx context.Context) error {
+	return ds.undeploy(ctx, 10)
+}
+
+func (ds *DockerService) undeploy(ctx context.Context, timeout uint) error {
+	ctx, span := ds.startSpan(ctx, "undeploy")
+	defer span.End()
+
 	ds.log.Infoln("Iniciando el proceso de Undeploy")
 	if ds.CheckState(UNDEPLOYED) {
 		ds.log.Infoln("El servicio ya se habia removido (undeployed)")
-		return
+		return nil
 	}
 
 	if ds.container == nil || ds.container.ID == "" {
 		ds.log.Warnln("El servicio no esta asociado a un contenedor")
-		return
+		return nil
 	}
 
-	err := ds.dockerCli().UndeployContainer(ds.container.ID, true, 10)
+	// el contenedor se remueve aunque la operacion se haya cancelado, ya que el rollback se
+	// ejecuta luego de cancelar el despliegue
+	span.SetAttributes(tracing.Attr("container.id", ds.container.ID))
+	err := ds.dockerCli().WithContext(context.WithoutCancel(ctx)).UndeployContainer(ds.container.ID, true, timeout)
 	if err != nil {
 		ds.log.Warnln("No se pudo remover el contenedor", err)
-		return
+		span.SetError(err)
+		return err
 	}
 	ds.log.Infoln("Proceso de undeploy exitoso")
 	ds.setState(UNDEPLOYED)
+
+	return nil
 }
 
 func (ds *DockerService) ContainerName() string {
@@ -272,10 +357,74 @@ func (ds *DockerService) ContainerSwarmNode() string {
 	return ds.container.Node.Name
 }
 
+func (ds *DockerService) ContainerCreated() time.Time {
+	return ds.container.Created
+}
+
+// Container entrega los datos del contenedor obtenidos en la ultima inspeccion
+func (ds *DockerService) Container() *docker.Container {
+	return ds.container
+}
+
+// Logs escribe los logs del contenedor en las salidas entregadas
+func (ds *DockerService) Logs(config helper.LogsConfig, stdout io.Writer, stderr io.Writer) error {
+	if ds.container == nil || ds.container.ID == "" {
+		return errors.New("El servicio no esta asociado a un contenedor")
+	}
+
+	return ds.dockerCli().ContainerLogs(ds.container.ID, config, stdout, stderr)
+}
+
+// Exec ejecuta un comando dentro del contenedor del servicio
+func (ds *DockerService) Exec(cmd []string, user string) (helper.ExecResult, error) {
+	if ds.container == nil || ds.container.ID == "" {
+		return helper.ExecResult{}, errors.New("El servicio no esta asociado a un contenedor")
+	}
+
+	return ds.dockerCli().Exec(ds.container.ID, cmd, user)
+}
+
+// ContainerHealth entrega el estado del healthcheck informado por Docker al listar el
+// contenedor: healthy, unhealthy o starting. Vacio si el contenedor no tiene healthcheck
+func (ds *DockerService) ContainerHealth() string {
+	return ds.health
+}
+
+// SetContainerHealth registra el estado del healthcheck del listado, ya que la inspeccion del
+// contenedor no lo incluye
+func (ds *DockerService) SetContainerHealth(health string) {
+	ds.health = health
+}
+
+// SetContainerDead registra si el listado informa que el contenedor esta en estado dead
+func (ds *DockerService) SetContainerDead(dead bool) {
+	ds.dead = dead
+}
+
 func (ds *DockerService) ContainerState() string {
 	return ds.container.State.String()
 }
 
+// ContainerStatus entrega el estado del contenedor con los nombres que utiliza Docker en sus
+// filtros: created, restarting, running, paused, exited o dead
+func (ds *DockerService) ContainerStatus() string {
+	state := ds.container.State
+	switch {
+	case ds.dead:
+		return "dead"
+	case state.Restarting:
+		return "restarting"
+	case state.Paused:
+		return "paused"
+	case state.Running:
+		return "running"
+	case state.StartedAt.IsZero():
+		return "created"
+	}
+
+	return "exited"
+}
+
 func (ds *DockerService) Loaded() bool {
 	return ds.loaded
 }
@@ -305,23 +454,77 @@ func (ds *DockerService) AddressAndPort(internalPort int64) (string, error) {
 		}
 	}
 
-	return "", errors.New(fmt.Sprintf("Puerto %s desconocido", internalPort))
+	return "", errors.New(fmt.Sprintf("Puerto %d desconocido", internalPort))
 }
 
-func (ds *DockerService) RunSmokeTest(monitor monitor.Monitor) {
-	var err error
-	var addr string
-
+// Check ejecuta el monitor sobre la direccion del servicio sin notificar un cambio de paso
+func (ds *DockerService) Check(ctx context.Context, monitor monitor.Monitor) bool {
 	// TODO check a puertos que no sean 8080
-	addr, err = ds.AddressAndPort(8080)
+	addr, err := ds.AddressAndPort(8080)
 	if err != nil {
-		ds.setStep(STEP_FAILED)
+		ds.log.Errorln(err)
+		return false
+	}
+
+	return monitor.Check(ctx, ds.GetId(), addr)
+}
+
+// Restart reinicia el contenedor y actualiza sus datos, ya que los puertos publicados pueden cambiar
+func (ds *DockerService) Restart(timeout uint) error {
+	if ds.container == nil || ds.container.ID == "" {
+		return errors.New("El servicio no esta asociado a un contenedor")
+	}
+
+	ds.log.Infoln("Reiniciando el contenedor", ds.container.Name)
+	container, err := ds.dockerCli().RestartContainer(ds.container.ID, timeout)
+	if err != nil {
+		ds.log.Errorln("No se pudo reiniciar el contenedor", err)
+		return err
+	}
+
+	ds.container = container
+	return nil
+}
+
+// cancelOnDeath cancela el contexto si Docker notifica la caida del contenedor
+func (ds *DockerService) cancelOnDeath(ctx context.Context, cancel context.CancelFunc) {
+	if ds.container == nil || ds.container.ID == "" {
 		return
 	}
 
-	result := monitor.Check(ds.GetId(), addr)
+	death, err := ds.dockerCli().WaitContainerDeath(ctx, ds.container.ID)
+	if err != nil {
+		ds.log.Warnln("No se pudo seguir los eventos del contenedor", err)
+		return
+	}
+
+	go func() {
+		select {
+		case <-ctx.Done():
+		case status := <-death:
+			ds.log.Errorf("El contenedor notificó %s durante el smoke test", status)
+			cancel()
+		}
+	}()
+}
+
+// RunSmokeTest verifica el servicio con el monitor entregado. Si el contenedor muere durante
+// la prueba, se detiene sin esperar que se agoten los reintentos
+func (ds *DockerService) RunSmokeTest(ctx context.Context, monitor monitor.Monitor) {
+	ctx, span := ds.startSpan(ctx, "smoke_test")
+	defer span.End()
+
+	smokeCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	ds.cancelOnDeath(smokeCtx, cancel)
+
+	result := ds.Check(smokeCtx, monitor)
 
 	ds.log.Infof("Se terminó el Smoke Test con estado %t", result)
+	span.SetAttributes(tracing.Attr("service.healthy", result))
+	if !result {
+		span.SetStatus(tracing.STATUS_ERROR, "El Smoke Test falló")
+	}
 
 	if result {
 		ds.setStep(STEP_SMOKE_READY)
@@ -330,26 +533,23 @@ func (ds *DockerService) RunSmokeTest(monitor monitor.Monitor) {
 	}
 }
 
-func (ds *DockerService) RunWarmUp(monitor monitor.Monitor) {
+func (ds *DockerService) RunWarmUp(ctx context.Context, monitor monitor.Monitor) {
 	if !monitor.Configured() {
 		ds.log.Infoln("El servicio no tiene configurado Warm UP. Se saltará esta validación")
 		ds.setStep(STEP_WARM_READY)
 		return
 	}
 
-	var err error
-	var addr string
-
-	// TODO check a puertos que no sean 8080
-	addr, err = ds.AddressAndPort(8080)
-	if err != nil {
-		ds.setStep(STEP_FAILED)
-		return
-	}
+	ctx, span := ds.startSpan(ctx, "warm_up")
+	defer span.End()
 
-	result := monitor.Check(ds.GetId(), addr)
+	result := ds.Check(ctx, monitor)
 
 	ds.log.Infof("Se terminó el Warm UP con estado %t", result)
+	span.SetAttributes(tracing.Attr("service.healthy", result))
+	if !result {
+		span.SetStatus(tracing.STATUS_ERROR, "El Warm UP falló")
+	}
 
 	if result {
 		ds.setStep(STEP_WARM_READY)
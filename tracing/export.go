@@ -0,0 +1,246 @@
+package tracing
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// scopeName identifica a yale como la libreria que genera los spans
+const scopeName = "github.com/ch3lo/yale"
+
+// Los tipos otlp* son la codificacion JSON de ExportTraceServiceRequest de OTLP
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceId           string         `json:"traceId"`
+	SpanId            string         `json:"spanId"`
+	ParentSpanId      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              int            `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Status            otlpStatus     `json:"status"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+type otlpAnyValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+}
+
+// otlpKind convierte el tipo de span a la enumeracion de OTLP, donde internal es 1 y client es 3
+func otlpKind(kind SpanKind) int {
+	if kind == SPAN_CLIENT {
+		return 3
+	}
+
+	return 1
+}
+
+func otlpValue(value interface{}) otlpAnyValue {
+	switch v := value.(type) {
+	case string:
+		return otlpAnyValue{StringValue: &v}
+	case bool:
+		return otlpAnyValue{BoolValue: &v}
+	case int:
+		s := strconv.Itoa(v)
+		return otlpAnyValue{IntValue: &s}
+	case int64:
+		s := strconv.FormatInt(v, 10)
+		return otlpAnyValue{IntValue: &s}
+	case float64:
+		return otlpAnyValue{DoubleValue: &v}
+	}
+
+	s := fmt.Sprint(value)
+	return otlpAnyValue{StringValue: &s}
+}
+
+func otlpAttributes(attrs []Attribute) []otlpKeyValue {
+	var values []otlpKeyValue
+	for _, attr := range attrs {
+		values = append(values, otlpKeyValue{Key: attr.Key, Value: otlpValue(attr.Value)})
+	}
+
+	return values
+}
+
+func unixNano(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+// EncodeOTLP codifica el lote de spans como un ExportTraceServiceRequest de OTLP/JSON
+func EncodeOTLP(resource []Attribute, spans []SpanData) ([]byte, error) {
+	scope := otlpScopeSpans{Scope: otlpScope{Name: scopeName}}
+	for _, span := range spans {
+		encoded := otlpSpan{
+			TraceId:           span.TraceId.String(),
+			SpanId:            span.SpanId.String(),
+			Name:              span.Name,
+			Kind:              otlpKind(span.Kind),
+			StartTimeUnixNano: unixNano(span.Start),
+			EndTimeUnixNano:   unixNano(span.End),
+			Attributes:        otlpAttributes(span.Attributes),
+			Status:            otlpStatus{Code: int(span.Status) - 1, Message: span.StatusMessage},
+		}
+		if span.ParentId.IsValid() {
+			encoded.ParentSpanId = span.ParentId.String()
+		}
+		scope.Spans = append(scope.Spans, encoded)
+	}
+
+	request := otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource:   otlpResource{Attributes: otlpAttributes(resource)},
+		ScopeSpans: []otlpScopeSpans{scope},
+	}}}
+
+	return json.Marshal(request)
+}
+
+// httpExporter envia los spans al endpoint OTLP/HTTP de un collector
+type httpExporter struct {
+	url     string
+	headers map[string]string
+	client  *http.Client
+}
+
+func (e *httpExporter) Export(resource []Attribute, spans []SpanData) error {
+	body, err := EncodeOTLP(resource, spans)
+	if err != nil {
+		return err
+	}
+
+	request, err := http.NewRequest("POST", e.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	request.Header.Set("Content-Type", "application/json")
+	for key, value := range e.headers {
+		request.Header.Set(key, value)
+	}
+
+	response, err := e.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		data, _ := ioutil.ReadAll(response.Body)
+		return errors.New(fmt.Sprintf("El collector respondió %s: %s", response.Status, strings.TrimSpace(string(data))))
+	}
+
+	return nil
+}
+
+// writerExporter escribe cada lote como una linea OTLP/JSON, el mismo formato que lee el
+// receptor de archivos del collector de OpenTelemetry
+type writerExporter struct {
+	mutex  sync.Mutex
+	writer io.Writer
+}
+
+func (e *writerExporter) Export(resource []Attribute, spans []SpanData) error {
+	body, err := EncodeOTLP(resource, spans)
+	if err != nil {
+		return err
+	}
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	_, err = e.writer.Write(append(body, '\n'))
+	return err
+}
+
+func NewWriterExporter(writer io.Writer) Exporter {
+	return &writerExporter{writer: writer}
+}
+
+// TracesEndpoint entrega la url de las trazas a partir de la url base de un collector, como la de
+// OTEL_EXPORTER_OTLP_ENDPOINT. A diferencia de NewExporter, siempre agrega /v1/traces aunque la
+// url tenga ruta, para los collectors publicados bajo un prefijo
+func TracesEndpoint(base string) string {
+	return strings.TrimRight(base, "/") + "/v1/traces"
+}
+
+// NewExporter crea el exporter del endpoint entregado. Acepta la url OTLP/HTTP de un collector,
+// a la que se agrega /v1/traces si no tiene ruta, stdout, stderr o file://ruta
+func NewExporter(endpoint string, headers map[string]string, timeout time.Duration) (Exporter, error) {
+	switch endpoint {
+	case "stdout":
+		return NewWriterExporter(os.Stdout), nil
+	case "stderr":
+		return NewWriterExporter(os.Stderr), nil
+	}
+
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Endpoint de trazas %s invalido: %s", endpoint, err))
+	}
+
+	switch u.Scheme {
+	case "http", "https":
+		if u.Path == "" || u.Path == "/" {
+			u.Path = "/v1/traces"
+		}
+		return &httpExporter{url: u.String(), headers: headers, client: &http.Client{Timeout: timeout}}, nil
+	case "file":
+		path := u.Path
+		if u.Host != "" {
+			path = u.Host + u.Path
+		}
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("No se pudo abrir el archivo de trazas %s: %s", path, err))
+		}
+		return NewWriterExporter(file), nil
+	}
+
+	return nil, errors.New(fmt.Sprintf("Endpoint de trazas %s no soportado, se espera http, https, file, stdout o stderr", endpoint))
+}
@@ -0,0 +1,401 @@
+// Package tracing registra las trazas de los despliegues con el modelo de OpenTelemetry: cada
+// operacion es un span con inicio, termino, atributos y estado, y los spans de una misma
+// operacion comparten el identificador de la traza. Los spans terminados se exportan en lotes
+// con OTLP/JSON a un collector o a un archivo. Si no se configura un tracer, Start entrega
+// spans nil cuyos metodos no hacen nada, por lo que instrumentar el codigo no tiene costo
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/ch3lo/yale/util"
+)
+
+type TraceId [16]byte
+
+func (t TraceId) String() string {
+	return hex.EncodeToString(t[:])
+}
+
+type SpanId [8]byte
+
+func (s SpanId) String() string {
+	return hex.EncodeToString(s[:])
+}
+
+func (s SpanId) IsValid() bool {
+	return s != SpanId{}
+}
+
+// SPAN_INTERNAL  Operacion interna de yale, como un despliegue o un paso de un servicio
+// SPAN_CLIENT    Llamada a un sistema externo, como el API de Docker o un health check
+type SpanKind int
+
+const (
+	SPAN_INTERNAL SpanKind = 1 + iota
+	SPAN_CLIENT
+)
+
+var spanKind = [...]string{
+	"SPAN_INTERNAL",
+	"SPAN_CLIENT",
+}
+
+func (k SpanKind) String() string {
+	return spanKind[k-1]
+}
+
+// STATUS_UNSET  El span terminó sin indicar un resultado
+// STATUS_OK     La operacion terminó correctamente
+// STATUS_ERROR  La operacion falló
+type StatusCode int
+
+const (
+	STATUS_UNSET StatusCode = 1 + iota
+	STATUS_OK
+	STATUS_ERROR
+)
+
+var statusCode = [...]string{
+	"STATUS_UNSET",
+	"STATUS_OK",
+	"STATUS_ERROR",
+}
+
+func (c StatusCode) String() string {
+	return statusCode[c-1]
+}
+
+// Attribute es un atributo de un span o del recurso. Value puede ser string, bool, int, int64 o float64
+type Attribute struct {
+	Key   string
+	Value interface{}
+}
+
+func Attr(key string, value interface{}) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+// SpanData son los datos de un span terminado, tal como se exportan
+type SpanData struct {
+	Name          string
+	TraceId       TraceId
+	SpanId        SpanId
+	ParentId      SpanId
+	Kind          SpanKind
+	Start         time.Time
+	End           time.Time
+	Attributes    []Attribute
+	Status        StatusCode
+	StatusMessage string
+}
+
+// Span es una operacion en curso. Sus metodos aceptan un span nil, que es el que entrega Start
+// cuando las trazas estan desactivadas
+type Span struct {
+	tracer *Tracer
+	mutex  sync.Mutex
+	data   SpanData
+	ended  bool
+}
+
+func (s *Span) SetKind(kind SpanKind) {
+	if s == nil {
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.data.Kind = kind
+}
+
+func (s *Span) SetAttributes(attrs ...Attribute) {
+	if s == nil {
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.data.Attributes = append(s.data.Attributes, attrs...)
+}
+
+func (s *Span) SetStatus(code StatusCode, message string) {
+	if s == nil {
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.data.Status = code
+	s.data.StatusMessage = message
+}
+
+// SetError marca el span como fallido con el mensaje del error. Un error nil no cambia el estado
+func (s *Span) SetError(err error) {
+	if err != nil {
+		s.SetStatus(STATUS_ERROR, err.Error())
+	}
+}
+
+// End termina el span y lo encola para exportarlo. Las llamadas siguientes no tienen efecto
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+
+	s.mutex.Lock()
+	if s.ended {
+		s.mutex.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.End = time.Now()
+	data := s.data
+	s.mutex.Unlock()
+
+	s.tracer.enqueue(data)
+}
+
+// TraceId entrega el identificador de la traza en hexadecimal, vacio si el span es nil
+func (s *Span) TraceId() string {
+	if s == nil {
+		return ""
+	}
+
+	return s.data.TraceId.String()
+}
+
+// Traceparent entrega el header traceparent de W3C Trace Context, para propagar la traza a los
+// servicios que se verifican. Vacio si el span es nil
+func (s *Span) Traceparent() string {
+	if s == nil {
+		return ""
+	}
+
+	return "00-" + s.data.TraceId.String() + "-" + s.data.SpanId.String() + "-01"
+}
+
+type spanKey struct{}
+
+// FromContext entrega el span en curso del contexto, o nil si no tiene
+func FromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
+
+// Exporter envia un lote de spans terminados al destino de las trazas
+type Exporter interface {
+	Export(resource []Attribute, spans []SpanData) error
+}
+
+// Config define un tracer. ServiceName y Resource describen el proceso que genera las trazas.
+// Los spans se exportan en lotes de hasta BatchSize, o cada Interval si el lote no se completa
+type Config struct {
+	ServiceName string
+	Resource    []Attribute
+	Exporter    Exporter
+	BatchSize   int
+	Interval    time.Duration
+}
+
+// queueSize es la cantidad de spans pendientes de exportar. Si el destino no alcanza a recibirlos
+// se descartan los nuevos spans para no detener el despliegue
+const queueSize = 2048
+
+var ErrNoExporter = errors.New("El tracer no tiene un exporter")
+
+// Tracer crea los spans y los exporta en segundo plano
+type Tracer struct {
+	config   Config
+	resource []Attribute
+	mutex    sync.Mutex
+	closed   bool
+	dropped  int
+	queue    chan SpanData
+	done     chan struct{}
+}
+
+func NewTracer(config Config) (*Tracer, error) {
+	if config.Exporter == nil {
+		return nil, ErrNoExporter
+	}
+
+	if config.BatchSize <= 0 {
+		config.BatchSize = 512
+	}
+
+	if config.Interval <= 0 {
+		config.Interval = 2 * time.Second
+	}
+
+	t := &Tracer{
+		config:   config,
+		resource: append([]Attribute{Attr("service.name", config.ServiceName)}, config.Resource...),
+		queue:    make(chan SpanData, queueSize),
+		done:     make(chan struct{}),
+	}
+	go t.run()
+
+	return t, nil
+}
+
+func newTraceId() TraceId {
+	var id TraceId
+	rand.Read(id[:])
+	return id
+}
+
+func newSpanId() SpanId {
+	var id SpanId
+	rand.Read(id[:])
+	return id
+}
+
+// Start inicia un span hijo del span del contexto, o una nueva traza si el contexto no tiene
+// uno, y entrega el contexto con el nuevo span
+func (t *Tracer) Start(ctx context.Context, name string, attrs ...Attribute) (context.Context, *Span) {
+	span := &Span{
+		tracer: t,
+		data: SpanData{
+			Name:       name,
+			SpanId:     newSpanId(),
+			Kind:       SPAN_INTERNAL,
+			Start:      time.Now(),
+			Attributes: attrs,
+			Status:     STATUS_UNSET,
+		},
+	}
+
+	if parent := FromContext(ctx); parent != nil {
+		span.data.TraceId = parent.data.TraceId
+		span.data.ParentId = parent.data.SpanId
+	} else {
+		span.data.TraceId = newTraceId()
+	}
+
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+func (t *Tracer) enqueue(data SpanData) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.closed {
+		return
+	}
+
+	select {
+	case t.queue <- data:
+	default:
+		t.dropped++
+		if t.dropped == 1 {
+			util.Log.Warnln("La cola de trazas esta llena, se descartan los nuevos spans")
+		}
+	}
+}
+
+func (t *Tracer) export(batch []SpanData) {
+	if len(batch) == 0 {
+		return
+	}
+
+	if err := t.config.Exporter.Export(t.resource, batch); err != nil {
+		util.Log.Errorf("No se pudieron exportar %d spans: %s", len(batch), err)
+	}
+}
+
+func (t *Tracer) run() {
+	defer close(t.done)
+
+	ticker := time.NewTicker(t.config.Interval)
+	defer ticker.Stop()
+
+	var batch []SpanData
+	for {
+		select {
+		case data, ok := <-t.queue:
+			if !ok {
+				t.export(batch)
+				return
+			}
+
+			batch = append(batch, data)
+			if len(batch) >= t.config.BatchSize {
+				t.export(batch)
+				batch = nil
+			}
+		case <-ticker.C:
+			t.export(batch)
+			batch = nil
+		}
+	}
+}
+
+// Shutdown deja de aceptar spans y espera que se exporten los pendientes, como maximo el tiempo
+// entregado. Indica si se exportaron todos los spans
+func (t *Tracer) Shutdown(timeout time.Duration) bool {
+	t.mutex.Lock()
+	if !t.closed {
+		t.closed = true
+		close(t.queue)
+	}
+	t.mutex.Unlock()
+
+	select {
+	case <-t.done:
+		return true
+	case <-time.After(timeout):
+		util.Log.Warnf("Quedaron spans sin exportar luego de esperar %s", timeout)
+		return false
+	}
+}
+
+var (
+	defaultMutex  sync.RWMutex
+	defaultTracer *Tracer
+)
+
+// SetDefault define el tracer que utiliza Start. nil desactiva las trazas
+func SetDefault(t *Tracer) {
+	defaultMutex.Lock()
+	defer defaultMutex.Unlock()
+
+	defaultTracer = t
+}
+
+// Enabled indica si hay un tracer configurado
+func Enabled() bool {
+	defaultMutex.RLock()
+	defer defaultMutex.RUnlock()
+
+	return defaultTracer != nil
+}
+
+// Start inicia un span con el tracer por defecto. Si las trazas estan desactivadas entrega el
+// mismo contexto y un span nil
+func Start(ctx context.Context, name string, attrs ...Attribute) (context.Context, *Span) {
+	defaultMutex.RLock()
+	t := defaultTracer
+	defaultMutex.RUnlock()
+
+	if t == nil {
+		return ctx, nil
+	}
+
+	return t.Start(ctx, name, attrs...)
+}
+
+// StartChild inicia un span solo si el contexto ya pertenece a una traza. Se utiliza en las
+// operaciones de bajo nivel, que fuera de un despliegue solo generarian trazas sueltas
+func StartChild(ctx context.Context, name string, attrs ...Attribute) (context.Context, *Span) {
+	if FromContext(ctx) == nil {
+		return ctx, nil
+	}
+
+	return Start(ctx, name, attrs...)
+}
@@ -0,0 +1,181 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recorder guarda los spans exportados
+type recorder struct {
+	mutex sync.Mutex
+	spans []SpanData
+}
+
+func (r *recorder) Export(resource []Attribute, spans []SpanData) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.spans = append(r.spans, spans...)
+	return nil
+}
+
+func TestSpansShareTrace(t *testing.T) {
+	r := &recorder{}
+	tracer, err := NewTracer(Config{ServiceName: "yale", Exporter: r})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, root := tracer.Start(context.Background(), "deploy", Attr("container.image", "app"))
+	_, child := tracer.Start(ctx, "stack a")
+	child.SetError(errors.New("fallo"))
+	child.End()
+	child.End()
+	root.End()
+
+	if !tracer.Shutdown(time.Second) {
+		t.Fatal("No se exportaron los spans")
+	}
+
+	if len(r.spans) != 2 {
+		t.Fatalf("Se exportaron %d spans, se esperaban 2", len(r.spans))
+	}
+
+	stack, deploy := r.spans[0], r.spans[1]
+	if stack.TraceId != deploy.TraceId || stack.ParentId != deploy.SpanId || deploy.ParentId.IsValid() {
+		t.Errorf("Los spans no forman una traza %#v %#v", deploy, stack)
+	}
+
+	if stack.Status != STATUS_ERROR || stack.StatusMessage != "fallo" || deploy.Status != STATUS_UNSET {
+		t.Errorf("Estados inesperados %s %s", stack.Status, deploy.Status)
+	}
+
+	if root.TraceId() != deploy.TraceId.String() || !strings.HasPrefix(root.Traceparent(), "00-"+root.TraceId()+"-") {
+		t.Errorf("Identificadores inesperados %s %s", root.TraceId(), root.Traceparent())
+	}
+}
+
+func TestDisabledTracing(t *testing.T) {
+	ctx := context.Background()
+	traced, span := Start(ctx, "deploy")
+	if span != nil || traced != ctx {
+		t.Fatal("Sin tracer se esperaba un span nil y el mismo contexto")
+	}
+
+	span.SetAttributes(Attr("a", 1))
+	span.SetError(errors.New("fallo"))
+	span.End()
+	if span.TraceId() != "" || span.Traceparent() != "" {
+		t.Error("Un span nil no debe tener identificadores")
+	}
+}
+
+func TestStartChildRequiresTrace(t *testing.T) {
+	r := &recorder{}
+	tracer, _ := NewTracer(Config{ServiceName: "yale", Exporter: r})
+	SetDefault(tracer)
+	defer SetDefault(nil)
+
+	if _, span := StartChild(context.Background(), "docker ListContainers"); span != nil {
+		t.Error("Fuera de una traza no se esperaba un span")
+	}
+
+	ctx, root := Start(context.Background(), "deploy")
+	_, span := StartChild(ctx, "docker ListContainers")
+	if span == nil || span.TraceId() != root.TraceId() {
+		t.Error("Se esperaba un span hijo del despliegue")
+	}
+}
+
+func TestHTTPExporter(t *testing.T) {
+	var path, contentType, auth string
+	var body map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		data, _ := ioutil.ReadAll(req.Body)
+		path, contentType, auth = req.URL.Path, req.Header.Get("Content-Type"), req.Header.Get("Authorization")
+		json.Unmarshal(data, &body)
+	}))
+	defer server.Close()
+
+	exporter, err := NewExporter(server.URL, map[string]string{"Authorization": "Bearer x"}, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tracer, _ := NewTracer(Config{ServiceName: "yale", Exporter: exporter})
+	ctx, root := tracer.Start(context.Background(), "deploy", Attr("deploy.failed", 0), Attr("deploy.ok", true))
+	_, child := tracer.Start(ctx, "docker PullImage")
+	child.SetKind(SPAN_CLIENT)
+	child.End()
+	root.End()
+	tracer.Shutdown(5 * time.Second)
+
+	if path != "/v1/traces" || contentType != "application/json" || auth != "Bearer x" {
+		t.Fatalf("Envio inesperado %s %s %s", path, contentType, auth)
+	}
+
+	resource := body["resourceSpans"].([]interface{})[0].(map[string]interface{})
+	attrs := resource["resource"].(map[string]interface{})["attributes"].([]interface{})
+	service := attrs[0].(map[string]interface{})
+	if service["key"] != "service.name" || service["value"].(map[string]interface{})["stringValue"] != "yale" {
+		t.Errorf("Recurso inesperado %v", attrs)
+	}
+
+	spans := resource["scopeSpans"].([]interface{})[0].(map[string]interface{})["spans"].([]interface{})
+	pull, deploy := spans[0].(map[string]interface{}), spans[1].(map[string]interface{})
+	if pull["kind"] != 3.0 || pull["parentSpanId"] != deploy["spanId"] || deploy["parentSpanId"] != nil {
+		t.Errorf("Spans inesperados %v %v", pull, deploy)
+	}
+
+	failed := deploy["attributes"].([]interface{})[0].(map[string]interface{})["value"].(map[string]interface{})
+	if failed["intValue"] != "0" {
+		t.Errorf("Atributo entero inesperado %v", failed)
+	}
+}
+
+func TestTracesEndpoint(t *testing.T) {
+	for base, expected := range map[string]string{
+		"http://localhost:4318":         "http://localhost:4318/v1/traces",
+		"http://localhost:4318/":        "http://localhost:4318/v1/traces",
+		"https://collector.lan/otlp":    "https://collector.lan/otlp/v1/traces",
+		"https://collector.lan/otlp///": "https://collector.lan/otlp/v1/traces",
+	} {
+		if endpoint := TracesEndpoint(base); endpoint != expected {
+			t.Errorf("Endpoint inesperado para %s: %s, se esperaba %s", base, endpoint, expected)
+		}
+	}
+}
+
+func TestWriterExporter(t *testing.T) {
+	var buffer bytes.Buffer
+	tracer, _ := NewTracer(Config{ServiceName: "yale", Exporter: NewWriterExporter(&buffer)})
+	_, span := tracer.Start(context.Background(), "deploy")
+	span.End()
+	tracer.Shutdown(time.Second)
+
+	lines := strings.Split(strings.TrimSpace(buffer.String()), "\n")
+	if len(lines) != 1 || !strings.Contains(lines[0], `"traceId":"`+span.TraceId()+`"`) {
+		t.Errorf("Salida inesperada %q", buffer.String())
+	}
+}
+
+func TestInvalidExporter(t *testing.T) {
+	for _, endpoint := range []string{"udp://localhost:4317", "localhost:4318"} {
+		if _, err := NewExporter(endpoint, nil, time.Second); err == nil {
+			t.Errorf("Se esperaba un error con el endpoint %s", endpoint)
+		}
+	}
+
+	if _, err := NewTracer(Config{}); err != ErrNoExporter {
+		t.Errorf("Se esperaba ErrNoExporter, se obtuvo %v", err)
+	}
+}
@@ -18,6 +18,11 @@ func MaskEnv(unmaskedEnvs []string) []string {
 
 var letters = []rune("ABCDEFGHIJKLMNOPQRSTUVWXYZ")
 
+// Letter entrega la letra asociada a n. Luego de la Z continua con AA, AB, ...
 func Letter(n int) string {
-	return string(letters[n])
+	if n < len(letters) {
+		return string(letters[n])
+	}
+
+	return Letter(n/len(letters)-1) + string(letters[n%len(letters)])
 }
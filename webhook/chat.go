@@ -0,0 +1,257 @@
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/ch3lo/yale/cluster"
+)
+
+// FORMAT_EVENT    Evento completo firmado, para integraciones propias
+// FORMAT_SLACK    Mensaje para los incoming webhooks de Slack y compatibles (Mattermost, Rocket.Chat)
+// FORMAT_TEAMS    MessageCard para los incoming webhooks de Microsoft Teams
+// FORMAT_GENERIC  Titulo, texto y resumen del despliegue en JSON
+type Format int
+
+const (
+	FORMAT_EVENT Format = 1 + iota
+	FORMAT_SLACK
+	FORMAT_TEAMS
+	FORMAT_GENERIC
+)
+
+var format = [...]string{
+	"event",
+	"slack",
+	"teams",
+	"generic",
+}
+
+func (f Format) String() string {
+	return format[f-1]
+}
+
+// ParseFormat obtiene el formato a partir de su nombre
+func ParseFormat(name string) (Format, error) {
+	for k, v := range format {
+		if v == strings.ToLower(name) {
+			return Format(k + 1), nil
+		}
+	}
+
+	return 0, errors.New(fmt.Sprintf("Formato de notificacion %s desconocido, se espera slack, teams o generic", name))
+}
+
+// Summary es el resumen de un despliegue que se entrega a las plantillas de los mensajes de chat.
+// Status es started, succeeded, partial (exitoso con stacks fallidos) o failed
+type Summary struct {
+	DeployId         string        `json:"deploy_id"`
+	TraceId          string        `json:"trace_id,omitempty"`
+	Operation        string        `json:"operation"`
+	Image            string        `json:"image"`
+	Tag              string        `json:"tag"`
+	Stacks           []string      `json:"stacks"`
+	Status           string        `json:"status"`
+	Ok               bool          `json:"ok"`
+	Started          time.Time     `json:"started"`
+	Duration         time.Duration `json:"-"`
+	Seconds          float64       `json:"duration_seconds"`
+	Succeeded        []string      `json:"succeeded,omitempty"`
+	Failed           []string      `json:"failed,omitempty"`
+	Canceled         []string      `json:"canceled,omitempty"`
+	RolledBack       []string      `json:"rolled_back,omitempty"`
+	FailedContainers []string      `json:"failed_containers,omitempty"`
+}
+
+// DefaultTemplates define el titulo y los mensajes de inicio y termino. Un archivo de plantillas
+// puede redefinir cualquiera de ellas con {{define "title"}}, {{define "started"}} o {{define "finished"}}
+const DefaultTemplates = `{{define "title"}}{{.Operation}} {{.Image}}:{{.Tag}} {{status .}}{{end}}
+{{define "started"}}Comenzó el {{.Operation}} de {{.Image}}:{{.Tag}} en los stacks {{join .Stacks ", "}}{{end}}
+{{define "finished"}}{{if .Ok}}Terminó{{else}}Falló{{end}} el {{.Operation}} de {{.Image}}:{{.Tag}} en {{.Duration}}
+Stacks exitosos: {{or (join .Succeeded ", ") "ninguno"}}
+{{- if .Failed}}
+Stacks fallidos: {{join .Failed ", "}}{{end}}
+{{- if .Canceled}}
+Stacks cancelados: {{join .Canceled ", "}}{{end}}
+{{- if .FailedContainers}}
+Contenedores fallidos: {{join .FailedContainers ", "}}{{end}}
+{{- if .RolledBack}}
+Rollback: {{join .RolledBack ", "}}{{else}}
+Sin rollback{{end}}{{end}}`
+
+var statusText = map[string]string{
+	"started":   "en curso",
+	"succeeded": "exitoso",
+	"partial":   "parcial",
+	"failed":    "fallido",
+}
+
+var statusColor = map[string]string{
+	"started":   "439FE0",
+	"succeeded": "2EB886",
+	"partial":   "DAA038",
+	"failed":    "A30200",
+}
+
+// LoadTemplates carga las plantillas por defecto y luego las del archivo, si se entrega uno
+func LoadTemplates(path string) (*template.Template, error) {
+	funcs := template.FuncMap{
+		"join":   strings.Join,
+		"status": func(s *Summary) string { return statusText[s.Status] },
+	}
+
+	tmpl, err := template.New("chat").Funcs(funcs).Parse(DefaultTemplates)
+	if err != nil {
+		return nil, err
+	}
+
+	if path == "" {
+		return tmpl, nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("No se pudo leer el archivo de plantillas %s: %s", path, err))
+	}
+
+	if tmpl, err = tmpl.Parse(string(data)); err != nil {
+		return nil, errors.New(fmt.Sprintf("Plantillas del archivo %s invalidas: %s", path, err))
+	}
+
+	return tmpl, nil
+}
+
+// chatFormatter acumula los eventos de cada despliegue y arma el mensaje al iniciar y terminar
+type chatFormatter struct {
+	format    Format
+	templates *template.Template
+	mutex     sync.Mutex
+	summaries map[string]*Summary
+}
+
+func newChatFormatter(format Format, templates *template.Template) *chatFormatter {
+	return &chatFormatter{
+		format:    format,
+		templates: templates,
+		summaries: make(map[string]*Summary),
+	}
+}
+
+// observe registra el evento y entrega el resumen si corresponde notificarlo
+func (f *chatFormatter) observe(event cluster.DeployEvent) (*Summary, bool) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	summary, ok := f.summaries[event.DeployId]
+	if !ok {
+		summary = &Summary{
+			DeployId:  event.DeployId,
+			TraceId:   event.TraceId,
+			Operation: event.Operation,
+			Image:     event.Image,
+			Tag:       event.Tag,
+			Stacks:    event.Stacks,
+			Started:   event.Time,
+		}
+		f.summaries[event.DeployId] = summary
+	}
+
+	switch event.Type {
+	case cluster.EVENT_DEPLOY_STARTED:
+		summary.Status = "started"
+		copied := *summary
+		return &copied, true
+	case cluster.EVENT_SERVICE_STEP:
+		if event.Step == "STEP_FAILED" {
+			failed := event.Stack + "/" + event.ServiceId
+			if len(event.ContainerId) >= 12 {
+				failed += " (" + event.ContainerId[:12] + ")"
+			}
+			summary.FailedContainers = append(summary.FailedContainers, failed)
+		}
+	case cluster.EVENT_DEPLOY_FINISHED:
+		delete(f.summaries, event.DeployId)
+		summary.Ok = event.Ok
+		summary.Duration = event.Time.Sub(summary.Started).Round(time.Millisecond)
+		summary.Seconds = summary.Duration.Seconds()
+		if event.Result != nil {
+			summary.Succeeded = event.Result.Succeeded
+			summary.Failed = event.Result.Failed
+			summary.Canceled = event.Result.Canceled
+			summary.RolledBack = event.Result.RolledBack
+		}
+
+		summary.Status = "failed"
+		if event.Ok && len(summary.Failed) > 0 {
+			summary.Status = "partial"
+		} else if event.Ok {
+			summary.Status = "succeeded"
+		}
+		return summary, true
+	}
+
+	return nil, false
+}
+
+func (f *chatFormatter) execute(name string, summary *Summary) (string, error) {
+	var buffer bytes.Buffer
+	if err := f.templates.ExecuteTemplate(&buffer, name, summary); err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(buffer.String()), nil
+}
+
+// render arma el cuerpo del mensaje en el formato del destino
+func (f *chatFormatter) render(summary *Summary) ([]byte, error) {
+	title, err := f.execute("title", summary)
+	if err != nil {
+		return nil, err
+	}
+
+	name := "finished"
+	if summary.Status == "started" {
+		name = "started"
+	}
+
+	text, err := f.execute(name, summary)
+	if err != nil {
+		return nil, err
+	}
+
+	var message interface{}
+	switch f.format {
+	case FORMAT_SLACK:
+		message = map[string]interface{}{
+			"text": "*" + title + "*",
+			"attachments": []map[string]string{
+				{"color": "#" + statusColor[summary.Status], "text": text, "fallback": title},
+			},
+		}
+	case FORMAT_TEAMS:
+		message = map[string]string{
+			"@type":      "MessageCard",
+			"@context":   "https://schema.org/extensions",
+			"summary":    title,
+			"title":      title,
+			"themeColor": statusColor[summary.Status],
+			"text":       strings.Replace(text, "\n", "  \n", -1),
+		}
+	default:
+		message = map[string]interface{}{
+			"title":   title,
+			"text":    text,
+			"status":  summary.Status,
+			"summary": summary,
+		}
+	}
+
+	return json.Marshal(message)
+}
@@ -0,0 +1,151 @@
+package webhook
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ch3lo/yale/cluster"
+)
+
+// deployEvents simula los eventos de un despliegue en los stacks a y b que dura 90 segundos
+func deployEvents(ok bool, result cluster.DeployResult) []cluster.DeployEvent {
+	start := time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC)
+	base := cluster.DeployEvent{DeployId: "d1", Operation: "deploy", Image: "app", Tag: "1.0-abc", Time: start}
+
+	started := base
+	started.Type, started.Stacks = cluster.EVENT_DEPLOY_STARTED, []string{"a", "b"}
+
+	failed := base
+	failed.Type, failed.Stack, failed.ServiceId, failed.Step = cluster.EVENT_SERVICE_STEP, "b", "b_small", "STEP_FAILED"
+	failed.ContainerId = "933f9a7ddd74da87d8629d5728421c148400ef07aca99ba96c7defd25e456080"
+
+	ready := base
+	ready.Type, ready.Stack, ready.ServiceId, ready.Step = cluster.EVENT_SERVICE_STEP, "a", "a_pond", "STEP_WARM_READY"
+
+	finished := base
+	finished.Type, finished.Ok, finished.Result = cluster.EVENT_DEPLOY_FINISHED, ok, &result
+	finished.Time = start.Add(90 * time.Second)
+
+	return []cluster.DeployEvent{started, ready, failed, finished}
+}
+
+// notifyAll envia los eventos al destino de chat y entrega los cuerpos recibidos por el servidor local
+func notifyAll(t *testing.T, target Target, events []cluster.DeployEvent) []map[string]interface{} {
+	r := &receiver{}
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	target.URL = server.URL
+	target.Timeout = time.Second
+	d, err := NewDispatcher([]Target{target})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, event := range events {
+		d.Notify(event)
+	}
+	d.Close(5 * time.Second)
+
+	var messages []map[string]interface{}
+	for k := 0; k < r.count(); k++ {
+		var message map[string]interface{}
+		if err := json.Unmarshal(r.bodies[k], &message); err != nil {
+			t.Fatal(err)
+		}
+		messages = append(messages, message)
+	}
+
+	return messages
+}
+
+func TestSlackNotificationsForFailedDeploy(t *testing.T) {
+	result := cluster.DeployResult{Failed: []string{"b"}, RolledBack: []string{"a", "b"}}
+	messages := notifyAll(t, Target{Format: FORMAT_SLACK}, deployEvents(false, result))
+
+	if len(messages) != 2 {
+		t.Fatalf("Se recibieron %d mensajes, se esperaban 2", len(messages))
+	}
+
+	if messages[0]["text"] != "*deploy app:1.0-abc en curso*" {
+		t.Errorf("Mensaje de inicio inesperado %v", messages[0])
+	}
+
+	if messages[1]["text"] != "*deploy app:1.0-abc fallido*" {
+		t.Errorf("Titulo de termino inesperado %v", messages[1]["text"])
+	}
+
+	attachment := messages[1]["attachments"].([]interface{})[0].(map[string]interface{})
+	text := attachment["text"].(string)
+	for _, expected := range []string{
+		"Falló el deploy de app:1.0-abc en 1m30s",
+		"Stacks exitosos: ninguno",
+		"Stacks fallidos: b",
+		"Contenedores fallidos: b/b_small (933f9a7ddd74)",
+		"Rollback: a, b",
+	} {
+		if !strings.Contains(text, expected) {
+			t.Errorf("El mensaje %q no contiene %q", text, expected)
+		}
+	}
+
+	if attachment["color"] != "#A30200" {
+		t.Errorf("Color inesperado %v", attachment["color"])
+	}
+}
+
+func TestTeamsNotificationWithCustomTemplate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "yale-chat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "chat.tmpl")
+	custom := `{{define "finished"}}{{.Image}} listo en {{.Seconds}}s{{end}}`
+	if err := ioutil.WriteFile(path, []byte(custom), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	result := cluster.DeployResult{Succeeded: []string{"a", "b"}}
+	messages := notifyAll(t, Target{Format: FORMAT_TEAMS, Templates: path}, deployEvents(true, result))
+
+	if len(messages) != 2 {
+		t.Fatalf("Se recibieron %d mensajes, se esperaban 2", len(messages))
+	}
+
+	finished := messages[1]
+	if finished["@type"] != "MessageCard" || finished["themeColor"] != "2EB886" || finished["title"] != "deploy app:1.0-abc exitoso" {
+		t.Errorf("Tarjeta inesperada %v", finished)
+	}
+
+	if finished["text"] != "app listo en 90s" {
+		t.Errorf("La plantilla no se aplicó, texto %q", finished["text"])
+	}
+}
+
+func TestGenericNotificationForPartialDeploy(t *testing.T) {
+	result := cluster.DeployResult{Succeeded: []string{"a"}, Failed: []string{"b"}, RolledBack: []string{"b"}}
+	messages := notifyAll(t, Target{Format: FORMAT_GENERIC}, deployEvents(true, result))
+
+	if len(messages) != 2 {
+		t.Fatalf("Se recibieron %d mensajes, se esperaban 2", len(messages))
+	}
+
+	summary := messages[1]["summary"].(map[string]interface{})
+	if messages[1]["status"] != "partial" || summary["duration_seconds"] != 90.0 || summary["deploy_id"] != "d1" {
+		t.Errorf("Resumen inesperado %v", messages[1])
+	}
+}
+
+func TestInvalidTemplateFile(t *testing.T) {
+	if _, err := LoadTemplates("/nonexistent/chat.tmpl"); err == nil {
+		t.Error("Se esperaba un error con un archivo inexistente")
+	}
+}
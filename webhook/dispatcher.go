@@ -0,0 +1,201 @@
+package webhook
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/ch3lo/yale/cluster"
+	"github.com/ch3lo/yale/util"
+)
+
+// queueSize es la cantidad de eventos pendientes por destino. Si el destino no alcanza a
+// recibirlos se descartan los nuevos eventos para no detener el despliegue
+const queueSize = 1000
+
+// worker envia en orden los eventos de un destino
+type worker struct {
+	target Target
+	sink   sink
+	chat   *chatFormatter
+	events map[string]bool
+	queue  chan delivery
+	log    *log.Entry
+}
+
+func (w *worker) accepts(eventType string) bool {
+	return len(w.events) == 0 || w.events[eventType]
+}
+
+// render arma el cuerpo del envio del evento e indica si el destino lo debe recibir
+func (w *worker) render(id string, event cluster.DeployEvent) ([]byte, bool, error) {
+	if w.chat == nil {
+		if !w.accepts(event.Type.String()) {
+			return nil, false, nil
+		}
+		body, err := json.Marshal(NewPayload(id, event))
+		return body, true, err
+	}
+
+	summary, notify := w.chat.observe(event)
+	if !notify || !w.accepts(event.Type.String()) {
+		return nil, false, nil
+	}
+
+	body, err := w.chat.render(summary)
+	return body, true, err
+}
+
+func (w *worker) run(done *sync.WaitGroup) {
+	defer done.Done()
+
+	for d := range w.queue {
+		w.send(d)
+	}
+}
+
+// send entrega el evento, reintentando los errores transitorios con espera exponencial
+func (w *worker) send(d delivery) {
+	signature := ""
+	if w.target.Secret != "" {
+		signature = Sign(w.target.Secret, d.timestamp, d.body)
+	}
+
+	wait := w.target.Backoff
+	for attempt := 0; ; attempt++ {
+		err := w.sink.deliver(d, signature)
+		if err == nil {
+			w.log.Debugf("Se entregó el evento %s %s", d.event, d.id)
+			return
+		}
+
+		if _, permanent := err.(*permanentError); permanent || attempt >= w.target.Retries {
+			w.log.Errorf("No se pudo entregar el evento %s %s: %s", d.event, d.id, err)
+			return
+		}
+
+		w.log.Warnf("Error al entregar el evento %s %s, reintento %d/%d en %s: %s", d.event, d.id, attempt+1, w.target.Retries, wait, err)
+		time.Sleep(wait)
+		wait *= 2
+	}
+}
+
+// Dispatcher reparte los eventos de los despliegues entre los destinos. Cada destino tiene su
+// propia cola, por lo que un destino lento no retrasa a los demas ni al despliegue
+type Dispatcher struct {
+	workers []*worker
+	running sync.WaitGroup
+	mutex   sync.Mutex
+	closed  bool
+}
+
+// NewDispatcher valida los destinos y comienza a atender sus colas
+func NewDispatcher(targets []Target) (*Dispatcher, error) {
+	d := new(Dispatcher)
+
+	for _, target := range targets {
+		s, err := newSink(target)
+		if err != nil {
+			return nil, err
+		}
+
+		w := &worker{
+			target: target,
+			sink:   s,
+			events: make(map[string]bool),
+			queue:  make(chan delivery, queueSize),
+			log:    util.Log.WithFields(log.Fields{"webhook": target.URL}),
+		}
+
+		if target.Format != 0 && target.Format != FORMAT_EVENT {
+			templates, err := LoadTemplates(target.Templates)
+			if err != nil {
+				return nil, err
+			}
+			w.chat = newChatFormatter(target.Format, templates)
+		}
+
+		for _, name := range target.Events {
+			eventType, err := NormalizeEvent(name)
+			if err != nil {
+				return nil, err
+			}
+			w.events[eventType] = true
+		}
+
+		d.workers = append(d.workers, w)
+	}
+
+	for _, w := range d.workers {
+		d.running.Add(1)
+		go w.run(&d.running)
+	}
+
+	return d, nil
+}
+
+func newDeliveryId() string {
+	id := make([]byte, 16)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// Notify encola el evento en los destinos que lo aceptan. Es un cluster.DeployObserver
+func (d *Dispatcher) Notify(event cluster.DeployEvent) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if d.closed || len(d.workers) == 0 {
+		return
+	}
+
+	id := newDeliveryId()
+	for _, w := range d.workers {
+		body, send, err := w.render(id, event)
+		if err != nil {
+			w.log.Errorf("No se pudo armar el envio del evento %s: %s", event.Type, err)
+			continue
+		}
+
+		if !send {
+			continue
+		}
+
+		entry := delivery{id: id, event: event.Type.String(), timestamp: time.Now().Unix(), body: body}
+		select {
+		case w.queue <- entry:
+		default:
+			w.log.Errorf("La cola del webhook esta llena, se descarta el evento %s %s", entry.event, entry.id)
+		}
+	}
+}
+
+// Close deja de aceptar eventos y espera que se entreguen los pendientes, como maximo el tiempo
+// entregado. Indica si se entregaron todos los eventos
+func (d *Dispatcher) Close(timeout time.Duration) bool {
+	d.mutex.Lock()
+	if !d.closed {
+		d.closed = true
+		for _, w := range d.workers {
+			close(w.queue)
+		}
+	}
+	d.mutex.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		d.running.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		util.Log.Warnf("Quedaron eventos sin entregar a los webhooks luego de esperar %s", timeout)
+		return false
+	}
+}
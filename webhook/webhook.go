@@ -0,0 +1,231 @@
+// Package webhook entrega los eventos de los despliegues a destinos externos. Cada evento se
+// envia como un documento JSON firmado con HMAC-SHA256, con reintentos ante errores transitorios.
+// Los destinos de chat (Slack, Teams o generico) reciben en cambio un resumen del inicio y
+// termino de cada despliegue. Los destinos file:// escriben los envios en un archivo, una linea
+// por envio, lo que permite probar las integraciones sin un servidor
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ch3lo/yale/cluster"
+)
+
+const (
+	HeaderEvent     = "X-Yale-Event"
+	HeaderDelivery  = "X-Yale-Delivery"
+	HeaderTimestamp = "X-Yale-Timestamp"
+	HeaderSignature = "X-Yale-Signature"
+)
+
+// Target es un destino de los eventos. URL puede ser http://, https:// o file://.
+// Format define el cuerpo de los envios, por defecto el evento completo. Los formatos de chat
+// solo notifican el inicio y termino de los despliegues, con mensajes armados con las plantillas
+// por defecto o las del archivo Templates.
+// Events limita los tipos de evento enviados, vacio envia todos.
+// Los envios fallidos se reintentan Retries veces con una espera que parte en Backoff y se duplica.
+type Target struct {
+	URL       string
+	Format    Format
+	Templates string
+	Secret    string
+	Events    []string
+	Retries   int
+	Backoff   time.Duration
+	Timeout   time.Duration
+}
+
+// Payload es el documento JSON que recibe el destino
+type Payload struct {
+	Id          string                `json:"id"`
+	Type        string                `json:"type"`
+	DeployId    string                `json:"deploy_id"`
+	TraceId     string                `json:"trace_id,omitempty"`
+	Time        time.Time             `json:"time"`
+	Operation   string                `json:"operation,omitempty"`
+	Image       string                `json:"image,omitempty"`
+	Tag         string                `json:"tag,omitempty"`
+	Stacks      []string              `json:"stacks,omitempty"`
+	Stack       string                `json:"stack,omitempty"`
+	ServiceId   string                `json:"service_id,omitempty"`
+	ContainerId string                `json:"container_id,omitempty"`
+	Step        string                `json:"step,omitempty"`
+	Status      string                `json:"status,omitempty"`
+	Ok          *bool                 `json:"ok,omitempty"`
+	Result      *cluster.DeployResult `json:"result,omitempty"`
+}
+
+// NewPayload convierte el evento al documento que reciben los destinos, con el id de envio entregado
+func NewPayload(id string, event cluster.DeployEvent) Payload {
+	payload := Payload{
+		Id:          id,
+		Type:        event.Type.String(),
+		DeployId:    event.DeployId,
+		TraceId:     event.TraceId,
+		Time:        event.Time.UTC(),
+		Operation:   event.Operation,
+		Image:       event.Image,
+		Tag:         event.Tag,
+		Stacks:      event.Stacks,
+		Stack:       event.Stack,
+		ServiceId:   event.ServiceId,
+		ContainerId: event.ContainerId,
+		Step:        event.Step,
+		Status:      event.Status,
+		Result:      event.Result,
+	}
+
+	if event.Type == cluster.EVENT_STACK_FINISHED || event.Type == cluster.EVENT_DEPLOY_FINISHED {
+		ok := event.Ok
+		payload.Ok = &ok
+	}
+
+	return payload
+}
+
+// Sign calcula la firma de un envio como sha256=HEX(HMAC-SHA256(secret, timestamp + "." + body)).
+// El receptor debe recalcularla con el header X-Yale-Timestamp y rechazar los envios antiguos
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify indica si la firma corresponde al envio
+func Verify(secret string, timestamp int64, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, timestamp, body)), []byte(signature))
+}
+
+// NormalizeEvent acepta el nombre de un tipo de evento con o sin el prefijo EVENT_ y en
+// cualquier capitalizacion, por ejemplo deploy_finished
+func NormalizeEvent(name string) (string, error) {
+	name = strings.ToUpper(strings.TrimSpace(name))
+	if !strings.HasPrefix(name, "EVENT_") {
+		name = "EVENT_" + name
+	}
+
+	for t := cluster.EVENT_DEPLOY_STARTED; t <= cluster.EVENT_DEPLOY_FINISHED; t++ {
+		if t.String() == name {
+			return name, nil
+		}
+	}
+
+	return "", errors.New(fmt.Sprintf("Evento %s desconocido", name))
+}
+
+// delivery es un evento serializado listo para enviarse
+type delivery struct {
+	id        string
+	event     string
+	timestamp int64
+	body      []byte
+}
+
+// permanentError es un error de envio que no se resuelve al reintentar
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+type sink interface {
+	deliver(d delivery, signature string) error
+}
+
+type httpSink struct {
+	url    string
+	client *http.Client
+}
+
+func (s *httpSink) deliver(d delivery, signature string) error {
+	request, err := http.NewRequest("POST", s.url, bytes.NewReader(d.body))
+	if err != nil {
+		return &permanentError{err}
+	}
+
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("User-Agent", "yale-webhook")
+	request.Header.Set(HeaderEvent, d.event)
+	request.Header.Set(HeaderDelivery, d.id)
+	request.Header.Set(HeaderTimestamp, strconv.FormatInt(d.timestamp, 10))
+	if signature != "" {
+		request.Header.Set(HeaderSignature, signature)
+	}
+
+	response, err := s.client.Do(request)
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+
+	if response.StatusCode >= 200 && response.StatusCode < 300 {
+		return nil
+	}
+
+	err = errors.New(fmt.Sprintf("El destino respondió %s", response.Status))
+	if response.StatusCode >= 500 || response.StatusCode == http.StatusRequestTimeout || response.StatusCode == http.StatusTooManyRequests {
+		return err
+	}
+
+	return &permanentError{err}
+}
+
+// fileSink agrega cada evento como una linea JSON al final del archivo
+type fileSink struct {
+	path  string
+	mutex sync.Mutex
+}
+
+func (s *fileSink) deliver(d delivery, signature string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	file, err := os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = file.Write(append(d.body, '\n'))
+	return err
+}
+
+func newSink(target Target) (sink, error) {
+	u, err := url.Parse(target.URL)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Url del webhook %s invalida: %s", target.URL, err))
+	}
+
+	switch u.Scheme {
+	case "http", "https":
+		return &httpSink{url: target.URL, client: &http.Client{Timeout: target.Timeout}}, nil
+	case "file":
+		path := u.Path
+		if u.Host != "" {
+			path = u.Host + u.Path
+		}
+		if path == "" {
+			return nil, errors.New(fmt.Sprintf("El webhook %s no tiene ruta", target.URL))
+		}
+		return &fileSink{path: path}, nil
+	}
+
+	return nil, errors.New(fmt.Sprintf("Esquema del webhook %s no soportado, se espera http, https o file", target.URL))
+}
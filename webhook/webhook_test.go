@@ -0,0 +1,190 @@
+package webhook
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ch3lo/yale/cluster"
+)
+
+// receiver registra los envios recibidos y responde con los estados programados
+type receiver struct {
+	mutex    sync.Mutex
+	statuses []int
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := ioutil.ReadAll(req.Body)
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.requests = append(r.requests, req)
+	r.bodies = append(r.bodies, body)
+
+	status := http.StatusOK
+	if len(r.statuses) > 0 {
+		status, r.statuses = r.statuses[0], r.statuses[1:]
+	}
+	w.WriteHeader(status)
+}
+
+func (r *receiver) count() int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return len(r.requests)
+}
+
+func testEvent(eventType cluster.DeployEventType) cluster.DeployEvent {
+	return cluster.DeployEvent{Type: eventType, DeployId: "d1", Time: time.Now(), Image: "app", Tag: "1.0-abc", Stack: "a"}
+}
+
+func TestSignAndVerify(t *testing.T) {
+	body := []byte(`{"type":"EVENT_DEPLOY_STARTED"}`)
+	signature := Sign("s3cret", 1700000000, body)
+
+	if !Verify("s3cret", 1700000000, body, signature) {
+		t.Error("La firma no se verificó con los mismos datos")
+	}
+
+	if Verify("s3cret", 1700000001, body, signature) || Verify("otro", 1700000000, body, signature) {
+		t.Error("La firma se verificó con datos distintos")
+	}
+}
+
+func TestNormalizeEvent(t *testing.T) {
+	for _, name := range []string{"deploy_finished", "EVENT_DEPLOY_FINISHED", "Deploy_Finished"} {
+		if eventType, err := NormalizeEvent(name); err != nil || eventType != "EVENT_DEPLOY_FINISHED" {
+			t.Errorf("El evento %s se normalizó como %q: %v", name, eventType, err)
+		}
+	}
+
+	if _, err := NormalizeEvent("deploy_exploded"); err == nil {
+		t.Error("Se esperaba un error con un evento desconocido")
+	}
+}
+
+func TestDeliveryIsSignedAndRetried(t *testing.T) {
+	r := &receiver{statuses: []int{http.StatusServiceUnavailable, http.StatusOK}}
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	d, err := NewDispatcher([]Target{{URL: server.URL, Secret: "s3cret", Retries: 2, Backoff: time.Millisecond, Timeout: time.Second}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d.Notify(testEvent(cluster.EVENT_DEPLOY_STARTED))
+	if !d.Close(5 * time.Second) {
+		t.Fatal("No se entregaron los eventos pendientes")
+	}
+
+	if r.count() != 2 {
+		t.Fatalf("El destino recibió %d envios, se esperaban 2", r.count())
+	}
+
+	req, body := r.requests[1], r.bodies[1]
+	timestamp, _ := strconv.ParseInt(req.Header.Get(HeaderTimestamp), 10, 64)
+	if !Verify("s3cret", timestamp, body, req.Header.Get(HeaderSignature)) {
+		t.Errorf("Firma invalida %q", req.Header.Get(HeaderSignature))
+	}
+
+	if req.Header.Get(HeaderEvent) != "EVENT_DEPLOY_STARTED" || req.Header.Get(HeaderDelivery) != r.requests[0].Header.Get(HeaderDelivery) {
+		t.Errorf("Headers inesperados %v", req.Header)
+	}
+
+	var payload Payload
+	if err := json.Unmarshal(body, &payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload.DeployId != "d1" || payload.Image != "app" || payload.Ok != nil {
+		t.Errorf("Evento inesperado %#v", payload)
+	}
+}
+
+func TestClientErrorIsNotRetried(t *testing.T) {
+	r := &receiver{statuses: []int{http.StatusBadRequest}}
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	d, err := NewDispatcher([]Target{{URL: server.URL, Retries: 3, Backoff: time.Millisecond, Timeout: time.Second}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d.Notify(testEvent(cluster.EVENT_DEPLOY_STARTED))
+	d.Close(5 * time.Second)
+
+	if r.count() != 1 {
+		t.Errorf("El destino recibió %d envios, se esperaba 1", r.count())
+	}
+
+	if r.requests[0].Header.Get(HeaderSignature) != "" {
+		t.Error("Se firmó el evento sin secreto")
+	}
+}
+
+func TestFileSinkWithEventFilter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "yale-webhook")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "events.jsonl")
+	d, err := NewDispatcher([]Target{{URL: "file://" + path, Events: []string{"stack_finished", "deploy_finished"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d.Notify(testEvent(cluster.EVENT_DEPLOY_STARTED))
+	stack := testEvent(cluster.EVENT_STACK_FINISHED)
+	stack.Status, stack.Ok = "STACK_READY", true
+	d.Notify(stack)
+	d.Notify(testEvent(cluster.EVENT_DEPLOY_FINISHED))
+	d.Close(5 * time.Second)
+	d.Notify(testEvent(cluster.EVENT_DEPLOY_STARTED))
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	var payloads []Payload
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var payload Payload
+		if err := json.Unmarshal(scanner.Bytes(), &payload); err != nil {
+			t.Fatal(err)
+		}
+		payloads = append(payloads, payload)
+	}
+
+	if len(payloads) != 2 || payloads[0].Type != "EVENT_STACK_FINISHED" || payloads[1].Type != "EVENT_DEPLOY_FINISHED" {
+		t.Fatalf("Eventos inesperados %#v", payloads)
+	}
+
+	if payloads[0].Ok == nil || !*payloads[0].Ok || payloads[0].Status != "STACK_READY" {
+		t.Errorf("Evento de stack inesperado %#v", payloads[0])
+	}
+}
+
+func TestInvalidTargets(t *testing.T) {
+	for _, target := range []Target{{URL: "ftp://host/events"}, {URL: "file://"}, {URL: "http://host", Events: []string{"nope"}}} {
+		if _, err := NewDispatcher([]Target{target}); err == nil {
+			t.Errorf("Se esperaba un error con el destino %#v", target)
+		}
+	}
+}